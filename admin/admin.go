@@ -0,0 +1,153 @@
+// Package admin provides the operations support needs to look into the rate limits of a user and to act on them,
+// both as a Go API and as HTTP endpoints: listing the keys of a user, showing its status for every notification type,
+// resetting its keys, and raising or lowering its limits for a while.
+package admin
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/errs"
+	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/notification"
+
+	"github.com/segmentio/ksuid"
+)
+
+// RateLimiter is the part of the rate_limiter.RateLimiter interface used by the Admin.
+type RateLimiter interface {
+	Keys(ctx context.Context, prefix string) ([]string, error)
+	Reset(ctx context.Context, key string) error
+}
+
+// TypeStatus is the rate limit status of a user for a notification type.
+type TypeStatus struct {
+	Type   string
+	Status *models.RateLimitStatus
+}
+
+// Admin inspects and manipulates the rate limits of the users of a notification service.
+// The temporary limits it sets only apply when the service gets its overrides from Overrides with the same store.
+type Admin struct {
+	service  *notification.Service
+	rlimiter RateLimiter
+	store    OverrideStore
+	now      func() time.Time
+}
+
+// New creates an Admin of the notification service, which uses the rate limiter of the service
+// and keeps the temporary limits in the store.
+func New(service *notification.Service, rlimiter RateLimiter, store OverrideStore) *Admin {
+	return &Admin{
+		service:  service,
+		rlimiter: rlimiter,
+		store:    store,
+		now:      time.Now,
+	}
+}
+
+// Keys returns the rate limit keys of a user, sorted, which can be reset one by one with ResetKey.
+func (a *Admin) Keys(ctx context.Context, userID ksuid.KSUID) ([]string, error) {
+	if userID.IsNil() {
+		return nil, fmt.Errorf("invalid user ID: %w", errs.ErrInvalidArguments)
+	}
+
+	keys, err := a.rlimiter.Keys(ctx, notification.KeyPrefix(userID))
+	if err != nil {
+		return nil, fmt.Errorf("error listing rate limit keys of user %v: %w", userID, err)
+	}
+
+	return keys, nil
+}
+
+// Status returns the rate limit status of a user for every notification type, sorted by type, without using any
+// of its rate limit. The tenant ID is optional, and it is only used to look up the overrides of the user.
+func (a *Admin) Status(ctx context.Context, userID ksuid.KSUID, tenantID string) ([]*TypeStatus, error) {
+	types := a.service.Types()
+
+	statuses := make([]*TypeStatus, 0, len(types))
+	for _, typ := range types {
+		status, err := a.service.Status(ctx, userID, tenantID, typ)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, &TypeStatus{Type: typ, Status: status})
+	}
+
+	return statuses, nil
+}
+
+// ResetKey clears the rate limit of a key, such as one of the keys of a user.
+func (a *Admin) ResetKey(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("invalid key: %w", errs.ErrInvalidArguments)
+	}
+
+	if err := a.rlimiter.Reset(ctx, key); err != nil {
+		return fmt.Errorf("error resetting rate limit key %v: %w", key, err)
+	}
+
+	return nil
+}
+
+// Reset clears the rate limit of a user for a notification type, every one of its windows included.
+func (a *Admin) Reset(ctx context.Context, userID ksuid.KSUID, tenantID string, typ string) error {
+	return a.service.Reset(ctx, userID, tenantID, typ)
+}
+
+// SetTemporaryLimit replaces the limit of a user for the notification type of the configuration until the TTL
+// elapses, raising or lowering it, and replacing any previous temporary limit of the user for the type.
+// The counters of the user are kept, so the limit applies to what the user already used in the current window.
+func (a *Admin) SetTemporaryLimit(ctx context.Context, userID ksuid.KSUID, conf *configs.LimitConfig, ttl time.Duration) (*TemporaryLimit, error) {
+	switch {
+	case userID.IsNil():
+		return nil, fmt.Errorf("invalid user ID: %w", errs.ErrInvalidArguments)
+	case conf == nil:
+		return nil, fmt.Errorf("missing temporary limit: %w", errs.ErrInvalidArguments)
+	case !slices.Contains(a.service.Types(), conf.Type):
+		return nil, fmt.Errorf("notification type %v not found in config: %w", conf.Type, errs.ErrInvalidArguments)
+	case ttl < time.Millisecond:
+		return nil, fmt.Errorf("invalid TTL %v, must be at least 1ms: %w", ttl, errs.ErrInvalidArguments)
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid temporary limit, %v: %w", err, errs.ErrInvalidArguments)
+	}
+
+	limit := &TemporaryLimit{Limit: conf, ExpiresAt: a.now().Add(ttl)}
+	if err := a.store.SetLimit(ctx, userID.String(), limit); err != nil {
+		return nil, fmt.Errorf("error setting temporary limit of user %v: %w", userID, err)
+	}
+
+	return limit, nil
+}
+
+// TemporaryLimits returns the temporary limits of a user that did not expire, sorted by notification type.
+func (a *Admin) TemporaryLimits(ctx context.Context, userID ksuid.KSUID) ([]*TemporaryLimit, error) {
+	if userID.IsNil() {
+		return nil, fmt.Errorf("invalid user ID: %w", errs.ErrInvalidArguments)
+	}
+
+	limits, err := a.store.Limits(ctx, userID.String())
+	if err != nil {
+		return nil, fmt.Errorf("error getting temporary limits of user %v: %w", userID, err)
+	}
+
+	return limits, nil
+}
+
+// DeleteTemporaryLimit removes the temporary limit of a user for a notification type before it expires,
+// so the user gets its previous limit back.
+func (a *Admin) DeleteTemporaryLimit(ctx context.Context, userID ksuid.KSUID, typ string) error {
+	if userID.IsNil() || typ == "" {
+		return fmt.Errorf("invalid temporary limit values: %w", errs.ErrInvalidArguments)
+	}
+
+	if err := a.store.DeleteLimit(ctx, userID.String(), typ); err != nil {
+		return fmt.Errorf("error deleting temporary limit of user %v: %w", userID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,335 @@
+package admin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/errs"
+	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/notification"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatewayFunc is a notification.Gateway that sends the notifications with a function.
+type gatewayFunc func(ctx context.Context, userID string, message string) error
+
+func (fn gatewayFunc) Send(ctx context.Context, userID string, message string) error {
+	return fn(ctx, userID, message)
+}
+
+// newAdmin returns an Admin of a notification service with a memory rate limiter, whose limits are two status
+// notifications per minute and one news per minute, unless the base overrides say otherwise.
+func newAdmin(t *testing.T, base notification.OverrideProvider) (*Admin, *notification.Service) {
+	rlimiter := rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, rate_limiter.SlidingWindowCounter, nil)
+	t.Cleanup(func() {
+		_ = rlimiter.(interface{ Close() error }).Close()
+	})
+
+	limits := configs.LimitConfigMap{
+		"status": {Type: "status", Limit: 2, WSize: configs.Duration(time.Minute)},
+		"news":   {Type: "news", Limit: 1, WSize: configs.Duration(time.Minute)},
+	}
+
+	store := NewOverrideStore(rate_limiter.MemoryBackend, nil)
+	gateway := gatewayFunc(func(ctx context.Context, userID string, message string) error { return nil })
+	svc := notification.NewService(rlimiter, gateway, limits, notification.WithOverrideProvider(Overrides(store, base)))
+
+	return New(svc, rlimiter, store), svc
+}
+
+func send(svc *notification.Service, userID ksuid.KSUID, typ string) error {
+	return svc.Send(context.Background(), &models.Notification{Type: typ, UserID: userID, Message: "hello"})
+}
+
+func TestAdmin_KeysAndStatus(t *testing.T) {
+	a, svc := newAdmin(t, nil)
+	userID, otherID := ksuid.New(), ksuid.New()
+	ctx := context.Background()
+
+	keys, err := a.Keys(ctx, userID)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	require.NoError(t, send(svc, userID, "status"))
+	require.NoError(t, send(svc, userID, "news"))
+	require.NoError(t, send(svc, otherID, "status"))
+
+	keys, err = a.Keys(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"{" + userID.String() + "}-news", "{" + userID.String() + "}-status"}, keys)
+
+	statuses, err := a.Status(ctx, userID, "")
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+
+	assert.Equal(t, "news", statuses[0].Type)
+	assert.Equal(t, models.Denied, statuses[0].Status.State)
+	assert.Equal(t, 1, statuses[0].Status.Count)
+	assert.Zero(t, statuses[0].Status.Remaining)
+
+	assert.Equal(t, "status", statuses[1].Type)
+	assert.Equal(t, models.Allowed, statuses[1].Status.State)
+	assert.Equal(t, 1, statuses[1].Status.Count)
+	assert.Equal(t, 1, statuses[1].Status.Remaining)
+	assert.Greater(t, statuses[1].Status.ExpiresAtMs, time.Now().UnixMilli())
+
+	_, err = a.Keys(ctx, ksuid.Nil)
+	assert.ErrorIs(t, err, errs.ErrInvalidArguments)
+}
+
+func TestAdmin_Reset(t *testing.T) {
+	a, svc := newAdmin(t, nil)
+	userID := ksuid.New()
+	ctx := context.Background()
+
+	require.NoError(t, send(svc, userID, "news"))
+	require.Error(t, send(svc, userID, "news"))
+
+	require.NoError(t, a.Reset(ctx, userID, "", "news"))
+	require.NoError(t, send(svc, userID, "news"))
+
+	keys, err := a.Keys(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+
+	require.NoError(t, a.ResetKey(ctx, keys[0]))
+	require.NoError(t, send(svc, userID, "news"))
+
+	assert.ErrorIs(t, a.ResetKey(ctx, ""), errs.ErrInvalidArguments)
+	assert.ErrorIs(t, a.Reset(ctx, userID, "", ""), errs.ErrInvalidArguments)
+}
+
+func TestAdmin_TemporaryLimits(t *testing.T) {
+	a, svc := newAdmin(t, nil)
+	userID, otherID := ksuid.New(), ksuid.New()
+	ctx := context.Background()
+
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	// raises the news limit of the user
+	raised := &configs.LimitConfig{Type: "news", Limit: 3, WSize: configs.Duration(time.Minute)}
+	limit, err := a.SetTemporaryLimit(ctx, userID, raised, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour), limit.ExpiresAt)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, send(svc, userID, "news"))
+	}
+	require.Error(t, send(svc, userID, "news"))
+
+	// the other users keep the configured limit
+	require.NoError(t, send(svc, otherID, "news"))
+	require.Error(t, send(svc, otherID, "news"))
+
+	limits, err := a.TemporaryLimits(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, limits, 1)
+	assert.Equal(t, raised, limits[0].Limit)
+
+	// lowers the status limit of the user
+	lowered := &configs.LimitConfig{Type: "status", Limit: 1, WSize: configs.Duration(time.Minute)}
+	_, err = a.SetTemporaryLimit(ctx, userID, lowered, time.Hour)
+	require.NoError(t, err)
+
+	require.NoError(t, send(svc, userID, "status"))
+	require.Error(t, send(svc, userID, "status"))
+
+	limits, err = a.TemporaryLimits(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, limits, 2)
+	assert.Equal(t, "news", limits[0].Limit.Type)
+	assert.Equal(t, "status", limits[1].Limit.Type)
+
+	// the user gets its configured status limit back
+	require.NoError(t, a.DeleteTemporaryLimit(ctx, userID, "status"))
+	require.NoError(t, send(svc, userID, "status"))
+
+	limits, err = a.TemporaryLimits(ctx, userID)
+	require.NoError(t, err)
+	require.Len(t, limits, 1)
+}
+
+func TestAdmin_TemporaryLimitsExpire(t *testing.T) {
+	a, svc := newAdmin(t, nil)
+	userID := ksuid.New()
+	ctx := context.Background()
+
+	raised := &configs.LimitConfig{Type: "news", Limit: 2, WSize: configs.Duration(time.Minute)}
+	_, err := a.SetTemporaryLimit(ctx, userID, raised, 50*time.Millisecond)
+	require.NoError(t, err)
+
+	require.NoError(t, send(svc, userID, "news"))
+	require.NoError(t, send(svc, userID, "news"))
+
+	time.Sleep(60 * time.Millisecond)
+
+	limits, err := a.TemporaryLimits(ctx, userID)
+	require.NoError(t, err)
+	assert.Empty(t, limits)
+
+	status, err := svc.Status(ctx, userID, "", "news")
+	require.NoError(t, err)
+	assert.Equal(t, models.Denied, status.State)
+	assert.EqualValues(t, 1, status.Limit)
+}
+
+func TestAdmin_SetTemporaryLimitErrors(t *testing.T) {
+	a, _ := newAdmin(t, nil)
+	userID := ksuid.New()
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		userID ksuid.KSUID
+		conf   *configs.LimitConfig
+		ttl    time.Duration
+	}{
+		{
+			name:   "nil user",
+			userID: ksuid.Nil,
+			conf:   &configs.LimitConfig{Type: "news", Limit: 2, WSize: configs.Duration(time.Minute)},
+			ttl:    time.Hour,
+		},
+		{
+			name:   "missing limit",
+			userID: userID,
+			ttl:    time.Hour,
+		},
+		{
+			name:   "unknown type",
+			userID: userID,
+			conf:   &configs.LimitConfig{Type: "marketing", Limit: 2, WSize: configs.Duration(time.Minute)},
+			ttl:    time.Hour,
+		},
+		{
+			name:   "invalid limit",
+			userID: userID,
+			conf:   &configs.LimitConfig{Type: "news", Limit: 0, WSize: configs.Duration(time.Minute)},
+			ttl:    time.Hour,
+		},
+		{
+			name:   "no ttl",
+			userID: userID,
+			conf:   &configs.LimitConfig{Type: "news", Limit: 2, WSize: configs.Duration(time.Minute)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.SetTemporaryLimit(ctx, tt.userID, tt.conf, tt.ttl)
+			assert.ErrorIs(t, err, errs.ErrInvalidArguments)
+		})
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	ctx := context.Background()
+	store := NewOverrideStore(rate_limiter.MemoryBackend, nil)
+
+	news := &configs.LimitConfig{Type: "news", Limit: 10, WSize: configs.Duration(time.Minute)}
+	status := &configs.LimitConfig{Type: "status", Limit: 20, WSize: configs.Duration(time.Minute)}
+	base := configs.OverrideList{
+		{UserID: "user", Limits: []*configs.LimitConfig{news, status}},
+		{UserID: "exempt", Exempt: true},
+	}
+	provider := Overrides(store, base)
+
+	// without temporary limits the base override is kept
+	override, err := provider.Override(ctx, "user", "")
+	require.NoError(t, err)
+	assert.Same(t, base[0], override)
+
+	override, err = provider.Override(ctx, "nobody", "")
+	require.NoError(t, err)
+	assert.Nil(t, override)
+
+	temporary := &configs.LimitConfig{Type: "news", Limit: 1, WSize: configs.Duration(time.Minute)}
+	for _, userID := range []string{"user", "exempt", "nobody"} {
+		require.NoError(t, store.SetLimit(ctx, userID, &TemporaryLimit{Limit: temporary, ExpiresAt: time.Now().Add(time.Hour)}))
+	}
+
+	override, err = provider.Override(ctx, "user", "")
+	require.NoError(t, err)
+	assert.Equal(t, temporary, override.Limit("news"))
+	assert.Equal(t, status, override.Limit("status"))
+	assert.Len(t, base[0].Limits, 2, "the base override must not change")
+	assert.Equal(t, news, base[0].Limit("news"))
+
+	override, err = provider.Override(ctx, "exempt", "")
+	require.NoError(t, err)
+	assert.True(t, override.Exempt)
+
+	override, err = provider.Override(ctx, "nobody", "")
+	require.NoError(t, err)
+	assert.Equal(t, "nobody", override.UserID)
+	assert.Equal(t, temporary, override.Limit("news"))
+	assert.Nil(t, override.Limit("status"))
+}
+
+// countingStore is an OverrideStore that counts the reads of the temporary limits.
+type countingStore struct {
+	OverrideStore
+	reads int
+}
+
+func (cs *countingStore) Limits(ctx context.Context, userID string) ([]*TemporaryLimit, error) {
+	cs.reads++
+	return cs.OverrideStore.Limits(ctx, userID)
+}
+
+func TestCachedOverrideStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	counting := &countingStore{OverrideStore: newMemoryOverrideStore()}
+	store := newCachedOverrideStore(counting, time.Second)
+	store.now = func() time.Time { return now }
+
+	// the users without temporary limits are cached too
+	for i := 0; i < 3; i++ {
+		limits, err := store.Limits(ctx, "user")
+		require.NoError(t, err)
+		assert.Empty(t, limits)
+	}
+	assert.Equal(t, 1, counting.reads)
+
+	// the changes made through the store are seen right away
+	limit := &TemporaryLimit{Limit: &configs.LimitConfig{Type: "news", Limit: 1}, ExpiresAt: now.Add(1500 * time.Millisecond)}
+	require.NoError(t, store.SetLimit(ctx, "user", limit))
+	limits, err := store.Limits(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, []*TemporaryLimit{limit}, limits)
+	assert.Equal(t, 2, counting.reads)
+
+	// the ones made elsewhere once the cached limits expire
+	require.NoError(t, counting.DeleteLimit(ctx, "user", "news"))
+	limits, err = store.Limits(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, limits, 1)
+
+	now = now.Add(time.Second)
+	limits, err = store.Limits(ctx, "user")
+	require.NoError(t, err)
+	assert.Empty(t, limits)
+	assert.Equal(t, 3, counting.reads)
+
+	// a cached limit that expires is left out, and the expired entries are swept
+	require.NoError(t, store.SetLimit(ctx, "user", limit))
+	_, err = store.Limits(ctx, "user")
+	require.NoError(t, err)
+	now = now.Add(600 * time.Millisecond)
+	limits, err = store.Limits(ctx, "user")
+	require.NoError(t, err)
+	assert.Empty(t, limits)
+
+	now = now.Add(time.Second)
+	_, err = store.Limits(ctx, "other")
+	require.NoError(t, err)
+	assert.NotContains(t, store.entries, "user")
+}
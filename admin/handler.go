@@ -0,0 +1,285 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/errs"
+	"github.com/godoylucase/rate-limit/models"
+
+	"github.com/segmentio/ksuid"
+)
+
+// maxBodyBytes is the largest request body accepted.
+const maxBodyBytes = 1 << 16
+
+// KeysResponse lists the rate limit keys of a user.
+type KeysResponse struct {
+	Keys []string `json:"keys"`
+}
+
+// TypeStatusResponse is the rate limit status of a user for a notification type. ResetAt is when the window
+// of the status expires, and RetryAfterMs how long a denied notification has to wait to be sent.
+type TypeStatusResponse struct {
+	Type         string       `json:"type"`
+	State        models.State `json:"state"`
+	Count        int          `json:"count"`
+	Limit        int64        `json:"limit"`
+	Remaining    int          `json:"remaining"`
+	ResetAt      *time.Time   `json:"reset_at,omitempty"`
+	RetryAfterMs int64        `json:"retry_after_ms,omitempty"`
+	Window       string       `json:"window,omitempty"`
+	Exempt       bool         `json:"exempt,omitempty"`
+}
+
+// StatusResponse lists the rate limit status of a user for every notification type.
+type StatusResponse struct {
+	Types []*TypeStatusResponse `json:"types"`
+}
+
+// TemporaryLimitRequest is the body of the requests setting a temporary limit, which is a limit configuration,
+// without its type, which comes from the path, along with how long it lasts, such as "1h".
+type TemporaryLimitRequest struct {
+	configs.LimitConfig
+	TTL configs.Duration `json:"ttl"`
+}
+
+// TemporaryLimitsResponse lists the temporary limits of a user.
+type TemporaryLimitsResponse struct {
+	Limits []*TemporaryLimit `json:"limits"`
+}
+
+// ErrorResponse tells why a request failed.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// HandlerOption configures optional behavior of the admin handler.
+type HandlerOption func(*handlerOptions)
+
+// handlerOptions are the settings of Handler.
+type handlerOptions struct {
+	token string
+}
+
+// WithBearerToken makes the admin handler answer 401 Unauthorized to the requests that do not carry the token
+// in their Authorization header, as in "Authorization: Bearer <token>". An empty token turns the check off.
+func WithBearerToken(token string) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.token = token
+	}
+}
+
+// Handler returns the http.Handler of the admin endpoints, which must only be reachable by the operators:
+//
+//	GET    /admin/v1/users/{user_id}/keys               lists the rate limit keys of the user
+//	GET    /admin/v1/users/{user_id}/status             shows the status of the user for every notification type
+//	DELETE /admin/v1/users/{user_id}/limits/{type}      resets the rate limit of the user for the type
+//	DELETE /admin/v1/keys/{key}                         resets a rate limit key
+//	GET    /admin/v1/users/{user_id}/overrides          lists the temporary limits of the user
+//	PUT    /admin/v1/users/{user_id}/overrides/{type}   sets a temporary limit of the user for the type
+//	DELETE /admin/v1/users/{user_id}/overrides/{type}   removes the temporary limit of the user for the type
+//
+// The status and the limits of a user take an optional tenant_id query parameter to look up its overrides.
+// The endpoints are not authenticated unless the WithBearerToken option is given.
+func (a *Admin) Handler(opts ...HandlerOption) http.Handler {
+	hopts := &handlerOptions{}
+	for _, opt := range opts {
+		opt(hopts)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/v1/users/{user_id}/keys", a.handleKeys)
+	mux.HandleFunc("GET /admin/v1/users/{user_id}/status", a.handleStatus)
+	mux.HandleFunc("DELETE /admin/v1/users/{user_id}/limits/{type}", a.handleReset)
+	mux.HandleFunc("DELETE /admin/v1/keys/{key...}", a.handleResetKey)
+	mux.HandleFunc("GET /admin/v1/users/{user_id}/overrides", a.handleTemporaryLimits)
+	mux.HandleFunc("PUT /admin/v1/users/{user_id}/overrides/{type}", a.handleSetTemporaryLimit)
+	mux.HandleFunc("DELETE /admin/v1/users/{user_id}/overrides/{type}", a.handleDeleteTemporaryLimit)
+
+	if hopts.token == "" {
+		return mux
+	}
+
+	return requireBearerToken(hopts.token, mux)
+}
+
+// requireBearerToken answers 401 Unauthorized to the requests whose bearer token is not the given one,
+// comparing them in constant time, and passes the others to next.
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeJSON(w, http.StatusUnauthorized, &ErrorResponse{Error: "missing or invalid bearer token"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Admin) handleKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := a.Keys(r.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &KeysResponse{Keys: keys})
+}
+
+func (a *Admin) handleStatus(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	statuses, err := a.Status(r.Context(), userID, r.URL.Query().Get("tenant_id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := &StatusResponse{Types: make([]*TypeStatusResponse, 0, len(statuses))}
+	for _, ts := range statuses {
+		resp.Types = append(resp.Types, typeStatusResponse(ts))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (a *Admin) handleReset(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.Reset(r.Context(), userID, r.URL.Query().Get("tenant_id"), r.PathValue("type")); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) handleResetKey(w http.ResponseWriter, r *http.Request) {
+	if err := a.ResetKey(r.Context(), r.PathValue("key")); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) handleTemporaryLimits(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	limits, err := a.TemporaryLimits(r.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &TemporaryLimitsResponse{Limits: limits})
+}
+
+func (a *Admin) handleSetTemporaryLimit(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req TemporaryLimitRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&req); err != nil {
+		writeError(w, fmt.Errorf("invalid request body, %v: %w", err, errs.ErrInvalidArguments))
+		return
+	}
+
+	conf := req.LimitConfig
+	conf.Type = r.PathValue("type")
+
+	limit, err := a.SetTemporaryLimit(r.Context(), userID, &conf, time.Duration(req.TTL))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, limit)
+}
+
+func (a *Admin) handleDeleteTemporaryLimit(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.DeleteTemporaryLimit(r.Context(), userID, r.PathValue("type")); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pathUserID parses the user ID of the path, answering 400 Bad Request when it is not valid.
+func pathUserID(w http.ResponseWriter, r *http.Request) (ksuid.KSUID, bool) {
+	userID, err := ksuid.Parse(r.PathValue("user_id"))
+	if err != nil {
+		writeError(w, fmt.Errorf("invalid user ID %q: %w", r.PathValue("user_id"), errs.ErrInvalidArguments))
+		return ksuid.Nil, false
+	}
+
+	return userID, true
+}
+
+// typeStatusResponse returns the response of the status of a notification type.
+func typeStatusResponse(ts *TypeStatus) *TypeStatusResponse {
+	resp := &TypeStatusResponse{
+		Type:         ts.Type,
+		State:        ts.Status.State,
+		Count:        ts.Status.Count,
+		Limit:        ts.Status.Limit,
+		Remaining:    ts.Status.Remaining,
+		RetryAfterMs: ts.Status.RetryAfter.Milliseconds(),
+		Window:       ts.Status.Window,
+		Exempt:       ts.Status.Exempt,
+	}
+	if ts.Status.ExpiresAtMs > 0 {
+		resetAt := time.UnixMilli(ts.Status.ExpiresAtMs).UTC()
+		resp.ResetAt = &resetAt
+	}
+
+	return resp
+}
+
+// writeError writes the error with 400 Bad Request when it is caused by invalid arguments,
+// and with 500 Internal Server Error otherwise.
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if errors.Is(err, errs.ErrInvalidArguments) {
+		code = http.StatusBadRequest
+	}
+
+	writeJSON(w, code, &ErrorResponse{Error: err.Error()})
+}
+
+// writeJSON writes the value as the JSON body of the response with the status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,158 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/godoylucase/rate-limit/models"
+
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+	return rec
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) *T {
+	var v T
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&v))
+
+	return &v
+}
+
+func TestHandler_KeysStatusAndReset(t *testing.T) {
+	a, svc := newAdmin(t, nil)
+	h := a.Handler()
+	userID := ksuid.New()
+	userPath := "/admin/v1/users/" + userID.String()
+
+	require.NoError(t, send(svc, userID, "news"))
+	require.NoError(t, send(svc, userID, "status"))
+
+	rec := serve(h, http.MethodGet, userPath+"/keys", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	keys := decode[KeysResponse](t, rec)
+	assert.Equal(t, []string{"{" + userID.String() + "}-news", "{" + userID.String() + "}-status"}, keys.Keys)
+
+	rec = serve(h, http.MethodGet, userPath+"/status", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	status := decode[StatusResponse](t, rec)
+	require.Len(t, status.Types, 2)
+	assert.Equal(t, "news", status.Types[0].Type)
+	assert.Equal(t, models.Denied, status.Types[0].State)
+	assert.Equal(t, 1, status.Types[0].Count)
+	assert.Zero(t, status.Types[0].Remaining)
+	assert.Positive(t, status.Types[0].RetryAfterMs)
+	assert.NotNil(t, status.Types[0].ResetAt)
+	assert.Equal(t, "status", status.Types[1].Type)
+	assert.Equal(t, 1, status.Types[1].Remaining)
+
+	rec = serve(h, http.MethodDelete, userPath+"/limits/news", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	require.NoError(t, send(svc, userID, "news"))
+
+	rec = serve(h, http.MethodDelete, "/admin/v1/keys/"+keys.Keys[1], "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = serve(h, http.MethodGet, userPath+"/keys", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, keys.Keys[:1], decode[KeysResponse](t, rec).Keys)
+}
+
+func TestHandler_TemporaryLimits(t *testing.T) {
+	a, svc := newAdmin(t, nil)
+	h := a.Handler()
+	userID := ksuid.New()
+	overridesPath := "/admin/v1/users/" + userID.String() + "/overrides"
+
+	rec := serve(h, http.MethodPut, overridesPath+"/news", `{"limit": 2, "window_size": "1m", "ttl": "1h"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	limit := decode[TemporaryLimit](t, rec)
+	assert.Equal(t, "news", limit.Limit.Type)
+	assert.EqualValues(t, 2, limit.Limit.Limit)
+
+	require.NoError(t, send(svc, userID, "news"))
+	require.NoError(t, send(svc, userID, "news"))
+	require.Error(t, send(svc, userID, "news"))
+
+	rec = serve(h, http.MethodGet, overridesPath, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	limits := decode[TemporaryLimitsResponse](t, rec)
+	require.Len(t, limits.Limits, 1)
+	assert.Equal(t, "news", limits.Limits[0].Limit.Type)
+
+	rec = serve(h, http.MethodDelete, overridesPath+"/news", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = serve(h, http.MethodGet, overridesPath, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, decode[TemporaryLimitsResponse](t, rec).Limits)
+}
+
+func TestHandler_Errors(t *testing.T) {
+	a, _ := newAdmin(t, nil)
+	h := a.Handler()
+	overridesPath := "/admin/v1/users/" + ksuid.New().String() + "/overrides"
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{name: "invalid user", method: http.MethodGet, target: "/admin/v1/users/nobody/keys"},
+		{name: "invalid body", method: http.MethodPut, target: overridesPath + "/news", body: `{`},
+		{name: "unknown type", method: http.MethodPut, target: overridesPath + "/marketing", body: `{"limit": 2, "window_size": "1m", "ttl": "1h"}`},
+		{name: "missing ttl", method: http.MethodPut, target: overridesPath + "/news", body: `{"limit": 2, "window_size": "1m"}`},
+		{name: "invalid limit", method: http.MethodPut, target: overridesPath + "/news", body: `{"window_size": "1m", "ttl": "1h"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(h, tt.method, tt.target, tt.body)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.NotEmpty(t, decode[ErrorResponse](t, rec).Error)
+		})
+	}
+}
+
+func TestHandler_BearerToken(t *testing.T) {
+	a, _ := newAdmin(t, nil)
+	h := a.Handler(WithBearerToken("secret"))
+	target := "/admin/v1/users/" + ksuid.New().String() + "/keys"
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      int
+	}{
+		{name: "missing token", wantCode: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer nope", wantCode: http.StatusUnauthorized},
+		{name: "other scheme", authorization: "Basic secret", wantCode: http.StatusUnauthorized},
+		{name: "valid token", authorization: "Bearer secret", wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantCode == http.StatusUnauthorized {
+				assert.Equal(t, `Bearer realm="admin"`, rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/notification"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// OverridesCacheTTL is how long the redis OverrideStore keeps the temporary limits it read for a user, so sending
+// a notification does not read them from redis every time. The changes made through the same store are seen
+// right away, and the ones made by the other instances of the service once the cached limits expire.
+const OverridesCacheTTL = 5 * time.Second
+
+// TemporaryLimit is a limit of a notification type that replaces the one of a user until it expires.
+type TemporaryLimit struct {
+	Limit     *configs.LimitConfig `json:"limit"`
+	ExpiresAt time.Time            `json:"expires_at"`
+}
+
+// OverrideStore keeps the temporary limits of the users. SetLimit replaces the temporary limit of a user for the type
+// of the limit, and Limits returns the ones of a user that did not expire, sorted by type.
+type OverrideStore interface {
+	SetLimit(ctx context.Context, userID string, limit *TemporaryLimit) error
+	DeleteLimit(ctx context.Context, userID, typ string) error
+	Limits(ctx context.Context, userID string) ([]*TemporaryLimit, error)
+}
+
+// NewOverrideStore returns the OverrideStore of the backend, the same way rate_limiter.GetWithBackend does.
+// The redis store shares the temporary limits among every instance of the service, caching them for
+// OverridesCacheTTL, while the memory one keeps them within the current process, and it does not use the redis
+// client, so it can be nil.
+func NewOverrideStore(backend string, cli redis.UniversalClient) OverrideStore {
+	if backend == rate_limiter.MemoryBackend {
+		return newMemoryOverrideStore()
+	}
+
+	return newCachedOverrideStore(newRedisOverrideStore(cli), OverridesCacheTTL)
+}
+
+// Overrides returns the OverrideProvider of the notification service that applies the temporary limits of a user
+// on top of the override the base provider finds for it, which may be nil. The limits of the other notification types
+// are the ones of the base override, and a user the base override exempts stays exempt.
+func Overrides(store OverrideStore, base notification.OverrideProvider) notification.OverrideProvider {
+	return &overrides{store: store, base: base}
+}
+
+// overrides is the OverrideProvider returned by Overrides.
+type overrides struct {
+	store OverrideStore
+	base  notification.OverrideProvider
+}
+
+// Override returns the override of the user, with its temporary limits replacing the ones of the base override.
+func (o *overrides) Override(ctx context.Context, userID, tenantID string) (*configs.Override, error) {
+	var override *configs.Override
+	if o.base != nil {
+		var err error
+		if override, err = o.base.Override(ctx, userID, tenantID); err != nil {
+			return nil, err
+		}
+	}
+
+	limits, err := o.store.Limits(ctx, userID)
+	if err != nil {
+		return nil, err
+	} else if len(limits) == 0 || (override != nil && override.Exempt) {
+		return override, nil
+	}
+
+	merged := &configs.Override{UserID: userID}
+	if override != nil {
+		copied := *override
+		merged = &copied
+		merged.Limits = nil
+	}
+
+	temporary := make(map[string]bool, len(limits))
+	for _, limit := range limits {
+		temporary[limit.Limit.Type] = true
+		merged.Limits = append(merged.Limits, limit.Limit)
+	}
+	if override != nil {
+		for _, conf := range override.Limits {
+			if !temporary[conf.Type] {
+				merged.Limits = append(merged.Limits, conf)
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// redisSetLimitScript sets the temporary limit stored at the field ARGV[1] of the hash KEYS[1] to ARGV[2],
+// extending the expiration of the hash to ARGV[3] milliseconds when it would expire earlier, so it lasts as long
+// as its longest limit. The hash has no expiration right after it is created, which PTTL reports as -1.
+var redisSetLimitScript = redis.NewScript(`
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+local ttl = tonumber(ARGV[3])
+if redis.call('PTTL', KEYS[1]) < ttl then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+end
+return 1
+`)
+
+// redisOverrideStore keeps the temporary limits of every user in a hash keyed by type, which shares the cluster
+// hash tag of the rate limit keys of the user. The limits that expired are skipped when they are read, and the hash
+// expires along with the last one.
+type redisOverrideStore struct {
+	redis redis.UniversalClient
+	now   func() time.Time
+}
+
+func newRedisOverrideStore(redis redis.UniversalClient) *redisOverrideStore {
+	return &redisOverrideStore{
+		redis: redis,
+		now:   time.Now,
+	}
+}
+
+// SetLimit replaces the temporary limit of the user for the type of the limit.
+func (rs *redisOverrideStore) SetLimit(ctx context.Context, userID string, limit *TemporaryLimit) error {
+	value, err := json.Marshal(limit)
+	if err != nil {
+		return fmt.Errorf("failed to encode temporary limit for user: %v with error: %w", userID, err)
+	}
+
+	ttl := limit.ExpiresAt.Sub(rs.now()).Milliseconds()
+	if ttl < 1 {
+		return nil
+	}
+
+	key := overridesKey(userID)
+	if err := redisSetLimitScript.Run(ctx, rs.redis, []string{key}, limit.Limit.Type, value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set temporary limit for key: %v with error: %w", key, err)
+	}
+
+	return nil
+}
+
+// DeleteLimit removes the temporary limit of the user for the type.
+func (rs *redisOverrideStore) DeleteLimit(ctx context.Context, userID, typ string) error {
+	key := overridesKey(userID)
+	if err := rs.redis.HDel(ctx, key, typ).Err(); err != nil {
+		return fmt.Errorf("failed to delete temporary limit for key: %v with error: %w", key, err)
+	}
+
+	return nil
+}
+
+// Limits returns the temporary limits of the user that did not expire, sorted by type.
+func (rs *redisOverrideStore) Limits(ctx context.Context, userID string) ([]*TemporaryLimit, error) {
+	key := overridesKey(userID)
+	values, err := rs.redis.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get temporary limits for key: %v with error: %w", key, err)
+	}
+
+	now := rs.now()
+	limits := make([]*TemporaryLimit, 0, len(values))
+	for typ, value := range values {
+		var limit TemporaryLimit
+		if err := json.Unmarshal([]byte(value), &limit); err != nil {
+			return nil, fmt.Errorf("failed to decode temporary limit %v for key: %v with error: %w", typ, key, err)
+		}
+		if now.Before(limit.ExpiresAt) {
+			limits = append(limits, &limit)
+		}
+	}
+
+	return sortedLimits(limits), nil
+}
+
+// memoryOverrideStore keeps the temporary limits of every user within the current process.
+type memoryOverrideStore struct {
+	mu     sync.Mutex
+	limits map[string]map[string]*TemporaryLimit
+	now    func() time.Time
+}
+
+func newMemoryOverrideStore() *memoryOverrideStore {
+	return &memoryOverrideStore{
+		limits: make(map[string]map[string]*TemporaryLimit),
+		now:    time.Now,
+	}
+}
+
+// SetLimit replaces the temporary limit of the user for the type of the limit.
+func (ms *memoryOverrideStore) SetLimit(_ context.Context, userID string, limit *TemporaryLimit) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.limits[userID] == nil {
+		ms.limits[userID] = make(map[string]*TemporaryLimit)
+	}
+	ms.limits[userID][limit.Limit.Type] = limit
+
+	return nil
+}
+
+// DeleteLimit removes the temporary limit of the user for the type.
+func (ms *memoryOverrideStore) DeleteLimit(_ context.Context, userID, typ string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.limits[userID], typ)
+	if len(ms.limits[userID]) == 0 {
+		delete(ms.limits, userID)
+	}
+
+	return nil
+}
+
+// Limits returns the temporary limits of the user that did not expire, sorted by type.
+// The expired ones are removed as they are found.
+func (ms *memoryOverrideStore) Limits(_ context.Context, userID string) ([]*TemporaryLimit, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := ms.now()
+	limits := make([]*TemporaryLimit, 0, len(ms.limits[userID]))
+	for typ, limit := range ms.limits[userID] {
+		if !now.Before(limit.ExpiresAt) {
+			delete(ms.limits[userID], typ)
+			continue
+		}
+		limits = append(limits, limit)
+	}
+	if len(ms.limits[userID]) == 0 {
+		delete(ms.limits, userID)
+	}
+
+	return sortedLimits(limits), nil
+}
+
+// cachedLimits are the temporary limits of a user read from a store, which are used until expiresAt.
+type cachedLimits struct {
+	limits    []*TemporaryLimit
+	expiresAt time.Time
+}
+
+// cachedOverrideStore caches the temporary limits the store returns for every user during ttl, users without any
+// included. The limits of a user are dropped from the cache when they are changed through it, and the cached limits
+// that expired are swept once every ttl, so the cache only holds the users seen lately.
+type cachedOverrideStore struct {
+	store OverrideStore
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cachedLimits
+	swept   time.Time
+}
+
+func newCachedOverrideStore(store OverrideStore, ttl time.Duration) *cachedOverrideStore {
+	return &cachedOverrideStore{
+		store:   store,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*cachedLimits),
+	}
+}
+
+// SetLimit replaces the temporary limit of the user for the type of the limit.
+func (cs *cachedOverrideStore) SetLimit(ctx context.Context, userID string, limit *TemporaryLimit) error {
+	defer cs.invalidate(userID)
+	return cs.store.SetLimit(ctx, userID, limit)
+}
+
+// DeleteLimit removes the temporary limit of the user for the type.
+func (cs *cachedOverrideStore) DeleteLimit(ctx context.Context, userID, typ string) error {
+	defer cs.invalidate(userID)
+	return cs.store.DeleteLimit(ctx, userID, typ)
+}
+
+// Limits returns the temporary limits of the user that did not expire, sorted by type, reading them from the store
+// when they are not cached.
+func (cs *cachedOverrideStore) Limits(ctx context.Context, userID string) ([]*TemporaryLimit, error) {
+	now := cs.now()
+
+	cs.mu.Lock()
+	entry, ok := cs.entries[userID]
+	cs.mu.Unlock()
+
+	if !ok || !now.Before(entry.expiresAt) {
+		limits, err := cs.store.Limits(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		entry = &cachedLimits{limits: limits, expiresAt: now.Add(cs.ttl)}
+		cs.mu.Lock()
+		cs.entries[userID] = entry
+		cs.sweep(now)
+		cs.mu.Unlock()
+	}
+
+	limits := make([]*TemporaryLimit, 0, len(entry.limits))
+	for _, limit := range entry.limits {
+		if now.Before(limit.ExpiresAt) {
+			limits = append(limits, limit)
+		}
+	}
+
+	return limits, nil
+}
+
+// invalidate drops the cached limits of the user.
+func (cs *cachedOverrideStore) invalidate(userID string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	delete(cs.entries, userID)
+}
+
+// sweep drops the cached limits that expired, once every ttl at most. It must be called with the lock held.
+func (cs *cachedOverrideStore) sweep(now time.Time) {
+	if now.Sub(cs.swept) < cs.ttl {
+		return
+	}
+
+	for userID, entry := range cs.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(cs.entries, userID)
+		}
+	}
+	cs.swept = now
+}
+
+// overridesKey returns the key of the temporary limits of a user, which shares the cluster hash tag
+// of its rate limit keys but never shows up among them.
+func overridesKey(userID string) string {
+	return fmt.Sprintf("ratelimit:overrides:{%v}", userID)
+}
+
+// sortedLimits sorts the temporary limits by type.
+func sortedLimits(limits []*TemporaryLimit) []*TemporaryLimit {
+	sort.Slice(limits, func(i, j int) bool {
+		return limits[i].Limit.Type < limits[j].Limit.Type
+	})
+
+	return limits
+}
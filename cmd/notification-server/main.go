@@ -0,0 +1,173 @@
+/*
+Command notification-server serves the notification API over HTTP, sending the notifications through the gateway
+of the configuration within its rate limits. The admin API, which inspects and resets the rate limits of the users
+and sets their temporary limits, is served on its own address when -admin-addr is set, so it can be kept private.
+It requires the bearer token of the ADMIN_TOKEN environment variable when it is set.
+The OpenTelemetry spans of the notifications and the rate limiter checks are written to stderr as JSON lines
+when the OTEL_TRACES_EXPORTER environment variable is console, and tracing is off when it is empty or none.
+The metrics of the rate limits are served in the Prometheus text exposition format at /metrics.
+The RATELIMIT_* environment variables replace the values of the configuration file, as they do for ratelimitctl.
+
+Usage:
+
+	notification-server -config config.json -addr :8080 -admin-addr 127.0.0.1:8081
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/godoylucase/rate-limit/admin"
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/gateway"
+	"github.com/godoylucase/rate-limit/metrics"
+	"github.com/godoylucase/rate-limit/notification"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+	"github.com/godoylucase/rate-limit/server"
+	"github.com/godoylucase/rate-limit/tracing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// shutdownTimeout is how long the requests being served are waited for when the server stops.
+const shutdownTimeout = 10 * time.Second
+
+// adminTokenEnv is the environment variable holding the bearer token of the admin API.
+const adminTokenEnv = "ADMIN_TOKEN"
+
+func main() {
+	configPath := flag.String("config", "config.json", "path of the configuration file")
+	addr := flag.String("addr", ":8080", "address the server listens on")
+	adminAddr := flag.String("admin-addr", "", "address the admin API listens on, disabled when empty")
+	flag.Parse()
+
+	if err := run(*configPath, *addr, *adminAddr); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the notification API on the address, and the admin API on the admin address when it is not empty,
+// until the process is interrupted.
+func run(configPath, addr, adminAddr string) error {
+	conf, _, err := configs.NewLoader(configPath).Load()
+	if err != nil {
+		return err
+	}
+
+	gw, err := gateway.New(conf.Gateway)
+	if err != nil {
+		return err
+	}
+
+	// The memory backend needs no redis section
+	var opts []server.Option
+	var redisCli redis.UniversalClient
+	if conf.RateLimiterBackend != rate_limiter.MemoryBackend && conf.Redis != nil {
+		redisCli = conf.Redis.Client()
+		defer redisCli.Close()
+
+		opts = append(opts, server.WithReadinessCheck(func(ctx context.Context) error {
+			return redisCli.Ping(ctx).Err()
+		}))
+	}
+
+	rlimiter, err := rate_limiter.New(conf.RateLimiterBackend, conf.RateLimiterType, redisCli)
+	if err != nil {
+		return err
+	}
+	store := admin.NewOverrideStore(conf.RateLimiterBackend, redisCli)
+
+	prom, err := metrics.NewPrometheus(nil)
+	if err != nil {
+		return err
+	}
+	rlimiter = metrics.RateLimiter(rlimiter, conf.RateLimiterType, prom)
+
+	svcOpts := []notification.Option{
+		notification.WithGlobalLimit(conf.Global),
+		notification.WithOverrideProvider(admin.Overrides(store, conf.Overrides)),
+		notification.WithMetrics(prom, conf.RateLimiterType),
+	}
+
+	tp, err := tracerProviderFromEnv()
+	if err != nil {
+		return err
+	}
+	if tp != nil {
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := tp.Shutdown(ctx); err != nil {
+				log.Printf("error flushing the spans: %v", err)
+			}
+		}()
+
+		rlimiter = tracing.RateLimiter(rlimiter, conf.RateLimiterType, tp)
+		svcOpts = append(svcOpts, notification.WithTracing(tp, conf.RateLimiterType))
+	}
+
+	svc := notification.NewService(rlimiter, gw, conf.Limits, svcOpts...)
+	defer svc.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", prom.Handler())
+	mux.Handle("/", server.New(svc, opts...))
+
+	servers := []*http.Server{{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}}
+	if adminAddr != "" {
+		token := os.Getenv(adminTokenEnv)
+		if token == "" {
+			log.Printf("the admin API is served without authentication, set %v to require a bearer token", adminTokenEnv)
+		}
+
+		servers = append(servers, &http.Server{
+			Addr:              adminAddr,
+			Handler:           admin.New(svc, rlimiter, store).Handler(admin.WithBearerToken(token)),
+			ReadHeaderTimeout: 10 * time.Second,
+		})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			log.Printf("notification server listening on %v", srv.Addr)
+			errCh <- srv.ListenAndServe()
+		}(srv)
+	}
+
+	var serveErr error
+	select {
+	case serveErr = <-errCh:
+	case <-ctx.Done():
+	}
+
+	log.Printf("notification server shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil && serveErr == nil {
+			serveErr = err
+		}
+	}
+	if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// tracesExporterEnv is the standard OpenTelemetry environment variable that chooses where the spans go.
+const tracesExporterEnv = "OTEL_TRACES_EXPORTER"
+
+// The exporters of tracesExporterEnv, which turns tracing off when it is empty.
+const (
+	exporterNone    = "none"
+	exporterConsole = "console"
+)
+
+// newTracerProvider returns the tracer provider of the exporter, or nil when tracing is off.
+// The console exporter writes every span to w as a JSON line. The provider must be shut down to flush its spans.
+func newTracerProvider(exporter string, w io.Writer) (*sdktrace.TracerProvider, error) {
+	switch exporter {
+	case "", exporterNone:
+		return nil, nil
+	case exporterConsole:
+		return sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(&consoleExporter{encoder: json.NewEncoder(w)}),
+			sdktrace.WithResource(resource.Default()),
+		), nil
+	default:
+		return nil, fmt.Errorf("unsupported %v %q, must be %v or %v", tracesExporterEnv, exporter, exporterNone, exporterConsole)
+	}
+}
+
+// tracerProviderFromEnv returns the tracer provider chosen by the OTEL_TRACES_EXPORTER environment variable,
+// which writes the spans to stderr, or nil when tracing is off.
+func tracerProviderFromEnv() (*sdktrace.TracerProvider, error) {
+	return newTracerProvider(os.Getenv(tracesExporterEnv), os.Stderr)
+}
+
+// consoleSpan is a span as the console exporter writes it.
+type consoleSpan struct {
+	Name       string                 `json:"name"`
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Start      time.Time              `json:"start"`
+	DurationMs float64                `json:"duration_ms"`
+	Status     string                 `json:"status"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// consoleExporter is a span exporter that writes every span as a JSON line.
+type consoleExporter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// ExportSpans writes the spans.
+func (ce *consoleExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	ce.mu.Lock()
+	defer ce.mu.Unlock()
+
+	for _, span := range spans {
+		cs := consoleSpan{
+			Name:       span.Name(),
+			TraceID:    span.SpanContext().TraceID().String(),
+			SpanID:     span.SpanContext().SpanID().String(),
+			Start:      span.StartTime(),
+			DurationMs: float64(span.EndTime().Sub(span.StartTime()).Microseconds()) / 1000,
+			Status:     span.Status().Code.String(),
+		}
+		if span.Parent().IsValid() {
+			cs.ParentID = span.Parent().SpanID().String()
+		}
+		if attrs := span.Attributes(); len(attrs) > 0 {
+			cs.Attributes = make(map[string]interface{}, len(attrs))
+			for _, attr := range attrs {
+				cs.Attributes[string(attr.Key)] = attr.Value.AsInterface()
+			}
+		}
+
+		if err := ce.encoder.Encode(&cs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Shutdown does nothing, since the spans are written as they are exported.
+func (ce *consoleExporter) Shutdown(context.Context) error {
+	return nil
+}
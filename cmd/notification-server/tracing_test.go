@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestNewTracerProvider(t *testing.T) {
+	for _, exporter := range []string{"", exporterNone} {
+		tp, err := newTracerProvider(exporter, nil)
+		require.NoError(t, err)
+		assert.Nil(t, tp)
+	}
+
+	_, err := newTracerProvider("otlp", nil)
+	assert.Error(t, err)
+
+	var out bytes.Buffer
+	tp, err := newTracerProvider(exporterConsole, &out)
+	require.NoError(t, err)
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "Service.Send")
+	_, child := tp.Tracer("test").Start(ctx, "Gateway.Send")
+	child.SetAttributes(attribute.String("notification.type", "news"))
+	child.End()
+	parent.End()
+	require.NoError(t, tp.Shutdown(context.Background()))
+
+	var spans []consoleSpan
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var span consoleSpan
+		require.NoError(t, decoder.Decode(&span))
+		spans = append(spans, span)
+	}
+
+	require.Len(t, spans, 2)
+	assert.Equal(t, "Gateway.Send", spans[0].Name)
+	assert.Equal(t, "news", spans[0].Attributes["notification.type"])
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentID)
+	assert.Equal(t, spans[1].TraceID, spans[0].TraceID)
+	assert.Equal(t, "Service.Send", spans[1].Name)
+	assert.Empty(t, spans[1].ParentID)
+}
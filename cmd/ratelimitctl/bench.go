@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+
+	"github.com/segmentio/ksuid"
+)
+
+// benchResult is the outcome of a benchmark.
+type benchResult struct {
+	allowed   atomic.Int64
+	denied    atomic.Int64
+	failed    atomic.Int64
+	elapsed   time.Duration
+	latencies []time.Duration
+}
+
+// bench checks the rate limits of synthetic users as fast as it can, spreading the requests among them, and reports
+// the decisions along with the throughput and the latency of the checks. Every user gets fresh keys, which are reset
+// once the benchmark ends.
+func bench(ctx context.Context, args []string, out, errOut io.Writer) error {
+	fs := newFlagSet("bench", errOut)
+	cf := addConfigFlags(fs)
+	algorithm := fs.String("algorithm", "", "rate limiter algorithm, the configured one when empty")
+	typ := fs.String("type", "", "notification type whose limits are checked, the first one in order when empty")
+	users := fs.Int("users", 100, "number of users the requests are spread among")
+	requests := fs.Int("requests", 10000, "number of requests")
+	concurrency := fs.Int("concurrency", 8, "number of requests checked at the same time")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	if *algorithm != "" && !rate_limiter.IsValidType(*algorithm) {
+		return fmt.Errorf("unknown rate limiter algorithm %q, must be one of %v", *algorithm, rate_limiter.Types)
+	}
+	if *users < 1 || *requests < 1 || *concurrency < 1 {
+		fmt.Fprintln(errOut, "-users, -requests and -concurrency must be positive")
+		fs.Usage()
+		return errUsage
+	}
+
+	conf, _, err := cf.load()
+	if err != nil {
+		return err
+	}
+
+	lconf, err := benchLimit(conf, *typ)
+	if err != nil {
+		return err
+	}
+
+	b, err := newBackend(conf, *algorithm)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	if err := b.ping(ctx); err != nil {
+		return err
+	}
+
+	userWindows := make([][]rate_limiter.Window, *users)
+	for i := range userWindows {
+		userWindows[i] = lconf.RateLimiterWindows(configs.LimitKey(ksuid.New().String(), lconf.Type))
+	}
+	defer func() {
+		// the context may be done already, and the keys have to be removed anyway
+		for _, windows := range userWindows {
+			for _, window := range windows {
+				_ = b.rlimiter.Reset(context.Background(), window.Key)
+			}
+		}
+	}()
+
+	result := runBench(ctx, b.rlimiter, userWindows, *requests, *concurrency)
+
+	name := *algorithm
+	if name == "" {
+		name = conf.RateLimiterType
+	}
+	writeBenchResult(out, name, lconf.Type, result)
+
+	return ctx.Err()
+}
+
+// benchLimit returns the limit of the notification type, or the one of the first type in order when it is empty.
+func benchLimit(conf *configs.NotificationService, typ string) (*configs.LimitConfig, error) {
+	if typ == "" {
+		types := make([]string, 0, len(conf.Limits))
+		for t := range conf.Limits {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		if len(types) == 0 {
+			return nil, fmt.Errorf("the configuration has no notification types")
+		}
+		typ = types[0]
+	}
+
+	lconf := conf.Limits.Get(typ)
+	if lconf == nil {
+		return nil, fmt.Errorf("notification type %v not found in config", typ)
+	}
+
+	return lconf, nil
+}
+
+// runBench checks the requests with the workers, taking the users in turns, until all of them are checked
+// or the context is done.
+func runBench(ctx context.Context, rlimiter rate_limiter.RateLimiter, userWindows [][]rate_limiter.Window, requests, concurrency int) *benchResult {
+	result := &benchResult{latencies: make([]time.Duration, requests)}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				i := int(next.Add(1)) - 1
+				if i >= requests {
+					return
+				}
+
+				checkStart := time.Now()
+				statuses, err := rlimiter.CheckLimitsN(ctx, userWindows[i%len(userWindows)], 1)
+				result.latencies[i] = time.Since(checkStart)
+
+				switch {
+				case err != nil:
+					result.failed.Add(1)
+				case rate_limiter.Allowed(statuses):
+					result.allowed.Add(1)
+				default:
+					result.denied.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	result.elapsed = time.Since(start)
+
+	// the requests left out when the context is done have no latency
+	checked := int(result.allowed.Load() + result.denied.Load() + result.failed.Load())
+	result.latencies = result.latencies[:min(checked, requests)]
+
+	return result
+}
+
+// writeBenchResult writes the decisions, throughput and latency percentiles of the benchmark.
+func writeBenchResult(out io.Writer, algorithm, typ string, result *benchResult) {
+	checked := len(result.latencies)
+	sort.Slice(result.latencies, func(i, j int) bool { return result.latencies[i] < result.latencies[j] })
+
+	fmt.Fprintf(out, "algorithm: %v, type: %v\n", algorithm, typ)
+	fmt.Fprintf(out, "requests: %v, allowed: %v, denied: %v, errors: %v\n",
+		checked, result.allowed.Load(), result.denied.Load(), result.failed.Load())
+	if checked == 0 {
+		return
+	}
+
+	fmt.Fprintf(out, "elapsed: %v, throughput: %.0f req/s\n",
+		result.elapsed.Round(time.Millisecond), float64(checked)/result.elapsed.Seconds())
+	fmt.Fprintf(out, "latency p50: %v, p90: %v, p99: %v, max: %v\n",
+		percentile(result.latencies, 50), percentile(result.latencies, 90),
+		percentile(result.latencies, 99), result.latencies[checked-1].Round(time.Microsecond))
+}
+
+// percentile returns the p-th percentile of the sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	idx := (len(sorted)*p+99)/100 - 1
+	if idx < 0 {
+		idx = 0
+	}
+
+	return sorted[idx].Round(time.Microsecond)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/godoylucase/rate-limit/admin"
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/errs"
+	"github.com/godoylucase/rate-limit/gateway"
+	"github.com/godoylucase/rate-limit/models"
+
+	"github.com/segmentio/ksuid"
+)
+
+// validate checks the configuration, listing every problem found, or summing it up when it is valid.
+func validate(_ context.Context, args []string, out, errOut io.Writer) error {
+	fs := newFlagSet("validate", errOut)
+	cf := addConfigFlags(fs)
+	origins := fs.Bool("origins", false, "list where every value set comes from")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	conf, valueOrigins, err := cf.load()
+	var verr *configs.ValidationError
+	if errors.As(err, &verr) {
+		for _, problem := range verr.Problems {
+			fmt.Fprintln(out, problem)
+		}
+		return fmt.Errorf("configuration %v is not valid, %v problem(s) found", cf.path, len(verr.Problems))
+	} else if err != nil {
+		return err
+	}
+
+	types := make([]string, 0, len(conf.Limits))
+	for typ := range conf.Limits {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	backend := conf.RateLimiterBackend
+	if backend == "" {
+		backend = "redis"
+	}
+
+	fmt.Fprintf(out, "configuration %v is valid\n", cf.path)
+	fmt.Fprintf(out, "backend: %v, algorithm: %v, gateway: %v\n", backend, conf.RateLimiterType, conf.Gateway.Type)
+	fmt.Fprintf(out, "notification types: %v\n", strings.Join(types, ", "))
+	fmt.Fprintf(out, "global limit: %v, overrides: %v\n", conf.Global != nil, len(conf.Overrides))
+
+	if *origins {
+		keys := make([]string, 0, len(valueOrigins))
+		for key := range valueOrigins {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			origin := valueOrigins[key]
+			fmt.Fprintf(out, "%v: %v %v\n", key, origin.Source, origin.Name)
+		}
+	}
+
+	return nil
+}
+
+// status shows the rate limit status of a user for a notification type, or for every one of them,
+// without using any of its rate limit.
+func status(ctx context.Context, args []string, out, errOut io.Writer) error {
+	fs := newFlagSet("status", errOut)
+	cf := addConfigFlags(fs)
+	user := fs.String("user", "", "ID of the user (required)")
+	tenant := fs.String("tenant", "", "ID of the tenant of the user, to look up its overrides")
+	typ := fs.String("type", "", "notification type, every one of them when empty")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	userID, err := parseUserID(*user)
+	if err != nil {
+		return err
+	}
+
+	b, err := connect(ctx, cf, "status")
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	svc := b.service(&gateway.Log{})
+	adm := admin.New(svc, b.rlimiter, b.store)
+
+	var statuses []*admin.TypeStatus
+	if *typ == "" {
+		if statuses, err = adm.Status(ctx, userID, *tenant); err != nil {
+			return err
+		}
+	} else {
+		st, err := svc.Status(ctx, userID, *tenant, *typ)
+		if err != nil {
+			return err
+		}
+		statuses = []*admin.TypeStatus{{Type: *typ, Status: st}}
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tSTATE\tCOUNT\tLIMIT\tREMAINING\tRESET\tRETRY AFTER")
+	for _, ts := range statuses {
+		st := ts.Status
+		if st.Exempt {
+			fmt.Fprintf(tw, "%v\texempt\t-\t-\t-\t-\t-\n", ts.Type)
+			continue
+		}
+
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			ts.Type, st.State, st.Count, st.Limit, st.Remaining, resetIn(st), st.RetryAfter.Round(time.Millisecond))
+	}
+
+	return tw.Flush()
+}
+
+// reset resets a rate limit key, or every key of a user for a notification type.
+func reset(ctx context.Context, args []string, out, errOut io.Writer) error {
+	fs := newFlagSet("reset", errOut)
+	cf := addConfigFlags(fs)
+	key := fs.String("key", "", "rate limit key to reset, as listed by the admin API")
+	user := fs.String("user", "", "ID of the user whose rate limit of the type is reset, instead of a key")
+	tenant := fs.String("tenant", "", "ID of the tenant of the user, to look up its overrides")
+	typ := fs.String("type", "", "notification type whose rate limit of the user is reset")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	if (*key == "") == (*user == "" && *typ == "") {
+		fmt.Fprintln(errOut, "either -key or -user along with -type must be set")
+		fs.Usage()
+		return errUsage
+	}
+
+	var userID ksuid.KSUID
+	if *key == "" {
+		var err error
+		if userID, err = parseUserID(*user); err != nil {
+			return err
+		}
+	}
+
+	b, err := connect(ctx, cf, "reset")
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	adm := admin.New(b.service(&gateway.Log{}), b.rlimiter, b.store)
+	if *key != "" {
+		if err := adm.ResetKey(ctx, *key); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "rate limit key %v reset\n", *key)
+		return nil
+	}
+
+	if err := adm.Reset(ctx, userID, *tenant, *typ); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "rate limit of user %v for %v reset\n", userID, *typ)
+
+	return nil
+}
+
+// send sends test notifications through the notification service of the configuration, within its rate limits,
+// logging them instead of delivering them.
+func send(ctx context.Context, args []string, out, errOut io.Writer) error {
+	fs := newFlagSet("send", errOut)
+	cf := addConfigFlags(fs)
+	user := fs.String("user", "", "ID of the user, a new one when empty")
+	tenant := fs.String("tenant", "", "ID of the tenant of the user, to look up its overrides")
+	typ := fs.String("type", "", "notification type (required)")
+	message := fs.String("message", "test notification from ratelimitctl", "message of the notification")
+	count := fs.Int("count", 1, "number of notifications to send")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	if *typ == "" || *count < 1 {
+		fmt.Fprintln(errOut, "-type must be set and -count must be positive")
+		fs.Usage()
+		return errUsage
+	}
+
+	userID := ksuid.New()
+	if *user != "" {
+		var err error
+		if userID, err = parseUserID(*user); err != nil {
+			return err
+		}
+	}
+
+	conf, _, err := cf.load()
+	if err != nil {
+		return err
+	}
+
+	b, err := newBackend(conf, "")
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	if err := b.ping(ctx); err != nil {
+		return err
+	}
+
+	svc := b.service(&gateway.Log{Logger: log.New(out, "", log.LstdFlags)})
+	defer svc.Close()
+
+	denied := 0
+	for i := 1; i <= *count; i++ {
+		notif := &models.Notification{Type: *typ, UserID: userID, TenantID: *tenant, Message: *message}
+
+		st, err := svc.SendWithStatus(ctx, notif)
+		var errLimit *errs.ErrExceededRateLimit
+		switch {
+		case errors.As(err, &errLimit):
+			denied++
+			fmt.Fprintf(out, "notification %v denied: %v/%v used in %v, retry after %v\n",
+				i, errLimit.Count, errLimit.Limit, errLimit.WindowName, errLimit.RetryAfter.Round(time.Millisecond))
+		case err != nil:
+			return err
+		case st.Exempt:
+			fmt.Fprintf(out, "notification %v allowed: user %v is exempt\n", i, userID)
+		default:
+			fmt.Fprintf(out, "notification %v allowed: %v/%v used in %v, %v remaining\n",
+				i, st.Count, st.Limit, st.Window, st.Remaining)
+		}
+	}
+
+	if denied > 0 {
+		return fmt.Errorf("%v of %v notification(s) for user %v were rate limited", denied, *count, userID)
+	}
+
+	return nil
+}
+
+// connect loads the configuration and connects to its rate limiter, which must be shared among the processes.
+func connect(ctx context.Context, cf *configFlags, cmd string) (*backend, error) {
+	conf, _, err := cf.load()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := newBackend(conf, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := b.requireShared(cmd); err != nil {
+		_ = b.Close()
+		return nil, err
+	}
+	if err := b.ping(ctx); err != nil {
+		_ = b.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// parseUserID parses the ID of a user given by a flag.
+func parseUserID(user string) (ksuid.KSUID, error) {
+	if user == "" {
+		return ksuid.Nil, fmt.Errorf("-user must be set: %w", errs.ErrInvalidArguments)
+	}
+
+	userID, err := ksuid.Parse(user)
+	if err != nil {
+		return ksuid.Nil, fmt.Errorf("invalid user ID %q: %w", user, errs.ErrInvalidArguments)
+	}
+
+	return userID, nil
+}
+
+// resetIn returns how long until the window of the status expires, or "-" when it is not known or already expired.
+func resetIn(st *models.RateLimitStatus) string {
+	d := time.Until(time.UnixMilli(st.ExpiresAtMs)).Round(time.Millisecond)
+	if st.ExpiresAtMs <= 0 || d <= 0 {
+		return "-"
+	}
+
+	return d.String()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/godoylucase/rate-limit/admin"
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/notification"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// configFlags are the flags every command loads its configuration with.
+type configFlags struct {
+	path     string
+	strict   bool
+	settings [][2]string
+}
+
+// addConfigFlags adds the configuration flags to the flag set.
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	cf := &configFlags{}
+	fs.StringVar(&cf.path, "config", "config.json", "path of the configuration file")
+	fs.BoolVar(&cf.strict, "strict", false, "reject the fields of the configuration file that are not known")
+	fs.Func("set", "configuration setting as key=value, which can be repeated", func(kv string) error {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid setting %q, must be key=value", kv)
+		}
+
+		cf.settings = append(cf.settings, [2]string{key, value})
+		return nil
+	})
+
+	return cf
+}
+
+// load loads the configuration file along with the environment variables and the -set settings,
+// returning the origin of every value set.
+func (cf *configFlags) load() (*configs.NotificationService, configs.Origins, error) {
+	var opts []configs.LoadOption
+	if cf.strict {
+		opts = append(opts, configs.Strict())
+	}
+
+	loader := configs.NewLoader(cf.path, opts...)
+	for _, setting := range cf.settings {
+		loader.Set(setting[0], setting[1])
+	}
+
+	return loader.Load()
+}
+
+// backend is the rate limiter of a configuration, along with the store of its temporary limits.
+type backend struct {
+	conf     *configs.NotificationService
+	redis    redis.UniversalClient
+	rlimiter rate_limiter.RateLimiter
+	store    admin.OverrideStore
+}
+
+// newBackend returns the rate limiter of the configuration, of the algorithm given by typ, or the configured one
+// when it is empty. It fails for an unknown algorithm or backend. Close must be called once it is no longer used.
+func newBackend(conf *configs.NotificationService, typ string) (*backend, error) {
+	if typ == "" {
+		typ = conf.RateLimiterType
+	}
+
+	b := &backend{conf: conf}
+	if conf.RateLimiterBackend != rate_limiter.MemoryBackend {
+		b.redis = conf.Redis.Client()
+	}
+
+	rlimiter, err := rate_limiter.New(conf.RateLimiterBackend, typ, b.redis)
+	if err != nil {
+		_ = b.Close()
+		return nil, err
+	}
+	b.rlimiter = rlimiter
+	b.store = admin.NewOverrideStore(conf.RateLimiterBackend, b.redis)
+
+	return b, nil
+}
+
+// requireShared fails for the memory backend, whose rate limits only live within the process running the command,
+// so there is nothing to inspect nor to reset.
+func (b *backend) requireShared(cmd string) error {
+	if b.conf.RateLimiterBackend == rate_limiter.MemoryBackend {
+		return fmt.Errorf("%v needs the rate limits to be kept in redis, the memory backend keeps them within each process", cmd)
+	}
+
+	return nil
+}
+
+// service returns the notification service of the configuration, which sends the notifications through the gateway
+// and applies the same global limit and overrides as the notification server, temporary limits included.
+func (b *backend) service(gateway notification.Gateway) *notification.Service {
+	return notification.NewService(b.rlimiter, gateway, b.conf.Limits,
+		notification.WithGlobalLimit(b.conf.Global),
+		notification.WithOverrideProvider(admin.Overrides(b.store, b.conf.Overrides)),
+		notification.WithAlgorithm(b.conf.RateLimiterType),
+	)
+}
+
+// ping checks that redis answers, so an unreachable one is reported before running the command.
+func (b *backend) ping(ctx context.Context) error {
+	if b.redis == nil {
+		return nil
+	}
+
+	if err := b.redis.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("error connecting to redis: %w", err)
+	}
+
+	return nil
+}
+
+// Close releases the rate limiter and the redis client.
+func (b *backend) Close() error {
+	if closer, ok := b.rlimiter.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	if b.redis != nil {
+		return b.redis.Close()
+	}
+
+	return nil
+}
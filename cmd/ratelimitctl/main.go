@@ -0,0 +1,119 @@
+/*
+Command ratelimitctl runs the everyday operations on the rate limits of the notification service, so they do not need
+any Go code nor a redis client.
+
+Usage:
+
+	ratelimitctl <command> [flags]
+
+The commands are:
+
+	validate   checks a configuration file, listing every problem found
+	status     shows the rate limit status of a user for a notification type, or for all of them
+	reset      resets a rate limit key, or the rate limit of a user for a notification type
+	send       sends a test notification through the notification service, logging it instead of delivering it
+	bench      generates synthetic load against a rate limiter algorithm
+
+Every command takes the -config flag with the path of the configuration file, whose values can be overridden with
+the RATELIMIT_* environment variables and with -set flags, such as -set redis.host=10.0.0.5.
+Run "ratelimitctl <command> -h" for the flags of a command.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+)
+
+// command runs a subcommand with its arguments, writing its output to out and its usage and errors to errOut.
+type command func(ctx context.Context, args []string, out, errOut io.Writer) error
+
+var commands = map[string]command{
+	"validate": validate,
+	"status":   status,
+	"reset":    reset,
+	"send":     send,
+	"bench":    bench,
+}
+
+// errUsage is returned when the command line is not valid, once its usage has been printed.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "ratelimitctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command named by the first argument, writing its output to out and its usage and errors to errOut.
+func run(ctx context.Context, args []string, out, errOut io.Writer) error {
+	if len(args) == 0 {
+		usage(errOut)
+		return errUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(errOut, "unknown command %q\n", args[0])
+		usage(errOut)
+		return errUsage
+	}
+
+	err := cmd(ctx, args[1:], out, errOut)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+
+	return err
+}
+
+// usage writes the list of commands.
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "usage: ratelimitctl <command> [flags]\n\ncommands: %v\n", names)
+}
+
+// newFlagSet returns the flag set of a command, which writes its errors and usage to errOut.
+func newFlagSet(name string, errOut io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("ratelimitctl "+name, flag.ContinueOnError)
+	fs.SetOutput(errOut)
+
+	return fs
+}
+
+// parse parses the flags of a command, which takes no positional arguments.
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments %v\n", fs.Args())
+		fs.Usage()
+		return errUsage
+	}
+
+	return nil
+}
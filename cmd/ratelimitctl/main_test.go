@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const memoryConfig = `{
+  "rate_limit": {
+    "type": "sliding_window",
+    "backend": "memory",
+    "limits": [
+      {"type": "status", "limit": 2, "window_size": "1m"},
+      {"type": "news", "limit": 1, "window_size": "1m"}
+    ]
+  }
+}`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func runCommand(args ...string) (string, string, error) {
+	var out, errOut bytes.Buffer
+	err := run(context.Background(), args, &out, &errOut)
+
+	return out.String(), errOut.String(), err
+}
+
+func TestRun_Usage(t *testing.T) {
+	_, errOut, err := runCommand()
+	assert.ErrorIs(t, err, errUsage)
+	assert.Contains(t, errOut, "usage: ratelimitctl <command> [flags]")
+
+	_, errOut, err = runCommand("unknown")
+	assert.ErrorIs(t, err, errUsage)
+	assert.Contains(t, errOut, `unknown command "unknown"`)
+
+	_, errOut, err = runCommand("send", "-h")
+	assert.NoError(t, err)
+	assert.Contains(t, errOut, "-type")
+
+	_, _, err = runCommand("reset", "-config", writeConfig(t, memoryConfig))
+	assert.ErrorIs(t, err, errUsage)
+}
+
+func TestValidate(t *testing.T) {
+	out, _, err := runCommand("validate", "-config", writeConfig(t, memoryConfig), "-set", "rate_limit.limits[status].limit=5", "-origins")
+	require.NoError(t, err)
+	assert.Contains(t, out, "is valid")
+	assert.Contains(t, out, "backend: memory, algorithm: sliding_window, gateway: log")
+	assert.Contains(t, out, "notification types: news, status")
+	assert.Contains(t, out, "rate_limit.limits[status].limit: override")
+
+	invalid := `{"rate_limit": {"type": "leaky_bucket", "backend": "memory", "limits": [{"type": "news", "limit": 0, "window_size_ms": 1000}]}}`
+	out, _, err = runCommand("validate", "-config", writeConfig(t, invalid))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 problem(s) found")
+	assert.Contains(t, out, "rate_limit.type: unknown rate limiter type")
+	assert.Contains(t, out, "rate_limit.limits[0].limit")
+}
+
+func TestSend(t *testing.T) {
+	path := writeConfig(t, memoryConfig)
+	userID := ksuid.New().String()
+
+	out, _, err := runCommand("send", "-config", path, "-user", userID, "-type", "status", "-count", "2")
+	require.NoError(t, err)
+	assert.Contains(t, out, "notification sent to user "+userID+": test notification from ratelimitctl")
+	assert.Contains(t, out, "notification 1 allowed: 1/2 used in status/1m0s, 1 remaining")
+	assert.Contains(t, out, "notification 2 allowed: 2/2 used in status/1m0s, 0 remaining")
+
+	out, _, err = runCommand("send", "-config", path, "-user", userID, "-type", "news", "-count", "2")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 2 notification(s)")
+	assert.Contains(t, out, "notification 2 denied: 1/1 used in news/1m0s")
+
+	_, _, err = runCommand("send", "-config", path, "-user", "nobody", "-type", "news")
+	assert.ErrorContains(t, err, "invalid user ID")
+}
+
+func TestStatusAndReset_MemoryBackend(t *testing.T) {
+	path := writeConfig(t, memoryConfig)
+	userID := ksuid.New().String()
+
+	_, _, err := runCommand("status", "-config", path, "-user", userID)
+	assert.ErrorContains(t, err, "memory backend")
+
+	_, _, err = runCommand("reset", "-config", path, "-key", "{"+userID+"}-status")
+	assert.ErrorContains(t, err, "memory backend")
+}
+
+func TestBench(t *testing.T) {
+	path := writeConfig(t, memoryConfig)
+
+	out, _, err := runCommand("bench", "-config", path, "-algorithm", "gcra", "-type", "news",
+		"-users", "10", "-requests", "100", "-concurrency", "4")
+	require.NoError(t, err)
+	assert.Contains(t, out, "algorithm: gcra, type: news")
+	assert.Contains(t, out, "requests: 100, allowed: 10, denied: 90, errors: 0")
+	assert.Contains(t, out, "latency p50:")
+
+	_, _, err = runCommand("bench", "-config", path, "-algorithm", "leaky_bucket")
+	assert.ErrorContains(t, err, "unknown rate limiter algorithm")
+
+	_, _, err = runCommand("bench", "-config", path, "-type", "marketing")
+	assert.ErrorContains(t, err, "notification type marketing not found")
+}
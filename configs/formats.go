@@ -0,0 +1,98 @@
+package configs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the format of a configuration file.
+type Format string
+
+// Formats of the configuration files.
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// FormatOf returns the format of a configuration file from its extension, which is YAML for .yaml and .yml files,
+// TOML for .toml files and JSON for any other one.
+func FormatOf(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+// WithFormat sets the format of the configuration file instead of taking it from its extension,
+// for instance for a file without one.
+func WithFormat(format Format) LoadOption {
+	return func(opts *loadOptions) {
+		opts.format = format
+	}
+}
+
+// toJSON converts the content of a configuration file in the format to JSON, so every format is decoded
+// and validated the same way. Keys keep their names, so the paths of the problems found are the same too.
+func toJSON(content []byte, format Format) ([]byte, error) {
+	var raw interface{}
+	switch format {
+	case FormatJSON:
+		return content, nil
+	case FormatYAML:
+		if err := yaml.Unmarshal(content, &raw); err != nil {
+			return nil, fmt.Errorf("error parsing YAML configuration: %w", err)
+		}
+	case FormatTOML:
+		if err := toml.Unmarshal(content, &raw); err != nil {
+			return nil, fmt.Errorf("error parsing TOML configuration: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown configuration format %q, must be one of %v, %v or %v", format, FormatJSON, FormatYAML, FormatTOML)
+	}
+
+	content, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error converting %v configuration to JSON: %w", strings.ToUpper(string(format)), err)
+	}
+
+	return content, nil
+}
+
+// Duration is a time.Duration written as a human-readable string in the configuration files, such as "1m" or "24h".
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON parses the duration from a string, rejecting anything else with a *json.UnmarshalTypeError,
+// which encoding/json completes with the path of the field.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return &json.UnmarshalTypeError{Value: string(bytes.TrimSpace(data)), Type: reflect.TypeOf(*d)}
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: "duration " + strconv.Quote(s), Type: reflect.TypeOf(*d)}
+	}
+	*d = Duration(parsed)
+
+	return nil
+}
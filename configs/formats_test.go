@@ -0,0 +1,284 @@
+package configs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const jsonConfig = `{
+	"redis": {"host": "localhost", "port": 6379},
+	"rate_limit": {
+		"type": "sliding_window",
+		"limits": [
+			{"type": "status", "limit": 2, "window_size": "1m"},
+			{"type": "news", "windows": [{"limit": 3, "window_size_ms": 60000}, {"limit": 50, "window_size": "24h"}]}
+		],
+		"global": {"limit": 100, "window_size": "24h"},
+		"overrides": [{"tenant_id": "acme", "global": {"limit": 1000, "window_size": "24h"}}]
+	}
+}`
+
+const yamlConfig = `
+redis:
+  host: localhost
+  port: 6379
+rate_limit:
+  type: sliding_window
+  limits:
+    - type: status
+      limit: 2
+      window_size: 1m
+    - type: news
+      windows:
+        - limit: 3
+          window_size_ms: 60000
+        - limit: 50
+          window_size: 24h
+  global:
+    limit: 100
+    window_size: 24h
+  overrides:
+    - tenant_id: acme
+      global:
+        limit: 1000
+        window_size: 24h
+`
+
+const tomlConfig = `
+[redis]
+host = "localhost"
+port = 6379
+
+[rate_limit]
+type = "sliding_window"
+
+[[rate_limit.limits]]
+type = "status"
+limit = 2
+window_size = "1m"
+
+[[rate_limit.limits]]
+type = "news"
+windows = [
+  { limit = 3, window_size_ms = 60000 },
+  { limit = 50, window_size = "24h" },
+]
+
+[rate_limit.global]
+limit = 100
+window_size = "24h"
+
+[[rate_limit.overrides]]
+tenant_id = "acme"
+global = { limit = 1000, window_size = "24h" }
+`
+
+// writeFile writes the content to a file with the name in a temporary directory, returning its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestFormatOf(t *testing.T) {
+	assert.Equal(t, FormatJSON, FormatOf("config.json"))
+	assert.Equal(t, FormatYAML, FormatOf("config.yaml"))
+	assert.Equal(t, FormatYAML, FormatOf("/etc/ratelimit/config.YML"))
+	assert.Equal(t, FormatTOML, FormatOf("config.toml"))
+	assert.Equal(t, FormatJSON, FormatOf("config"))
+}
+
+func TestLoad_Formats(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		opts []LoadOption
+	}{
+		{name: "json", path: writeFile(t, "config.json", jsonConfig)},
+		{name: "yaml", path: writeFile(t, "config.yaml", yamlConfig)},
+		{name: "yml", path: writeFile(t, "config.yml", yamlConfig)},
+		{name: "toml", path: writeFile(t, "config.toml", tomlConfig)},
+		{name: "format override", path: writeFile(t, "config", yamlConfig), opts: []LoadOption{WithFormat(FormatYAML)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := Load(tt.path, append(tt.opts, Strict())...)
+			require.NoError(t, err)
+
+			assert.Equal(t, "localhost:6379", conf.RedisAddr)
+			assert.Equal(t, "sliding_window", conf.RateLimiterType)
+			assert.Equal(t, time.Minute, conf.Limits.Get("status").WindowsSizeDuration())
+
+			limit, size := conf.Limits.Get("status").Quota()
+			assert.Equal(t, int64(2), limit)
+			assert.Equal(t, time.Minute, size)
+
+			windows := conf.Limits.Get("news").WindowConfigs()
+			require.Len(t, windows, 2)
+			limit, size = windows[0].Quota()
+			assert.Equal(t, int64(3), limit)
+			assert.Equal(t, time.Minute, size)
+			limit, size = windows[1].Quota()
+			assert.Equal(t, int64(50), limit)
+			assert.Equal(t, 24*time.Hour, size)
+
+			limit, size = conf.Global.Quota()
+			assert.Equal(t, int64(100), limit)
+			assert.Equal(t, 24*time.Hour, size)
+
+			require.Len(t, conf.Overrides, 1)
+			assert.Equal(t, int64(1000), conf.Overrides[0].Global.Limit)
+		})
+	}
+}
+
+func TestLoad_FormatsValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{
+			name: "json",
+			path: writeFile(t, "config.json", `{
+				"redis": {"host": "localhost"},
+				"rate_limit": {
+					"type": "leaky_bucket",
+					"limits": [
+						{"type": "status", "limit": 2, "window_size": "1m"},
+						{"type": "news", "limit": 2, "window_size": "1m", "window_size_ms": 60000, "windw": 1}
+					]
+				}
+			}`),
+		},
+		{
+			name: "yaml",
+			path: writeFile(t, "config.yaml", `
+redis:
+  host: localhost
+rate_limit:
+  type: leaky_bucket
+  limits:
+    - {type: status, limit: 2, window_size: 1m}
+    - {type: news, limit: 2, window_size: 1m, window_size_ms: 60000, windw: 1}
+`),
+		},
+		{
+			name: "toml",
+			path: writeFile(t, "config.toml", `
+redis = { host = "localhost" }
+
+[rate_limit]
+type = "leaky_bucket"
+limits = [
+  { type = "status", limit = 2, window_size = "1m" },
+  { type = "news", limit = 2, window_size = "1m", window_size_ms = 60000, windw = 1 },
+]
+`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.path, Strict())
+			assert.Equal(t, []string{
+				"rate_limit.limits[1].windw: unknown field",
+				`rate_limit.type: unknown rate limiter type "leaky_bucket", must be one of sliding_window, fixed_window, token_bucket, gcra`,
+				"rate_limit.limits[1].window_size: must not be set along with window_size_ms",
+			}, problems(t, err))
+		})
+	}
+}
+
+func TestLoad_InvalidDuration(t *testing.T) {
+	// The durations that cannot be parsed keep the rest of the configuration from being decoded
+	path := writeFile(t, "config.yaml", `
+rate_limit:
+  type: leaky_bucket
+  limits:
+    - {type: status, limit: 2, window_size: 1 minute}
+  global: {limit: 2, window_size: 1m, windw: 1}
+`)
+
+	_, err := Load(path, Strict())
+	assert.Equal(t, []string{
+		"rate_limit.global.windw: unknown field",
+		`rate_limit.limits[0].window_size: must be a duration such as "1m" or "24h", got "1 minute"`,
+	}, problems(t, err))
+}
+
+func TestLoad_FormatErrors(t *testing.T) {
+	_, err := Load(writeFile(t, "config.yaml", "redis: [localhost"))
+	assert.ErrorContains(t, err, "error parsing YAML configuration")
+
+	_, err = Load(writeFile(t, "config.toml", "redis = "))
+	assert.ErrorContains(t, err, "error parsing TOML configuration")
+
+	_, err = Load(writeFile(t, "config.json", jsonConfig), WithFormat("xml"))
+	assert.EqualError(t, err, `unknown configuration format "xml", must be one of json, yaml or toml`)
+}
+
+func TestWindowConfig_Size(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{name: "duration", content: `{"limit": 1, "window_size": "1500ms"}`},
+		{name: "milliseconds", content: `{"limit": 1, "window_size_ms": 1500}`},
+		{name: "number", content: `{"limit": 1, "window_size": 1500}`, want: []string{`window_size: must be a duration such as "1m" or "24h", got 1500`}},
+		{name: "below a millisecond", content: `{"limit": 1, "window_size": "500us"}`, want: []string{"window_size: must be at least 1ms, got 500µs"}},
+		{name: "fraction of a millisecond", content: `{"limit": 1, "window_size": "1.5ms"}`, want: []string{"window_size: must be a whole number of milliseconds, got 1.5ms"}},
+		{name: "negative", content: `{"limit": 1, "window_size": "-1m"}`, want: []string{"window_size: must be at least 1ms, got -1m0s"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw interface{}
+			require.NoError(t, json.Unmarshal([]byte(tt.content), &raw))
+
+			v := &validator{}
+			v.checkFieldsOf("", raw, reflect.TypeOf(WindowConfig{}), true)
+			if v.err() == nil {
+				var window WindowConfig
+				require.NoError(t, json.Unmarshal([]byte(tt.content), &window))
+				window.validate(v, "")
+
+				if len(tt.want) == 0 {
+					_, size := window.Quota()
+					assert.Equal(t, 1500*time.Millisecond, size)
+				}
+			}
+
+			if len(tt.want) == 0 {
+				assert.NoError(t, v.err())
+				return
+			}
+			assert.Equal(t, tt.want, problems(t, v.err()))
+		})
+	}
+}
+
+func TestDuration_JSON(t *testing.T) {
+	data, err := json.Marshal(&WindowConfig{Limit: 1, WSize: Duration(time.Minute)})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"limit": 1, "window_size_ms": 0, "window_size": "1m0s"}`, string(data))
+
+	var window WindowConfig
+	require.NoError(t, json.Unmarshal(data, &window))
+	assert.Equal(t, Duration(time.Minute), window.WSize)
+
+	var typeErr *json.UnmarshalTypeError
+	assert.ErrorAs(t, json.Unmarshal([]byte(`{"window_size": "1 minute"}`), &window), &typeErr)
+	assert.ErrorAs(t, json.Unmarshal([]byte(`{"window_size": 60}`), &window), &typeErr)
+}
@@ -0,0 +1,556 @@
+package configs
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEnvPrefix is the prefix of the environment variables read by a Loader when no other one is given.
+const DefaultEnvPrefix = "RATELIMIT"
+
+// errUnknownSetting is the error of a setting key that does not exist.
+var errUnknownSetting = errors.New("unknown setting")
+
+// Source is the layer an effective configuration value comes from.
+type Source string
+
+// Sources of the configuration values, from the lowest precedence to the highest.
+const (
+	SourceDefault  Source = "default"
+	SourceFile     Source = "file"
+	SourceEnv      Source = "env"
+	SourceOverride Source = "override"
+)
+
+// Origin tells where an effective configuration value comes from. Name is the path of the file
+// or the name of the environment variable that set it.
+type Origin struct {
+	Source Source
+	Name   string
+}
+
+// String returns the string representation of the Origin, such as "env RATELIMIT_REDIS_HOST".
+func (o Origin) String() string {
+	if o.Name == "" {
+		return string(o.Source)
+	}
+
+	return fmt.Sprintf("%v %v", o.Source, o.Name)
+}
+
+// Origins are the origins of the configuration values, keyed by their setting key, such as "redis.host"
+// or "rate_limit.limits[status].limit", where the limits of the notification types are keyed by their type.
+type Origins map[string]Origin
+
+// Of returns the origin of the value of the setting key, which is SourceDefault when no layer set it.
+func (o Origins) Of(key string) Origin {
+	if origin, ok := o[key]; ok {
+		return origin
+	}
+
+	return Origin{Source: SourceDefault}
+}
+
+// Loader loads the configuration in layers, each one replacing the values set by the previous ones:
+// the configuration file, then the environment variables, then the values set with Set.
+//
+// The environment variables are named after the setting keys, upper cased with their dots replaced by underscores
+// and prefixed with RATELIMIT_, leaving out the rate_limit section. The limits of the notification types are named
+// after their type, such as RATELIMIT_REDIS_HOST for redis.host, RATELIMIT_GATEWAY_URL for gateway.url,
+// RATELIMIT_TYPE for rate_limit.type, RATELIMIT_GLOBAL_LIMIT for rate_limit.global.limit, and
+// RATELIMIT_LIMITS_STATUS_LIMIT for rate_limit.limits[status].limit. A notification type the file does not have
+// is added by its variables.
+type Loader struct {
+	path      string
+	opts      []LoadOption
+	envPrefix string
+	settings  []setting
+}
+
+// setting is a value set with Loader.Set.
+type setting struct {
+	key   string
+	value string
+}
+
+// NewLoader returns a Loader for the configuration file at the specified filepath, which is read with the options.
+// The filepath can be empty for a configuration that comes from the environment variables and Set alone.
+func NewLoader(filepath string, opts ...LoadOption) *Loader {
+	return &Loader{
+		path:      filepath,
+		opts:      opts,
+		envPrefix: DefaultEnvPrefix,
+	}
+}
+
+// WithEnvPrefix replaces the prefix of the environment variables read by the Loader, RATELIMIT by default.
+// An empty prefix turns off the environment variables layer.
+func (l *Loader) WithEnvPrefix(prefix string) *Loader {
+	l.envPrefix = prefix
+	return l
+}
+
+// Set sets the value of the setting key, replacing the values of the file and the environment variables.
+// The keys are the same as the ones of Origins, and the values are written the way the environment variables are,
+// with comma separated lists for redis.addrs. Unknown keys and invalid values are reported by Load.
+func (l *Loader) Set(key, value string) *Loader {
+	l.settings = append(l.settings, setting{key: key, value: value})
+	return l
+}
+
+// Flag returns a flag.Value that calls Set with the key=value pairs it is given, so the settings can come
+// from the command line, e.g. flag.Var(loader.Flag(), "set", "configuration setting as key=value").
+func (l *Loader) Flag() flag.Value {
+	return &settingsFlag{loader: l}
+}
+
+// Load loads and validates the configuration, returning the origin of every value set by one of its layers.
+// The problems found in any of the layers are reported together with a *ValidationError.
+func (l *Loader) Load() (*NotificationService, Origins, error) {
+	var content []byte
+	if l.path != "" {
+		var err error
+		if content, err = os.ReadFile(l.path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return l.load(content)
+}
+
+// Watch loads the configuration like Load, and returns a Watcher that keeps it up to date as NewWatcher does.
+// Every change of the file is loaded with the layers of the Loader, so the environment variables and the values
+// set with Set keep replacing the ones of the file. The Loader needs a file, and it must not change while watched.
+func (l *Loader) Watch(interval time.Duration) (*Watcher, error) {
+	if l.path == "" {
+		return nil, errors.New("watching the configuration needs a file")
+	}
+
+	return newWatcher(l.path, interval, func(content []byte) (*NotificationService, error) {
+		conf, _, err := l.load(content)
+		return conf, err
+	})
+}
+
+// load loads the layers of the configuration on top of the content of its file, which is ignored when
+// the Loader has no file.
+func (l *Loader) load(content []byte) (*NotificationService, Origins, error) {
+	opts := newLoadOptions(l.opts)
+	origins := Origins{}
+
+	jsonConf, v := &JsonConfiguration{}, &validator{}
+	if l.path != "" {
+		var raw interface{}
+		var err error
+		if jsonConf, raw, v, err = decode(content, opts.fileFormat(l.path), opts); err != nil {
+			return nil, nil, err
+		}
+		fileOrigins(origins, "", raw, Origin{Source: SourceFile, Name: l.path})
+	}
+
+	for _, env := range l.environment(jsonConf, v, opts.strict) {
+		origins.apply(jsonConf, v, env.key, env.value, Origin{Source: SourceEnv, Name: env.name})
+	}
+	for _, s := range l.settings {
+		origins.apply(jsonConf, v, s.key, s.value, Origin{Source: SourceOverride})
+	}
+
+	conf, err := build(jsonConf, v)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return conf, origins, nil
+}
+
+// apply sets the value of the setting key on the configuration and records its origin, or records
+// a problem when the key does not exist or the value is not valid.
+func (o Origins) apply(jsonConf *JsonConfiguration, v *validator, key, value string, origin Origin) {
+	if err := jsonConf.set(key, value); errors.Is(err, errUnknownSetting) {
+		v.add(key, "unknown setting from %v", origin)
+		return
+	} else if err != nil {
+		v.add(key, "invalid value %q from %v: %v", value, origin, err)
+		return
+	}
+
+	o[key] = origin
+
+	// Setting the window size in one unit clears the one in the other unit
+	if limit, ok := strings.CutSuffix(key, ".window_size"); ok {
+		delete(o, limit+".window_size_ms")
+	} else if limit, ok := strings.CutSuffix(key, ".window_size_ms"); ok {
+		delete(o, limit+".window_size")
+	}
+}
+
+// envSetting is a setting taken from an environment variable.
+type envSetting struct {
+	name  string
+	key   string
+	value string
+}
+
+// environment returns the settings of the environment variables with the prefix of the Loader, sorted by name.
+// The variables that do not match any setting are reported as problems in strict mode, and ignored otherwise.
+func (l *Loader) environment(jsonConf *JsonConfiguration, v *validator, strict bool) []envSetting {
+	if l.envPrefix == "" {
+		return nil
+	}
+
+	prefix := l.envPrefix + "_"
+	var envs []envSetting
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		key, ok := jsonConf.envKey(strings.TrimPrefix(name, prefix))
+		if !ok {
+			if strict {
+				v.add(name, "unknown environment variable")
+			}
+			continue
+		}
+		envs = append(envs, envSetting{name: name, key: key, value: value})
+	}
+
+	sort.Slice(envs, func(i, j int) bool {
+		return envs[i].name < envs[j].name
+	})
+
+	return envs
+}
+
+// redisSettings are the settings of the redis section, returning the field of the configuration each one sets.
+var redisSettings = map[string]func(rc *RedisConfig) interface{}{
+	"host":        func(rc *RedisConfig) interface{} { return &rc.Host },
+	"port":        func(rc *RedisConfig) interface{} { return &rc.Port },
+	"addrs":       func(rc *RedisConfig) interface{} { return &rc.Addrs },
+	"cluster":     func(rc *RedisConfig) interface{} { return &rc.Cluster },
+	"master_name": func(rc *RedisConfig) interface{} { return &rc.MasterName },
+	"username":    func(rc *RedisConfig) interface{} { return &rc.Username },
+	"password":    func(rc *RedisConfig) interface{} { return &rc.Password },
+	"db":          func(rc *RedisConfig) interface{} { return &rc.DB },
+	"tls.server_name": func(rc *RedisConfig) interface{} {
+		return &rc.tls().ServerName
+	},
+	"tls.insecure_skip_verify": func(rc *RedisConfig) interface{} {
+		return &rc.tls().InsecureSkipVerify
+	},
+}
+
+// gatewaySettings are the settings of the gateway section, returning the field of the configuration each one sets.
+// The headers of a webhook can only be set by the configuration file.
+var gatewaySettings = map[string]func(gc *GatewayConfig) interface{}{
+	"type":    func(gc *GatewayConfig) interface{} { return &gc.Type },
+	"url":     func(gc *GatewayConfig) interface{} { return &gc.URL },
+	"timeout": func(gc *GatewayConfig) interface{} { return &gc.Timeout },
+}
+
+// limitSettings are the settings of a limit, returning the field of the configuration each one sets.
+var limitSettings = map[string]func(conf *LimitConfig) interface{}{
+	"limit":          func(conf *LimitConfig) interface{} { return &conf.Limit },
+	"window_size_ms": func(conf *LimitConfig) interface{} { return &conf.WSizeMs },
+	"window_size":    func(conf *LimitConfig) interface{} { return &conf.WSize },
+	"refill_rate":    func(conf *LimitConfig) interface{} { return &conf.RefillRate },
+	"burst":          func(conf *LimitConfig) interface{} { return &conf.Burst },
+	"on_failure":     func(conf *LimitConfig) interface{} { return &conf.OnFailure },
+	"degraded_ratio": func(conf *LimitConfig) interface{} { return &conf.DegradedRatio },
+}
+
+// tls returns the TLS configuration, enabling TLS when it is not.
+func (rc *RedisConfig) tls() *TLSConfig {
+	if rc.TLS == nil {
+		rc.TLS = &TLSConfig{}
+	}
+
+	return rc.TLS
+}
+
+// set sets the value of the setting key, adding the sections and the notification types it needs.
+// Nothing is added when the key does not exist or the value is not valid.
+func (jc *JsonConfiguration) set(key, value string) error {
+	if name, ok := strings.CutPrefix(key, "redis."); ok {
+		field, ok := redisSettings[name]
+		if !ok {
+			return errUnknownSetting
+		} else if err := setValue(field(&RedisConfig{}), value); err != nil {
+			return err
+		}
+
+		if jc.Redis == nil {
+			jc.Redis = &RedisConfig{}
+		}
+		return setValue(field(jc.Redis), value)
+	}
+
+	if name, ok := strings.CutPrefix(key, "gateway."); ok {
+		field, ok := gatewaySettings[name]
+		if !ok {
+			return errUnknownSetting
+		} else if err := setValue(field(&GatewayConfig{}), value); err != nil {
+			return err
+		}
+
+		if jc.Gateway == nil {
+			jc.Gateway = &GatewayConfig{}
+		}
+		return setValue(field(jc.Gateway), value)
+	}
+
+	var limit func(rlc *RateLimitConfig) *LimitConfig
+	name, _ := strings.CutPrefix(key, "rate_limit.")
+	switch {
+	case key == "rate_limit.type", key == "rate_limit.backend":
+		if jc.RateLimit == nil {
+			jc.RateLimit = &RateLimitConfig{}
+		}
+		if name == "type" {
+			return setValue(&jc.RateLimit.Type, value)
+		}
+		return setValue(&jc.RateLimit.Backend, value)
+	case strings.HasPrefix(key, "rate_limit.global."):
+		name = strings.TrimPrefix(name, "global.")
+		limit = func(rlc *RateLimitConfig) *LimitConfig {
+			if rlc.Global == nil {
+				rlc.Global = &LimitConfig{}
+			}
+			return rlc.Global
+		}
+	case strings.HasPrefix(key, "rate_limit.limits["):
+		typ, field, ok := strings.Cut(strings.TrimPrefix(name, "limits["), "].")
+		if !ok || typ == "" {
+			return errUnknownSetting
+		}
+		name = field
+		limit = func(rlc *RateLimitConfig) *LimitConfig {
+			return rlc.limit(typ)
+		}
+	default:
+		return errUnknownSetting
+	}
+
+	field, ok := limitSettings[name]
+	if !ok {
+		return errUnknownSetting
+	} else if err := setValue(field(&LimitConfig{}), value); err != nil {
+		return err
+	}
+
+	if jc.RateLimit == nil {
+		jc.RateLimit = &RateLimitConfig{}
+	}
+	conf := limit(jc.RateLimit)
+
+	// The window size replaces the one set in the other unit
+	switch name {
+	case "window_size":
+		conf.WSizeMs = 0
+	case "window_size_ms":
+		conf.WSize = 0
+	}
+
+	return setValue(field(conf), value)
+}
+
+// limit returns the limit of the notification type, adding it when there is none.
+func (rlc *RateLimitConfig) limit(typ string) *LimitConfig {
+	for _, conf := range rlc.Limits {
+		if conf != nil && conf.Type == typ {
+			return conf
+		}
+	}
+
+	conf := &LimitConfig{Type: typ}
+	rlc.Limits = append(rlc.Limits, conf)
+
+	return conf
+}
+
+// envKey returns the setting key of an environment variable, given its name without the prefix.
+// The notification types of the limits are matched with the ones of the configuration regardless of their case
+// and of their dashes, which cannot be part of an environment variable name, or lower cased otherwise.
+func (jc *JsonConfiguration) envKey(name string) (string, bool) {
+	switch name {
+	case "TYPE":
+		return "rate_limit.type", true
+	case "BACKEND":
+		return "rate_limit.backend", true
+	}
+
+	if field, ok := strings.CutPrefix(name, "REDIS_"); ok {
+		return settingKey("redis.", field, redisSettings)
+	}
+	if field, ok := strings.CutPrefix(name, "GATEWAY_"); ok {
+		return settingKey("gateway.", field, gatewaySettings)
+	}
+	if field, ok := strings.CutPrefix(name, "GLOBAL_"); ok {
+		return settingKey("rate_limit.global.", field, limitSettings)
+	}
+
+	rest, ok := strings.CutPrefix(name, "LIMITS_")
+	if !ok {
+		return "", false
+	}
+
+	// The longest field names are matched first, so WINDOW_SIZE_MS is not taken for WINDOW_SIZE
+	fields := sortedKeys(limitSettings)
+	sort.Slice(fields, func(i, j int) bool {
+		return len(fields[i]) > len(fields[j])
+	})
+	for _, field := range fields {
+		envType, ok := strings.CutSuffix(rest, "_"+envName(field))
+		if !ok || envType == "" {
+			continue
+		}
+
+		typ := strings.ToLower(envType)
+		if jc.RateLimit != nil {
+			for _, conf := range jc.RateLimit.Limits {
+				if conf != nil && envName(conf.Type) == envType {
+					typ = conf.Type
+					break
+				}
+			}
+		}
+		return fmt.Sprintf("rate_limit.limits[%v].%v", typ, field), true
+	}
+
+	return "", false
+}
+
+// settingKey returns the setting key of the section with the prefix for the field of an environment variable.
+func settingKey[T any](prefix, envField string, settings map[string]T) (string, bool) {
+	for _, field := range sortedKeys(settings) {
+		if envName(field) == envField {
+			return prefix + field, true
+		}
+	}
+
+	return "", false
+}
+
+// envName returns the environment variable name of a setting, upper cased with underscores.
+func envName(name string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+// sortedKeys returns the keys of the settings in order.
+func sortedKeys[T any](settings map[string]T) []string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// setValue parses the value into the field of the configuration.
+func setValue(field interface{}, value string) error {
+	switch field := field.(type) {
+	case *string:
+		*field = value
+	case *[]string:
+		*field = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field = append(*field, item)
+			}
+		}
+	case *bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be a boolean")
+		}
+		*field = parsed
+	case *int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		*field = parsed
+	case *int64:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		*field = parsed
+	case *float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		*field = parsed
+	case *Duration:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("must be a duration such as \"1m\" or \"24h\"")
+		}
+		*field = Duration(parsed)
+	default:
+		return fmt.Errorf("unsupported setting of type %T", field)
+	}
+
+	return nil
+}
+
+// fileOrigins records the origin of every value of the raw JSON value at path. The elements of the arrays
+// that have a type, such as the limits, are keyed by it rather than by their index, as the setting keys are.
+func fileOrigins(origins Origins, path string, raw interface{}, origin Origin) {
+	switch raw := raw.(type) {
+	case map[string]interface{}:
+		for name, value := range raw {
+			fileOrigins(origins, field(path, name), value, origin)
+		}
+	case []interface{}:
+		for i, elem := range raw {
+			elemPath := index(path, i)
+			if object, ok := elem.(map[string]interface{}); ok {
+				if typ, ok := object["type"].(string); ok && typ != "" {
+					elemPath = fmt.Sprintf("%v[%v]", path, typ)
+				}
+			}
+			fileOrigins(origins, elemPath, elem, origin)
+		}
+	case nil:
+	default:
+		origins[path] = origin
+	}
+}
+
+// settingsFlag is the flag.Value of Loader.Flag.
+type settingsFlag struct {
+	loader *Loader
+	set    []string
+}
+
+// String returns the settings given to the flag.
+func (f *settingsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+
+	return strings.Join(f.set, ",")
+}
+
+// Set sets a key=value setting on the Loader.
+func (f *settingsFlag) Set(kv string) error {
+	key, value, ok := strings.Cut(kv, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("invalid setting %q, must be key=value", kv)
+	}
+
+	f.loader.Set(key, value)
+	f.set = append(f.set, kv)
+
+	return nil
+}
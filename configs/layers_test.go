@@ -0,0 +1,144 @@
+package configs
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const layeredConfig = `{
+	"redis": {"host": "localhost", "port": 6379},
+	"rate_limit": {
+		"type": "sliding_window",
+		"limits": [
+			{"type": "status", "limit": 2, "window_size_ms": 1000},
+			{"type": "password-reset", "limit": 3, "window_size": "1h"}
+		]
+	}
+}`
+
+func TestLoader_Load(t *testing.T) {
+	path := writeFile(t, "config.json", layeredConfig)
+
+	t.Setenv("RATELIMIT_REDIS_HOST", "redis.internal")
+	t.Setenv("RATELIMIT_REDIS_PASSWORD", "secret")
+	t.Setenv("RATELIMIT_REDIS_TLS_SERVER_NAME", "redis.internal")
+	t.Setenv("RATELIMIT_LIMITS_STATUS_LIMIT", "5")
+	t.Setenv("RATELIMIT_LIMITS_STATUS_WINDOW_SIZE", "1m")
+	t.Setenv("RATELIMIT_LIMITS_PASSWORD_RESET_LIMIT", "4")
+	t.Setenv("RATELIMIT_LIMITS_NEWS_LIMIT", "10")
+	t.Setenv("RATELIMIT_LIMITS_NEWS_WINDOW_SIZE_MS", "60000")
+	t.Setenv("RATELIMIT_GLOBAL_LIMIT", "100")
+	t.Setenv("RATELIMIT_GLOBAL_WINDOW_SIZE", "24h")
+	t.Setenv("RATELIMIT_GATEWAY_TYPE", "webhook")
+	t.Setenv("RATELIMIT_GATEWAY_URL", "https://notify.internal/send")
+	t.Setenv("RATELIMIT_UNKNOWN", "ignored")
+
+	conf, origins, err := NewLoader(path).
+		Set("redis.host", "redis-0.internal").
+		Set("rate_limit.limits[status].limit", "6").
+		Set("gateway.timeout", "5s").
+		Load()
+	require.NoError(t, err)
+
+	// The programmatic overrides win over the environment variables, which win over the file
+	assert.Equal(t, "redis-0.internal:6379", conf.RedisAddr)
+	assert.Equal(t, "secret", conf.Redis.Password)
+	assert.Equal(t, "redis.internal", conf.Redis.TLS.ServerName)
+
+	limit, size := conf.Limits.Get("status").Quota()
+	assert.Equal(t, int64(6), limit)
+	assert.Equal(t, time.Minute, size)
+
+	limit, size = conf.Limits.Get("password-reset").Quota()
+	assert.Equal(t, int64(4), limit)
+	assert.Equal(t, time.Hour, size)
+
+	limit, size = conf.Limits.Get("news").Quota()
+	assert.Equal(t, int64(10), limit)
+	assert.Equal(t, time.Minute, size)
+
+	limit, size = conf.Global.Quota()
+	assert.Equal(t, int64(100), limit)
+	assert.Equal(t, 24*time.Hour, size)
+
+	assert.Equal(t, &GatewayConfig{Type: GatewayWebhook, URL: "https://notify.internal/send", Timeout: Duration(5 * time.Second)}, conf.Gateway)
+
+	assert.Equal(t, Origin{Source: SourceOverride}, origins.Of("redis.host"))
+	assert.Equal(t, Origin{Source: SourceFile, Name: path}, origins.Of("redis.port"))
+	assert.Equal(t, Origin{Source: SourceEnv, Name: "RATELIMIT_REDIS_PASSWORD"}, origins.Of("redis.password"))
+	assert.Equal(t, Origin{Source: SourceDefault}, origins.Of("redis.db"))
+	assert.Equal(t, Origin{Source: SourceFile, Name: path}, origins.Of("rate_limit.type"))
+	assert.Equal(t, Origin{Source: SourceOverride}, origins.Of("rate_limit.limits[status].limit"))
+	assert.Equal(t, Origin{Source: SourceEnv, Name: "RATELIMIT_LIMITS_STATUS_WINDOW_SIZE"}, origins.Of("rate_limit.limits[status].window_size"))
+	assert.Equal(t, Origin{Source: SourceDefault}, origins.Of("rate_limit.limits[status].window_size_ms"))
+	assert.Equal(t, Origin{Source: SourceEnv, Name: "RATELIMIT_LIMITS_PASSWORD_RESET_LIMIT"}, origins.Of("rate_limit.limits[password-reset].limit"))
+	assert.Equal(t, Origin{Source: SourceFile, Name: path}, origins.Of("rate_limit.limits[password-reset].window_size"))
+	assert.Equal(t, Origin{Source: SourceEnv, Name: "RATELIMIT_GLOBAL_LIMIT"}, origins.Of("rate_limit.global.limit"))
+	assert.Equal(t, Origin{Source: SourceEnv, Name: "RATELIMIT_GATEWAY_URL"}, origins.Of("gateway.url"))
+
+	assert.Equal(t, "env RATELIMIT_GLOBAL_LIMIT", origins.Of("rate_limit.global.limit").String())
+	assert.Equal(t, "override", origins.Of("redis.host").String())
+}
+
+func TestLoader_LoadWithoutFile(t *testing.T) {
+	t.Setenv("APP_BACKEND", "memory")
+	t.Setenv("APP_TYPE", "gcra")
+	t.Setenv("APP_LIMITS_STATUS_LIMIT", "5")
+	t.Setenv("APP_LIMITS_STATUS_WINDOW_SIZE", "1s")
+	t.Setenv("RATELIMIT_TYPE", "fixed_window")
+
+	conf, origins, err := NewLoader("").WithEnvPrefix("APP").Load()
+	require.NoError(t, err)
+
+	assert.Nil(t, conf.Redis)
+	assert.Equal(t, "gcra", conf.RateLimiterType)
+	assert.Equal(t, "memory", conf.RateLimiterBackend)
+	assert.Equal(t, int64(5), conf.Limits.Get("status").Limit)
+	assert.Equal(t, Origin{Source: SourceEnv, Name: "APP_TYPE"}, origins.Of("rate_limit.type"))
+}
+
+func TestLoader_LoadErrors(t *testing.T) {
+	path := writeFile(t, "config.json", layeredConfig)
+
+	t.Setenv("RATELIMIT_REDIS_PORT", "redis")
+	t.Setenv("RATELIMIT_REDISHOST", "localhost")
+	t.Setenv("RATELIMIT_TYPE", "leaky_bucket")
+
+	_, _, err := NewLoader(path, Strict()).
+		Set("rate_limit.limits[status].burst", "many").
+		Set("rate_limit.limits[news].rate", "1").
+		Set("rate_limit.limits[alerts].window_size", "1 minute").
+		Set("redis.tls", "true").
+		Load()
+
+	assert.Equal(t, []string{
+		"RATELIMIT_REDISHOST: unknown environment variable",
+		`redis.port: invalid value "redis" from env RATELIMIT_REDIS_PORT: must be an integer`,
+		`rate_limit.limits[status].burst: invalid value "many" from override: must be an integer`,
+		"rate_limit.limits[news].rate: unknown setting from override",
+		`rate_limit.limits[alerts].window_size: invalid value "1 minute" from override: must be a duration such as "1m" or "24h"`,
+		"redis.tls: unknown setting from override",
+		`rate_limit.type: unknown rate limiter type "leaky_bucket", must be one of sliding_window, fixed_window, token_bucket, gcra`,
+	}, problems(t, err))
+}
+
+func TestLoader_Flag(t *testing.T) {
+	path := writeFile(t, "config.json", layeredConfig)
+	loader := NewLoader(path).WithEnvPrefix("")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(loader.Flag(), "set", "configuration setting as key=value")
+	require.NoError(t, fs.Parse([]string{"-set", "redis.addrs=redis-0:6379, redis-1:6379", "-set", "redis.cluster=true"}))
+	assert.Error(t, fs.Parse([]string{"-set", "redis.host"}))
+
+	conf, origins, err := loader.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"redis-0:6379", "redis-1:6379"}, conf.Redis.Addrs)
+	assert.True(t, conf.Redis.Cluster)
+	assert.Equal(t, Origin{Source: SourceOverride}, origins.Of("redis.cluster"))
+	assert.Equal(t, "redis.addrs=redis-0:6379, redis-1:6379,redis.cluster=true", fs.Lookup("set").Value.String())
+}
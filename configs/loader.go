@@ -2,12 +2,39 @@
 package configs
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"math"
 	"os"
 	"time"
+
+	"github.com/godoylucase/rate-limit/rate_limiter"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Failure policies applied when the rate limiter backend is unavailable.
+const (
+	// FailClosed rejects the notifications, it is the default policy.
+	FailClosed = "fail_closed"
+	// FailOpen sends the notifications without rate limiting them.
+	FailOpen = "fail_open"
+	// Degrade rate limits the notifications within the current process, using a scaled down limit.
+	Degrade = "degrade"
 )
 
+// Gateways the notifications can be sent through.
+const (
+	// GatewayLog logs the notifications instead of sending them, it is the default gateway.
+	GatewayLog = "log"
+	// GatewayWebhook posts the notifications as JSON to a URL.
+	GatewayWebhook = "webhook"
+)
+
+// defaultDegradedRatio is the share of the limit used by the Degrade policy when none is configured.
+const defaultDegradedRatio = 0.5
+
 // Unit represents the unit of measurement for rate limits.
 type Unit string
 
@@ -23,11 +50,28 @@ func (lcm LimitConfigMap) Get(typ string) *LimitConfig {
 type JsonConfiguration struct {
 	Redis     *RedisConfig     `json:"redis"`
 	RateLimit *RateLimitConfig `json:"rate_limit"`
+	Gateway   *GatewayConfig   `json:"gateway,omitempty"`
 }
 
+// RedisConfig represents the configuration for the redis connection.
+// A single node is described by Host and Port, while Addrs lists the seed addresses of a cluster
+// or the sentinel addresses when MasterName is set.
 type RedisConfig struct {
-	Host string `json:"host"`
-	Port int    `json:"port"`
+	Host       string     `json:"host"`
+	Port       int        `json:"port"`
+	Addrs      []string   `json:"addrs,omitempty"`
+	Cluster    bool       `json:"cluster,omitempty"`
+	MasterName string     `json:"master_name,omitempty"`
+	Username   string     `json:"username,omitempty"`
+	Password   string     `json:"password,omitempty"`
+	DB         int        `json:"db,omitempty"`
+	TLS        *TLSConfig `json:"tls,omitempty"`
+}
+
+// TLSConfig represents the TLS configuration for the redis connection. TLS is enabled when it is present.
+type TLSConfig struct {
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
 }
 
 func (rc *RedisConfig) Address() string {
@@ -37,55 +81,335 @@ func (rc *RedisConfig) Address() string {
 	return rc.Host
 }
 
+// Addresses returns the configured addresses, falling back to the single node address.
+func (rc *RedisConfig) Addresses() []string {
+	if len(rc.Addrs) > 0 {
+		return rc.Addrs
+	}
+	return []string{rc.Address()}
+}
+
+// UniversalOptions returns the redis options described by the configuration.
+func (rc *RedisConfig) UniversalOptions() *redis.UniversalOptions {
+	opts := &redis.UniversalOptions{
+		Addrs:      rc.Addresses(),
+		MasterName: rc.MasterName,
+		Username:   rc.Username,
+		Password:   rc.Password,
+		DB:         rc.DB,
+	}
+
+	if rc.TLS != nil {
+		opts.TLSConfig = &tls.Config{
+			ServerName:         rc.TLS.ServerName,
+			InsecureSkipVerify: rc.TLS.InsecureSkipVerify,
+		}
+	}
+
+	return opts
+}
+
+// Client returns a redis client for the configuration: a failover client when a sentinel master name is set,
+// a cluster client when cluster mode is enabled or several addresses are given, and a single node client otherwise.
+func (rc *RedisConfig) Client() redis.UniversalClient {
+	opts := rc.UniversalOptions()
+
+	if rc.Cluster && rc.MasterName == "" {
+		return redis.NewClusterClient(opts.Cluster())
+	}
+
+	return redis.NewUniversalClient(opts)
+}
+
 // LimitConfig represents the configuration for a rate limit.
+// RefillRate and Burst are meant for the token bucket algorithm: tokens are added back at RefillRate
+// tokens per second and the bucket holds at most Burst tokens.
+// The window size is set either in milliseconds with WSizeMs, or as a human-readable duration with WSize.
+// Windows stacks several limits for the same type, e.g. 3 per minute and 50 per day, in which case
+// they replace Limit, WSizeMs, WSize, RefillRate and Burst.
+// OnFailure is the policy applied when the rate limiter backend is unavailable, and DegradedRatio
+// is the share of the limit allowed by the Degrade policy.
 type LimitConfig struct {
-	Type    string `json:"type"`
-	Limit   int64  `json:"limit"`
-	WSizeMs int64  `json:"window_size_ms"`
+	Type          string          `json:"type"`
+	Limit         int64           `json:"limit"`
+	WSizeMs       int64           `json:"window_size_ms"`
+	WSize         Duration        `json:"window_size,omitempty"`
+	RefillRate    float64         `json:"refill_rate,omitempty"`
+	Burst         int64           `json:"burst,omitempty"`
+	Windows       []*WindowConfig `json:"windows,omitempty"`
+	OnFailure     string          `json:"on_failure,omitempty"`
+	DegradedRatio float64         `json:"degraded_ratio,omitempty"`
+}
+
+// WindowConfig represents one of the windows a notification type is limited by at once.
+// Its fields have the same meaning as the ones of LimitConfig.
+type WindowConfig struct {
+	Limit      int64    `json:"limit"`
+	WSizeMs    int64    `json:"window_size_ms"`
+	WSize      Duration `json:"window_size,omitempty"`
+	RefillRate float64  `json:"refill_rate,omitempty"`
+	Burst      int64    `json:"burst,omitempty"`
 }
 
 // RateLimitConfig represents the configuration for rate limits.
+// Backend is where the limits are kept, either "redis" (default) or "memory" for a single process.
+// Global is an optional limit per user that counts the notifications of all types together, on top of the
+// limit of each type. Its Type is not used.
+// Overrides replaces the limits of specific users or tenants.
 type RateLimitConfig struct {
-	Type   string         `json:"type"`
-	Limits []*LimitConfig `json:"limits"`
+	Type      string         `json:"type"`
+	Backend   string         `json:"backend,omitempty"`
+	Limits    []*LimitConfig `json:"limits"`
+	Global    *LimitConfig   `json:"global,omitempty"`
+	Overrides OverrideList   `json:"overrides,omitempty"`
+}
+
+// GatewayConfig represents the configuration for the gateway the notifications are sent through.
+// Type is either "log" (default) or "webhook", which posts every notification to URL along with Headers,
+// giving up after Timeout.
+type GatewayConfig struct {
+	Type    string            `json:"type"`
+	URL     string            `json:"url,omitempty"`
+	Timeout Duration          `json:"timeout,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 // NotificationService represents the notification service with its configurations.
 type NotificationService struct {
-	RedisAddr       string
-	RateLimiterType string
-	Limits          LimitConfigMap
+	Redis              *RedisConfig
+	RedisAddr          string
+	RateLimiterType    string
+	RateLimiterBackend string
+	Limits             LimitConfigMap
+	Global             *LimitConfig
+	Overrides          OverrideList
+	Gateway            *GatewayConfig
+}
+
+// LoadOption configures how a configuration file is loaded.
+type LoadOption func(*loadOptions)
+
+// loadOptions are the settings of Load.
+type loadOptions struct {
+	strict bool
+	format Format
+}
+
+// Strict makes Load reject the fields of the configuration file it does not know about, such as misspelled ones,
+// which are ignored otherwise.
+func Strict() LoadOption {
+	return func(opts *loadOptions) {
+		opts.strict = true
+	}
 }
 
 // Load reads the configuration file at the specified filepath and returns a NotificationService.
-// It parses the JSON content of the file and populates the service's configurations.
-func Load(filepath string) (*NotificationService, error) {
+// It parses the content of the file, in the format given by its extension or the WithFormat option,
+// validates it and populates the service's configurations.
+// A configuration with missing sections or values out of range is rejected with a *ValidationError,
+// which lists every problem found along with the JSON path of the field.
+func Load(filepath string, opts ...LoadOption) (*NotificationService, error) {
 	content, err := os.ReadFile(filepath)
 	if err != nil {
 		return nil, err
 	}
 
+	lopts := newLoadOptions(opts)
+	return load(content, lopts.fileFormat(filepath), lopts)
+}
+
+// newLoadOptions applies the options to the default settings.
+func newLoadOptions(opts []LoadOption) *loadOptions {
+	lopts := &loadOptions{}
+	for _, opt := range opts {
+		opt(lopts)
+	}
+
+	return lopts
+}
+
+// fileFormat returns the format of the configuration file, either the one set by WithFormat or the one of its extension.
+func (opts *loadOptions) fileFormat(path string) Format {
+	if opts.format != "" {
+		return opts.format
+	}
+
+	return FormatOf(path)
+}
+
+// load parses and validates the content of a configuration file in the format.
+func load(content []byte, format Format, opts *loadOptions) (*NotificationService, error) {
+	jsonConf, _, v, err := decode(content, format, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return build(jsonConf, v)
+}
+
+// decode parses the content of a configuration file in the format, returning the configuration along with
+// its raw JSON value and the validator with the problems found in its fields.
+func decode(content []byte, format Format, opts *loadOptions) (*JsonConfiguration, interface{}, *validator, error) {
+	content, err := toJSON(content, format)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// The fields that would fail to decode are checked first, so their problems are reported with their paths
+	v := &validator{}
+	v.checkFields(raw, opts.strict)
+	if v.undecodable {
+		return nil, nil, nil, v.err()
+	}
+
 	var jsonConf JsonConfiguration
 	if err := json.Unmarshal(content, &jsonConf); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return &jsonConf, raw, v, nil
+}
+
+// build validates the configuration, adding its problems to the ones already found, and populates the service's
+// configurations when there are none.
+func build(jsonConf *JsonConfiguration, v *validator) (*NotificationService, error) {
+	jsonConf.validate(v)
+	if err := v.err(); err != nil {
 		return nil, err
 	}
 
+	return newNotificationService(jsonConf), nil
+}
+
+// newNotificationService populates the service's configurations from the parsed configuration file.
+func newNotificationService(jsonConf *JsonConfiguration) *NotificationService {
 	limits := make(map[string]*LimitConfig, len(jsonConf.RateLimit.Limits))
 	for _, config := range jsonConf.RateLimit.Limits {
 		limits[config.Type] = config
 	}
 
-	// Create a new NotificationService with the parsed configurations.
-	service := &NotificationService{
-		RedisAddr:       jsonConf.Redis.Address(),
-		RateLimiterType: jsonConf.RateLimit.Type,
-		Limits:          limits,
+	// The redis section is optional for the memory backend
+	var redisAddr string
+	if jsonConf.Redis != nil {
+		redisAddr = jsonConf.Redis.Address()
 	}
 
-	return service, nil
+	// The gateway section is optional, logging the notifications without it
+	gateway := jsonConf.Gateway
+	if gateway == nil {
+		gateway = &GatewayConfig{Type: GatewayLog}
+	}
+
+	// Create a new NotificationService with the parsed configurations.
+	return &NotificationService{
+		Redis:              jsonConf.Redis,
+		RedisAddr:          redisAddr,
+		RateLimiterType:    jsonConf.RateLimit.Type,
+		RateLimiterBackend: jsonConf.RateLimit.Backend,
+		Limits:             limits,
+		Global:             jsonConf.RateLimit.Global,
+		Overrides:          jsonConf.RateLimit.Overrides,
+		Gateway:            gateway,
+	}
 }
 
 // WindowsSizeDuration returns the window size duration for the limit configuration.
 func (conf *LimitConfig) WindowsSizeDuration() time.Duration {
-	return time.Millisecond * time.Duration(conf.WSizeMs)
+	return conf.window().size()
+}
+
+// Quota returns the limit and the time window to check requests against.
+// When both RefillRate and Burst are set, the limit is the burst and the window is the time
+// it takes to refill it at the configured rate, e.g. a refill rate of 10 and a burst of 50
+// results in 50 requests per 5 seconds. Otherwise, Limit and the window size are returned.
+func (conf *LimitConfig) Quota() (int64, time.Duration) {
+	return conf.window().Quota()
+}
+
+// WindowConfigs returns the windows the notification type is limited by, which is a single one
+// built from Limit, WSizeMs, WSize, RefillRate and Burst when Windows is empty.
+func (conf *LimitConfig) WindowConfigs() []*WindowConfig {
+	if len(conf.Windows) > 0 {
+		return conf.Windows
+	}
+
+	return []*WindowConfig{conf.window()}
+}
+
+// RateLimiterWindows returns the windows to check with the rate limiter for the key, such as the one returned
+// by LimitKey. A configuration with a single window keeps its limit under the key, while each of several windows
+// gets its own key suffixed with the window size in milliseconds, all of them sharing the cluster hash tag of the key.
+func (conf *LimitConfig) RateLimiterWindows(key string) []rate_limiter.Window {
+	wconfs := conf.WindowConfigs()
+
+	windows := make([]rate_limiter.Window, 0, len(wconfs))
+	for _, wconf := range wconfs {
+		limit, size := wconf.Quota()
+
+		window := rate_limiter.Window{Key: key, Limit: limit, Size: size}
+		if len(wconfs) > 1 {
+			window.Key = fmt.Sprintf("%v:%v", key, size.Milliseconds())
+		}
+		windows = append(windows, window)
+	}
+
+	return windows
+}
+
+// LimitKey returns the rate limit key of the limit of a type for a subject, such as a user ID or a client IP.
+// The subject is the cluster hash tag of the key, so all the keys of a subject land on the same redis slot.
+func LimitKey(subject, typ string) string {
+	return fmt.Sprintf("{%v}-%v", subject, typ)
+}
+
+// window returns the single window described by Limit, WSizeMs, WSize, RefillRate and Burst.
+func (conf *LimitConfig) window() *WindowConfig {
+	return &WindowConfig{
+		Limit:      conf.Limit,
+		WSizeMs:    conf.WSizeMs,
+		WSize:      conf.WSize,
+		RefillRate: conf.RefillRate,
+		Burst:      conf.Burst,
+	}
+}
+
+// Quota returns the limit and the time window of the window, the same way LimitConfig.Quota does.
+func (wc *WindowConfig) Quota() (int64, time.Duration) {
+	if wc.RefillRate > 0 && wc.Burst > 0 {
+		return wc.Burst, time.Duration(float64(wc.Burst) / wc.RefillRate * float64(time.Second))
+	}
+
+	return wc.Limit, wc.size()
+}
+
+// size returns the window size, which is WSize when it is set and WSizeMs otherwise.
+func (wc *WindowConfig) size() time.Duration {
+	if wc.WSize != 0 {
+		return time.Duration(wc.WSize)
+	}
+
+	return time.Millisecond * time.Duration(wc.WSizeMs)
+}
+
+// FailurePolicy returns the policy applied when the rate limiter backend is unavailable, FailClosed by default.
+func (conf *LimitConfig) FailurePolicy() string {
+	if conf.OnFailure == "" {
+		return FailClosed
+	}
+	return conf.OnFailure
+}
+
+// DegradedLimit scales down the limit for the Degrade policy, allowing at least one request.
+func (conf *LimitConfig) DegradedLimit(limit int64) int64 {
+	ratio := conf.DegradedRatio
+	if ratio <= 0 {
+		ratio = defaultDegradedRatio
+	}
+
+	return int64(math.Max(1, math.Floor(float64(limit)*ratio)))
 }
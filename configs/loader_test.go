@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/godoylucase/rate-limit/rate_limiter"
+
+	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,17 +27,24 @@ func TestLoad(t *testing.T) {
 			Port: 6379,
 		},
 		RateLimit: &RateLimitConfig{
-			Type: "sample_rate_limiter",
+			Type:    "sliding_window",
+			Backend: "memory",
 			Limits: []*LimitConfig{
 				{
-					Type:  "type1",
-					Limit: 10,
+					Type:    "type1",
+					Limit:   10,
+					WSizeMs: 1000,
 				},
 				{
-					Type:  "type2",
-					Limit: 20,
+					Type:    "type2",
+					Limit:   20,
+					WSizeMs: 1000,
 				},
 			},
+			Global: &LimitConfig{
+				Limit:   50,
+				WSizeMs: 60000,
+			},
 		},
 	}
 
@@ -60,7 +71,10 @@ func TestLoad(t *testing.T) {
 	// Verify the loaded service matches the expected values
 	assert.Equal(t, conf.Redis.Address(), service.RedisAddr, "Unexpected GatewayType")
 	assert.Equal(t, conf.RateLimit.Type, service.RateLimiterType, "Unexpected RateLimiterType")
+	assert.Equal(t, conf.RateLimit.Backend, service.RateLimiterBackend, "Unexpected RateLimiterBackend")
 	assert.Len(t, service.Limits, len(conf.RateLimit.Limits), "Unexpected number of Limits")
+	assert.Equal(t, conf.RateLimit.Global, service.Global, "Unexpected Global limit")
+	assert.Equal(t, &GatewayConfig{Type: GatewayLog}, service.Gateway, "Unexpected Gateway")
 	for _, limit := range conf.RateLimit.Limits {
 		assert.NotNil(t, service.Limits[limit.Type], "Missing LimitConfig for type: %s", limit.Type)
 		assert.Equal(t, limit.Limit, service.Limits[limit.Type].Limit, "Unexpected Limit value for type %s", limit.Type)
@@ -113,3 +127,157 @@ func TestLimitConfigMap_Get(t *testing.T) {
 	invalidLimit := lcm.Get("invalid_type")
 	assert.Nil(t, invalidLimit, "Get() did not return nil for invalid type")
 }
+
+func TestLimitConfig_Quota(t *testing.T) {
+	tests := []struct {
+		name        string
+		conf        *LimitConfig
+		wantLimit   int64
+		wantTWindow time.Duration
+	}{
+		{
+			name:        "window limit",
+			conf:        &LimitConfig{Type: "type1", Limit: 10, WSizeMs: 1000},
+			wantLimit:   10,
+			wantTWindow: time.Second,
+		},
+		{
+			name:        "refill rate and burst",
+			conf:        &LimitConfig{Type: "type1", Limit: 10, WSizeMs: 1000, RefillRate: 10, Burst: 50},
+			wantLimit:   50,
+			wantTWindow: 5 * time.Second,
+		},
+		{
+			name:        "burst without refill rate",
+			conf:        &LimitConfig{Type: "type1", Limit: 10, WSizeMs: 1000, Burst: 50},
+			wantLimit:   10,
+			wantTWindow: time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, tWindow := tt.conf.Quota()
+			assert.Equal(t, tt.wantLimit, limit)
+			assert.Equal(t, tt.wantTWindow, tWindow)
+		})
+	}
+}
+
+func TestLimitConfig_WindowConfigs(t *testing.T) {
+	single := &LimitConfig{Type: "type1", Limit: 10, WSizeMs: 1000}
+	assert.Equal(t, []*WindowConfig{{Limit: 10, WSizeMs: 1000}}, single.WindowConfigs())
+
+	var stacked LimitConfig
+	err := json.Unmarshal([]byte(`{
+		"type": "type1",
+		"windows": [
+			{"limit": 3, "window_size_ms": 60000},
+			{"limit": 50, "window_size_ms": 86400000},
+			{"refill_rate": 10, "burst": 50}
+		]
+	}`), &stacked)
+	assert.NoError(t, err)
+
+	windows := stacked.WindowConfigs()
+	assert.Len(t, windows, 3)
+
+	limit, tWindow := windows[1].Quota()
+	assert.Equal(t, int64(50), limit)
+	assert.Equal(t, 24*time.Hour, tWindow)
+
+	limit, tWindow = windows[2].Quota()
+	assert.Equal(t, int64(50), limit)
+	assert.Equal(t, 5*time.Second, tWindow)
+}
+
+func TestLimitConfig_RateLimiterWindows(t *testing.T) {
+	key := LimitKey("user", "news")
+	assert.Equal(t, "{user}-news", key)
+
+	single := &LimitConfig{Type: "news", Limit: 10, WSize: Duration(time.Minute)}
+	assert.Equal(t, []rate_limiter.Window{{Key: "{user}-news", Limit: 10, Size: time.Minute}}, single.RateLimiterWindows(key))
+
+	stacked := &LimitConfig{Type: "news", Windows: []*WindowConfig{
+		{Limit: 3, WSize: Duration(time.Minute)},
+		{Limit: 50, WSizeMs: 86400000},
+	}}
+	assert.Equal(t, []rate_limiter.Window{
+		{Key: "{user}-news:60000", Limit: 3, Size: time.Minute},
+		{Key: "{user}-news:86400000", Limit: 50, Size: 24 * time.Hour},
+	}, stacked.RateLimiterWindows(key))
+}
+
+func TestRedisConfig_UniversalOptions(t *testing.T) {
+	rc := &RedisConfig{
+		Addrs:      []string{"redis-0:6379", "redis-1:6379", "redis-2:6379"},
+		MasterName: "mymaster",
+		Username:   "user",
+		Password:   "secret",
+		DB:         2,
+		TLS:        &TLSConfig{ServerName: "redis.internal"},
+	}
+
+	opts := rc.UniversalOptions()
+	assert.Equal(t, rc.Addrs, opts.Addrs)
+	assert.Equal(t, rc.MasterName, opts.MasterName)
+	assert.Equal(t, rc.Username, opts.Username)
+	assert.Equal(t, rc.Password, opts.Password)
+	assert.Equal(t, rc.DB, opts.DB)
+	assert.NotNil(t, opts.TLSConfig)
+	assert.Equal(t, "redis.internal", opts.TLSConfig.ServerName)
+
+	single := &RedisConfig{Host: "localhost", Port: 6379}
+	assert.Equal(t, []string{"localhost:6379"}, single.UniversalOptions().Addrs)
+	assert.Nil(t, single.UniversalOptions().TLSConfig)
+}
+
+func TestRedisConfig_Client(t *testing.T) {
+	tests := []struct {
+		name string
+		conf *RedisConfig
+		want redis.UniversalClient
+	}{
+		{
+			name: "single node",
+			conf: &RedisConfig{Host: "localhost", Port: 6379},
+			want: &redis.Client{},
+		},
+		{
+			name: "cluster seeds",
+			conf: &RedisConfig{Addrs: []string{"redis-0:6379", "redis-1:6379"}},
+			want: &redis.ClusterClient{},
+		},
+		{
+			name: "cluster with a single seed",
+			conf: &RedisConfig{Addrs: []string{"redis-0:6379"}, Cluster: true},
+			want: &redis.ClusterClient{},
+		},
+		{
+			name: "sentinel",
+			conf: &RedisConfig{Addrs: []string{"sentinel-0:26379"}, MasterName: "mymaster"},
+			want: &redis.Client{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := tt.conf.Client()
+			defer client.Close()
+
+			assert.IsType(t, tt.want, client)
+		})
+	}
+}
+
+func TestLimitConfig_FailurePolicy(t *testing.T) {
+	assert.Equal(t, FailClosed, (&LimitConfig{}).FailurePolicy())
+	assert.Equal(t, FailOpen, (&LimitConfig{OnFailure: FailOpen}).FailurePolicy())
+	assert.Equal(t, Degrade, (&LimitConfig{OnFailure: Degrade}).FailurePolicy())
+}
+
+func TestLimitConfig_DegradedLimit(t *testing.T) {
+	assert.Equal(t, int64(50), (&LimitConfig{}).DegradedLimit(100))
+	assert.Equal(t, int64(10), (&LimitConfig{DegradedRatio: 0.1}).DegradedLimit(100))
+	assert.Equal(t, int64(1), (&LimitConfig{DegradedRatio: 0.1}).DegradedLimit(5))
+}
@@ -0,0 +1,44 @@
+package configs
+
+import "context"
+
+// Override replaces the rate limits of a user, or of all the users of a tenant when TenantID is set instead of UserID.
+// Limits replaces the limit configuration of the notification types it lists, keeping the default one of the rest,
+// and Global replaces the global limit per user. An exempt user is not rate limited at all.
+type Override struct {
+	UserID   string         `json:"user_id,omitempty"`
+	TenantID string         `json:"tenant_id,omitempty"`
+	Exempt   bool           `json:"exempt,omitempty"`
+	Limits   []*LimitConfig `json:"limits,omitempty"`
+	Global   *LimitConfig   `json:"global,omitempty"`
+}
+
+// Limit returns the limit configuration of the override for the specified type, or nil when it keeps the default one.
+func (o *Override) Limit(typ string) *LimitConfig {
+	for _, conf := range o.Limits {
+		if conf.Type == typ {
+			return conf
+		}
+	}
+
+	return nil
+}
+
+// OverrideList is the static list of overrides of the configuration.
+type OverrideList []*Override
+
+// Override returns the override of a user, falling back to the one of its tenant, or nil when there is none.
+// It never fails, which lets the list be used as the override provider of the notification service.
+func (ol OverrideList) Override(_ context.Context, userID, tenantID string) (*Override, error) {
+	var tenantOverride *Override
+	for _, override := range ol {
+		switch {
+		case override.UserID != "" && override.UserID == userID:
+			return override, nil
+		case override.UserID == "" && tenantID != "" && override.TenantID == tenantID && tenantOverride == nil:
+			tenantOverride = override
+		}
+	}
+
+	return tenantOverride, nil
+}
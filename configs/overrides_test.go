@@ -0,0 +1,44 @@
+package configs
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverrideList_Override(t *testing.T) {
+	var overrides OverrideList
+	err := json.Unmarshal([]byte(`[
+		{"tenant_id": "enterprise", "limits": [{"type": "news", "limit": 100, "window_size_ms": 1000}]},
+		{"user_id": "vip", "tenant_id": "enterprise", "exempt": true},
+		{"user_id": "abuser", "limits": [{"type": "news", "limit": 1, "window_size_ms": 60000}], "global": {"limit": 5, "window_size_ms": 86400000}}
+	]`), &overrides)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		userID   string
+		tenantID string
+		want     *Override
+	}{
+		{name: "user override", userID: "abuser", want: overrides[2]},
+		{name: "user override over the tenant one", userID: "vip", tenantID: "enterprise", want: overrides[1]},
+		{name: "tenant override", userID: "employee", tenantID: "enterprise", want: overrides[0]},
+		{name: "no override", userID: "someone", tenantID: "startup"},
+		{name: "no tenant", userID: "someone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			override, err := overrides.Override(context.Background(), tt.userID, tt.tenantID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, override)
+		})
+	}
+
+	assert.Equal(t, int64(1), overrides[2].Limit("news").Limit)
+	assert.Nil(t, overrides[2].Limit("status"))
+}
@@ -0,0 +1,471 @@
+package configs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/godoylucase/rate-limit/rate_limiter"
+)
+
+// FieldError is a problem with a field of the configuration, located by its JSON path,
+// such as "rate_limit.limits[0].window_size_ms".
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+// Error returns the string representation of the FieldError error.
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Path, e.Message)
+}
+
+// ValidationError lists every problem found in a configuration, so all of them can be fixed at once.
+type ValidationError struct {
+	Problems []*FieldError
+}
+
+// Error returns the string representation of the ValidationError error.
+func (e *ValidationError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		problems = append(problems, problem.Error())
+	}
+
+	return fmt.Sprintf("invalid configuration, %v problem(s) found: %v", len(e.Problems), strings.Join(problems, "; "))
+}
+
+// Unwrap returns the problems of the configuration.
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		errs = append(errs, problem)
+	}
+
+	return errs
+}
+
+// validator collects the problems found in a configuration.
+// Undecodable is set when the configuration has values that cannot be decoded, so it cannot be validated further.
+type validator struct {
+	problems    []*FieldError
+	undecodable bool
+}
+
+// add records a problem with the field at path.
+func (v *validator) add(path string, format string, args ...interface{}) {
+	v.problems = append(v.problems, &FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns a ValidationError with the problems found, or nil when there are none.
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Problems: v.problems}
+}
+
+// field returns the path of a field of the object at path.
+func field(path, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+// index returns the path of an element of the array at path.
+func index(path string, i int) string {
+	return fmt.Sprintf("%v[%v]", path, i)
+}
+
+// validate checks that the configuration has the sections the notification service needs and that
+// every value is within its range, so that nothing is silently ignored or replaced by a default.
+func (jc *JsonConfiguration) validate(v *validator) {
+	backend := ""
+	if jc.RateLimit == nil {
+		v.add("rate_limit", "missing section")
+	} else {
+		backend = jc.RateLimit.Backend
+		jc.RateLimit.validate(v, "rate_limit")
+	}
+
+	switch {
+	case jc.Redis != nil:
+		jc.Redis.validate(v, "redis")
+	case backend != rate_limiter.MemoryBackend:
+		v.add("redis", "missing section, which is required unless rate_limit.backend is %v", rate_limiter.MemoryBackend)
+	}
+
+	if jc.Gateway != nil {
+		jc.Gateway.validate(v, "gateway")
+	}
+}
+
+// validate checks the gateway settings, where a webhook needs an absolute http or https URL.
+func (gc *GatewayConfig) validate(v *validator, path string) {
+	switch gc.Type {
+	case "", GatewayLog:
+	case GatewayWebhook:
+		if gc.URL == "" {
+			v.add(field(path, "url"), "must be set for the %v gateway", GatewayWebhook)
+		} else if u, err := url.Parse(gc.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add(field(path, "url"), "must be an absolute http or https URL, got %q", gc.URL)
+		}
+	default:
+		v.add(field(path, "type"), "unknown gateway %q, must be %v or %v", gc.Type, GatewayLog, GatewayWebhook)
+	}
+	if gc.Timeout < 0 {
+		v.add(field(path, "timeout"), "must not be negative, got %v", time.Duration(gc.Timeout))
+	}
+}
+
+// validate checks the redis connection settings.
+func (rc *RedisConfig) validate(v *validator, path string) {
+	if rc.Host == "" && len(rc.Addrs) == 0 {
+		v.add(field(path, "host"), "must be set unless addrs is")
+	}
+	if rc.Port < 0 || rc.Port > 65535 {
+		v.add(field(path, "port"), "must be between 0 and 65535, got %v", rc.Port)
+	}
+	for i, addr := range rc.Addrs {
+		if addr == "" {
+			v.add(index(field(path, "addrs"), i), "must not be empty")
+		}
+	}
+	if rc.DB < 0 {
+		v.add(field(path, "db"), "must not be negative, got %v", rc.DB)
+	}
+	if rc.Cluster && rc.MasterName != "" {
+		v.add(field(path, "master_name"), "must not be set along with cluster")
+	}
+	if rc.Cluster && rc.DB != 0 {
+		v.add(field(path, "db"), "must be 0 in cluster mode, got %v", rc.DB)
+	}
+}
+
+// validate checks the rate limiter settings, the limits of the notification types, the global limit and the overrides.
+func (rlc *RateLimitConfig) validate(v *validator, path string) {
+	switch {
+	case rlc.Type == "":
+		v.add(field(path, "type"), "must be one of %v", strings.Join(rate_limiter.Types, ", "))
+	case !rate_limiter.IsValidType(rlc.Type):
+		v.add(field(path, "type"), "unknown rate limiter type %q, must be one of %v", rlc.Type, strings.Join(rate_limiter.Types, ", "))
+	}
+	if !rate_limiter.IsValidBackend(rlc.Backend) {
+		v.add(field(path, "backend"), "unknown backend %q, must be %v or %v", rlc.Backend, rate_limiter.RedisBackend, rate_limiter.MemoryBackend)
+	}
+
+	limitsPath := field(path, "limits")
+	if len(rlc.Limits) == 0 {
+		v.add(limitsPath, "must list at least one notification type")
+	}
+
+	types := make(map[string]string, len(rlc.Limits))
+	for i, conf := range rlc.Limits {
+		confPath := index(limitsPath, i)
+		if conf == nil {
+			v.add(confPath, "must not be null")
+			continue
+		}
+
+		if conf.Type == "" {
+			v.add(field(confPath, "type"), "must be set")
+		} else if other, ok := types[conf.Type]; ok {
+			v.add(field(confPath, "type"), "duplicates the type %q of %v", conf.Type, other)
+		} else {
+			types[conf.Type] = confPath
+		}
+		conf.validate(v, confPath)
+	}
+
+	if rlc.Global != nil {
+		rlc.Global.validate(v, field(path, "global"))
+	}
+
+	rlc.Overrides.validate(v, field(path, "overrides"), types)
+}
+
+// validate checks the overrides, whose limits must belong to the notification types of the configuration.
+func (ol OverrideList) validate(v *validator, path string, types map[string]string) {
+	owners := make(map[string]string, len(ol))
+	for i, override := range ol {
+		overridePath := index(path, i)
+		if override == nil {
+			v.add(overridePath, "must not be null")
+			continue
+		}
+
+		// The override of a user is the first one with its user ID, and the one of a tenant the first one
+		// with its tenant ID and no user ID, so the following ones would never apply
+		owner := ""
+		switch {
+		case override.UserID != "":
+			owner = fmt.Sprintf("user %q", override.UserID)
+		case override.TenantID != "":
+			owner = fmt.Sprintf("tenant %q", override.TenantID)
+		default:
+			v.add(overridePath, "must set user_id or tenant_id")
+		}
+		if other, ok := owners[owner]; ok {
+			v.add(overridePath, "duplicates the override of %v in %v", owner, other)
+		} else if owner != "" {
+			owners[owner] = overridePath
+		}
+
+		if override.Exempt && (len(override.Limits) > 0 || override.Global != nil) {
+			v.add(field(overridePath, "exempt"), "lifts every limit, so limits and global must not be set")
+		}
+
+		limitsPath := field(overridePath, "limits")
+		overridden := make(map[string]string, len(override.Limits))
+		for j, conf := range override.Limits {
+			confPath := index(limitsPath, j)
+			if conf == nil {
+				v.add(confPath, "must not be null")
+				continue
+			}
+
+			switch _, ok := types[conf.Type]; {
+			case conf.Type == "":
+				v.add(field(confPath, "type"), "must be set")
+			case !ok:
+				v.add(field(confPath, "type"), "unknown notification type %q", conf.Type)
+			case overridden[conf.Type] != "":
+				v.add(field(confPath, "type"), "duplicates the type %q of %v", conf.Type, overridden[conf.Type])
+			default:
+				overridden[conf.Type] = confPath
+			}
+			conf.validate(v, confPath)
+		}
+
+		if override.Global != nil {
+			override.Global.validate(v, field(overridePath, "global"))
+		}
+	}
+}
+
+// Validate checks a limit on its own, the same way Load checks the limits of a configuration file, such as a limit
+// given at runtime. It returns a *ValidationError with the problems found, whose paths are relative to the limit.
+func (conf *LimitConfig) Validate() error {
+	v := &validator{}
+	conf.validate(v, "")
+
+	return v.err()
+}
+
+// validate checks the windows and the failure policy of a limit.
+func (conf *LimitConfig) validate(v *validator, path string) {
+	switch conf.OnFailure {
+	case "", FailClosed, FailOpen, Degrade:
+	default:
+		v.add(field(path, "on_failure"), "unknown failure policy %q, must be one of %v, %v or %v", conf.OnFailure, FailClosed, FailOpen, Degrade)
+	}
+	if conf.DegradedRatio < 0 || conf.DegradedRatio > 1 {
+		v.add(field(path, "degraded_ratio"), "must be between 0 and 1, got %v", conf.DegradedRatio)
+	}
+
+	if len(conf.Windows) == 0 {
+		conf.window().validate(v, path)
+		return
+	}
+
+	// The windows replace the single window of the limit
+	for _, single := range []struct {
+		name string
+		set  bool
+	}{
+		{name: "limit", set: conf.Limit != 0},
+		{name: "window_size_ms", set: conf.WSizeMs != 0},
+		{name: "window_size", set: conf.WSize != 0},
+		{name: "refill_rate", set: conf.RefillRate != 0},
+		{name: "burst", set: conf.Burst != 0},
+	} {
+		if single.set {
+			v.add(field(path, single.name), "must not be set along with windows")
+		}
+	}
+
+	windowsPath := field(path, "windows")
+	sizes := make(map[time.Duration]string, len(conf.Windows))
+	for i, window := range conf.Windows {
+		windowPath := index(windowsPath, i)
+		if window == nil {
+			v.add(windowPath, "must not be null")
+			continue
+		}
+		window.validate(v, windowPath)
+
+		// The windows of a limit are kept under keys suffixed with their size
+		_, size := window.Quota()
+		if other, ok := sizes[size]; ok && size > 0 {
+			v.add(windowPath, "duplicates the window size %v of %v", size, other)
+		} else {
+			sizes[size] = windowPath
+		}
+	}
+}
+
+// validate checks that the window has a positive limit and size, either set directly or through
+// the refill rate and burst of a token bucket.
+func (wc *WindowConfig) validate(v *validator, path string) {
+	if wc.RefillRate < 0 {
+		v.add(field(path, "refill_rate"), "must not be negative, got %v", wc.RefillRate)
+	}
+	if wc.Burst < 0 {
+		v.add(field(path, "burst"), "must not be negative, got %v", wc.Burst)
+	}
+
+	switch {
+	case wc.RefillRate > 0 && wc.Burst > 0:
+		if _, size := wc.Quota(); size < time.Millisecond {
+			v.add(field(path, "refill_rate"), "refills the burst in less than a millisecond")
+		}
+	case wc.RefillRate > 0:
+		v.add(field(path, "burst"), "must be set along with refill_rate")
+	case wc.Burst > 0:
+		v.add(field(path, "refill_rate"), "must be set along with burst")
+	default:
+		if wc.Limit < 1 {
+			v.add(field(path, "limit"), "must be positive, got %v", wc.Limit)
+		}
+		wc.validateSize(v, path)
+	}
+}
+
+// validateSize checks that the window size is set once, either in milliseconds or as a duration,
+// and that it is a positive number of milliseconds, which is the precision of the rate limiters.
+func (wc *WindowConfig) validateSize(v *validator, path string) {
+	size := time.Duration(wc.WSize)
+	switch {
+	case size == 0 && wc.WSizeMs < 1:
+		v.add(field(path, "window_size_ms"), "must be positive, got %v", wc.WSizeMs)
+	case size == 0:
+	case wc.WSizeMs != 0:
+		v.add(field(path, "window_size"), "must not be set along with window_size_ms")
+	case size < time.Millisecond:
+		v.add(field(path, "window_size"), "must be at least 1ms, got %v", size)
+	case size%time.Millisecond != 0:
+		v.add(field(path, "window_size"), "must be a whole number of milliseconds, got %v", size)
+	}
+}
+
+// checkFields walks the raw JSON value of a configuration along with the configuration it is decoded into,
+// recording a problem for every value of the wrong type, such as a string for a number or a duration that cannot be
+// parsed, and for every field the configuration does not have in strict mode, which would otherwise be ignored,
+// such as a misspelled one.
+func (v *validator) checkFields(raw interface{}, strict bool) {
+	v.checkFieldsOf("", raw, reflect.TypeOf(JsonConfiguration{}), strict)
+}
+
+// checkFieldsOf walks the decoded JSON value at path along with the type it is decoded into.
+// Field names are matched case insensitively, as encoding/json does.
+func (v *validator) checkFieldsOf(path string, raw interface{}, typ reflect.Type, strict bool) {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	if typ == reflect.TypeOf(Duration(0)) {
+		if s, ok := raw.(string); !ok {
+			v.add(path, "must be a duration such as \"1m\" or \"24h\", got %v", raw)
+			v.undecodable = true
+		} else if _, err := time.ParseDuration(s); err != nil {
+			v.add(path, "must be a duration such as \"1m\" or \"24h\", got %q", s)
+			v.undecodable = true
+		}
+		return
+	}
+
+	// A null leaves the field as it is, whatever its type
+	if raw == nil {
+		return
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		object, ok := raw.(map[string]interface{})
+		if !ok {
+			v.wrongType(path, "an object", raw)
+			return
+		}
+
+		for _, name := range sortedKeys(object) {
+			if sf, ok := jsonField(typ, name); ok {
+				v.checkFieldsOf(field(path, name), object[name], sf.Type, strict)
+			} else if strict {
+				v.add(field(path, name), "unknown field")
+			}
+		}
+	case reflect.Map:
+		object, ok := raw.(map[string]interface{})
+		if !ok {
+			v.wrongType(path, "an object", raw)
+			return
+		}
+
+		for _, name := range sortedKeys(object) {
+			v.checkFieldsOf(field(path, name), object[name], typ.Elem(), strict)
+		}
+	case reflect.Slice:
+		array, ok := raw.([]interface{})
+		if !ok {
+			v.wrongType(path, "an array", raw)
+			return
+		}
+
+		for i, elem := range array {
+			v.checkFieldsOf(index(path, i), elem, typ.Elem(), strict)
+		}
+	case reflect.String:
+		if _, ok := raw.(string); !ok {
+			v.wrongType(path, "a string", raw)
+		}
+	case reflect.Bool:
+		if _, ok := raw.(bool); !ok {
+			v.wrongType(path, "a boolean", raw)
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := raw.(float64); !ok {
+			v.wrongType(path, "a number", raw)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := raw.(float64); !ok || n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 || reflect.Zero(typ).OverflowInt(int64(n)) {
+			v.wrongType(path, "an integer", raw)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, ok := raw.(float64); !ok || n != math.Trunc(n) || n < 0 || n >= math.MaxUint64 || reflect.Zero(typ).OverflowUint(uint64(n)) {
+			v.wrongType(path, "a non-negative integer", raw)
+		}
+	}
+}
+
+// wrongType records the problem of a value that cannot be decoded into its field, which is described by what.
+func (v *validator) wrongType(path, what string, raw interface{}) {
+	value, _ := json.Marshal(raw)
+	v.add(path, "must be %v, got %s", what, value)
+	v.undecodable = true
+}
+
+// jsonField returns the field of the struct type that the JSON name is decoded into.
+func jsonField(typ reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if tag == "-" || !sf.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = sf.Name
+		}
+		if strings.EqualFold(tag, name) {
+			return sf, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
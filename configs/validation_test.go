@@ -0,0 +1,258 @@
+package configs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// problems returns the paths and messages of the problems of a ValidationError.
+func problems(t *testing.T, err error) []string {
+	t.Helper()
+
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+
+	found := make([]string, 0, len(validationErr.Problems))
+	for _, problem := range validationErr.Problems {
+		found = append(found, problem.Error())
+	}
+
+	return found
+}
+
+func TestLoad_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		strict  bool
+		want    []string
+	}{
+		{
+			name:    "missing sections",
+			content: `{}`,
+			want: []string{
+				"rate_limit: missing section",
+				"redis: missing section, which is required unless rate_limit.backend is memory",
+			},
+		},
+		{
+			name:    "memory backend without redis",
+			content: `{"rate_limit": {"type": "gcra", "backend": "memory", "limits": [{"type": "news", "limit": 1, "window_size_ms": 1000}]}}`,
+		},
+		{
+			name: "invalid rate limiter and redis settings",
+			content: `{
+				"redis": {"port": 70000, "cluster": true, "master_name": "mymaster", "db": 1},
+				"rate_limit": {"type": "leaky_bucket", "backend": "postgres", "limits": []}
+			}`,
+			want: []string{
+				`rate_limit.type: unknown rate limiter type "leaky_bucket", must be one of sliding_window, fixed_window, token_bucket, gcra`,
+				`rate_limit.backend: unknown backend "postgres", must be redis or memory`,
+				"rate_limit.limits: must list at least one notification type",
+				"redis.host: must be set unless addrs is",
+				"redis.port: must be between 0 and 65535, got 70000",
+				"redis.master_name: must not be set along with cluster",
+				"redis.db: must be 0 in cluster mode, got 1",
+			},
+		},
+		{
+			name: "invalid limits",
+			content: `{
+				"redis": {"host": "localhost"},
+				"rate_limit": {
+					"type": "sliding_window",
+					"limits": [
+						{"type": "news", "limit": -1, "window_size_ms": 0},
+						{"type": "news", "limit": 1, "window_size_ms": 1000, "on_failure": "retry", "degraded_ratio": 2},
+						{"limit": 1, "refill_rate": 10},
+						null
+					],
+					"global": {"limit": 5}
+				}
+			}`,
+			want: []string{
+				"rate_limit.limits[0].limit: must be positive, got -1",
+				"rate_limit.limits[0].window_size_ms: must be positive, got 0",
+				`rate_limit.limits[1].type: duplicates the type "news" of rate_limit.limits[0]`,
+				`rate_limit.limits[1].on_failure: unknown failure policy "retry", must be one of fail_closed, fail_open or degrade`,
+				"rate_limit.limits[1].degraded_ratio: must be between 0 and 1, got 2",
+				"rate_limit.limits[2].type: must be set",
+				"rate_limit.limits[2].burst: must be set along with refill_rate",
+				"rate_limit.limits[3]: must not be null",
+				"rate_limit.global.window_size_ms: must be positive, got 0",
+			},
+		},
+		{
+			name: "invalid windows",
+			content: `{
+				"redis": {"host": "localhost"},
+				"rate_limit": {
+					"type": "token_bucket",
+					"limits": [{
+						"type": "news",
+						"limit": 3,
+						"windows": [
+							{"limit": 3, "window_size_ms": 60000},
+							{"refill_rate": 0.05, "burst": 3},
+							{"refill_rate": 100000, "burst": 1}
+						]
+					}]
+				}
+			}`,
+			want: []string{
+				"rate_limit.limits[0].limit: must not be set along with windows",
+				"rate_limit.limits[0].windows[1]: duplicates the window size 1m0s of rate_limit.limits[0].windows[0]",
+				"rate_limit.limits[0].windows[2].refill_rate: refills the burst in less than a millisecond",
+			},
+		},
+		{
+			name: "invalid overrides",
+			content: `{
+				"redis": {"host": "localhost"},
+				"rate_limit": {
+					"type": "fixed_window",
+					"limits": [{"type": "news", "limit": 1, "window_size_ms": 1000}],
+					"overrides": [
+						{"exempt": true},
+						{"user_id": "vip", "exempt": true, "global": {"limit": 1, "window_size_ms": 1000}},
+						{"user_id": "vip", "limits": [{"type": "alerts", "limit": 1, "window_size_ms": 1000}]},
+						{"tenant_id": "acme", "limits": [{"type": "news", "limit": 0, "window_size_ms": 1000}]}
+					]
+				}
+			}`,
+			want: []string{
+				"rate_limit.overrides[0]: must set user_id or tenant_id",
+				"rate_limit.overrides[1].exempt: lifts every limit, so limits and global must not be set",
+				`rate_limit.overrides[2]: duplicates the override of user "vip" in rate_limit.overrides[1]`,
+				`rate_limit.overrides[2].limits[0].type: unknown notification type "alerts"`,
+				"rate_limit.overrides[3].limits[0].limit: must be positive, got 0",
+			},
+		},
+		{
+			name: "invalid gateway",
+			content: `{
+				"redis": {"host": "localhost"},
+				"rate_limit": {"type": "gcra", "limits": [{"type": "news", "limit": 1, "window_size_ms": 1000}]},
+				"gateway": {"type": "webhook", "url": "localhost:8080/notify", "timeout": "-1s"}
+			}`,
+			want: []string{
+				`gateway.url: must be an absolute http or https URL, got "localhost:8080/notify"`,
+				"gateway.timeout: must not be negative, got -1s",
+			},
+		},
+		{
+			name: "unknown gateway",
+			content: `{
+				"redis": {"host": "localhost"},
+				"rate_limit": {"type": "gcra", "limits": [{"type": "news", "limit": 1, "window_size_ms": 1000}]},
+				"gateway": {"type": "sms"}
+			}`,
+			want: []string{
+				`gateway.type: unknown gateway "sms", must be log or webhook`,
+			},
+		},
+		{
+			name: "values of the wrong type",
+			content: `{
+				"redis": {"host": 1, "port": "6379", "cluster": "yes", "addrs": "localhost:6379", "tls": true},
+				"rate_limit": {
+					"type": "gcra",
+					"limits": [{"type": "news", "limit": 1.5, "window_size_ms": 1000, "refill_rate": "fast"}],
+					"overrides": {}
+				},
+				"gateway": {"type": "webhook", "url": "http://localhost", "headers": {"X-Token": 1}}
+			}`,
+			want: []string{
+				`gateway.headers.X-Token: must be a string, got 1`,
+				`rate_limit.limits[0].limit: must be an integer, got 1.5`,
+				`rate_limit.limits[0].refill_rate: must be a number, got "fast"`,
+				`rate_limit.overrides: must be an array, got {}`,
+				`redis.addrs: must be an array, got "localhost:6379"`,
+				`redis.cluster: must be a boolean, got "yes"`,
+				`redis.host: must be a string, got 1`,
+				`redis.port: must be an integer, got "6379"`,
+				`redis.tls: must be an object, got true`,
+			},
+		},
+		{
+			name: "unknown fields are ignored",
+			content: `{
+				"redis": {"host": "localhost", "pasword": "secret"},
+				"rate_limit": {"type": "gcra", "limits": [{"type": "news", "limit": 1, "window_size_ms": 1000, "window_sise_ms": 5}]}
+			}`,
+		},
+		{
+			name: "unknown fields are rejected in strict mode",
+			content: `{
+				"redis": {"host": "localhost", "pasword": "secret"},
+				"rate_limit": {
+					"type": "gcra",
+					"limits": [{"type": "news", "limit": 1, "window_size_ms": 1000, "window_sise_ms": 5, "Burst": 0}],
+					"overrides": [{"user_id": "vip", "global": {"limit": 1, "window_size_ms": 1000, "unit": "ms"}}]
+				},
+				"gatway": {}
+			}`,
+			strict: true,
+			want: []string{
+				"gatway: unknown field",
+				"rate_limit.limits[0].window_sise_ms: unknown field",
+				"rate_limit.overrides[0].global.unit: unknown field",
+				"redis.pasword: unknown field",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			var opts []LoadOption
+			if tt.strict {
+				opts = append(opts, Strict())
+			}
+
+			conf, err := Load(path, opts...)
+			if len(tt.want) == 0 {
+				require.NoError(t, err)
+				assert.NotNil(t, conf)
+				return
+			}
+
+			assert.Nil(t, conf)
+			assert.Equal(t, tt.want, problems(t, err))
+		})
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	limitErr := &FieldError{Path: "rate_limit.limits[0].limit", Message: "must be positive, got 0"}
+	err := &ValidationError{Problems: []*FieldError{
+		{Path: "redis", Message: "missing section"},
+		limitErr,
+	}}
+
+	assert.EqualError(t, err, "invalid configuration, 2 problem(s) found: redis: missing section; rate_limit.limits[0].limit: must be positive, got 0")
+
+	var fieldErr *FieldError
+	require.True(t, errors.As(err, &fieldErr))
+	assert.Equal(t, "redis", fieldErr.Path)
+	assert.ErrorIs(t, err, limitErr)
+}
+
+func TestLimitConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&LimitConfig{Type: "news", Limit: 1, WSizeMs: 1000}).Validate())
+
+	err := (&LimitConfig{Type: "news", Limit: 1, WSizeMs: 1000, Windows: []*WindowConfig{{Limit: 0, WSize: Duration(time.Minute)}}}).Validate()
+	assert.Equal(t, []string{
+		"limit: must not be set along with windows",
+		"window_size_ms: must not be set along with windows",
+		"windows[0].limit: must be positive, got 0",
+	}, problems(t, err))
+}
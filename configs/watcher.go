@@ -0,0 +1,184 @@
+package configs
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultWatchInterval is how often a Watcher polls the configuration file when no interval is given.
+const DefaultWatchInterval = 5 * time.Second
+
+// ConfigChange describes a change of the configuration file seen by a Watcher. When the new content was rejected,
+// Err tells why and New is nil, since the Watcher keeps the Old configuration, which is the last good one.
+type ConfigChange struct {
+	Old *NotificationService
+	New *NotificationService
+	Err error
+}
+
+// Watcher keeps the configuration of a file up to date by polling it, so the rate limits can change
+// without restarting the notification service. A changed file is only applied once it is valid,
+// otherwise the Watcher keeps the last good configuration and reports the error to its subscribers.
+// Only the limits, the global limit and the overrides of a running service follow the changes,
+// while the redis and rate limiter settings keep the values they were created with.
+type Watcher struct {
+	path     string
+	interval time.Duration
+	parse    func(content []byte) (*NotificationService, error)
+	current  atomic.Pointer[NotificationService]
+
+	mu          sync.Mutex
+	subscribers []func(ConfigChange)
+	seen        [sha256.Size]byte
+	applied     [sha256.Size]byte
+	lastErr     error
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWatcher loads the configuration file at the specified filepath and starts polling it for changes every interval,
+// or every DefaultWatchInterval when interval is not positive. The file must hold a valid configuration to begin with.
+// The options apply to every load of the file, and the Watcher must be closed to stop polling.
+// Use Loader.Watch instead for the environment variables and the settings of a Loader to apply to every load.
+func NewWatcher(filepath string, interval time.Duration, opts ...LoadOption) (*Watcher, error) {
+	lopts := newLoadOptions(opts)
+	return newWatcher(filepath, interval, func(content []byte) (*NotificationService, error) {
+		return load(content, lopts.fileFormat(filepath), lopts)
+	})
+}
+
+// newWatcher loads the configuration file at the specified filepath with parse, which parses every content
+// of the file, and starts polling it for changes.
+func newWatcher(filepath string, interval time.Duration, parse func(content []byte) (*NotificationService, error)) (*Watcher, error) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	content, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %v: %w", filepath, err)
+	}
+
+	w := &Watcher{
+		path:     filepath,
+		interval: interval,
+		parse:    parse,
+		seen:     sha256.Sum256(content),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	w.applied = w.seen
+	w.current.Store(conf)
+
+	go w.watch()
+
+	return w, nil
+}
+
+// Config returns the configuration currently in use, which is the last good one read from the file.
+func (w *Watcher) Config() *NotificationService {
+	return w.current.Load()
+}
+
+// Override returns the override of a user, or of its tenant, listed in the configuration currently in use,
+// which lets the Watcher be used as the override provider of the notification service.
+func (w *Watcher) Override(ctx context.Context, userID, tenantID string) (*Override, error) {
+	return w.Config().Overrides.Override(ctx, userID, tenantID)
+}
+
+// Subscribe registers a function that is called with every change of the configuration file, either applied
+// or rejected, for instance to log it. The functions are called in the order they were registered, one change
+// at a time, and they must not call Subscribe nor Reload.
+func (w *Watcher) Subscribe(fn func(ConfigChange)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.subscribers = append(w.subscribers, fn)
+}
+
+// Reload reads the configuration file right away instead of waiting for the next poll, and applies it when
+// it changed. It returns the error the file was rejected with, which keeps being returned until the file changes.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// A file that cannot be read is seen as the zero hash, so the error is only reported once
+	var sum [sha256.Size]byte
+	content, err := os.ReadFile(w.path)
+	if err == nil {
+		sum = sha256.Sum256(content)
+	}
+
+	if sum == w.seen {
+		return w.lastErr
+	}
+	w.seen = sum
+
+	var conf *NotificationService
+	switch {
+	case err != nil:
+		err = fmt.Errorf("error reading config file %v: %w", w.path, err)
+	case sum == w.applied:
+		// The file is back to the configuration in use
+		w.lastErr = nil
+		return nil
+	default:
+		if conf, err = w.parse(content); err != nil {
+			err = fmt.Errorf("invalid config file %v: %w", w.path, err)
+		}
+	}
+
+	change := ConfigChange{Old: w.current.Load(), Err: err}
+	if err == nil {
+		change.New = conf
+		w.current.Store(conf)
+		w.applied = sum
+	}
+	w.lastErr = err
+
+	for _, fn := range w.subscribers {
+		fn(change)
+	}
+
+	return err
+}
+
+// Close stops polling the configuration file. The configuration in use stays available.
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+	<-w.stopped
+
+	return nil
+}
+
+// watch polls the configuration file until the Watcher is closed.
+func (w *Watcher) watch() {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Rejected files are reported to the subscribers
+			_ = w.Reload()
+		case <-w.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package configs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const watchedConfig = `{
+	"redis": {"host": "localhost", "port": 6379},
+	"rate_limit": {
+		"type": "sliding_window",
+		"limits": [{"type": "news", "limit": %v, "window_size_ms": 1000}],
+		"overrides": [{"user_id": "vip", "exempt": true}]
+	}
+}`
+
+// writeConfig writes the content to the configuration file, formatting it with the arguments.
+func writeConfig(t *testing.T, path string, content string, args ...interface{}) {
+	t.Helper()
+	if len(args) > 0 {
+		content = fmt.Sprintf(content, args...)
+	}
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestLoader_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig(t, path, watchedConfig, 10)
+	t.Setenv("RATELIMIT_REDIS_HOST", "redis.internal")
+
+	w, err := NewLoader(path).Set("rate_limit.limits[news].window_size", "1m").Watch(time.Hour)
+	require.NoError(t, err)
+	defer w.Close()
+
+	assert.Equal(t, "redis.internal", w.Config().Redis.Host)
+	assert.Equal(t, time.Minute, w.Config().Limits.Get("news").WindowsSizeDuration())
+
+	// The environment variables and the settings still apply once the file changes
+	writeConfig(t, path, watchedConfig, 20)
+	require.NoError(t, w.Reload())
+	assert.Equal(t, int64(20), w.Config().Limits.Get("news").Limit)
+	assert.Equal(t, "redis.internal", w.Config().Redis.Host)
+	assert.Equal(t, time.Minute, w.Config().Limits.Get("news").WindowsSizeDuration())
+
+	_, err = NewLoader("").Watch(time.Hour)
+	assert.Error(t, err)
+}
+
+func TestWatcher_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig(t, path, watchedConfig, 10)
+
+	w, err := NewWatcher(path, time.Hour)
+	require.NoError(t, err)
+	defer w.Close()
+
+	var changes []ConfigChange
+	w.Subscribe(func(change ConfigChange) {
+		changes = append(changes, change)
+	})
+
+	initial := w.Config()
+	assert.Equal(t, int64(10), initial.Limits.Get("news").Limit)
+
+	// An unchanged file is not reported
+	assert.NoError(t, w.Reload())
+	assert.Empty(t, changes)
+
+	// A valid change is applied
+	writeConfig(t, path, watchedConfig, 20)
+	assert.NoError(t, w.Reload())
+	require.Len(t, changes, 1)
+	assert.Same(t, initial, changes[0].Old)
+	assert.Same(t, w.Config(), changes[0].New)
+	assert.NoError(t, changes[0].Err)
+	assert.Equal(t, int64(20), w.Config().Limits.Get("news").Limit)
+
+	// An invalid change is rejected once, keeping the last good configuration
+	writeConfig(t, path, watchedConfig, 0)
+	assert.Error(t, w.Reload())
+	assert.Error(t, w.Reload())
+	require.Len(t, changes, 2)
+	assert.Nil(t, changes[1].New)
+	assert.Error(t, changes[1].Err)
+	assert.Equal(t, int64(20), w.Config().Limits.Get("news").Limit)
+
+	writeConfig(t, path, "{invalid_json}")
+	assert.Error(t, w.Reload())
+	require.Len(t, changes, 3)
+
+	// A missing file is rejected too
+	require.NoError(t, os.Remove(path))
+	assert.Error(t, w.Reload())
+	require.Len(t, changes, 4)
+	assert.Equal(t, int64(20), w.Config().Limits.Get("news").Limit)
+
+	// Going back to the configuration in use is not a change
+	writeConfig(t, path, watchedConfig, 20)
+	assert.NoError(t, w.Reload())
+	assert.Len(t, changes, 4)
+}
+
+func TestWatcher_Polling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig(t, path, watchedConfig, 10)
+
+	w, err := NewWatcher(path, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var changes []ConfigChange
+	w.Subscribe(func(change ConfigChange) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, change)
+	})
+
+	writeConfig(t, path, watchedConfig, 20)
+	assert.Eventually(t, func() bool {
+		return w.Config().Limits.Get("news").Limit == 20
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, changes, 1)
+	assert.NoError(t, changes[0].Err)
+}
+
+func TestWatcher_Override(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig(t, path, watchedConfig, 10)
+
+	w, err := NewWatcher(path, time.Hour)
+	require.NoError(t, err)
+	defer w.Close()
+
+	override, err := w.Override(context.Background(), "vip", "")
+	require.NoError(t, err)
+	require.NotNil(t, override)
+	assert.True(t, override.Exempt)
+}
+
+func TestNewWatcher_InvalidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig(t, path, `{"rate_limit": {"limits": []}}`)
+
+	_, err := NewWatcher(path, time.Hour)
+	assert.Error(t, err)
+
+	_, err = NewWatcher(filepath.Join(t.TempDir(), "missing.json"), time.Hour)
+	assert.Error(t, err)
+}
@@ -3,6 +3,7 @@ package errs
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 // ErrInvalidArguments is an error indicating that the provided arguments are invalid.
@@ -11,14 +12,42 @@ var ErrInvalidArguments = errors.New("invalid arguments")
 // ErrInternalError is an error indicating an internal error occurred.
 var ErrInternalError = errors.New("internal error")
 
+// ErrGateway is an error indicating that the gateway failed to send a notification.
+var ErrGateway = errors.New("gateway error")
+
 // ErrExceededRateLimit is an error indicating that the rate limit has been exceeded.
+// It describes the window that was exceeded, and RetryAfter tells how long to wait before trying again.
 type ErrExceededRateLimit struct {
-	State     string // The state associated with the rate limit.
-	Count     int    // The number of requests made within the rate limit.
-	ExpiresAt int64  // The timestamp when the rate limit expires.
+	State      string        // The state associated with the rate limit.
+	Count      int           // The number of requests made within the rate limit.
+	ExpiresAt  int64         // The timestamp when the rate limit expires.
+	Fallback   string        // How the decision was made when the rate limiter backend was unavailable, if it was.
+	Limit      int64         // The limit of the window that was exceeded.
+	Remaining  int           // The units of the limit left, which were not enough for the request.
+	RetryAfter time.Duration // How long until the request fits within the limit, zero when it costs more than the limit.
+	Window     time.Duration // The size of the window that was exceeded.
+	WindowName string        // The name of the window that was exceeded, such as "news/1m0s".
+	Global     bool          // Whether the limit exceeded is the global one of the user rather than the notification type one.
 }
 
 // Error returns the string representation of the ErrExceededRateLimit error.
 func (e *ErrExceededRateLimit) Error() string {
-	return fmt.Sprintf("rate limit exceeded: state=%v, count=%v, expiresAt=%v", e.State, e.Count, e.ExpiresAt)
+	scope := "rate limit"
+	if e.Global {
+		scope = "global rate limit"
+	}
+
+	msg := fmt.Sprintf("%v exceeded", scope)
+	if e.WindowName != "" {
+		msg += fmt.Sprintf(" on %v", e.WindowName)
+	}
+	msg += fmt.Sprintf(": state=%v, count=%v, remaining=%v, retryAfter=%v, expiresAt=%v", e.State, e.Count, e.Remaining, e.RetryAfter, e.ExpiresAt)
+	if e.Fallback != "" {
+		msg += fmt.Sprintf(", fallback=%v", e.Fallback)
+	}
+	if e.Window != 0 {
+		msg += fmt.Sprintf(", window=%v/%v", e.Limit, e.Window)
+	}
+
+	return msg
 }
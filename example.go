@@ -14,9 +14,9 @@ import (
 	"github.com/godoylucase/rate-limit/models"
 	"github.com/godoylucase/rate-limit/notification"
 	"github.com/godoylucase/rate-limit/rate_limiter"
-	"github.com/segmentio/ksuid"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/ksuid"
 )
 
 const (
@@ -34,15 +34,39 @@ func (g *gateway) Send(ctx context.Context, userID string, message string) error
 func main() {
 	ctx := context.Background()
 
-	conf, err := configs.Load("example_config.json")
+	watcher, err := configs.NewLoader("example_config.json").Watch(configs.DefaultWatchInterval)
 	if err != nil {
 		log.Panicf("failed to load configurations: %v", err)
 	}
+	defer watcher.Close()
+
+	watcher.Subscribe(func(change configs.ConfigChange) {
+		if change.Err != nil {
+			log.Printf("configuration change rejected: %v", change.Err)
+			return
+		}
+		log.Printf("configuration reloaded")
+	})
+
+	conf := watcher.Config()
 
-	redisCli := redis.NewClient(&redis.Options{Addr: conf.RedisAddr, Password: "", DB: 0})
-	rateLimiter := rate_limiter.Get(conf.RateLimiterType, redisCli)
+	// The memory backend needs no redis section
+	var redisCli redis.UniversalClient
+	if conf.RateLimiterBackend != rate_limiter.MemoryBackend {
+		redisCli = conf.Redis.Client()
+		defer redisCli.Close()
+	}
+
+	rateLimiter, err := rate_limiter.New(conf.RateLimiterBackend, conf.RateLimiterType, redisCli)
+	if err != nil {
+		log.Panicf("failed to create the rate limiter: %v", err)
+	}
 
-	srv := notification.NewService(rateLimiter, &gateway{}, conf.Limits)
+	srv := notification.NewService(rateLimiter, &gateway{}, conf.Limits,
+		notification.WithConfigWatcher(watcher),
+		notification.WithAlgorithm(conf.RateLimiterType),
+	)
+	defer srv.Close()
 
 	userID := ksuid.New()
 	for i := 0; i < notificationCount; i++ {
@@ -0,0 +1,53 @@
+// Package gateway provides the notification.Gateway implementations the notifications can be sent through,
+// which are chosen by the gateway section of the configuration.
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/notification"
+)
+
+// DefaultTimeout is how long a webhook waits for a notification to be sent when no timeout is configured.
+const DefaultTimeout = 10 * time.Second
+
+// New returns the gateway described by the configuration, which logs the notifications with the standard logger
+// when it is nil or its type is empty.
+func New(conf *configs.GatewayConfig) (notification.Gateway, error) {
+	if conf == nil {
+		return &Log{}, nil
+	}
+
+	switch conf.Type {
+	case "", configs.GatewayLog:
+		return &Log{}, nil
+	case configs.GatewayWebhook:
+		timeout := time.Duration(conf.Timeout)
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		return NewWebhook(conf.URL, timeout, conf.Headers), nil
+	default:
+		return nil, fmt.Errorf("unknown gateway %q", conf.Type)
+	}
+}
+
+// Log is a gateway that logs the notifications instead of sending them, meant for development and testing.
+type Log struct {
+	Logger *log.Logger // The logger the notifications are written to, the standard logger when nil.
+}
+
+// Send logs the notification for the user.
+func (g *Log) Send(_ context.Context, userID string, message string) error {
+	logf := log.Printf
+	if g.Logger != nil {
+		logf = g.Logger.Printf
+	}
+
+	logf("notification sent to user %v: %v", userID, message)
+	return nil
+}
@@ -0,0 +1,62 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	gw, err := New(nil)
+	require.NoError(t, err)
+	assert.IsType(t, &Log{}, gw)
+
+	gw, err = New(&configs.GatewayConfig{Type: configs.GatewayWebhook, URL: "https://notify.internal/send"})
+	require.NoError(t, err)
+	require.IsType(t, &Webhook{}, gw)
+	assert.Equal(t, DefaultTimeout, gw.(*Webhook).client.Timeout)
+
+	_, err = New(&configs.GatewayConfig{Type: "sms"})
+	assert.EqualError(t, err, `unknown gateway "sms"`)
+}
+
+func TestLog_Send(t *testing.T) {
+	var buf bytes.Buffer
+	gw := &Log{Logger: log.New(&buf, "", 0)}
+
+	require.NoError(t, gw.Send(context.Background(), "user", "hello"))
+	assert.Equal(t, "notification sent to user user: hello\n", buf.String())
+}
+
+func TestWebhook_Send(t *testing.T) {
+	var received webhookRequest
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		if received.Message == "fail" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	gw := NewWebhook(srv.URL, time.Second, map[string]string{"Authorization": "Bearer secret"})
+
+	require.NoError(t, gw.Send(context.Background(), "user", "hello"))
+	assert.Equal(t, webhookRequest{UserID: "user", Message: "hello"}, received)
+	assert.Equal(t, "Bearer secret", auth)
+
+	assert.EqualError(t, gw.Send(context.Background(), "user", "fail"), "webhook responded with status 502 Bad Gateway")
+}
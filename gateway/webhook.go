@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Webhook is a gateway that posts every notification as a JSON object with its user_id and message to a URL,
+// which is expected to answer with a 2xx status once it has sent it.
+type Webhook struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// webhookRequest is the body posted by a Webhook.
+type webhookRequest struct {
+	UserID  string `json:"user_id"`
+	Message string `json:"message"`
+}
+
+// NewWebhook creates a Webhook that posts the notifications to the URL along with the headers,
+// giving up on each one after the timeout.
+func NewWebhook(url string, timeout time.Duration, headers map[string]string) *Webhook {
+	return &Webhook{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Send posts the notification for the user to the URL of the webhook.
+func (g *Webhook) Send(ctx context.Context, userID string, message string) error {
+	body, err := json.Marshal(webhookRequest{UserID: userID, Message: message})
+	if err != nil {
+		return fmt.Errorf("error encoding webhook request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range g.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// The body is drained so the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %v", resp.Status)
+	}
+
+	return nil
+}
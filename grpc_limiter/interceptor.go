@@ -0,0 +1,192 @@
+// Package grpc_limiter provides gRPC server interceptors that apply the rate limits of the rate_limiter package
+// to unary and streaming calls. Every call is limited by the key a KeyFunc extracts from it, such as its peer address
+// or a value of its metadata, within the limit a LimitResolver finds for its method, which is given by the same
+// configuration structures as the notification limits. The calls carry the ratelimit-limit, ratelimit-remaining
+// and ratelimit-reset headers, and the rate limited calls fail with codes.ResourceExhausted and a RetryInfo detail
+// telling how long to wait before retrying them.
+package grpc_limiter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+	"github.com/godoylucase/rate-limit/transport"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// RateLimiter is the part of the rate_limiter.RateLimiter interface used by the interceptors.
+type RateLimiter interface {
+	CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error)
+	CheckLimitsN(ctx context.Context, windows []rate_limiter.Window, n int64) ([]*models.RateLimitStatus, error)
+}
+
+// LimitResolver returns the limit of a call by its full method name, or nil when the call is not rate limited.
+type LimitResolver func(fullMethod string) *configs.LimitConfig
+
+// MethodLimits returns a LimitResolver that finds the limit of a call by its method, which is the Type of the limits.
+// A method is either a full method name, such as "/pkg.Service/Method", or a service followed by a slash,
+// such as "/pkg.Service/", which matches every method of the service. The full method name wins over its service.
+func MethodLimits(limits configs.LimitConfigMap) LimitResolver {
+	return func(fullMethod string) *configs.LimitConfig {
+		if conf, ok := limits[fullMethod]; ok {
+			return conf
+		}
+
+		if i := strings.LastIndex(fullMethod, "/"); i > 0 {
+			return limits[fullMethod[:i+1]]
+		}
+		return nil
+	}
+}
+
+// FixedLimit returns a LimitResolver that applies the same limit to every call.
+func FixedLimit(conf *configs.LimitConfig) LimitResolver {
+	return func(string) *configs.LimitConfig {
+		return conf
+	}
+}
+
+// Interceptor rate limits the calls to a gRPC server.
+type Interceptor struct {
+	rlimiter     RateLimiter
+	key          KeyFunc
+	limits       LimitResolver
+	errorHandler func(ctx context.Context, fullMethod string, err error) error
+}
+
+// Option configures optional behavior of the Interceptor.
+type Option func(*Interceptor)
+
+// WithErrorHandler sets the function that returns the error of the calls whose rate limit cannot be checked,
+// which by default fail with codes.Unavailable. Calls whose limit fails open are passed to the handler instead.
+func WithErrorHandler(fn func(ctx context.Context, fullMethod string, err error) error) Option {
+	return func(i *Interceptor) {
+		i.errorHandler = fn
+	}
+}
+
+// New creates an Interceptor that limits the calls by the key extracted from them, within the limit resolved for them.
+func New(rlimiter RateLimiter, key KeyFunc, limits LimitResolver, opts ...Option) *Interceptor {
+	i := &Interceptor{
+		rlimiter:     rlimiter,
+		key:          key,
+		limits:       limits,
+		errorHandler: unavailable,
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	return i
+}
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor that only handles the calls that fit within their
+// rate limit. The calls without limit or without key are handled as they are.
+func (i *Interceptor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := i.limit(ctx, info.FullMethod, func(md metadata.MD) error { return grpc.SetHeader(ctx, md) }); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a grpc.StreamServerInterceptor that only handles the streams that fit within their
+// rate limit. The limit is checked once when the stream is opened, not for each of its messages.
+func (i *Interceptor) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := i.limit(ss.Context(), info.FullMethod, ss.SetHeader); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// limit checks the rate limit of a call, sets its headers and returns the error the call must fail with, if any.
+func (i *Interceptor) limit(ctx context.Context, fullMethod string, setHeader func(metadata.MD) error) error {
+	conf := i.limits(fullMethod)
+	if conf == nil {
+		return nil
+	}
+
+	key := i.key(ctx, fullMethod)
+	if key == "" {
+		return nil
+	}
+
+	st, err := i.check(ctx, key, conf)
+	if err != nil {
+		if conf.FailurePolicy() == configs.FailOpen {
+			return nil
+		}
+		return i.errorHandler(ctx, fullMethod, err)
+	}
+
+	// The headers are informative, a call is not failed because they could not be sent
+	_ = setHeader(headers(st, time.Now()))
+	if st.State == models.Denied {
+		return exhausted(st)
+	}
+
+	return nil
+}
+
+// check checks the rate limit of the key within every window of the configuration,
+// and returns the status of the window that decides the outcome.
+func (i *Interceptor) check(ctx context.Context, key string, conf *configs.LimitConfig) (*models.RateLimitStatus, error) {
+	windows := conf.RateLimiterWindows(configs.LimitKey(key, conf.Type))
+	if len(windows) == 1 {
+		st, err := i.rlimiter.CheckLimit(ctx, windows[0].Key, windows[0].Limit, windows[0].Size)
+		if err != nil {
+			return nil, fmt.Errorf("error checking rate limit for method %v: %w", conf.Type, err)
+		}
+		return st, nil
+	}
+
+	statuses, err := i.rlimiter.CheckLimitsN(ctx, windows, 1)
+	if err != nil {
+		return nil, fmt.Errorf("error checking rate limit for method %v: %w", conf.Type, err)
+	}
+
+	return statuses[rate_limiter.Decisive(statuses)], nil
+}
+
+// headers returns the RateLimit headers of the status as of now, as transport.Headers returns them,
+// in lower case as gRPC metadata expects.
+func headers(st *models.RateLimitStatus, now time.Time) metadata.MD {
+	return metadata.Pairs(transport.Headers(st, now)...)
+}
+
+// exhausted returns the error of a denied call, which carries a RetryInfo detail with the delay after which
+// it fits within its limit. Calls that never fit, because they exceed the limit by themselves, carry no detail.
+func exhausted(st *models.RateLimitStatus) error {
+	s := status.Newf(codes.ResourceExhausted, "rate limit exceeded: limit=%v, remaining=%v, retryAfter=%v",
+		st.Limit, st.Remaining, st.RetryAfter)
+	if st.RetryAfter <= 0 {
+		return s.Err()
+	}
+
+	detailed, err := s.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(st.RetryAfter)})
+	if err != nil {
+		return s.Err()
+	}
+	return detailed.Err()
+}
+
+// unavailable is the default error handler, which fails the call with codes.Unavailable.
+func unavailable(_ context.Context, _ string, _ error) error {
+	return status.Error(codes.Unavailable, "rate limit unavailable")
+}
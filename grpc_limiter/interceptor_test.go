@@ -0,0 +1,203 @@
+package grpc_limiter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+	"github.com/godoylucase/rate-limit/rate_limiter/ratelimitertest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	checkMethod = "/grpc.health.v1.Health/Check"
+	watchMethod = "/grpc.health.v1.Health/Watch"
+)
+
+// failingLimiter is a RateLimiter whose backend is unavailable.
+type failingLimiter struct{}
+
+func (failingLimiter) CheckLimit(context.Context, string, int64, time.Duration) (*models.RateLimitStatus, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingLimiter) CheckLimitsN(context.Context, []rate_limiter.Window, int64) ([]*models.RateLimitStatus, error) {
+	return nil, errors.New("connection refused")
+}
+
+// serve starts an in-process health server behind the interceptor, and returns a client connected to it.
+func serve(t *testing.T, interceptor *Interceptor) healthpb.HealthClient {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(interceptor.StreamServerInterceptor()),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func retryDelay(t *testing.T, err error) time.Duration {
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.ResourceExhausted, st.Code())
+
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.GetRetryDelay().AsDuration()
+		}
+	}
+	require.Fail(t, "the error has no RetryInfo detail")
+
+	return 0
+}
+
+func TestInterceptor_Unary(t *testing.T) {
+	limits := configs.LimitConfigMap{
+		checkMethod: {Type: checkMethod, Limit: 2, WSize: configs.Duration(time.Minute)},
+	}
+	client := serve(t, New(ratelimitertest.NewMemory(t, rate_limiter.SlidingWindowCounter), PeerAddress(), MethodLimits(limits)))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		var header metadata.MD
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2"}, header.Get("ratelimit-limit"))
+		assert.Equal(t, []string{[]string{"1", "0"}[i]}, header.Get("ratelimit-remaining"))
+		assert.Equal(t, []string{"60"}, header.Get("ratelimit-reset"))
+	}
+
+	var header metadata.MD
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+	assert.InDelta(t, time.Minute, retryDelay(t, err), float64(time.Second))
+	assert.Equal(t, []string{"0"}, header.Get("ratelimit-remaining"))
+	assert.Equal(t, []string{"60"}, header.Get("ratelimit-reset"))
+}
+
+func TestInterceptor_Stream(t *testing.T) {
+	conf := &configs.LimitConfig{Type: "health", Limit: 1, WSize: configs.Duration(time.Minute)}
+	key := Join(Metadata("x-tenant-id"), FullMethod())
+	client := serve(t, New(ratelimitertest.NewMemory(t, rate_limiter.TokenBucket), key, FixedLimit(conf)))
+
+	watch := func(tenant string) error {
+		ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(context.Background(), "x-tenant-id", tenant))
+		defer cancel()
+
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		return err
+	}
+
+	require.NoError(t, watch("acme"))
+	assert.InDelta(t, time.Minute, retryDelay(t, watch("acme")), float64(time.Second))
+
+	// Other tenants and other methods are not limited by it
+	require.NoError(t, watch("globex"))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant-id", "acme")
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+}
+
+func TestInterceptor_SeveralWindows(t *testing.T) {
+	conf := &configs.LimitConfig{
+		Type: "health",
+		Windows: []*configs.WindowConfig{
+			{Limit: 1, WSize: configs.Duration(100 * time.Millisecond)},
+			{Limit: 2, WSize: configs.Duration(time.Hour)},
+		},
+	}
+	client := serve(t, New(ratelimitertest.NewMemory(t, rate_limiter.FixedWindowCounter), PeerAddress(), FixedLimit(conf)))
+	ctx := context.Background()
+
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.LessOrEqual(t, retryDelay(t, err), 100*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	// The hourly window is the one exceeded now
+	time.Sleep(100 * time.Millisecond)
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Greater(t, retryDelay(t, err), 59*time.Minute)
+}
+
+func TestInterceptor_Errors(t *testing.T) {
+	conf := &configs.LimitConfig{Type: "health", Limit: 1, WSizeMs: 1000}
+	ctx := context.Background()
+
+	_, err := serve(t, New(failingLimiter{}, PeerAddress(), FixedLimit(conf))).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	var handled error
+	client := serve(t, New(failingLimiter{}, PeerAddress(), FixedLimit(conf), WithErrorHandler(func(_ context.Context, _ string, err error) error {
+		handled = err
+		return status.Error(codes.Internal, "internal")
+	})))
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.ErrorContains(t, handled, "error checking rate limit for method health: connection refused")
+
+	conf.OnFailure = configs.FailOpen
+	_, err = serve(t, New(failingLimiter{}, PeerAddress(), FixedLimit(conf))).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+}
+
+func TestMethodLimits(t *testing.T) {
+	limits := configs.LimitConfigMap{
+		"/grpc.health.v1.Health/":      {Type: "/grpc.health.v1.Health/"},
+		"/grpc.health.v1.Health/Check": {Type: "/grpc.health.v1.Health/Check"},
+	}
+	resolve := MethodLimits(limits)
+
+	assert.Equal(t, checkMethod, resolve(checkMethod).Type)
+	assert.Equal(t, "/grpc.health.v1.Health/", resolve(watchMethod).Type)
+	assert.Nil(t, resolve("/pkg.Service/Method"))
+}
+
+func TestKeyFuncs(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-tenant-id", "acme"))
+
+	assert.Equal(t, "acme", Metadata("X-Tenant-ID")(ctx, checkMethod))
+	assert.Equal(t, checkMethod, FullMethod()(ctx, checkMethod))
+	assert.Empty(t, PeerAddress()(ctx, checkMethod))
+	assert.Equal(t, "acme:"+checkMethod, Join(Metadata("x-tenant-id"), FullMethod())(ctx, checkMethod))
+	assert.Empty(t, Join(Metadata("x-user-id"), FullMethod())(ctx, checkMethod))
+	assert.Equal(t, "acme", FirstOf(Metadata("x-user-id"), Metadata("x-tenant-id"))(ctx, checkMethod))
+}
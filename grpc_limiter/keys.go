@@ -0,0 +1,68 @@
+package grpc_limiter
+
+import (
+	"context"
+	"net"
+
+	"github.com/godoylucase/rate-limit/transport"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// KeyFunc extracts the key a call is rate limited by, such as the address of its peer, from the context of the call
+// and the full name of its method. An empty key means the call cannot be told apart, in which case it is not rate limited.
+type KeyFunc func(ctx context.Context, fullMethod string) string
+
+// PeerAddress returns a KeyFunc that limits the calls by the IP address of the peer they come from.
+func PeerAddress() KeyFunc {
+	return func(ctx context.Context, _ string) string {
+		p, ok := peer.FromContext(ctx)
+		if !ok || p.Addr == nil {
+			return ""
+		}
+
+		addr := p.Addr.String()
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return addr
+		}
+		return host
+	}
+}
+
+// Metadata returns a KeyFunc that limits the calls by the first value of the named incoming metadata,
+// such as a tenant ID. Metadata names are case insensitive.
+func Metadata(name string) KeyFunc {
+	return func(ctx context.Context, _ string) string {
+		values := metadata.ValueFromIncomingContext(ctx, name)
+		if len(values) == 0 {
+			return ""
+		}
+		return values[0]
+	}
+}
+
+// FullMethod returns a KeyFunc that limits the calls by their full method name, such as "/pkg.Service/Method",
+// which shares the limit of every method among all the peers unless it is joined with another KeyFunc.
+func FullMethod() KeyFunc {
+	return func(_ context.Context, fullMethod string) string {
+		return fullMethod
+	}
+}
+
+// FirstOf returns a KeyFunc that uses the first non empty key of the given ones,
+// such as a tenant ID from the metadata of the call, or its peer address for anonymous calls.
+func FirstOf(keys ...KeyFunc) KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		return transport.FirstKey(keys, func(key KeyFunc) string { return key(ctx, fullMethod) })
+	}
+}
+
+// Join returns a KeyFunc that combines the keys of all the given ones, such as a peer address per method.
+// The key is empty when any of them is.
+func Join(keys ...KeyFunc) KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		return transport.JoinKeys(keys, func(key KeyFunc) string { return key(ctx, fullMethod) })
+	}
+}
@@ -0,0 +1,93 @@
+package http_limiter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/godoylucase/rate-limit/transport"
+)
+
+// KeyFunc extracts the key a request is rate limited by, such as the client IP or its API key.
+// An empty key means the request cannot be told apart, in which case it is not rate limited.
+type KeyFunc func(r *http.Request) string
+
+// ClientIP returns a KeyFunc that limits the requests by the IP address of the client they come from.
+func ClientIP() KeyFunc {
+	return func(r *http.Request) string {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+}
+
+// ForwardedClientIP returns a KeyFunc that limits the requests by the first address of their X-Forwarded-For header,
+// falling back to ClientIP without it. The header is set by the clients as they please, so it must only be used
+// behind a proxy that overwrites it.
+func ForwardedClientIP() KeyFunc {
+	clientIP := ClientIP()
+
+	return func(r *http.Request) string {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if first = strings.TrimSpace(first); first != "" {
+				return first
+			}
+		}
+		return clientIP(r)
+	}
+}
+
+// Header returns a KeyFunc that limits the requests by the value of the named header, such as a tenant ID.
+func Header(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// APIKey returns a KeyFunc that limits the requests by their API key, taken from the X-API-Key header
+// or from a bearer Authorization header. The key is hashed, so it is not stored as is by the rate limiter.
+func APIKey() KeyFunc {
+	return func(r *http.Request) string {
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				key = strings.TrimSpace(token)
+			}
+		}
+		if key == "" {
+			return ""
+		}
+
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+}
+
+// Route returns a KeyFunc that limits the requests by their method and path, such as "GET /v1/status",
+// which shares the limit of every route among all the clients unless it is joined with another KeyFunc.
+func Route() KeyFunc {
+	return func(r *http.Request) string {
+		return r.Method + " " + r.URL.Path
+	}
+}
+
+// FirstOf returns a KeyFunc that uses the first non empty key of the given ones,
+// such as the API key of the request, or its client IP for anonymous requests.
+func FirstOf(keys ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		return transport.FirstKey(keys, func(key KeyFunc) string { return key(r) })
+	}
+}
+
+// Join returns a KeyFunc that combines the keys of all the given ones, such as a client IP per route.
+// The key is empty when any of them is.
+func Join(keys ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		return transport.JoinKeys(keys, func(key KeyFunc) string { return key(r) })
+	}
+}
@@ -0,0 +1,179 @@
+// Package http_limiter provides a net/http middleware that applies the rate limits of the rate_limiter package
+// to any http.Handler. Every request is limited by the key a KeyFunc extracts from it, such as the client IP,
+// within the limit a LimitResolver finds for its route, which is given by the same configuration structures
+// as the notification limits. The responses carry the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers, and the rate limited requests are rejected with 429 Too Many Requests and a Retry-After header.
+package http_limiter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+	"github.com/godoylucase/rate-limit/transport"
+)
+
+// RateLimiter is the part of the rate_limiter.RateLimiter interface used by the middleware.
+type RateLimiter interface {
+	CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error)
+	CheckLimitsN(ctx context.Context, windows []rate_limiter.Window, n int64) ([]*models.RateLimitStatus, error)
+}
+
+// LimitResolver returns the limit of a request, or nil when the request is not rate limited.
+type LimitResolver func(r *http.Request) *configs.LimitConfig
+
+// RouteLimits returns a LimitResolver that finds the limit of a request by its route, which is the Type of the limits.
+// A route is either a method and a path, such as "POST /v1/notifications", or a path alone, which matches every method.
+// A path ending with a slash, such as "/v1/", matches every path below it as well, and the most specific route wins,
+// the same way http.ServeMux patterns do.
+func RouteLimits(limits configs.LimitConfigMap) LimitResolver {
+	return func(r *http.Request) *configs.LimitConfig {
+		var found *configs.LimitConfig
+		var foundLen int
+		for route, conf := range limits {
+			method, path, ok := strings.Cut(route, " ")
+			if !ok {
+				method, path = "", route
+			} else if method != r.Method {
+				continue
+			}
+
+			matches := path == r.URL.Path || strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path)
+			if !matches {
+				continue
+			}
+
+			// Longer paths are more specific, and so are routes with a method for the same path
+			length := 2 * len(path)
+			if method != "" {
+				length++
+			}
+			if length > foundLen {
+				found, foundLen = conf, length
+			}
+		}
+
+		return found
+	}
+}
+
+// FixedLimit returns a LimitResolver that applies the same limit to every request.
+func FixedLimit(conf *configs.LimitConfig) LimitResolver {
+	return func(*http.Request) *configs.LimitConfig {
+		return conf
+	}
+}
+
+// Middleware rate limits the requests to an http.Handler.
+type Middleware struct {
+	rlimiter     RateLimiter
+	key          KeyFunc
+	limits       LimitResolver
+	errorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// Option configures optional behavior of the Middleware.
+type Option func(*Middleware)
+
+// WithErrorHandler sets the function that answers the requests whose rate limit cannot be checked, which by default
+// replies with 503 Service Unavailable. Requests whose limit fails open are passed to the handler instead.
+func WithErrorHandler(fn func(w http.ResponseWriter, r *http.Request, err error)) Option {
+	return func(m *Middleware) {
+		m.errorHandler = fn
+	}
+}
+
+// New creates a Middleware that limits the requests by the key extracted from them, within the limit resolved for them.
+func New(rlimiter RateLimiter, key KeyFunc, limits LimitResolver, opts ...Option) *Middleware {
+	m := &Middleware{
+		rlimiter:     rlimiter,
+		key:          key,
+		limits:       limits,
+		errorHandler: unavailable,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Handler wraps next, so it only serves the requests that fit within their rate limit.
+// The requests without limit or without key are served as they are.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf := m.limits(r)
+		if conf == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := m.key(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		status, err := m.check(r.Context(), key, conf)
+		if err != nil {
+			if conf.FailurePolicy() == configs.FailOpen {
+				next.ServeHTTP(w, r)
+				return
+			}
+			m.errorHandler(w, r, err)
+			return
+		}
+
+		SetHeaders(w.Header(), status, time.Now())
+		if status.State == models.Denied {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// check checks the rate limit of the key within every window of the configuration,
+// and returns the status of the window that decides the outcome.
+func (m *Middleware) check(ctx context.Context, key string, conf *configs.LimitConfig) (*models.RateLimitStatus, error) {
+	windows := conf.RateLimiterWindows(configs.LimitKey(key, conf.Type))
+	if len(windows) == 1 {
+		status, err := m.rlimiter.CheckLimit(ctx, windows[0].Key, windows[0].Limit, windows[0].Size)
+		if err != nil {
+			return nil, fmt.Errorf("error checking rate limit for route %v: %w", conf.Type, err)
+		}
+		return status, nil
+	}
+
+	statuses, err := m.rlimiter.CheckLimitsN(ctx, windows, 1)
+	if err != nil {
+		return nil, fmt.Errorf("error checking rate limit for route %v: %w", conf.Type, err)
+	}
+
+	return statuses[rate_limiter.Decisive(statuses)], nil
+}
+
+// SetHeaders sets the RateLimit headers of the IETF draft for the status as of now, as transport.Headers
+// returns them. A denied request that can be retried gets a Retry-After header as well.
+func SetHeaders(header http.Header, status *models.RateLimitStatus, now time.Time) {
+	pairs := transport.Headers(status, now)
+	for i := 0; i < len(pairs); i += 2 {
+		header.Set(pairs[i], pairs[i+1])
+	}
+
+	if status.State == models.Denied && status.RetryAfter > 0 {
+		header.Set("Retry-After", strconv.FormatInt(transport.Seconds(status.RetryAfter), 10))
+	}
+}
+
+// unavailable is the default error handler, which replies with 503 Service Unavailable.
+func unavailable(w http.ResponseWriter, _ *http.Request, _ error) {
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
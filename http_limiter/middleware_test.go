@@ -0,0 +1,190 @@
+package http_limiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+	"github.com/godoylucase/rate-limit/rate_limiter/ratelimitertest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingLimiter is a RateLimiter whose backend is unavailable.
+type failingLimiter struct{}
+
+func (failingLimiter) CheckLimit(context.Context, string, int64, time.Duration) (*models.RateLimitStatus, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingLimiter) CheckLimitsN(context.Context, []rate_limiter.Window, int64) ([]*models.RateLimitStatus, error) {
+	return nil, errors.New("connection refused")
+}
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func serve(handler http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestMiddleware_Handler(t *testing.T) {
+	limits := configs.LimitConfigMap{
+		"POST /v1/notifications": {Type: "POST /v1/notifications", Limit: 2, WSize: configs.Duration(time.Minute)},
+	}
+	handler := New(ratelimitertest.NewMemory(t, rate_limiter.SlidingWindowCounter), ClientIP(), RouteLimits(limits)).Handler(ok)
+
+	for i := 0; i < 2; i++ {
+		rec := serve(handler, http.MethodPost, "/v1/notifications", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, []string{"1", "0"}[i], rec.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "60", rec.Header().Get("RateLimit-Reset"))
+		assert.Empty(t, rec.Header().Get("Retry-After"))
+	}
+
+	rec := serve(handler, http.MethodPost, "/v1/notifications", nil)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", rec.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	// Other routes and other clients are not limited by it
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "/v1/notifications", nil).Code)
+	assert.Empty(t, serve(handler, http.MethodGet, "/v1/notifications", nil).Header().Get("RateLimit-Limit"))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/notifications", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestMiddleware_HandlerSeveralWindows(t *testing.T) {
+	conf := &configs.LimitConfig{
+		Type: "api",
+		Windows: []*configs.WindowConfig{
+			{Limit: 1, WSize: configs.Duration(100 * time.Millisecond)},
+			{Limit: 2, WSize: configs.Duration(time.Hour)},
+		},
+	}
+	handler := New(ratelimitertest.NewMemory(t, rate_limiter.FixedWindowCounter), APIKey(), FixedLimit(conf)).Handler(ok)
+	header := http.Header{"X-Api-Key": {"secret"}}
+
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "/", header).Code)
+
+	rec := serve(handler, http.MethodGet, "/", header)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "/", header).Code)
+
+	// The hourly window is the one exceeded now
+	time.Sleep(100 * time.Millisecond)
+	rec = serve(handler, http.MethodGet, "/", header)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+
+	// Requests without API key are not limited
+	assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "/", nil).Code)
+}
+
+func TestMiddleware_HandlerErrors(t *testing.T) {
+	conf := &configs.LimitConfig{Type: "api", Limit: 1, WSizeMs: 1000}
+
+	rec := serve(New(failingLimiter{}, ClientIP(), FixedLimit(conf)).Handler(ok), http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var handled error
+	handler := New(failingLimiter{}, ClientIP(), FixedLimit(conf), WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		handled = err
+		w.WriteHeader(http.StatusInternalServerError)
+	})).Handler(ok)
+	rec = serve(handler, http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.ErrorContains(t, handled, "error checking rate limit for route api: connection refused")
+
+	conf.OnFailure = configs.FailOpen
+	rec = serve(New(failingLimiter{}, ClientIP(), FixedLimit(conf)).Handler(ok), http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRouteLimits(t *testing.T) {
+	limits := configs.LimitConfigMap{
+		"/v1/":                   {Type: "/v1/"},
+		"/v1/notifications":      {Type: "/v1/notifications"},
+		"POST /v1/notifications": {Type: "POST /v1/notifications"},
+		"GET /v1/admin/":         {Type: "GET /v1/admin/"},
+	}
+	resolve := RouteLimits(limits)
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: http.MethodPost, path: "/v1/notifications", want: "POST /v1/notifications"},
+		{method: http.MethodGet, path: "/v1/notifications", want: "/v1/notifications"},
+		{method: http.MethodGet, path: "/v1/status", want: "/v1/"},
+		{method: http.MethodGet, path: "/v1/admin/users", want: "GET /v1/admin/"},
+		{method: http.MethodDelete, path: "/v1/admin/users", want: "/v1/"},
+		{method: http.MethodGet, path: "/healthz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			conf := resolve(httptest.NewRequest(tt.method, tt.path, nil))
+			if tt.want == "" {
+				assert.Nil(t, conf)
+				return
+			}
+			require.NotNil(t, conf)
+			assert.Equal(t, tt.want, conf.Type)
+		})
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/v1/status", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	req.Header.Set("X-Tenant-ID", "acme")
+	req.Header.Set("Authorization", "Bearer secret")
+
+	assert.Equal(t, "192.0.2.1", ClientIP()(req))
+	assert.Equal(t, "203.0.113.7", ForwardedClientIP()(req))
+	assert.Equal(t, "acme", Header("X-Tenant-ID")(req))
+	assert.Equal(t, "GET /v1/status", Route()(req))
+	assert.Len(t, APIKey()(req), 64)
+	assert.NotContains(t, APIKey()(req), "secret")
+	assert.Equal(t, "acme:GET /v1/status", Join(Header("X-Tenant-ID"), Route())(req))
+	assert.Empty(t, Join(Header("X-User-ID"), Route())(req))
+	assert.Equal(t, "192.0.2.1", FirstOf(Header("X-User-ID"), ClientIP())(req))
+
+	req.Header.Del("X-Forwarded-For")
+	req.Header.Del("Authorization")
+	assert.Equal(t, "192.0.2.1", ForwardedClientIP()(req))
+	assert.Empty(t, APIKey()(req))
+
+	req.Header.Set("X-API-Key", "secret")
+	assert.Len(t, APIKey()(req), 64)
+}
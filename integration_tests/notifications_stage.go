@@ -2,8 +2,11 @@ package integration_tests
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 
+	"github.com/godoylucase/rate-limit/admin"
 	"github.com/godoylucase/rate-limit/configs"
 	"github.com/godoylucase/rate-limit/errs"
 	"github.com/godoylucase/rate-limit/integration_tests/support/gateway"
@@ -11,6 +14,7 @@ import (
 	"github.com/godoylucase/rate-limit/notification"
 	"github.com/godoylucase/rate-limit/rate_limiter"
 
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -31,6 +35,8 @@ func (g *noOpGW) Send(ctx context.Context, userID string, message string) error
 	return g.sender(ctx, userID, message)
 }
 
+var errGatewayDown = errors.New("gateway down")
+
 type notif struct {
 	itself *models.Notification
 	isSent bool
@@ -41,17 +47,29 @@ type NotificationStage struct {
 	assert  *assert.Assertions
 	require *require.Assertions
 
-	userID ksuid.KSUID
+	userID   ksuid.KSUID
+	tenantID string
 
 	conf *configs.NotificationService
 
 	gateway  notification.Gateway
+	redis    redis.UniversalClient
 	rlimiter notification.RateLimiter
 	service  *notification.Service
+	admin    *admin.Admin
 
 	notifications []*notif
 
-	sentCount atomic.Int32
+	sentCount    atomic.Int32
+	attemptCount atomic.Int32
+
+	deniedWindows []time.Duration
+	deniedRetries []time.Duration
+	globalDenials int
+
+	firstSentAt time.Time
+	deniedAt    time.Time
+	limitErr    *errs.ErrExceededRateLimit
 }
 
 func NotificationServiceTestStages(t *testing.T) (*NotificationStage, *NotificationStage, *NotificationStage) {
@@ -93,9 +111,35 @@ func (ns *NotificationStage) a_no_op_gateway() *NotificationStage {
 	return ns
 }
 
+func (ns *NotificationStage) a_gateway_failing_the_first_half_of_the_notifications() *NotificationStage {
+	noOp := &gateway.LogGW{}
+
+	senderFn := func(ctx context.Context, userID string, message string) error {
+		if int(ns.attemptCount.Add(1)) <= len(ns.notifications)/2 {
+			return errGatewayDown
+		}
+
+		ns.sentCount.Add(1)
+		return noOp.Send(ctx, userID, message)
+	}
+
+	ns.gateway = &noOpGW{senderFn}
+
+	return ns
+}
+
 func (ns *NotificationStage) a_redis_rate_limiter() *NotificationStage {
-	client := redis.NewClient(&redis.Options{Addr: ns.conf.RedisAddr, Password: "", DB: 0})
-	ns.rlimiter = rate_limiter.Get(ns.conf.RateLimiterType, client)
+	ns.redis = ns.conf.Redis.Client()
+	ns.rlimiter = rate_limiter.Get(ns.conf.RateLimiterType, ns.redis)
+
+	return ns
+}
+
+func (ns *NotificationStage) a_memory_rate_limiter() *NotificationStage {
+	ns.rlimiter = rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, ns.conf.RateLimiterType, nil)
+	ns.t.Cleanup(func() {
+		_ = ns.rlimiter.(io.Closer).Close()
+	})
 
 	return ns
 }
@@ -105,6 +149,80 @@ func (ns *NotificationStage) a_notification_service() *NotificationStage {
 	return ns
 }
 
+func (ns *NotificationStage) a_notification_service_refunding_on_gateway_errors() *NotificationStage {
+	ns.service = notification.NewService(ns.rlimiter, ns.gateway, ns.conf.Limits, notification.WithRefundOnGatewayError())
+	return ns
+}
+
+func (ns *NotificationStage) a_notification_service_charging_two_units_per_notification() *NotificationStage {
+	cost := func(*models.Notification) int64 { return 2 }
+	ns.service = notification.NewService(ns.rlimiter, ns.gateway, ns.conf.Limits, notification.WithCostFunc(cost))
+	return ns
+}
+
+func (ns *NotificationStage) a_notification_service_with_a_global_limit() *NotificationStage {
+	ns.require.NotNil(ns.conf.Global)
+
+	ns.service = notification.NewService(ns.rlimiter, ns.gateway, ns.conf.Limits, notification.WithGlobalLimit(ns.conf.Global))
+	return ns
+}
+
+func (ns *NotificationStage) a_notification_service_with_a_global_limit_and_overrides() *NotificationStage {
+	ns.require.NotEmpty(ns.conf.Overrides)
+
+	ns.service = notification.NewService(ns.rlimiter, ns.gateway, ns.conf.Limits,
+		notification.WithGlobalLimit(ns.conf.Global),
+		notification.WithOverrideProvider(ns.conf.Overrides),
+	)
+	return ns
+}
+
+func (ns *NotificationStage) a_notification_service_with_temporary_limits() *NotificationStage {
+	ns.require.NotNil(ns.redis)
+
+	store := admin.NewOverrideStore(rate_limiter.RedisBackend, ns.redis)
+	ns.service = notification.NewService(ns.rlimiter, ns.gateway, ns.conf.Limits,
+		notification.WithOverrideProvider(admin.Overrides(store, ns.conf.Overrides)),
+	)
+
+	rlimiter, ok := ns.rlimiter.(admin.RateLimiter)
+	ns.require.True(ok)
+	ns.admin = admin.New(ns.service, rlimiter, store)
+
+	return ns
+}
+
+func (ns *NotificationStage) the_admin_raises_the_status_limit_to_twice_its_size_for_a_while() *NotificationStage {
+	conf := ns.conf.Limits.Get("status")
+	ns.assert.NotNil(conf)
+
+	raised := *conf
+	raised.Limit *= 2
+	_, err := ns.admin.SetTemporaryLimit(context.Background(), ns.userID, &raised, time.Minute)
+	ns.require.NoError(err)
+
+	limits, err := ns.admin.TemporaryLimits(context.Background(), ns.userID)
+	ns.require.NoError(err)
+	ns.require.Len(limits, 1)
+	ns.require.Equal(raised.Limit, limits[0].Limit.Limit)
+
+	return ns
+}
+
+func (ns *NotificationStage) a_user_of_the_enterprise_tenant() *NotificationStage {
+	ns.tenantID = "enterprise"
+	return ns
+}
+
+func (ns *NotificationStage) news_notifications_group_with_limit_size() *NotificationStage {
+	conf := ns.conf.Limits.Get("news")
+	ns.assert.NotNil(conf)
+
+	ns.a_group_of_notifications_of_type_and_size(conf.Type, int(conf.Limit))
+
+	return ns
+}
+
 func (ns *NotificationStage) status_notifications_group_with_limit_size() *NotificationStage {
 	conf := ns.conf.Limits.Get("status")
 	ns.assert.NotNil(conf)
@@ -123,6 +241,15 @@ func (ns *NotificationStage) status_notifications_group_with_twice_limit_size()
 	return ns
 }
 
+func (ns *NotificationStage) status_notifications_group_with_one_more_than_limit_size() *NotificationStage {
+	conf := ns.conf.Limits.Get("status")
+	ns.assert.NotNil(conf)
+
+	ns.a_group_of_notifications_of_type_and_size(conf.Type, int(conf.Limit)+1)
+
+	return ns
+}
+
 func (ns *NotificationStage) news_notifications_group_with_twice_limit_size() *NotificationStage {
 	conf := ns.conf.Limits.Get("news")
 	ns.assert.NotNil(conf)
@@ -132,13 +259,35 @@ func (ns *NotificationStage) news_notifications_group_with_twice_limit_size() *N
 	return ns
 }
 
+func (ns *NotificationStage) digest_notifications_group_with_four_times_limit_size() *NotificationStage {
+	conf := ns.conf.Limits.Get("digest")
+	ns.assert.NotNil(conf)
+
+	ns.a_group_of_notifications_of_type_and_size(conf.Type, int(conf.Limit)*4)
+
+	return ns
+}
+
+func (ns *NotificationStage) alert_notifications_group_with_twice_the_long_window_limit_size() *NotificationStage {
+	conf := ns.conf.Limits.Get("alert")
+	ns.assert.NotNil(conf)
+
+	windows := conf.WindowConfigs()
+	ns.require.Len(windows, 2)
+
+	ns.a_group_of_notifications_of_type_and_size(conf.Type, int(windows[1].Limit)*2)
+
+	return ns
+}
+
 func (ns *NotificationStage) a_group_of_notifications_of_type_and_size(typ string, size int) *NotificationStage {
 	for i := 0; i < size; i++ {
 		n := &notif{
 			itself: &models.Notification{
-				Type:    typ,
-				UserID:  ns.userID,
-				Message: fmt.Sprintf("message from type %v, and value %v", typ, i+1),
+				Type:     typ,
+				UserID:   ns.userID,
+				TenantID: ns.tenantID,
+				Message:  fmt.Sprintf("message from type %v, and value %v", typ, i+1),
 			},
 			isSent: false,
 		}
@@ -173,6 +322,21 @@ func (ns *NotificationStage) the_service_sends_notifications_within_the_time_win
 	return ns
 }
 
+func (ns *NotificationStage) the_admin_resets_the_status_key_of_the_user() *NotificationStage {
+	keys, err := ns.admin.Keys(context.Background(), ns.userID)
+	ns.require.NoError(err)
+	ns.require.Len(keys, 1)
+	ns.require.Equal(notification.KeyPrefix(ns.userID)+"-status", keys[0])
+
+	ns.require.NoError(ns.admin.ResetKey(context.Background(), keys[0]))
+
+	keys, err = ns.admin.Keys(context.Background(), ns.userID)
+	ns.require.NoError(err)
+	ns.require.Empty(keys)
+
+	return ns
+}
+
 func (ns *NotificationStage) the_service_sends_notifications_exceeding_the_time_window() *NotificationStage {
 	for _, notif := range ns.notifications {
 		conf := ns.conf.Limits.Get(notif.itself.Type)
@@ -197,6 +361,144 @@ func (ns *NotificationStage) the_service_sends_notifications_exceeding_the_time_
 	return ns
 }
 
+func (ns *NotificationStage) the_service_sends_notifications_concurrently() *NotificationStage {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+
+	for _, n := range ns.notifications {
+		wg.Add(1)
+		go func(n *notif) {
+			defer wg.Done()
+			<-start
+
+			if err := ns.service.Send(context.Background(), n.itself); err == nil {
+				n.isSent = true
+			} else {
+				var errLimit *errs.ErrExceededRateLimit
+				ns.assert.ErrorAs(err, &errLimit)
+			}
+		}(n)
+	}
+
+	// release all the senders at once, so they compete for the same window
+	close(start)
+	wg.Wait()
+
+	return ns
+}
+
+func (ns *NotificationStage) the_service_sends_notifications_waiting_for_the_rate_limit() *NotificationStage {
+	for _, n := range ns.notifications {
+		// the deadline is long enough for every notification of the group to fit within the rate limit
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+		if err := ns.service.SendWait(ctx, n.itself); err == nil {
+			n.isSent = true
+		} else {
+			fmt.Printf("error sending notification: %v \n", err)
+		}
+
+		cancel()
+	}
+
+	return ns
+}
+
+func (ns *NotificationStage) the_service_sends_notifications_through_the_failing_gateway() *NotificationStage {
+	for _, n := range ns.notifications {
+		err := ns.service.Send(context.Background(), n.itself)
+		if err == nil {
+			n.isSent = true
+			continue
+		}
+
+		fmt.Printf("error sending notification: %v \n", err)
+		if !errors.Is(err, errGatewayDown) {
+			var errLimit *errs.ErrExceededRateLimit
+			ns.require.ErrorAs(err, &errLimit)
+		}
+	}
+
+	return ns
+}
+
+func (ns *NotificationStage) the_service_sends_notifications_in_two_bursts_across_the_short_window() *NotificationStage {
+	conf := ns.conf.Limits.Get("alert")
+	ns.assert.NotNil(conf)
+
+	half := len(ns.notifications) / 2
+	for i, n := range ns.notifications {
+		// the second burst starts once the short window has freed up
+		if i == half {
+			time.Sleep(time.Duration(conf.WindowConfigs()[0].WSizeMs+50) * time.Millisecond)
+		}
+
+		if err := ns.service.Send(context.Background(), n.itself); err == nil {
+			n.isSent = true
+		} else {
+			fmt.Printf("error sending notification: %v \n", err)
+
+			var errLimit *errs.ErrExceededRateLimit
+			ns.require.ErrorAs(err, &errLimit)
+			ns.deniedWindows = append(ns.deniedWindows, errLimit.Window)
+			ns.deniedRetries = append(ns.deniedRetries, errLimit.RetryAfter)
+		}
+	}
+
+	return ns
+}
+
+func (ns *NotificationStage) the_service_sends_notifications_retrying_the_denied_one_after_its_hint() *NotificationStage {
+	for i, n := range ns.notifications {
+		// the notifications are spread over the window, so the oldest one leaves it before the window ends
+		if i > 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		sentAt := time.Now()
+		if i == 0 {
+			ns.firstSentAt = sentAt
+		}
+
+		err := ns.service.Send(context.Background(), n.itself)
+		if err == nil {
+			n.isSent = true
+			continue
+		}
+
+		fmt.Printf("error sending notification: %v \n", err)
+
+		ns.require.ErrorAs(err, &ns.limitErr)
+		ns.deniedAt = sentAt
+
+		time.Sleep(ns.limitErr.RetryAfter)
+		ns.require.NoError(ns.service.Send(context.Background(), n.itself))
+		n.isSent = true
+	}
+
+	return ns
+}
+
+func (ns *NotificationStage) the_service_sends_notifications_counting_the_global_denials() *NotificationStage {
+	for _, n := range ns.notifications {
+		err := ns.service.Send(context.Background(), n.itself)
+		if err == nil {
+			n.isSent = true
+			continue
+		}
+
+		fmt.Printf("error sending notification: %v \n", err)
+
+		var errLimit *errs.ErrExceededRateLimit
+		ns.require.ErrorAs(err, &errLimit)
+		if errLimit.Global {
+			ns.globalDenials++
+		}
+	}
+
+	return ns
+}
+
 // then
 func (ns *NotificationStage) all_the_notifications_have_been_sent() *NotificationStage {
 	for _, n := range ns.notifications {
@@ -225,8 +527,132 @@ func (ns *NotificationStage) some_notifications_have_been_sent() *NotificationSt
 	return ns
 }
 
+func (ns *NotificationStage) exactly_the_digest_limit_of_notifications_have_been_sent() *NotificationStage {
+	conf := ns.conf.Limits.Get("digest")
+	ns.assert.NotNil(conf)
+
+	sent := 0
+	for _, n := range ns.notifications {
+		if n.isSent {
+			sent++
+		}
+	}
+
+	ns.require.Equal(int(conf.Limit), sent)
+	ns.require.Equal(int(conf.Limit), int(ns.sentCount.Load()))
+
+	return ns
+}
+
+func (ns *NotificationStage) the_status_shows_no_status_notifications_remaining() *NotificationStage {
+	conf := ns.conf.Limits.Get("status")
+	ns.assert.NotNil(conf)
+
+	status, err := ns.service.Status(context.Background(), ns.userID, "", conf.Type)
+	ns.require.NoError(err)
+	ns.require.Equal(models.Denied, status.State)
+	ns.require.Equal(int(conf.Limit), status.Count)
+	ns.require.Zero(status.Remaining)
+	ns.require.Equal(conf.Limit, status.Limit)
+
+	// the next status notification fits once the first one sent leaves the window
+	_, size := conf.Quota()
+	ns.require.Positive(status.RetryAfter)
+	ns.require.LessOrEqual(status.RetryAfter, size)
+
+	return ns
+}
+
+func (ns *NotificationStage) the_status_shows_all_the_status_notifications_remaining() *NotificationStage {
+	conf := ns.conf.Limits.Get("status")
+	ns.assert.NotNil(conf)
+
+	status, err := ns.service.Status(context.Background(), ns.userID, "", conf.Type)
+	ns.require.NoError(err)
+	ns.require.Equal(models.Allowed, status.State)
+	ns.require.Zero(status.Count)
+	ns.require.Equal(int(conf.Limit), status.Remaining)
+
+	return ns
+}
+
+func (ns *NotificationStage) second_half_of_the_notifications_have_been_sent() *NotificationStage {
+	for i, n := range ns.notifications {
+		ns.require.Equal(i >= len(ns.notifications)/2, n.isSent)
+	}
+
+	ns.require.Equal(len(ns.notifications)/2, int(ns.sentCount.Load()))
+
+	return ns
+}
+
+func (ns *NotificationStage) no_notifications_have_been_sent() *NotificationStage {
+	for _, n := range ns.notifications {
+		ns.require.False(n.isSent)
+	}
+
+	ns.require.Zero(ns.sentCount.Load())
+
+	return ns
+}
+
 func (ns *NotificationStage) first_half_of_the_notifications_have_been_sent() *NotificationStage {
 	ns.require.Equal(len(ns.notifications)/2, int(ns.sentCount.Load()))
 
 	return ns
 }
+
+func (ns *NotificationStage) exactly_the_long_window_limit_of_notifications_have_been_sent() *NotificationStage {
+	conf := ns.conf.Limits.Get("alert")
+	ns.assert.NotNil(conf)
+
+	windows := conf.WindowConfigs()
+	ns.require.Equal(int(windows[1].Limit), int(ns.sentCount.Load()))
+
+	return ns
+}
+
+func (ns *NotificationStage) the_denials_report_both_windows() *NotificationStage {
+	conf := ns.conf.Limits.Get("alert")
+	ns.assert.NotNil(conf)
+
+	for _, window := range conf.WindowConfigs() {
+		_, size := window.Quota()
+		ns.require.Contains(ns.deniedWindows, size)
+	}
+
+	// every denial tells when the notification fits within the window that denied it
+	for i, size := range ns.deniedWindows {
+		ns.require.Positive(ns.deniedRetries[i])
+		ns.require.LessOrEqual(ns.deniedRetries[i], size)
+	}
+
+	return ns
+}
+
+func (ns *NotificationStage) the_retry_hint_expires_with_the_oldest_notification() *NotificationStage {
+	conf := ns.conf.Limits.Get("status")
+	ns.assert.NotNil(conf)
+
+	limit, size := conf.Quota()
+	ns.require.NotNil(ns.limitErr)
+	ns.require.Equal(limit, ns.limitErr.Limit)
+	ns.require.Zero(ns.limitErr.Remaining)
+	ns.require.Equal(fmt.Sprintf("status/%v", size), ns.limitErr.WindowName)
+
+	// the denied notification fits once the oldest one leaves the window, not a whole window after it was denied
+	expected := ns.firstSentAt.Add(size).Sub(ns.deniedAt)
+	ns.require.Less(ns.limitErr.RetryAfter, size)
+	ns.require.InDelta(expected.Milliseconds(), ns.limitErr.RetryAfter.Milliseconds(), 30)
+
+	return ns
+}
+
+func (ns *NotificationStage) exactly_the_global_limit_of_notifications_have_been_sent() *NotificationStage {
+	limit, _ := ns.conf.Global.Quota()
+
+	ns.require.Equal(int(limit), int(ns.sentCount.Load()))
+	ns.require.Equal(len(ns.notifications)-int(limit), ns.globalDenials)
+
+	return ns
+}
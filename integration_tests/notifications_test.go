@@ -98,3 +98,666 @@ func (ns *NotificationServiceSuite) TestSendNotificationsRateLimited_FixedWindow
 	then.
 		first_half_of_the_notifications_have_been_sent()
 }
+
+func (ns *NotificationServiceSuite) TestSendNotificationsNoRateLimited_TokenBucketRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/token_bucket_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		status_notifications_group_with_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		all_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRateLimited_TokenBucketRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/token_bucket_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		status_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		first_half_of_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRateLimited_RefillingTokenBucket() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/token_bucket_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		news_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_exceeding_the_time_window()
+
+	then.
+		some_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsNoRateLimited_GCRARateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/gcra_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		status_notifications_group_with_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		all_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRateLimited_GCRARateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/gcra_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		status_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		some_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRateLimited_MovingGCRA() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/gcra_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		news_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_exceeding_the_time_window()
+
+	then.
+		some_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsConcurrently_SlidingWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/sliding_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		digest_notifications_group_with_four_times_limit_size()
+
+	when.
+		the_service_sends_notifications_concurrently()
+
+	then.
+		exactly_the_digest_limit_of_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsConcurrently_FixedWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/fixed_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		digest_notifications_group_with_four_times_limit_size()
+
+	when.
+		the_service_sends_notifications_concurrently()
+
+	then.
+		exactly_the_digest_limit_of_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsConcurrently_TokenBucketRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/token_bucket_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		digest_notifications_group_with_four_times_limit_size()
+
+	when.
+		the_service_sends_notifications_concurrently()
+
+	then.
+		exactly_the_digest_limit_of_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsConcurrently_GCRARateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/gcra_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		digest_notifications_group_with_four_times_limit_size()
+
+	when.
+		the_service_sends_notifications_concurrently()
+
+	then.
+		exactly_the_digest_limit_of_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRateLimited_MemorySlidingWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/sliding_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_memory_rate_limiter().and().
+		a_notification_service().and().
+		status_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		first_half_of_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsConcurrently_MemoryFixedWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/fixed_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_memory_rate_limiter().and().
+		a_notification_service().and().
+		digest_notifications_group_with_four_times_limit_size()
+
+	when.
+		the_service_sends_notifications_concurrently()
+
+	then.
+		exactly_the_digest_limit_of_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsWaiting_SlidingWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/sliding_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		news_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_waiting_for_the_rate_limit()
+
+	then.
+		all_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsWaiting_FixedWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/fixed_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		news_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_waiting_for_the_rate_limit()
+
+	then.
+		all_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsWaiting_TokenBucketRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/token_bucket_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		news_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_waiting_for_the_rate_limit()
+
+	then.
+		all_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsWaiting_GCRARateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/gcra_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		news_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_waiting_for_the_rate_limit()
+
+	then.
+		all_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendWeightedNotificationsRateLimited_SlidingWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/sliding_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service_charging_two_units_per_notification().and().
+		status_notifications_group_with_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		first_half_of_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendWeightedNotificationsRateLimited_FixedWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/fixed_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service_charging_two_units_per_notification().and().
+		status_notifications_group_with_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		first_half_of_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendWeightedNotificationsRateLimited_TokenBucketRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/token_bucket_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service_charging_two_units_per_notification().and().
+		status_notifications_group_with_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		first_half_of_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendWeightedNotificationsRateLimited_GCRARateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/gcra_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service_charging_two_units_per_notification().and().
+		status_notifications_group_with_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		first_half_of_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestNotificationsStatus_SlidingWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/sliding_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		the_status_shows_all_the_status_notifications_remaining().and().
+		status_notifications_group_with_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		all_the_notifications_have_been_sent().and().
+		the_status_shows_no_status_notifications_remaining()
+}
+
+func (ns *NotificationServiceSuite) TestNotificationsStatus_FixedWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/fixed_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		the_status_shows_all_the_status_notifications_remaining().and().
+		status_notifications_group_with_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		all_the_notifications_have_been_sent().and().
+		the_status_shows_no_status_notifications_remaining()
+}
+
+func (ns *NotificationServiceSuite) TestTemporaryLimit_SlidingWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/sliding_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service_with_temporary_limits().and().
+		the_admin_raises_the_status_limit_to_twice_its_size_for_a_while().and().
+		status_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		all_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestAdminResetsKey_FixedWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/fixed_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service_with_temporary_limits().and().
+		status_notifications_group_with_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window().and().
+		the_admin_resets_the_status_key_of_the_user()
+
+	then.
+		all_the_notifications_have_been_sent().and().
+		the_status_shows_all_the_status_notifications_remaining()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRetryingAfterTheHint_SlidingWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/sliding_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		status_notifications_group_with_one_more_than_limit_size()
+
+	when.
+		the_service_sends_notifications_retrying_the_denied_one_after_its_hint()
+
+	then.
+		all_the_notifications_have_been_sent().and().
+		the_retry_hint_expires_with_the_oldest_notification()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRetryingAfterTheHint_FixedWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/fixed_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		status_notifications_group_with_one_more_than_limit_size()
+
+	when.
+		the_service_sends_notifications_retrying_the_denied_one_after_its_hint()
+
+	then.
+		all_the_notifications_have_been_sent().and().
+		the_retry_hint_expires_with_the_oldest_notification()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRefundingGatewayErrors_SlidingWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/sliding_window_conf.json").and().
+		a_gateway_failing_the_first_half_of_the_notifications().and().
+		a_redis_rate_limiter().and().
+		a_notification_service_refunding_on_gateway_errors().and().
+		status_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_through_the_failing_gateway()
+
+	then.
+		second_half_of_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRefundingGatewayErrors_FixedWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/fixed_window_conf.json").and().
+		a_gateway_failing_the_first_half_of_the_notifications().and().
+		a_redis_rate_limiter().and().
+		a_notification_service_refunding_on_gateway_errors().and().
+		status_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_through_the_failing_gateway()
+
+	then.
+		second_half_of_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRefundingGatewayErrors_TokenBucketRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/token_bucket_conf.json").and().
+		a_gateway_failing_the_first_half_of_the_notifications().and().
+		a_redis_rate_limiter().and().
+		a_notification_service_refunding_on_gateway_errors().and().
+		status_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_through_the_failing_gateway()
+
+	then.
+		second_half_of_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRefundingGatewayErrors_GCRARateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/gcra_conf.json").and().
+		a_gateway_failing_the_first_half_of_the_notifications().and().
+		a_redis_rate_limiter().and().
+		a_notification_service_refunding_on_gateway_errors().and().
+		status_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_through_the_failing_gateway()
+
+	then.
+		second_half_of_the_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsNotRefundingGatewayErrors_SlidingWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/sliding_window_conf.json").and().
+		a_gateway_failing_the_first_half_of_the_notifications().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		status_notifications_group_with_twice_limit_size()
+
+	when.
+		the_service_sends_notifications_through_the_failing_gateway()
+
+	then.
+		no_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRateLimitedBySeveralWindows_SlidingWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/sliding_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		alert_notifications_group_with_twice_the_long_window_limit_size()
+
+	when.
+		the_service_sends_notifications_in_two_bursts_across_the_short_window()
+
+	then.
+		exactly_the_long_window_limit_of_notifications_have_been_sent().and().
+		the_denials_report_both_windows()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRateLimitedBySeveralWindows_FixedWindowRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/fixed_window_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		alert_notifications_group_with_twice_the_long_window_limit_size()
+
+	when.
+		the_service_sends_notifications_in_two_bursts_across_the_short_window()
+
+	then.
+		exactly_the_long_window_limit_of_notifications_have_been_sent().and().
+		the_denials_report_both_windows()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRateLimitedBySeveralWindows_TokenBucketRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/token_bucket_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		alert_notifications_group_with_twice_the_long_window_limit_size()
+
+	when.
+		the_service_sends_notifications_in_two_bursts_across_the_short_window()
+
+	then.
+		exactly_the_long_window_limit_of_notifications_have_been_sent().and().
+		the_denials_report_both_windows()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRateLimitedBySeveralWindows_GCRARateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/gcra_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service().and().
+		alert_notifications_group_with_twice_the_long_window_limit_size()
+
+	when.
+		the_service_sends_notifications_in_two_bursts_across_the_short_window()
+
+	then.
+		exactly_the_long_window_limit_of_notifications_have_been_sent().and().
+		the_denials_report_both_windows()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRateLimitedByGlobalLimit_RedisRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/global_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service_with_a_global_limit().and().
+		status_notifications_group_with_limit_size().and().
+		news_notifications_group_with_limit_size()
+
+	when.
+		the_service_sends_notifications_counting_the_global_denials()
+
+	then.
+		exactly_the_global_limit_of_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsRateLimitedByGlobalLimit_MemoryRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/global_conf.json").and().
+		a_no_op_gateway().and().
+		a_memory_rate_limiter().and().
+		a_notification_service_with_a_global_limit().and().
+		status_notifications_group_with_limit_size().and().
+		news_notifications_group_with_limit_size()
+
+	when.
+		the_service_sends_notifications_counting_the_global_denials()
+
+	then.
+		exactly_the_global_limit_of_notifications_have_been_sent()
+}
+
+func (ns *NotificationServiceSuite) TestSendNotificationsWithTenantOverride_RedisRateLimiter() {
+	given, when, then := NotificationServiceTestStages(ns.T())
+
+	given.
+		a_rate_limit_configuration_from("./support/configs/global_conf.json").and().
+		a_no_op_gateway().and().
+		a_redis_rate_limiter().and().
+		a_notification_service_with_a_global_limit_and_overrides().and().
+		a_user_of_the_enterprise_tenant().and().
+		status_notifications_group_with_limit_size().and().
+		news_notifications_group_with_limit_size()
+
+	when.
+		the_service_sends_notifications_within_the_time_window()
+
+	then.
+		all_the_notifications_have_been_sent()
+}
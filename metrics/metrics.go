@@ -0,0 +1,152 @@
+// Package metrics provides the telemetry of the rate limits: the decisions of the notification service per
+// notification type and algorithm, the latency and the errors of the rate limiter checks, the outcome of the
+// gateway sends, and the limits in use. The measurements go to a Recorder, so any metric system can be plugged in,
+// and Prometheus records them as Prometheus series served in the text exposition format.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+)
+
+// Recorder receives the measurements of the notification service and its rate limiter, and it must be safe
+// for concurrent use.
+//
+// Decision is called with the state of every rate limit check of a notification, or with Exempt for the notifications
+// of the exempt users, which are not checked, and GatewaySend with the outcome
+// of every notification sent through the gateway, err being nil when it succeeded. CheckDuration is called with how
+// long a check of the rate limiter took, and RateLimiterError with every operation of the rate limiter that failed.
+// Limits is called with the limits of the notification types and the global limit, which may be nil, every time they
+// change, replacing the previous ones.
+type Recorder interface {
+	Decision(typ, algorithm string, state models.State)
+	CheckDuration(algorithm, operation string, d time.Duration)
+	RateLimiterError(algorithm, operation string)
+	GatewaySend(typ string, err error)
+	Limits(lconfigs configs.LimitConfigMap, global *configs.LimitConfig)
+}
+
+// Exempt is the decision of the notifications of the exempt users, which are sent without any rate limit check.
+const Exempt models.State = "exempt"
+
+// Nop is a Recorder that discards every measurement.
+type Nop struct{}
+
+func (Nop) Decision(string, string, models.State)               {}
+func (Nop) CheckDuration(string, string, time.Duration)         {}
+func (Nop) RateLimiterError(string, string)                     {}
+func (Nop) GatewaySend(string, error)                           {}
+func (Nop) Limits(configs.LimitConfigMap, *configs.LimitConfig) {}
+
+// The operations of the rate limiter, as passed to CheckDuration and RateLimiterError.
+const (
+	OpCheckLimit   = "check_limit"
+	OpCheckLimitN  = "check_limit_n"
+	OpCheckLimitsN = "check_limits_n"
+	OpReserve      = "reserve"
+	OpWait         = "wait"
+	OpStatus       = "status"
+	OpReset        = "reset"
+	OpRefund       = "refund"
+	OpKeys         = "keys"
+)
+
+// RateLimiter returns the rate limiter that records how long the checks of the wrapped one take, labeled with
+// its algorithm, along with the operations that fail, such as the ones redis cannot answer. Reserve and Wait
+// are not timed, since they take as long as the request has to wait for the rate limit. The operations stopped
+// because their context was canceled are not counted as errors.
+func RateLimiter(rlimiter rate_limiter.RateLimiter, algorithm string, rec Recorder) rate_limiter.RateLimiter {
+	return &instrumented{RateLimiter: rlimiter, algorithm: algorithm, rec: rec}
+}
+
+// instrumented is the rate limiter returned by RateLimiter.
+type instrumented struct {
+	rate_limiter.RateLimiter
+	algorithm string
+	rec       Recorder
+}
+
+func (il *instrumented) CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	defer il.timed(OpCheckLimit, time.Now())
+	status, err := il.RateLimiter.CheckLimit(ctx, key, limit, tWindow)
+	return status, il.failed(OpCheckLimit, err)
+}
+
+func (il *instrumented) CheckLimitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	defer il.timed(OpCheckLimitN, time.Now())
+	status, err := il.RateLimiter.CheckLimitN(ctx, key, limit, tWindow, n)
+	return status, il.failed(OpCheckLimitN, err)
+}
+
+func (il *instrumented) CheckLimitsN(ctx context.Context, windows []rate_limiter.Window, n int64) ([]*models.RateLimitStatus, error) {
+	defer il.timed(OpCheckLimitsN, time.Now())
+	statuses, err := il.RateLimiter.CheckLimitsN(ctx, windows, n)
+	return statuses, il.failed(OpCheckLimitsN, err)
+}
+
+func (il *instrumented) Reserve(ctx context.Context, key string, limit int64, tWindow time.Duration) (*rate_limiter.Reservation, error) {
+	reservation, err := il.RateLimiter.Reserve(ctx, key, limit, tWindow)
+	return reservation, il.failed(OpReserve, err)
+}
+
+func (il *instrumented) ReserveN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*rate_limiter.Reservation, error) {
+	reservation, err := il.RateLimiter.ReserveN(ctx, key, limit, tWindow, n)
+	return reservation, il.failed(OpReserve, err)
+}
+
+func (il *instrumented) Wait(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	status, err := il.RateLimiter.Wait(ctx, key, limit, tWindow)
+	return status, il.failed(OpWait, err)
+}
+
+func (il *instrumented) WaitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	status, err := il.RateLimiter.WaitN(ctx, key, limit, tWindow, n)
+	return status, il.failed(OpWait, err)
+}
+
+func (il *instrumented) Status(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	status, err := il.RateLimiter.Status(ctx, key, limit, tWindow)
+	return status, il.failed(OpStatus, err)
+}
+
+func (il *instrumented) Reset(ctx context.Context, key string) error {
+	return il.failed(OpReset, il.RateLimiter.Reset(ctx, key))
+}
+
+func (il *instrumented) Refund(ctx context.Context, key string, limit int64, tWindow time.Duration, requestID string, n int64) error {
+	return il.failed(OpRefund, il.RateLimiter.Refund(ctx, key, limit, tWindow, requestID, n))
+}
+
+func (il *instrumented) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := il.RateLimiter.Keys(ctx, prefix)
+	return keys, il.failed(OpKeys, err)
+}
+
+// Close closes the wrapped rate limiter when it is an io.Closer, such as the ones of the memory backend.
+func (il *instrumented) Close() error {
+	if closer, ok := il.RateLimiter.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// timed records how long the operation took since it started.
+func (il *instrumented) timed(operation string, start time.Time) {
+	il.rec.CheckDuration(il.algorithm, operation, time.Since(start))
+}
+
+// failed records the error of the operation, unless it is nil or the operation was canceled, and returns it.
+func (il *instrumented) failed(operation string, err error) error {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		il.rec.RateLimiterError(il.algorithm, operation)
+	}
+
+	return err
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorderMock is a Recorder that keeps the rate limiter measurements.
+type recorderMock struct {
+	Nop
+	durations []string
+	errors    []string
+}
+
+func (r *recorderMock) CheckDuration(algorithm, operation string, d time.Duration) {
+	r.durations = append(r.durations, fmt.Sprintf("%v/%v", algorithm, operation))
+}
+
+func (r *recorderMock) RateLimiterError(algorithm, operation string) {
+	r.errors = append(r.errors, fmt.Sprintf("%v/%v", algorithm, operation))
+}
+
+// failingLimiter is a rate limiter whose checks fail with an error.
+type failingLimiter struct {
+	rate_limiter.RateLimiter
+	err error
+}
+
+func (fl *failingLimiter) CheckLimit(context.Context, string, int64, time.Duration) (*models.RateLimitStatus, error) {
+	return nil, fl.err
+}
+
+func TestRateLimiter(t *testing.T) {
+	rec := &recorderMock{}
+	memory := rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, rate_limiter.GCRA, nil)
+	rlimiter := RateLimiter(memory, rate_limiter.GCRA, rec)
+	t.Cleanup(func() {
+		require.NoError(t, rlimiter.(io.Closer).Close())
+	})
+	ctx := context.Background()
+
+	status, err := rlimiter.CheckLimit(ctx, "{user}-news", 1, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, models.Allowed, status.State)
+
+	status, err = rlimiter.CheckLimitN(ctx, "{user}-news", 1, time.Minute, 1)
+	require.NoError(t, err)
+	assert.Equal(t, models.Denied, status.State)
+
+	_, err = rlimiter.CheckLimitsN(ctx, []rate_limiter.Window{{Key: "{user}-status", Limit: 1, Size: time.Minute}}, 1)
+	require.NoError(t, err)
+
+	// the operations that are not checks are not timed
+	_, err = rlimiter.Status(ctx, "{user}-news", 1, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, rlimiter.Reset(ctx, "{user}-news"))
+
+	assert.Equal(t, []string{"gcra/check_limit", "gcra/check_limit_n", "gcra/check_limits_n"}, rec.durations)
+	assert.Empty(t, rec.errors)
+}
+
+func TestRateLimiter_Errors(t *testing.T) {
+	rec := &recorderMock{}
+	failing := &failingLimiter{err: errors.New("connection refused")}
+	rlimiter := RateLimiter(failing, rate_limiter.SlidingWindowCounter, rec)
+
+	_, err := rlimiter.CheckLimit(context.Background(), "{user}-news", 1, time.Minute)
+	require.ErrorIs(t, err, failing.err)
+
+	// a canceled check is not an error of the rate limiter
+	failing.err = fmt.Errorf("failed to check limit: %w", context.Canceled)
+	_, err = rlimiter.CheckLimit(context.Background(), "{user}-news", 1, time.Minute)
+	require.ErrorIs(t, err, context.Canceled)
+
+	assert.Equal(t, []string{"sliding_window/check_limit", "sliding_window/check_limit"}, rec.durations)
+	assert.Equal(t, []string{"sliding_window/check_limit"}, rec.errors)
+}
+
+func TestPrometheus(t *testing.T) {
+	p, err := NewPrometheus(nil)
+	require.NoError(t, err)
+
+	p.Decision("news", rate_limiter.GCRA, models.Allowed)
+	p.Decision("news", rate_limiter.GCRA, models.Allowed)
+	p.Decision("news", rate_limiter.GCRA, models.Denied)
+	p.CheckDuration(rate_limiter.GCRA, OpCheckLimitN, 3*time.Millisecond)
+	p.RateLimiterError(rate_limiter.GCRA, OpCheckLimitN)
+	p.GatewaySend("news", nil)
+	p.GatewaySend("news", errors.New("gateway down"))
+	p.Limits(configs.LimitConfigMap{
+		"news": {Type: "news", Limit: 3, WSize: configs.Duration(time.Minute)},
+		"alert": {Type: "alert", Windows: []*configs.WindowConfig{
+			{Limit: 2, WSize: configs.Duration(time.Minute)},
+			{Limit: 10, WSize: configs.Duration(time.Hour)},
+		}},
+	}, &configs.LimitConfig{Limit: 20, WSize: configs.Duration(time.Hour)})
+
+	body := scrape(t, p)
+	for _, series := range []string{
+		`ratelimit_decisions_total{algorithm="gcra",decision="allowed",type="news"} 2`,
+		`ratelimit_decisions_total{algorithm="gcra",decision="denied",type="news"} 1`,
+		`ratelimit_check_duration_seconds_bucket{algorithm="gcra",operation="check_limit_n",le="0.0032"} 1`,
+		`ratelimit_check_duration_seconds_count{algorithm="gcra",operation="check_limit_n"} 1`,
+		`ratelimit_backend_errors_total{algorithm="gcra",operation="check_limit_n"} 1`,
+		`ratelimit_gateway_sends_total{result="success",type="news"} 1`,
+		`ratelimit_gateway_sends_total{result="failure",type="news"} 1`,
+		`ratelimit_configured_limit{type="news",window="1m0s"} 3`,
+		`ratelimit_configured_limit{type="alert",window="1m0s"} 2`,
+		`ratelimit_configured_limit{type="alert",window="1h0m0s"} 10`,
+		`ratelimit_configured_limit{type="global",window="1h0m0s"} 20`,
+	} {
+		assert.Contains(t, body, series)
+	}
+
+	// the limits that are gone are removed
+	p.Limits(configs.LimitConfigMap{"news": {Type: "news", Limit: 5, WSize: configs.Duration(time.Minute)}}, nil)
+
+	body = scrape(t, p)
+	assert.Contains(t, body, `ratelimit_configured_limit{type="news",window="1m0s"} 5`)
+	assert.NotContains(t, body, `type="alert"`)
+	assert.NotContains(t, body, `type="global"`)
+
+	// the series cannot be registered twice in the same registry
+	_, err = NewPrometheus(p.registry)
+	assert.Error(t, err)
+}
+
+func scrape(t *testing.T, p *Prometheus) string {
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+
+	return rec.Body.String()
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the names of the Prometheus series.
+const namespace = "ratelimit"
+
+// globalType is the type label of the global limit in the configured limits gauge.
+const globalType = "global"
+
+// Prometheus is a Recorder that keeps the measurements as Prometheus series:
+//
+//	ratelimit_decisions_total{type, algorithm, decision}      rate limit checks of the notifications, allowed, denied or exempt
+//	ratelimit_check_duration_seconds{algorithm, operation}    latency of the rate limiter checks
+//	ratelimit_backend_errors_total{algorithm, operation}      operations of the rate limiter backend that failed
+//	ratelimit_gateway_sends_total{type, result}               notifications sent through the gateway, success or failure
+//	ratelimit_configured_limit{type, window}                  limit of every window of the notification types
+//
+// The global limit shows up in the configured limits with the global type.
+type Prometheus struct {
+	registry  *prometheus.Registry
+	decisions *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+	errors    *prometheus.CounterVec
+	gateway   *prometheus.CounterVec
+	limits    *prometheus.GaugeVec
+}
+
+// NewPrometheus creates a Prometheus recorder that registers its series in the registry, or in a new one when it is
+// nil, which lets them be served along with the ones of the rest of the application. It fails when the registry
+// already has series with the same names.
+func NewPrometheus(registry *prometheus.Registry) (*Prometheus, error) {
+	if registry == nil {
+		registry = prometheus.NewRegistry()
+	}
+
+	p := &Prometheus{
+		registry: registry,
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "decisions_total",
+			Help:      "Rate limit checks of the notifications by notification type, algorithm and decision.",
+		}, []string{"type", "algorithm", "decision"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "check_duration_seconds",
+			Help:      "Latency of the rate limiter checks by algorithm and operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 15),
+		}, []string{"algorithm", "operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_errors_total",
+			Help:      "Operations of the rate limiter backend that failed by algorithm and operation.",
+		}, []string{"algorithm", "operation"}),
+		gateway: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "gateway_sends_total",
+			Help:      "Notifications sent through the gateway by notification type and result.",
+		}, []string{"type", "result"}),
+		limits: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "configured_limit",
+			Help:      "Limit of every window of the notification types in use.",
+		}, []string{"type", "window"}),
+	}
+
+	for _, collector := range []prometheus.Collector{p.decisions, p.durations, p.errors, p.gateway, p.limits} {
+		if err := registry.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Handler returns the http.Handler serving the series of the registry in the Prometheus text exposition format.
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+// Decision counts a rate limit check of a notification.
+func (p *Prometheus) Decision(typ, algorithm string, state models.State) {
+	p.decisions.WithLabelValues(typ, algorithm, string(state)).Inc()
+}
+
+// CheckDuration observes the latency of a rate limiter check.
+func (p *Prometheus) CheckDuration(algorithm, operation string, d time.Duration) {
+	p.durations.WithLabelValues(algorithm, operation).Observe(d.Seconds())
+}
+
+// RateLimiterError counts an operation of the rate limiter that failed.
+func (p *Prometheus) RateLimiterError(algorithm, operation string) {
+	p.errors.WithLabelValues(algorithm, operation).Inc()
+}
+
+// GatewaySend counts a notification sent through the gateway, as a success or as a failure.
+func (p *Prometheus) GatewaySend(typ string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	p.gateway.WithLabelValues(typ, result).Inc()
+}
+
+// Limits replaces the configured limits with the ones of every window of the notification types and the global limit.
+func (p *Prometheus) Limits(lconfigs configs.LimitConfigMap, global *configs.LimitConfig) {
+	p.limits.Reset()
+
+	for typ, conf := range lconfigs {
+		p.setLimits(typ, conf)
+	}
+	if global != nil {
+		p.setLimits(globalType, global)
+	}
+}
+
+// setLimits sets the configured limit of every window of the configuration, labeled with the window size.
+func (p *Prometheus) setLimits(typ string, conf *configs.LimitConfig) {
+	for _, wconf := range conf.WindowConfigs() {
+		limit, size := wconf.Quota()
+		p.limits.WithLabelValues(typ, size.String()).Set(float64(limit))
+	}
+}
@@ -1,14 +1,17 @@
 package models
 
 import (
+	"time"
+
 	"github.com/segmentio/ksuid"
 )
 
 // Notification represents a notification with a type, user ID, and message.
 type Notification struct {
-	Type    string      // Type of the notification
-	UserID  ksuid.KSUID // User ID associated with the notification
-	Message string      // Message content of the notification
+	Type     string      // Type of the notification
+	UserID   ksuid.KSUID // User ID associated with the notification
+	TenantID string      // Optional tenant the user belongs to, whose rate limit overrides apply to the user
+	Message  string      // Message content of the notification
 }
 
 // isValid checks if a notification is valid.
@@ -37,9 +40,33 @@ const (
 	Denied  State = "denied"
 )
 
+// Fallback describes how a decision was made when the rate limiter backend was unavailable.
+type Fallback string
+
+const (
+	FailedOpen Fallback = "fail_open"
+	Degraded   Fallback = "degrade"
+)
+
 // RateLimitStatus represents the status of a rate limit.
+// Limit is the limit of the window the status is about, and Remaining is how many more units of it can be used
+// right away.
+// RetryAfter is how long a Denied request has to wait until it fits within the limit, which is zero when it is Allowed
+// or when it costs more than the limit, since it would never fit.
+// Window names the window the status is about, such as "news/1m0s", when it is known.
+// RequestID identifies the allowed request within the rate limit, when the rate limiter keeps track of every request
+// or of the window it was counted in, so its units can be refunded exactly.
+// Fallback is set when the decision was not made by the rate limiter backend.
+// Exempt is set when the user is not rate limited at all, in which case the status is always Allowed.
 type RateLimitStatus struct {
 	State       State
 	Count       int
+	Limit       int64
+	Remaining   int
+	RetryAfter  time.Duration
 	ExpiresAtMs int64
+	Window      string
+	RequestID   string
+	Fallback    Fallback
+	Exempt      bool
 }
@@ -3,13 +3,24 @@ package notification
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
 
 	"github.com/godoylucase/rate-limit/configs"
 	"github.com/godoylucase/rate-limit/errs"
+	"github.com/godoylucase/rate-limit/metrics"
 	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+	"github.com/godoylucase/rate-limit/tracing"
 
 	"time"
+
+	"github.com/segmentio/ksuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Gateway defines the interface for sending notifications.
@@ -17,56 +28,631 @@ type Gateway interface {
 	Send(ctx context.Context, userID string, message string) error
 }
 
-// RateLimiter is an interface that defines the methods for checking the rate limit.
+// RateLimiter is an interface that defines the methods for checking the rate limit of requests that cost n units,
+// either against a single window or several of them at once, for peeking at its status without changing it,
+// for refunding the units of requests that did not happen, and for clearing the rate limit of a key.
 type RateLimiter interface {
-	CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error)
+	CheckLimitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error)
+	CheckLimitsN(ctx context.Context, windows []rate_limiter.Window, n int64) ([]*models.RateLimitStatus, error)
+	WaitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error)
+	Status(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error)
+	Refund(ctx context.Context, key string, limit int64, tWindow time.Duration, requestID string, n int64) error
+	Reset(ctx context.Context, key string) error
+}
+
+// OverrideProvider looks up the rate limit override of a user, or of the tenant it belongs to, which replaces
+// the default limits of the user. It returns nil when the user keeps the default limits.
+// configs.OverrideList is the provider of the overrides listed in the configuration.
+type OverrideProvider interface {
+	Override(ctx context.Context, userID, tenantID string) (*configs.Override, error)
+}
+
+// CostFunc returns how many units of its rate limit a notification costs, which must be at least 1.
+type CostFunc func(notif *models.Notification) int64
+
+// limitFn checks the rate limit for the key using one of the RateLimiter methods.
+type limitFn func(rlimiter RateLimiter, ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error)
+
+// limitsFn checks the rate limit for several windows at once using the RateLimiter.
+type limitsFn func(rlimiter RateLimiter, ctx context.Context, windows []rate_limiter.Window, n int64) ([]*models.RateLimitStatus, error)
+
+// userLimits are the rate limits of a user for a notification type, once its override is applied.
+type userLimits struct {
+	conf   *configs.LimitConfig
+	global *configs.LimitConfig
+	exempt bool
+}
+
+// limitSet is the rate limit configuration of the Service, which is swapped at once when the limits change.
+type limitSet struct {
+	lconfigs configs.LimitConfigMap
+	global   *configs.LimitConfig
+}
+
+// limitWindow is a rate limit window of a user along with the configuration it comes from,
+// which is the one of the notification type unless the window belongs to the global limit.
+// The name of the window is the notification type, or global, followed by the window size, such as "news/1m0s".
+type limitWindow struct {
+	rate_limiter.Window
+	name   string
+	conf   *configs.LimitConfig
+	global bool
 }
 
 // Service is a notification service that sends notifications with rate limiting.
 type Service struct {
 	gateway  Gateway
 	rlimiter RateLimiter
-	lconfigs configs.LimitConfigMap
+	limits   atomic.Pointer[limitSet]
+	cost     CostFunc
+	refund   bool
+
+	overrides []OverrideProvider
+
+	metrics   metrics.Recorder
+	tracer    trace.Tracer
+	algorithm string
+
+	fallback     RateLimiter
+	fallbackOnce sync.Once
+	ownsFallback bool
+}
+
+// Option configures optional behavior of the Service.
+type Option func(*Service)
+
+// WithFallbackLimiter sets the rate limiter used by the notification types with the Degrade failure policy
+// while the main rate limiter is unavailable. It defaults to an in-memory rate limiter of the algorithm of the Service,
+// see WithAlgorithm, which is closed along with the Service. The rate limiter given here is left to the caller to close.
+func WithFallbackLimiter(rlimiter RateLimiter) Option {
+	return func(s *Service) {
+		s.fallback = rlimiter
+	}
+}
+
+// WithAlgorithm tells the Service the algorithm of its rate limiter, one of rate_limiter.Types, so its default
+// fallback rate limiter counts the requests the same way while the main one is unavailable. It defaults to the sliding
+// window counter. The algorithms given to WithMetrics and WithTracing set it too.
+func WithAlgorithm(algorithm string) Option {
+	return func(s *Service) {
+		s.algorithm = algorithm
+	}
+}
+
+// WithGlobalLimit sets a rate limit per user that counts the notifications of all types together, which is checked
+// along with the rate limit of each notification type. A nil configuration leaves the users without a global limit.
+// The failure policy of each notification type applies to the global limit too, scaled by its own degraded ratio.
+func WithGlobalLimit(conf *configs.LimitConfig) Option {
+	return func(s *Service) {
+		s.UpdateLimits(s.limits.Load().lconfigs, conf)
+	}
+}
+
+// WithConfigWatcher makes the Service follow the changes of the configuration file seen by the watcher,
+// swapping its limits and global limit every time a valid file is applied, and looking up the overrides
+// of the configuration in use. It replaces the limits given to NewService and the ones of WithGlobalLimit.
+func WithConfigWatcher(w *configs.Watcher) Option {
+	return func(s *Service) {
+		w.Subscribe(func(change configs.ConfigChange) {
+			if change.Err == nil {
+				s.UpdateLimits(change.New.Limits, change.New.Global)
+			}
+		})
+
+		conf := w.Config()
+		s.UpdateLimits(conf.Limits, conf.Global)
+		s.overrides = append(s.overrides, w)
+	}
+}
+
+// WithOverrideProvider adds a provider of rate limit overrides, which the Service asks before applying the default
+// limits of a user, so overrides can come from the configuration or any other source, such as a database.
+// The providers are asked in the order they were added, and the first override found applies.
+func WithOverrideProvider(provider OverrideProvider) Option {
+	return func(s *Service) {
+		s.overrides = append(s.overrides, provider)
+	}
+}
+
+// WithCostFunc sets the function that works out the cost of each notification, for instance the number of segments
+// of an SMS. Every notification costs a single unit by default.
+func WithCostFunc(cost CostFunc) Option {
+	return func(s *Service) {
+		s.cost = cost
+	}
+}
+
+// WithRefundOnGatewayError makes the Service refund the rate limit used by a notification when the gateway fails
+// to send it, so failed deliveries do not count against the users.
+func WithRefundOnGatewayError() Option {
+	return func(s *Service) {
+		s.refund = true
+	}
+}
+
+// WithMetrics makes the Service record its rate limit decisions, labeled with the algorithm of its rate limiter,
+// the outcome of the notifications it sends through the gateway, and the limits it uses every time they change.
+// The latency and the errors of the rate limiter are recorded by wrapping it with metrics.RateLimiter.
+func WithMetrics(rec metrics.Recorder, algorithm string) Option {
+	return func(s *Service) {
+		s.metrics = rec
+		s.algorithm = algorithm
+
+		limits := s.limits.Load()
+		rec.Limits(limits.lconfigs, limits.global)
+	}
+}
+
+// WithTracing makes the Service open its spans from the provider, instead of the global one, labeling them with
+// the algorithm of its rate limiter. Every notification sent gets a "Service.Send" span with its type, decision,
+// count and limit, with a "Gateway.Send" child span for its gateway call. The spans of the rate limiter checks
+// are opened by wrapping it with tracing.RateLimiter.
+func WithTracing(tp trace.TracerProvider, algorithm string) Option {
+	return func(s *Service) {
+		s.tracer = tracing.Tracer(tp)
+		s.algorithm = algorithm
+	}
 }
 
 // NewService creates a new instance of the Service.
-func NewService(rlimiter RateLimiter, gateway Gateway, lconfigs configs.LimitConfigMap) *Service {
-	return &Service{
+func NewService(rlimiter RateLimiter, gateway Gateway, lconfigs configs.LimitConfigMap, opts ...Option) *Service {
+	s := &Service{
 		gateway:  gateway,
 		rlimiter: rlimiter,
-		lconfigs: lconfigs,
+		cost:     unitCost,
+		metrics:  metrics.Nop{},
+		tracer:   tracing.Tracer(nil),
 	}
+	s.UpdateLimits(lconfigs, nil)
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Close releases the default fallback rate limiter, when it was created. The Service must not be used once closed.
+func (s *Service) Close() error {
+	s.fallbackOnce.Do(func() {})
+
+	if closer, ok := s.fallback.(io.Closer); ok && s.ownsFallback {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// UpdateLimits replaces the limits of the notification types and the global limit, which may be nil, at once.
+// The notifications being sent keep the limits they started with, and the next ones use the new limits.
+// The counters of the users are kept, so a new limit applies to what they already used in the current window.
+func (s *Service) UpdateLimits(lconfigs configs.LimitConfigMap, global *configs.LimitConfig) {
+	s.limits.Store(&limitSet{lconfigs: lconfigs, global: global})
+	s.metrics.Limits(lconfigs, global)
 }
 
 // Send sends a notification using the specified context and notification data.
 // It performs validation, checks the rate limit, and sends the notification using the gateway.
 func (s *Service) Send(ctx context.Context, notif *models.Notification) error {
+	_, err := s.SendWithStatus(ctx, notif)
+	return err
+}
+
+// SendWithStatus works like Send, and also returns the rate limit status the notification was sent with.
+// The status tells whether the decision was made by a fallback because the rate limiter was unavailable.
+// For a notification type with several windows, it is the status of the window that denies the notification
+// for the longest, or the one with the fewest remaining units when all of them allowed it.
+func (s *Service) SendWithStatus(ctx context.Context, notif *models.Notification) (*models.RateLimitStatus, error) {
+	return s.send(ctx, notif, RateLimiter.CheckLimitN, RateLimiter.CheckLimitsN)
+}
+
+// SendWait works like Send, but instead of rejecting a rate limited notification it blocks until the notification
+// fits within the rate limit. It only returns errs.ErrExceededRateLimit when that would not happen before the context
+// deadline, and it returns the context error when the context is done while waiting.
+func (s *Service) SendWait(ctx context.Context, notif *models.Notification) error {
+	_, err := s.send(ctx, notif, RateLimiter.WaitN, waitLimitsN)
+	return err
+}
+
+// Status returns the current rate limit status of a user for a notification type, without sending anything
+// nor using any of its rate limit. The Remaining field of the status tells how many more units of the rate limit
+// the user can use right away, and the State whether a notification that costs a single unit would be sent.
+// For a notification type with several windows, or when there is a global limit, it is the status of the most
+// restrictive one. The tenant ID is optional, and it is only used to look up the overrides of the user.
+func (s *Service) Status(ctx context.Context, userID ksuid.KSUID, tenantID string, typ string) (*models.RateLimitStatus, error) {
+	if userID.IsNil() || len(typ) == 0 {
+		return nil, fmt.Errorf("invalid status values: %w", errs.ErrInvalidArguments)
+	}
+
+	limits, err := s.userLimits(ctx, userID, tenantID, typ)
+	if err != nil {
+		return nil, err
+	} else if limits.exempt {
+		return &models.RateLimitStatus{State: models.Allowed, Exempt: true}, nil
+	}
+
+	windows := s.limitWindows(userID, typ, limits)
+	statuses := make([]*models.RateLimitStatus, 0, len(windows))
+	for _, window := range windows {
+		status, err := s.rlimiter.Status(ctx, window.Key, window.Limit, window.Size)
+		if err != nil {
+			return nil, fmt.Errorf("error getting rate limit status for notification type %v: %w", typ, err)
+		}
+		status.Window = window.name
+		statuses = append(statuses, status)
+	}
+
+	return statuses[rate_limiter.Decisive(statuses)], nil
+}
+
+// Types returns the notification types of the limits in use, sorted.
+func (s *Service) Types() []string {
+	lconfigs := s.limits.Load().lconfigs
+
+	types := make([]string, 0, len(lconfigs))
+	for typ := range lconfigs {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// Reset clears the rate limit of a user for a notification type, every one of its windows included, so the user
+// can send its notifications right away. The global limit of the user is kept. The tenant ID is optional, and it is
+// only used to look up the overrides of the user, which may give the type other windows.
+func (s *Service) Reset(ctx context.Context, userID ksuid.KSUID, tenantID string, typ string) error {
+	if userID.IsNil() || len(typ) == 0 {
+		return fmt.Errorf("invalid reset values: %w", errs.ErrInvalidArguments)
+	}
+
+	limits, err := s.userLimits(ctx, userID, tenantID, typ)
+	if err != nil {
+		return err
+	}
+
+	for _, window := range configWindows(limitKey(userID, typ), typ, limits.conf, false) {
+		if err := s.rlimiter.Reset(ctx, window.Key); err != nil {
+			return fmt.Errorf("error resetting rate limit for notification type %v: %w", typ, err)
+		}
+	}
+
+	return nil
+}
+
+// send sends the notification within the "Service.Send" span, which gets the attributes of the rate limit status
+// the notification was sent with. A rate limited notification is not a failure of the span.
+func (s *Service) send(ctx context.Context, notif *models.Notification, check limitFn, checkAll limitsFn) (*models.RateLimitStatus, error) {
+	ctx, span := s.tracer.Start(ctx, "Service.Send")
+	defer span.End()
+
+	if s.algorithm != "" {
+		span.SetAttributes(tracing.AlgorithmKey.String(s.algorithm))
+	}
+	if notif != nil {
+		span.SetAttributes(tracing.TypeKey.String(notif.Type))
+	}
+
+	status, err := s.sendNotification(ctx, notif, check, checkAll)
+	if status != nil {
+		span.SetAttributes(tracing.StatusAttributes(status)...)
+		if status.Exempt {
+			span.SetAttributes(tracing.ExemptKey.Bool(true))
+		}
+	}
+	if !errors.As(err, new(*errs.ErrExceededRateLimit)) {
+		tracing.Fail(span, err)
+	}
+
+	return status, err
+}
+
+// sendNotification validates the notification, checks its rate limit with the given functions, and sends it using
+// the gateway. The check function is used for the notification types with a single window, and checkAll for the ones
+// with several.
+func (s *Service) sendNotification(ctx context.Context, notif *models.Notification, check limitFn, checkAll limitsFn) (*models.RateLimitStatus, error) {
 	if !models.IsValid(notif) {
-		return fmt.Errorf("invalid notification values: %w", errs.ErrInvalidArguments)
+		return nil, fmt.Errorf("invalid notification values: %w", errs.ErrInvalidArguments)
+	}
+
+	limits, err := s.userLimits(ctx, notif.UserID, notif.TenantID, notif.Type)
+	if err != nil {
+		return nil, err
+	} else if limits.exempt {
+		s.metrics.Decision(notif.Type, s.algorithm, metrics.Exempt)
+		if err := s.sendThroughGateway(ctx, notif); err != nil {
+			return nil, fmt.Errorf("%w when sending notification: %w", errs.ErrGateway, err)
+		}
+		return &models.RateLimitStatus{State: models.Allowed, Exempt: true}, nil
 	}
 
-	conf := s.lconfigs.Get(notif.Type)
-	if conf == nil {
-		return fmt.Errorf("notification type %v not found in config: %w", notif.Type, errs.ErrInvalidArguments)
+	cost := s.cost(notif)
+	if cost < 1 {
+		return nil, fmt.Errorf("invalid cost %v for notification type %v: %w", cost, notif.Type, errs.ErrInvalidArguments)
 	}
 
-	key := fmt.Sprintf("%v-%v", notif.UserID.String(), notif.Type)
+	windows := s.limitWindows(notif.UserID, notif.Type, limits)
 
-	status, err := s.rlimiter.CheckLimit(ctx, key, conf.Limit, conf.WindowsSizeDuration())
+	statuses, err := s.checkLimits(ctx, limits.conf, windows, cost, check, checkAll)
 	if err != nil {
-		return fmt.Errorf("error checking rate limit for notification type %v: %w", notif.Type, err)
-	} else if status.State == models.Denied {
-		return &errs.ErrExceededRateLimit{
-			State:     string(status.State),
-			Count:     status.Count,
-			ExpiresAt: status.ExpiresAtMs,
+		return nil, fmt.Errorf("error checking rate limit for notification type %v: %w", notif.Type, err)
+	}
+
+	for i, status := range statuses {
+		status.Window = windows[i].name
+	}
+
+	idx := rate_limiter.Decisive(statuses)
+	status := statuses[idx]
+	s.metrics.Decision(notif.Type, s.algorithm, status.State)
+	if status.State == models.Denied {
+		limit := windows[idx].Limit
+		if status.Fallback == models.Degraded {
+			limit = windows[idx].conf.DegradedLimit(limit)
 		}
 
+		return status, &errs.ErrExceededRateLimit{
+			State:      string(status.State),
+			Count:      status.Count,
+			ExpiresAt:  status.ExpiresAtMs,
+			Fallback:   string(status.Fallback),
+			Limit:      limit,
+			Remaining:  status.Remaining,
+			RetryAfter: status.RetryAfter,
+			Window:     windows[idx].Size,
+			WindowName: windows[idx].name,
+			Global:     windows[idx].global,
+		}
 	}
 
-	if err := s.gateway.Send(ctx, notif.UserID.String(), notif.Message); err != nil {
-		return fmt.Errorf("gateway error when sending notification: %w", err)
+	if err := s.sendThroughGateway(ctx, notif); err != nil {
+		if s.refund {
+			if refundErr := s.refundLimits(ctx, windows, statuses, cost); refundErr != nil {
+				return status, fmt.Errorf("%w when sending notification: %w, and refunding its rate limit failed with error: %v", errs.ErrGateway, err, refundErr)
+			}
+		}
+		return status, fmt.Errorf("%w when sending notification: %w", errs.ErrGateway, err)
 	}
 
-	return nil
+	return status, nil
+}
+
+// sendThroughGateway sends the notification using the gateway within the "Gateway.Send" span,
+// recording whether it succeeded.
+func (s *Service) sendThroughGateway(ctx context.Context, notif *models.Notification) error {
+	ctx, span := s.tracer.Start(ctx, "Gateway.Send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(tracing.TypeKey.String(notif.Type)),
+	)
+	defer span.End()
+
+	err := s.gateway.Send(ctx, notif.UserID.String(), notif.Message)
+	s.metrics.GatewaySend(notif.Type, err)
+	tracing.Fail(span, err)
+
+	return err
+}
+
+// checkLimits checks the rate limit of every window charging n units, applying the failure policy of
+// the configuration when the rate limiter is unavailable. It returns the status of every window in the same order.
+func (s *Service) checkLimits(ctx context.Context, conf *configs.LimitConfig, windows []limitWindow, n int64, check limitFn, checkAll limitsFn) ([]*models.RateLimitStatus, error) {
+	statuses, err := checkWindows(s.rlimiter, ctx, rateLimiterWindows(windows, false), n, check, checkAll)
+	if err == nil || ctx.Err() != nil {
+		return statuses, err
+	}
+
+	switch conf.FailurePolicy() {
+	case configs.FailOpen:
+		statuses = make([]*models.RateLimitStatus, 0, len(windows))
+		for _, window := range windows {
+			statuses = append(statuses, &models.RateLimitStatus{
+				State:       models.Allowed,
+				Limit:       window.Limit,
+				ExpiresAtMs: time.Now().Add(window.Size).UnixMilli(),
+				Fallback:    models.FailedOpen,
+			})
+		}
+		return statuses, nil
+	case configs.Degrade:
+		statuses, fallbackErr := checkWindows(s.fallbackLimiter(), ctx, rateLimiterWindows(windows, true), n, check, checkAll)
+		if fallbackErr != nil {
+			return nil, fmt.Errorf("fallback rate limiter failed with error: %v, after: %w", fallbackErr, err)
+		}
+
+		for _, status := range statuses {
+			status.Fallback = models.Degraded
+		}
+		return statuses, nil
+	default:
+		return nil, err
+	}
+}
+
+// refundLimits gives back the n units used by a notification to the rate limiters that allowed it in every window.
+// The refund outlives the context of the notification, since the gateway may have failed because it was done.
+func (s *Service) refundLimits(ctx context.Context, windows []limitWindow, statuses []*models.RateLimitStatus, n int64) error {
+	ctx = context.WithoutCancel(ctx)
+
+	var refundErrs []error
+	for i, window := range windows {
+		var err error
+		switch statuses[i].Fallback {
+		case models.FailedOpen:
+			// Nothing was used while failing open
+		case models.Degraded:
+			err = s.fallbackLimiter().Refund(ctx, window.Key, window.conf.DegradedLimit(window.Limit), window.Size, statuses[i].RequestID, n)
+		default:
+			err = s.rlimiter.Refund(ctx, window.Key, window.Limit, window.Size, statuses[i].RequestID, n)
+		}
+
+		if err != nil {
+			refundErrs = append(refundErrs, err)
+		}
+	}
+
+	return errors.Join(refundErrs...)
+}
+
+// fallbackLimiter returns the rate limiter for the Degrade failure policy, creating the default one on first use
+// with the algorithm of the Service, or the sliding window counter when it is unknown.
+func (s *Service) fallbackLimiter() RateLimiter {
+	s.fallbackOnce.Do(func() {
+		if s.fallback == nil {
+			s.fallback = rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, s.algorithm, nil)
+			s.ownsFallback = true
+		}
+	})
+
+	return s.fallback
+}
+
+// checkWindows checks the rate limit of the windows with the rate limiter, using check when there is a single one
+// and checkAll when there are several.
+func checkWindows(rlimiter RateLimiter, ctx context.Context, windows []rate_limiter.Window, n int64, check limitFn, checkAll limitsFn) ([]*models.RateLimitStatus, error) {
+	if len(windows) > 1 {
+		return checkAll(rlimiter, ctx, windows, n)
+	}
+
+	status, err := check(rlimiter, ctx, windows[0].Key, windows[0].Limit, windows[0].Size, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*models.RateLimitStatus{status}, nil
+}
+
+// waitLimitsN is the limitsFn of SendWait, which blocks until the request fits within all the windows, checking them
+// again once the ones that denied it have room for it. It returns the Denied statuses right away when that would not happen
+// before the context deadline, or when the request costs more than the limit of a window, and it returns
+// the context error when the context is done while waiting.
+func waitLimitsN(rlimiter RateLimiter, ctx context.Context, windows []rate_limiter.Window, n int64) ([]*models.RateLimitStatus, error) {
+	for {
+		statuses, err := rlimiter.CheckLimitsN(ctx, windows, n)
+		if err != nil || rate_limiter.Allowed(statuses) {
+			return statuses, err
+		}
+
+		var retryAfter time.Duration
+		for i, status := range statuses {
+			if status.State != models.Denied {
+				continue
+			}
+			if n > windows[i].Limit {
+				return statuses, nil
+			}
+			retryAfter = max(retryAfter, status.RetryAfter)
+		}
+
+		delay := max(time.Millisecond, retryAfter)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return statuses, nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// userLimits returns the rate limits of a user for a notification type, applying the first override found
+// by the override providers to the default ones.
+func (s *Service) userLimits(ctx context.Context, userID ksuid.KSUID, tenantID string, typ string) (*userLimits, error) {
+	set := s.limits.Load()
+	limits := &userLimits{
+		conf:   set.lconfigs.Get(typ),
+		global: set.global,
+	}
+	if limits.conf == nil {
+		return nil, fmt.Errorf("notification type %v not found in config: %w", typ, errs.ErrInvalidArguments)
+	}
+
+	for _, provider := range s.overrides {
+		override, err := provider.Override(ctx, userID.String(), tenantID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting rate limit override for user %v: %w", userID, err)
+		} else if override == nil {
+			continue
+		}
+
+		limits.exempt = override.Exempt
+		if conf := override.Limit(typ); conf != nil {
+			limits.conf = conf
+		}
+		if override.Global != nil {
+			limits.global = override.Global
+		}
+		break
+	}
+
+	return limits, nil
+}
+
+// limitWindows returns the rate limit windows of a user for a notification type, followed by the ones of the global
+// limit when there is one.
+func (s *Service) limitWindows(userID ksuid.KSUID, typ string, limits *userLimits) []limitWindow {
+	windows := configWindows(limitKey(userID, typ), typ, limits.conf, false)
+	if limits.global != nil {
+		windows = append(windows, configWindows(globalKey(userID), "global", limits.global, true)...)
+	}
+
+	return windows
+}
+
+// configWindows returns the windows of a rate limit configuration for the key, named after name,
+// keyed the way configs.LimitConfig.RateLimiterWindows does.
+func configWindows(key, name string, conf *configs.LimitConfig, global bool) []limitWindow {
+	rlWindows := conf.RateLimiterWindows(key)
+
+	windows := make([]limitWindow, 0, len(rlWindows))
+	for _, rlWindow := range rlWindows {
+		windows = append(windows, limitWindow{
+			Window: rlWindow,
+			name:   fmt.Sprintf("%v/%v", name, rlWindow.Size),
+			conf:   conf,
+			global: global,
+		})
+	}
+
+	return windows
+}
+
+// rateLimiterWindows returns the windows to check with the rate limiter, with the limits allowed by
+// the Degrade failure policy of their configurations when degraded is set.
+func rateLimiterWindows(windows []limitWindow, degraded bool) []rate_limiter.Window {
+	rlWindows := make([]rate_limiter.Window, 0, len(windows))
+	for _, window := range windows {
+		rlWindow := window.Window
+		if degraded {
+			rlWindow.Limit = window.conf.DegradedLimit(window.Limit)
+		}
+		rlWindows = append(rlWindows, rlWindow)
+	}
+
+	return rlWindows
+}
+
+// KeyPrefix returns the prefix of every rate limit key of a user, which is its cluster hash tag.
+func KeyPrefix(userID ksuid.KSUID) string {
+	return fmt.Sprintf("{%v}", userID.String())
+}
+
+// limitKey returns the rate limit key of a user for a notification type.
+// The user ID is the cluster hash tag of the key, so all the keys of a user land on the same redis slot.
+func limitKey(userID ksuid.KSUID, typ string) string {
+	return configs.LimitKey(userID.String(), typ)
+}
+
+// globalKey returns the key of the global rate limit of a user, which shares the hash tag of its other keys
+// but never collides with the key of a notification type.
+func globalKey(userID ksuid.KSUID) string {
+	return fmt.Sprintf("{%v}:global", userID.String())
+}
+
+// unitCost is the default CostFunc, which charges a single unit per notification.
+func unitCost(*models.Notification) int64 {
+	return 1
 }
@@ -2,30 +2,74 @@ package notification
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/godoylucase/rate-limit/configs"
 	"github.com/godoylucase/rate-limit/errs"
+	"github.com/godoylucase/rate-limit/metrics"
 	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+	"github.com/godoylucase/rate-limit/tracing"
 
 	"github.com/segmentio/ksuid"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
-type CheckLimitFn func(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error)
+type CheckLimitFn func(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error)
 type SendFn func(ctx context.Context, userID string, message string) error
 
+type StatusFn func(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error)
+
+type RefundFn func(ctx context.Context, key string, limit int64, tWindow time.Duration, requestID string, n int64) error
+
+type CheckLimitsFn func(ctx context.Context, windows []rate_limiter.Window, n int64) ([]*models.RateLimitStatus, error)
+
+type ResetFn func(ctx context.Context, key string) error
+
 type RateLimitMock struct {
-	CheckLimitFn CheckLimitFn
+	CheckLimitFn  CheckLimitFn
+	CheckLimitsFn CheckLimitsFn
+	WaitFn        CheckLimitFn
+	StatusFn      StatusFn
+	RefundFn      RefundFn
+	ResetFn       ResetFn
 }
 
 type GatewayMock struct {
 	SendFn SendFn
 }
 
-func (r *RateLimitMock) CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
-	return r.CheckLimitFn(ctx, key, limit, tWindow)
+func (r *RateLimitMock) CheckLimitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	return r.CheckLimitFn(ctx, key, limit, tWindow, n)
+}
+
+func (r *RateLimitMock) CheckLimitsN(ctx context.Context, windows []rate_limiter.Window, n int64) ([]*models.RateLimitStatus, error) {
+	return r.CheckLimitsFn(ctx, windows, n)
+}
+
+func (r *RateLimitMock) WaitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	return r.WaitFn(ctx, key, limit, tWindow, n)
+}
+
+func (r *RateLimitMock) Status(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return r.StatusFn(ctx, key, limit, tWindow)
+}
+
+func (r *RateLimitMock) Refund(ctx context.Context, key string, limit int64, tWindow time.Duration, requestID string, n int64) error {
+	return r.RefundFn(ctx, key, limit, tWindow, requestID, n)
+}
+
+func (r *RateLimitMock) Reset(ctx context.Context, key string) error {
+	return r.ResetFn(ctx, key)
 }
 
 func (g *GatewayMock) Send(ctx context.Context, userID string, message string) error {
@@ -45,6 +89,7 @@ func TestService_Send(t *testing.T) {
 
 	config := configs.LimitConfigMap(conf)
 	now := time.Now()
+	userID := ksuid.New()
 
 	tests := []struct {
 		name         string
@@ -58,11 +103,12 @@ func TestService_Send(t *testing.T) {
 			name: "valid notification",
 			notif: &models.Notification{
 				Message: "Test message",
-				UserID:  ksuid.New(),
+				UserID:  userID,
 				Type:    "Test type",
 			},
 			config: &config,
-			checkLimitFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+			checkLimitFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+				require.Equal(t, "{"+userID.String()+"}-Test type", key)
 				return &models.RateLimitStatus{
 					State:       models.Allowed,
 					Count:       1,
@@ -100,7 +146,7 @@ func TestService_Send(t *testing.T) {
 				Type:    "Test type",
 			},
 			config: &config,
-			checkLimitFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+			checkLimitFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
 				return &models.RateLimitStatus{
 					State:       models.Denied,
 					Count:       1,
@@ -108,9 +154,12 @@ func TestService_Send(t *testing.T) {
 				}, nil
 			},
 			expectedErr: &errs.ErrExceededRateLimit{
-				State:     string(models.Denied),
-				Count:     1,
-				ExpiresAt: now.Unix(),
+				State:      string(models.Denied),
+				Count:      1,
+				ExpiresAt:  now.Unix(),
+				Limit:      1,
+				Window:     time.Second,
+				WindowName: "Test type/1s",
 			},
 		},
 		{
@@ -121,7 +170,7 @@ func TestService_Send(t *testing.T) {
 				Type:    "Test type",
 			},
 			config: &config,
-			checkLimitFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+			checkLimitFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
 				return &models.RateLimitStatus{
 					State:       models.Allowed,
 					Count:       1,
@@ -145,3 +194,875 @@ func TestService_Send(t *testing.T) {
 		})
 	}
 }
+
+func TestService_SendWithStatus_FailurePolicy(t *testing.T) {
+	ctx := context.Background()
+	redisErr := errors.New("connection refused")
+
+	failingLimiter := &RateLimitMock{
+		CheckLimitFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+			return nil, redisErr
+		},
+	}
+
+	tests := []struct {
+		name           string
+		conf           *configs.LimitConfig
+		algorithm      string
+		fallback       CheckLimitFn
+		sends          int
+		expectedSent   int
+		expectedStatus models.Fallback
+		expectedLimit  int64
+		expectedErr    error
+	}{
+		{
+			name:        "fail closed by default",
+			conf:        &configs.LimitConfig{Type: "Test type", Limit: 2, WSizeMs: 1000},
+			sends:       1,
+			expectedErr: redisErr,
+		},
+		{
+			name:           "fail open",
+			conf:           &configs.LimitConfig{Type: "Test type", Limit: 2, WSizeMs: 1000, OnFailure: configs.FailOpen},
+			sends:          5,
+			expectedSent:   5,
+			expectedStatus: models.FailedOpen,
+		},
+		{
+			name:           "degrade to the default in-memory limiter",
+			conf:           &configs.LimitConfig{Type: "Test type", Limit: 4, WSizeMs: 60000, OnFailure: configs.Degrade},
+			sends:          5,
+			expectedSent:   2,
+			expectedStatus: models.Degraded,
+			expectedLimit:  2,
+			expectedErr:    &errs.ErrExceededRateLimit{},
+		},
+		{
+			name:           "degrade to the in-memory limiter of the algorithm",
+			conf:           &configs.LimitConfig{Type: "Test type", Limit: 6, WSizeMs: 60000, OnFailure: configs.Degrade},
+			algorithm:      rate_limiter.GCRA,
+			sends:          5,
+			expectedSent:   3,
+			expectedStatus: models.Degraded,
+			expectedLimit:  3,
+			expectedErr:    &errs.ErrExceededRateLimit{},
+		},
+		{
+			name: "degrade to a custom limiter",
+			conf: &configs.LimitConfig{Type: "Test type", Limit: 10, WSizeMs: 1000, OnFailure: configs.Degrade, DegradedRatio: 0.1},
+			fallback: func(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+				require.Equal(t, int64(1), limit)
+				return &models.RateLimitStatus{State: models.Allowed, Count: 1}, nil
+			},
+			sends:          1,
+			expectedSent:   1,
+			expectedStatus: models.Degraded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := 0
+			gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error {
+				sent++
+				return nil
+			}}
+
+			opts := []Option{WithAlgorithm(tt.algorithm)}
+			if tt.fallback != nil {
+				opts = append(opts, WithFallbackLimiter(&RateLimitMock{CheckLimitFn: tt.fallback}))
+			}
+
+			s := NewService(failingLimiter, gateway, configs.LimitConfigMap{tt.conf.Type: tt.conf}, opts...)
+			defer func() {
+				require.NoError(t, s.Close())
+			}()
+			notif := &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: tt.conf.Type}
+
+			var lastErr error
+			for i := 0; i < tt.sends; i++ {
+				status, err := s.SendWithStatus(ctx, notif)
+				if err != nil {
+					lastErr = err
+					continue
+				}
+				require.Equal(t, tt.expectedStatus, status.Fallback)
+			}
+
+			require.Equal(t, tt.expectedSent, sent)
+			if tt.expectedErr == nil {
+				require.NoError(t, lastErr)
+				return
+			}
+
+			var errLimit *errs.ErrExceededRateLimit
+			if errors.As(tt.expectedErr, &errLimit) {
+				require.ErrorAs(t, lastErr, &errLimit)
+				require.Equal(t, string(tt.expectedStatus), errLimit.Fallback)
+				require.Equal(t, tt.expectedLimit, errLimit.Limit)
+				return
+			}
+			require.ErrorIs(t, lastErr, tt.expectedErr)
+		})
+	}
+}
+
+func TestService_SendWait(t *testing.T) {
+	conf := configs.LimitConfigMap{
+		"Test type": {
+			Type:    "Test type",
+			Limit:   1,
+			WSizeMs: 1000,
+		},
+	}
+
+	tests := []struct {
+		name         string
+		waitFn       CheckLimitFn
+		expectedSent int
+		expectedErr  error
+	}{
+		{
+			name: "sends once the rate limit allows it",
+			waitFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+				return &models.RateLimitStatus{State: models.Allowed, Count: 1}, nil
+			},
+			expectedSent: 1,
+		},
+		{
+			name: "rate limited beyond the deadline",
+			waitFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+				return &models.RateLimitStatus{State: models.Denied, Count: 1}, nil
+			},
+			expectedErr: &errs.ErrExceededRateLimit{State: string(models.Denied), Count: 1},
+		},
+		{
+			name: "context done while waiting",
+			waitFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+				return nil, context.DeadlineExceeded
+			},
+			expectedErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := 0
+			gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error {
+				sent++
+				return nil
+			}}
+
+			s := NewService(&RateLimitMock{WaitFn: tt.waitFn}, gateway, conf)
+			err := s.SendWait(context.Background(), &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: "Test type"})
+
+			require.Equal(t, tt.expectedSent, sent)
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			var errLimit *errs.ErrExceededRateLimit
+			if errors.As(tt.expectedErr, &errLimit) {
+				require.ErrorAs(t, err, &errLimit)
+				return
+			}
+			require.ErrorIs(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestService_Send_Cost(t *testing.T) {
+	ctx := context.Background()
+	conf := configs.LimitConfigMap{
+		"Test type": {
+			Type:    "Test type",
+			Limit:   10,
+			WSizeMs: 1000,
+		},
+	}
+
+	tests := []struct {
+		name         string
+		opts         []Option
+		expectedCost int64
+		expectedErr  error
+	}{
+		{
+			name:         "a single unit by default",
+			expectedCost: 1,
+		},
+		{
+			name:         "the units worked out by the cost function",
+			opts:         []Option{WithCostFunc(func(notif *models.Notification) int64 { return int64(len(notif.Message)) })},
+			expectedCost: int64(len("Test message")),
+		},
+		{
+			name:        "invalid cost",
+			opts:        []Option{WithCostFunc(func(*models.Notification) int64 { return 0 })},
+			expectedErr: errs.ErrInvalidArguments,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cost int64
+			rlimiter := &RateLimitMock{CheckLimitFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+				cost = n
+				return &models.RateLimitStatus{State: models.Allowed, Count: int(n)}, nil
+			}}
+			gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error { return nil }}
+
+			s := NewService(rlimiter, gateway, conf, tt.opts...)
+			err := s.Send(ctx, &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: "Test type"})
+
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				require.Zero(t, cost)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedCost, cost)
+		})
+	}
+}
+
+func TestService_Status(t *testing.T) {
+	ctx := context.Background()
+	conf := configs.LimitConfigMap{
+		"Test type": {
+			Type:    "Test type",
+			Limit:   5,
+			WSizeMs: 1000,
+		},
+	}
+	userID := ksuid.New()
+
+	tests := []struct {
+		name        string
+		userID      ksuid.KSUID
+		typ         string
+		statusFn    StatusFn
+		expected    *models.RateLimitStatus
+		expectedErr error
+	}{
+		{
+			name:   "current status",
+			userID: userID,
+			typ:    "Test type",
+			statusFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+				require.Equal(t, "{"+userID.String()+"}-Test type", key)
+				require.Equal(t, int64(5), limit)
+				require.Equal(t, time.Second, tWindow)
+				return &models.RateLimitStatus{State: models.Allowed, Count: 2, Remaining: 3}, nil
+			},
+			expected: &models.RateLimitStatus{State: models.Allowed, Count: 2, Remaining: 3, Window: "Test type/1s"},
+		},
+		{
+			name:        "invalid user",
+			typ:         "Test type",
+			expectedErr: errs.ErrInvalidArguments,
+		},
+		{
+			name:        "invalid notification type",
+			userID:      userID,
+			typ:         "Invalid Test type",
+			expectedErr: errs.ErrInvalidArguments,
+		},
+		{
+			name:   "rate limiter error",
+			userID: userID,
+			typ:    "Test type",
+			statusFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+				return nil, errs.ErrInternalError
+			},
+			expectedErr: errs.ErrInternalError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewService(&RateLimitMock{StatusFn: tt.statusFn}, &GatewayMock{}, conf)
+
+			status, err := s.Status(ctx, tt.userID, "", tt.typ)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, status)
+		})
+	}
+}
+
+func TestService_Send_RefundOnGatewayError(t *testing.T) {
+	conf := configs.LimitConfigMap{
+		"Test type": {
+			Type:      "Test type",
+			Limit:     2,
+			WSizeMs:   1000,
+			OnFailure: configs.FailOpen,
+		},
+	}
+
+	tests := []struct {
+		name           string
+		opts           []Option
+		status         *models.RateLimitStatus
+		refundErr      error
+		expectedRefund bool
+	}{
+		{
+			name:   "no refund by default",
+			status: &models.RateLimitStatus{State: models.Allowed, RequestID: "request"},
+		},
+		{
+			name:           "refunds the request",
+			opts:           []Option{WithRefundOnGatewayError()},
+			status:         &models.RateLimitStatus{State: models.Allowed, RequestID: "request"},
+			expectedRefund: true,
+		},
+		{
+			name:           "refund error",
+			opts:           []Option{WithRefundOnGatewayError()},
+			status:         &models.RateLimitStatus{State: models.Allowed, RequestID: "request"},
+			refundErr:      errors.New("refund failed"),
+			expectedRefund: true,
+		},
+		{
+			name: "nothing to refund after failing open",
+			opts: []Option{WithRefundOnGatewayError()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refunded := false
+			rlimiter := &RateLimitMock{
+				CheckLimitFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+					if tt.status == nil {
+						return nil, errs.ErrInternalError
+					}
+					return tt.status, nil
+				},
+				RefundFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration, requestID string, n int64) error {
+					require.Equal(t, "request", requestID)
+					require.Equal(t, int64(1), n)
+					refunded = true
+					return tt.refundErr
+				},
+			}
+			gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error {
+				return errs.ErrInternalError
+			}}
+
+			s := NewService(rlimiter, gateway, conf, tt.opts...)
+			err := s.Send(context.Background(), &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: "Test type"})
+
+			require.ErrorIs(t, err, errs.ErrInternalError)
+			require.Equal(t, tt.expectedRefund, refunded)
+			if tt.refundErr != nil {
+				require.ErrorContains(t, err, tt.refundErr.Error())
+			}
+		})
+	}
+}
+
+func TestService_Send_SeveralWindows(t *testing.T) {
+	conf := configs.LimitConfigMap{
+		"Test type": {
+			Type: "Test type",
+			Windows: []*configs.WindowConfig{
+				{Limit: 3, WSizeMs: 60000},
+				{Limit: 50, WSizeMs: 86400000},
+			},
+		},
+	}
+	userID := ksuid.New()
+	key := "{" + userID.String() + "}-Test type"
+
+	tests := []struct {
+		name           string
+		statuses       []*models.RateLimitStatus
+		gatewayErr     error
+		expectedErr    error
+		expectedWindow time.Duration
+		expectedRefund []string
+	}{
+		{
+			name: "allowed by every window",
+			statuses: []*models.RateLimitStatus{
+				{State: models.Allowed, Remaining: 2},
+				{State: models.Allowed, Remaining: 49},
+			},
+		},
+		{
+			name: "denied by the daily window",
+			statuses: []*models.RateLimitStatus{
+				{State: models.Allowed, Remaining: 2},
+				{State: models.Denied, Count: 50, RetryAfter: time.Hour},
+			},
+			expectedErr:    &errs.ErrExceededRateLimit{},
+			expectedWindow: 24 * time.Hour,
+		},
+		{
+			name: "refunds every window",
+			statuses: []*models.RateLimitStatus{
+				{State: models.Allowed, RequestID: "minute"},
+				{State: models.Allowed, RequestID: "day"},
+			},
+			gatewayErr:     errs.ErrInternalError,
+			expectedErr:    errs.ErrInternalError,
+			expectedRefund: []string{key + ":60000/minute", key + ":86400000/day"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var refunded []string
+			rlimiter := &RateLimitMock{
+				CheckLimitsFn: func(ctx context.Context, windows []rate_limiter.Window, n int64) ([]*models.RateLimitStatus, error) {
+					require.Equal(t, []rate_limiter.Window{
+						{Key: key + ":60000", Limit: 3, Size: time.Minute},
+						{Key: key + ":86400000", Limit: 50, Size: 24 * time.Hour},
+					}, windows)
+					return tt.statuses, nil
+				},
+				RefundFn: func(ctx context.Context, key string, limit int64, tWindow time.Duration, requestID string, n int64) error {
+					refunded = append(refunded, key+"/"+requestID)
+					return nil
+				},
+			}
+			gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error {
+				return tt.gatewayErr
+			}}
+
+			s := NewService(rlimiter, gateway, conf, WithRefundOnGatewayError())
+			err := s.Send(context.Background(), &models.Notification{Message: "Test message", UserID: userID, Type: "Test type"})
+
+			require.Equal(t, tt.expectedRefund, refunded)
+			if tt.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			var limitErr *errs.ErrExceededRateLimit
+			if errors.As(tt.expectedErr, &limitErr) {
+				require.ErrorAs(t, err, &limitErr)
+				require.Equal(t, tt.expectedWindow, limitErr.Window)
+				require.Equal(t, "Test type/24h0m0s", limitErr.WindowName)
+				require.Equal(t, int64(50), limitErr.Limit)
+				require.Equal(t, time.Hour, limitErr.RetryAfter)
+				require.ErrorContains(t, err, "window=50/24h0m0s")
+				return
+			}
+			require.ErrorIs(t, err, tt.expectedErr)
+			require.ErrorIs(t, err, errs.ErrGateway)
+		})
+	}
+}
+
+func TestService_SendWait_SeveralWindows(t *testing.T) {
+	conf := configs.LimitConfigMap{
+		"Test type": {
+			Type: "Test type",
+			Windows: []*configs.WindowConfig{
+				{Limit: 1, WSizeMs: 100},
+				{Limit: 2, WSizeMs: 60000},
+			},
+		},
+	}
+	rlimiter := rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, rate_limiter.SlidingWindowCounter, nil)
+	gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error { return nil }}
+	s := NewService(rlimiter, gateway, conf)
+	notif := &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: "Test type"}
+
+	require.NoError(t, s.Send(context.Background(), notif))
+
+	// The second notification waits for the short window to free up
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.SendWait(ctx, notif))
+
+	// The long window does not free up before the deadline
+	var limitErr *errs.ErrExceededRateLimit
+	require.ErrorAs(t, s.SendWait(ctx, notif), &limitErr)
+	require.Equal(t, time.Minute, limitErr.Window)
+
+	status, err := s.Status(context.Background(), notif.UserID, "", "Test type")
+	require.NoError(t, err)
+	require.Equal(t, 0, status.Remaining)
+}
+
+func TestService_Send_GlobalLimit(t *testing.T) {
+	conf := configs.LimitConfigMap{
+		"status": {Type: "status", Limit: 2, WSizeMs: 1000},
+		"news":   {Type: "news", Limit: 5, WSizeMs: 1000},
+	}
+	global := &configs.LimitConfig{Limit: 3, WSizeMs: 60000}
+
+	rlimiter := rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, rate_limiter.FixedWindowCounter, nil)
+	gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error { return nil }}
+	s := NewService(rlimiter, gateway, conf, WithGlobalLimit(global))
+
+	userID := ksuid.New()
+	status := &models.Notification{Message: "Test message", UserID: userID, Type: "status"}
+	news := &models.Notification{Message: "Test message", UserID: userID, Type: "news"}
+
+	require.NoError(t, s.Send(context.Background(), status))
+	require.NoError(t, s.Send(context.Background(), status))
+
+	// The status limit is hit before the global one
+	var limitErr *errs.ErrExceededRateLimit
+	require.ErrorAs(t, s.Send(context.Background(), status), &limitErr)
+	require.False(t, limitErr.Global)
+	require.Equal(t, time.Second, limitErr.Window)
+
+	// The news limit has room left, but the global one only fits a single notification more
+	require.NoError(t, s.Send(context.Background(), news))
+	err := s.Send(context.Background(), news)
+	require.ErrorAs(t, err, &limitErr)
+	require.True(t, limitErr.Global)
+	require.Equal(t, time.Minute, limitErr.Window)
+	require.ErrorContains(t, err, "global rate limit exceeded")
+
+	// Another user has a global limit of its own
+	require.NoError(t, s.Send(context.Background(), &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: "news"}))
+
+	current, err := s.Status(context.Background(), userID, "", "news")
+	require.NoError(t, err)
+	require.Equal(t, models.Denied, current.State)
+	require.Zero(t, current.Remaining)
+}
+
+type OverrideFn func(ctx context.Context, userID, tenantID string) (*configs.Override, error)
+
+type OverrideProviderMock struct {
+	OverrideFn OverrideFn
+}
+
+func (o *OverrideProviderMock) Override(ctx context.Context, userID, tenantID string) (*configs.Override, error) {
+	return o.OverrideFn(ctx, userID, tenantID)
+}
+
+func TestService_Send_Overrides(t *testing.T) {
+	conf := configs.LimitConfigMap{
+		"news": {Type: "news", Limit: 2, WSizeMs: 60000},
+	}
+	global := &configs.LimitConfig{Limit: 3, WSizeMs: 60000}
+	static := configs.OverrideList{
+		{TenantID: "enterprise", Limits: []*configs.LimitConfig{{Type: "news", Limit: 4, WSizeMs: 60000}}, Global: &configs.LimitConfig{Limit: 10, WSizeMs: 60000}},
+		{TenantID: "trusted", Exempt: true},
+	}
+	abuser := ksuid.New()
+	database := &OverrideProviderMock{OverrideFn: func(ctx context.Context, userID, tenantID string) (*configs.Override, error) {
+		if userID == abuser.String() {
+			return &configs.Override{UserID: userID, Limits: []*configs.LimitConfig{{Type: "news", Limit: 1, WSizeMs: 60000}}}, nil
+		}
+		return nil, nil
+	}}
+
+	tests := []struct {
+		name         string
+		userID       ksuid.KSUID
+		tenantID     string
+		expectedSent int
+		exempt       bool
+	}{
+		{name: "default limits", userID: ksuid.New(), expectedSent: 2},
+		{name: "unknown tenant", userID: ksuid.New(), tenantID: "startup", expectedSent: 2},
+		{name: "tenant override", userID: ksuid.New(), tenantID: "enterprise", expectedSent: 4},
+		{name: "provider asked first", userID: abuser, tenantID: "enterprise", expectedSent: 1},
+		{name: "exempt tenant", userID: ksuid.New(), tenantID: "trusted", expectedSent: 6, exempt: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rlimiter := rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, rate_limiter.SlidingWindowCounter, nil)
+			gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error { return nil }}
+			s := NewService(rlimiter, gateway, conf, WithGlobalLimit(global), WithOverrideProvider(database), WithOverrideProvider(static))
+
+			notif := &models.Notification{Message: "Test message", UserID: tt.userID, TenantID: tt.tenantID, Type: "news"}
+			sent := 0
+			for i := 0; i < 6; i++ {
+				status, err := s.SendWithStatus(context.Background(), notif)
+				if err == nil {
+					sent++
+					require.Equal(t, tt.exempt, status.Exempt)
+					continue
+				}
+
+				var limitErr *errs.ErrExceededRateLimit
+				require.ErrorAs(t, err, &limitErr)
+			}
+			require.Equal(t, tt.expectedSent, sent)
+
+			status, err := s.Status(context.Background(), tt.userID, tt.tenantID, "news")
+			require.NoError(t, err)
+			require.Equal(t, tt.exempt, status.Exempt)
+			require.Equal(t, models.Allowed == status.State, tt.exempt)
+		})
+	}
+}
+
+func TestService_Send_OverrideProviderError(t *testing.T) {
+	conf := configs.LimitConfigMap{
+		"news": {Type: "news", Limit: 2, WSizeMs: 60000},
+	}
+	provider := &OverrideProviderMock{OverrideFn: func(ctx context.Context, userID, tenantID string) (*configs.Override, error) {
+		return nil, errs.ErrInternalError
+	}}
+	gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error {
+		require.Fail(t, "the notification must not be sent")
+		return nil
+	}}
+
+	s := NewService(&RateLimitMock{}, gateway, conf, WithOverrideProvider(provider))
+	err := s.Send(context.Background(), &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: "news"})
+	require.ErrorIs(t, err, errs.ErrInternalError)
+}
+
+func TestService_Reset(t *testing.T) {
+	userID := ksuid.New()
+	conf := configs.LimitConfigMap{
+		"news": {
+			Type: "news",
+			Windows: []*configs.WindowConfig{
+				{Limit: 3, WSizeMs: 60000},
+				{Limit: 50, WSizeMs: 86400000},
+			},
+		},
+		"status": {Type: "status", Limit: 1, WSizeMs: 1000},
+	}
+
+	var reset []string
+	rlimiter := &RateLimitMock{ResetFn: func(ctx context.Context, key string) error {
+		reset = append(reset, key)
+		return nil
+	}}
+	s := NewService(rlimiter, &GatewayMock{}, conf, WithGlobalLimit(&configs.LimitConfig{Limit: 10, WSizeMs: 60000}))
+
+	require.Equal(t, []string{"news", "status"}, s.Types())
+
+	// Every window of the type is reset, and the global limit is kept
+	require.NoError(t, s.Reset(context.Background(), userID, "", "news"))
+	key := fmt.Sprintf("{%v}-news", userID)
+	require.Equal(t, []string{key + ":60000", key + ":86400000"}, reset)
+	require.Equal(t, fmt.Sprintf("{%v}", userID), KeyPrefix(userID))
+
+	require.ErrorIs(t, s.Reset(context.Background(), userID, "", "alerts"), errs.ErrInvalidArguments)
+	require.ErrorIs(t, s.Reset(context.Background(), ksuid.Nil, "", "news"), errs.ErrInvalidArguments)
+
+	rlimiter.ResetFn = func(ctx context.Context, key string) error {
+		return errs.ErrInternalError
+	}
+	require.ErrorIs(t, s.Reset(context.Background(), userID, "", "status"), errs.ErrInternalError)
+}
+
+func TestService_UpdateLimits(t *testing.T) {
+	conf := configs.LimitConfigMap{"news": {Type: "news", Limit: 1, WSizeMs: 60000}}
+
+	rlimiter := rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, rate_limiter.FixedWindowCounter, nil)
+	gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error { return nil }}
+	s := NewService(rlimiter, gateway, conf)
+
+	notif := &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: "news"}
+	require.NoError(t, s.Send(context.Background(), notif))
+	require.ErrorAs(t, s.Send(context.Background(), notif), new(*errs.ErrExceededRateLimit))
+
+	// The new limit applies to what the user already used
+	s.UpdateLimits(configs.LimitConfigMap{"news": {Type: "news", Limit: 2, WSizeMs: 60000}}, nil)
+	require.NoError(t, s.Send(context.Background(), notif))
+	require.ErrorAs(t, s.Send(context.Background(), notif), new(*errs.ErrExceededRateLimit))
+
+	// The limits replace the previous ones altogether
+	s.UpdateLimits(configs.LimitConfigMap{"status": {Type: "status", Limit: 2, WSizeMs: 60000}}, &configs.LimitConfig{Limit: 1, WSizeMs: 60000})
+	require.ErrorIs(t, s.Send(context.Background(), notif), errs.ErrInvalidArguments)
+
+	status := &models.Notification{Message: "Test message", UserID: notif.UserID, Type: "status"}
+	require.NoError(t, s.Send(context.Background(), status))
+
+	var limitErr *errs.ErrExceededRateLimit
+	require.ErrorAs(t, s.Send(context.Background(), status), &limitErr)
+	require.True(t, limitErr.Global)
+}
+
+func TestService_WithConfigWatcher(t *testing.T) {
+	vipID := ksuid.New()
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(limit int) {
+		content := fmt.Sprintf(`{
+			"redis": {"host": "localhost", "port": 6379},
+			"rate_limit": {
+				"type": "fixed_window",
+				"limits": [{"type": "news", "limit": %v, "window_size_ms": 60000}],
+				"overrides": [{"user_id": "%v", "exempt": true}]
+			}
+		}`, limit, vipID)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+	write(1)
+
+	w, err := configs.NewWatcher(path, time.Hour)
+	require.NoError(t, err)
+	defer w.Close()
+
+	rlimiter := rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, rate_limiter.FixedWindowCounter, nil)
+	gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error { return nil }}
+	s := NewService(rlimiter, gateway, nil, WithConfigWatcher(w))
+
+	notif := &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: "news"}
+	require.NoError(t, s.Send(context.Background(), notif))
+	require.ErrorAs(t, s.Send(context.Background(), notif), new(*errs.ErrExceededRateLimit))
+
+	// The overrides of the configuration apply
+	status, err := s.SendWithStatus(context.Background(), &models.Notification{Message: "Test message", UserID: vipID, Type: "news"})
+	require.NoError(t, err)
+	require.True(t, status.Exempt)
+
+	// A valid change swaps the limits
+	write(2)
+	require.NoError(t, w.Reload())
+	require.NoError(t, s.Send(context.Background(), notif))
+
+	// An invalid one keeps the last good limits
+	write(0)
+	require.Error(t, w.Reload())
+	require.ErrorAs(t, s.Send(context.Background(), notif), new(*errs.ErrExceededRateLimit))
+}
+
+// RecorderMock is a metrics.Recorder that keeps the measurements of the Service.
+type RecorderMock struct {
+	metrics.Nop
+	decisions []string
+	sends     []string
+	limits    []configs.LimitConfigMap
+}
+
+func (r *RecorderMock) Decision(typ, algorithm string, state models.State) {
+	r.decisions = append(r.decisions, fmt.Sprintf("%v/%v/%v", typ, algorithm, state))
+}
+
+func (r *RecorderMock) GatewaySend(typ string, err error) {
+	r.sends = append(r.sends, fmt.Sprintf("%v/%v", typ, err == nil))
+}
+
+func (r *RecorderMock) Limits(lconfigs configs.LimitConfigMap, _ *configs.LimitConfig) {
+	r.limits = append(r.limits, lconfigs)
+}
+
+func TestService_WithMetrics(t *testing.T) {
+	conf := configs.LimitConfigMap{"news": {Type: "news", Limit: 1, WSizeMs: 60000}}
+
+	rlimiter := rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, rate_limiter.FixedWindowCounter, nil)
+	failing := false
+	gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error {
+		if failing {
+			return errors.New("gateway down")
+		}
+		return nil
+	}}
+	rec := &RecorderMock{}
+	vip := ksuid.New()
+	s := NewService(rlimiter, gateway, conf, WithMetrics(rec, rate_limiter.FixedWindowCounter),
+		WithOverrideProvider(configs.OverrideList{{UserID: vip.String(), Exempt: true}}))
+
+	require.Equal(t, []configs.LimitConfigMap{conf}, rec.limits)
+
+	notif := &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: "news"}
+	require.NoError(t, s.Send(context.Background(), notif))
+	require.ErrorAs(t, s.Send(context.Background(), notif), new(*errs.ErrExceededRateLimit))
+
+	// The notifications of exempt users are recorded with their own decision
+	require.NoError(t, s.Send(context.Background(), &models.Notification{Message: "Test message", UserID: vip, Type: "news"}))
+
+	// A failed gateway send is recorded as such
+	failing = true
+	require.ErrorIs(t, s.Send(context.Background(), &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: "news"}), errs.ErrGateway)
+
+	require.Equal(t, []string{"news/fixed_window/allowed", "news/fixed_window/denied", "news/fixed_window/exempt", "news/fixed_window/allowed"}, rec.decisions)
+	require.Equal(t, []string{"news/true", "news/true", "news/false"}, rec.sends)
+
+	// The new limits are recorded as they change
+	updated := configs.LimitConfigMap{"status": {Type: "status", Limit: 2, WSizeMs: 60000}}
+	s.UpdateLimits(updated, nil)
+	require.Equal(t, []configs.LimitConfigMap{conf, updated}, rec.limits)
+}
+
+func TestService_WithTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+
+	conf := configs.LimitConfigMap{"news": {Type: "news", Limit: 1, WSizeMs: 60000}}
+	memory := rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, rate_limiter.SlidingWindowCounter, nil)
+	rlimiter := tracing.RateLimiter(memory, rate_limiter.SlidingWindowCounter, tp)
+
+	gatewayErr := errors.New("gateway down")
+	failing := false
+	gateway := &GatewayMock{SendFn: func(ctx context.Context, userID string, message string) error {
+		if failing {
+			return gatewayErr
+		}
+		return nil
+	}}
+	s := NewService(rlimiter, gateway, conf, WithTracing(tp, rate_limiter.SlidingWindowCounter))
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	notif := &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: "news"}
+	require.NoError(t, s.Send(ctx, notif))
+	require.ErrorAs(t, s.Send(ctx, notif), new(*errs.ErrExceededRateLimit))
+	failing = true
+	require.ErrorIs(t, s.Send(ctx, &models.Notification{Message: "Test message", UserID: ksuid.New(), Type: "news"}), gatewayErr)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	// the child spans end before their parent
+	require.Equal(t, []string{
+		"RateLimiter.CheckLimitN", "Gateway.Send", "Service.Send",
+		"RateLimiter.CheckLimitN", "Service.Send",
+		"RateLimiter.CheckLimitN", "Gateway.Send", "Service.Send",
+		"request",
+	}, names)
+
+	attrs := func(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+		m := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes {
+			m[kv.Key] = kv.Value
+		}
+		return m
+	}
+
+	// the trace context propagates from the request to the rate limiter and the gateway
+	sent, check, gw := spans[2], spans[0], spans[1]
+	require.Equal(t, parent.SpanContext().SpanID(), sent.Parent.SpanID())
+	require.Equal(t, sent.SpanContext.SpanID(), check.Parent.SpanID())
+	require.Equal(t, sent.SpanContext.SpanID(), gw.Parent.SpanID())
+	for _, span := range spans[:8] {
+		require.Equal(t, parent.SpanContext().TraceID(), span.SpanContext.TraceID())
+	}
+
+	sentAttrs := attrs(sent)
+	require.Equal(t, "news", sentAttrs[tracing.TypeKey].AsString())
+	require.Equal(t, "sliding_window", sentAttrs[tracing.AlgorithmKey].AsString())
+	require.Equal(t, "allowed", sentAttrs[tracing.DecisionKey].AsString())
+	require.EqualValues(t, 1, sentAttrs[tracing.CountKey].AsInt64())
+	require.EqualValues(t, 1, sentAttrs[tracing.LimitKey].AsInt64())
+	require.Equal(t, "news/1m0s", sentAttrs[tracing.WindowKey].AsString())
+	require.Equal(t, "sliding_window", attrs(check)[tracing.AlgorithmKey].AsString())
+	require.Equal(t, "news", attrs(gw)[tracing.TypeKey].AsString())
+
+	// a rate limited notification is not a failure, while a gateway error is
+	denied := spans[4]
+	require.Equal(t, "denied", attrs(denied)[tracing.DecisionKey].AsString())
+	require.Equal(t, codes.Unset, denied.Status.Code)
+
+	require.Equal(t, codes.Error, spans[6].Status.Code)
+	require.Equal(t, codes.Error, spans[7].Status.Code)
+	require.Equal(t, "allowed", attrs(spans[7])[tracing.DecisionKey].AsString())
+}
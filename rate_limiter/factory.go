@@ -1,12 +1,19 @@
 // Package rate_limiter provides a rate limiter implementation for controlling the rate of requests.
-// It includes two types of rate limiters: FixedWindowCounter and SlidingWindowCounter.
-// The Get function returns the appropriate rate limiter based on the provided type.
+// It includes four types of rate limiters: FixedWindowCounter, SlidingWindowCounter, TokenBucket and GCRA.
+// Each of them is available on two backends: RedisBackend, meant for distributed environments,
+// and MemoryBackend, which keeps the limits within the current process.
+// The redis backend works with any redis.UniversalClient, either a single node, a sentinel failover or a cluster client,
+// and it stores every key within a cluster hash tag, so the keys used by one check always land on the same slot.
+// The New function returns the appropriate rate limiter based on the provided backend and type, failing for unknown
+// ones, while Get and GetWithBackend fall back to the defaults.
 package rate_limiter
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"github.com/godoylucase/rate-limit/errs"
 	"github.com/godoylucase/rate-limit/models"
 
 	"github.com/go-redis/redis/v8"
@@ -15,21 +22,121 @@ import (
 const (
 	FixedWindowCounter   = "fixed_window"
 	SlidingWindowCounter = "sliding_window"
+	TokenBucket          = "token_bucket"
+	GCRA                 = "gcra"
+)
+
+const (
+	RedisBackend  = "redis"
+	MemoryBackend = "memory"
 )
 
 // RateLimiter is an interface that defines the methods for checking the rate limit.
+// CheckLimit answers right away whether the request is allowed, while Reserve and Wait let the caller
+// take the first slot available before the context deadline.
+// Every request costs a single unit of the limit, except for the N variants, which charge n units at once,
+// either all of them or none. A request that costs more than the limit is always denied.
+// CheckLimitsN checks a request against several windows at once, such as 3 per minute and 50 per day,
+// and only charges it to all of them when it fits within every one, so a denied request uses none of them.
+// Status peeks at the current status of the rate limit without changing it.
+// Reset clears the rate limit of a key, and Refund gives back n units of a request that was allowed but did not
+// happen, identified by the RequestID of its status, which the fixed and sliding window counters require:
+// the fixed window only refunds a request while its window lasts, and the sliding window removes its exact units.
+// Keys lists the keys starting with a prefix, such as the hash tag of a user, which is meant for inspecting them.
 type RateLimiter interface {
 	CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error)
+	CheckLimitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error)
+	CheckLimitsN(ctx context.Context, windows []Window, n int64) ([]*models.RateLimitStatus, error)
+	Reserve(ctx context.Context, key string, limit int64, tWindow time.Duration) (*Reservation, error)
+	ReserveN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*Reservation, error)
+	Wait(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error)
+	WaitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error)
+	Status(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error)
+	Reset(ctx context.Context, key string) error
+	Refund(ctx context.Context, key string, limit int64, tWindow time.Duration, requestID string, n int64) error
+	Keys(ctx context.Context, prefix string) ([]string, error)
 }
 
-// Get returns the appropriate rate limiter based on the provided type.
-func Get(typ string, redis *redis.Client) RateLimiter {
+// Types lists the rate limiter types, in the order they are documented.
+var Types = []string{SlidingWindowCounter, FixedWindowCounter, TokenBucket, GCRA}
+
+// IsValidType tells whether typ is one of the rate limiter types.
+func IsValidType(typ string) bool {
+	switch typ {
+	case FixedWindowCounter, SlidingWindowCounter, TokenBucket, GCRA:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsValidBackend tells whether backend is one of the rate limiter backends, an empty one meaning RedisBackend.
+func IsValidBackend(backend string) bool {
+	switch backend {
+	case "", RedisBackend, MemoryBackend:
+		return true
+	default:
+		return false
+	}
+}
+
+// Get returns the appropriate redis backed rate limiter based on the provided type.
+// An unknown type falls back to the sliding window counter, so callers taking the type from
+// the user input should use New instead.
+func Get(typ string, redis redis.UniversalClient) RateLimiter {
 	switch typ {
 	case FixedWindowCounter:
 		return newFixedWindowCounter(redis)
 	case SlidingWindowCounter:
 		return newSlidingWindowCounter(redis)
+	case TokenBucket:
+		return newTokenBucket(redis)
+	case GCRA:
+		return newGCRA(redis)
 	default:
 		return newSlidingWindowCounter(redis)
 	}
 }
+
+// New returns the appropriate rate limiter based on the provided backend and type, the same way GetWithBackend does.
+// Unlike GetWithBackend, it fails with errs.ErrInvalidArguments for an unknown type or backend, and for the redis
+// backend without a redis client, so it is the one to use with types and backends taken from the user input.
+func New(backend, typ string, redis redis.UniversalClient) (RateLimiter, error) {
+	if !IsValidType(typ) {
+		return nil, fmt.Errorf("unknown rate limiter type %q, must be one of %v: %w", typ, Types, errs.ErrInvalidArguments)
+	}
+	if !IsValidBackend(backend) {
+		return nil, fmt.Errorf("unknown rate limiter backend %q, must be one of %v: %w", backend, []string{RedisBackend, MemoryBackend}, errs.ErrInvalidArguments)
+	}
+	if backend != MemoryBackend && redis == nil {
+		return nil, fmt.Errorf("the %v backend needs a redis client: %w", RedisBackend, errs.ErrInvalidArguments)
+	}
+
+	return GetWithBackend(backend, typ, redis), nil
+}
+
+// GetWithBackend returns the appropriate rate limiter based on the provided backend and type.
+// An empty backend defaults to RedisBackend, and so does any backend other than MemoryBackend, while an unknown type
+// falls back to the sliding window counter, the same way Get does. Use New to reject them instead.
+// The MemoryBackend does not use the redis client, so it can be nil,
+// and the returned rate limiter implements io.Closer to stop the background eviction of its expired keys.
+func GetWithBackend(backend, typ string, redis redis.UniversalClient) RateLimiter {
+	if backend != MemoryBackend {
+		return Get(typ, redis)
+	}
+
+	store := newMemoryStore(memoryCleanupInterval)
+
+	switch typ {
+	case FixedWindowCounter:
+		return newMemoryFixedWindowCounter(store)
+	case SlidingWindowCounter:
+		return newMemorySlidingWindowCounter(store)
+	case TokenBucket:
+		return newMemoryTokenBucket(store)
+	case GCRA:
+		return newMemoryGCRA(store)
+	default:
+		return newMemorySlidingWindowCounter(store)
+	}
+}
@@ -1,10 +1,17 @@
 package rate_limiter
 
 import (
+	"context"
+	"io"
 	"testing"
+	"time"
+
+	"github.com/godoylucase/rate-limit/errs"
+	"github.com/godoylucase/rate-limit/models"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGet(t *testing.T) {
@@ -29,6 +36,16 @@ func TestGet(t *testing.T) {
 			typ:  SlidingWindowCounter,
 			want: newSlidingWindowCounter(redisClient),
 		},
+		{
+			name: "Token Bucket",
+			typ:  TokenBucket,
+			want: newTokenBucket(redisClient),
+		},
+		{
+			name: "GCRA",
+			typ:  GCRA,
+			want: newGCRA(redisClient),
+		},
 		{
 			name: "Default",
 			typ:  "Default",
@@ -43,3 +60,153 @@ func TestGet(t *testing.T) {
 		})
 	}
 }
+
+func TestGetWithBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		typ     string
+		want    RateLimiter
+	}{
+		{
+			name:    "Redis Fixed Window Counter",
+			backend: RedisBackend,
+			typ:     FixedWindowCounter,
+			want:    &fixedWindowCounter{},
+		},
+		{
+			name:    "Default Backend",
+			backend: "",
+			typ:     TokenBucket,
+			want:    &tokenBucket{},
+		},
+		{
+			name:    "Memory Fixed Window Counter",
+			backend: MemoryBackend,
+			typ:     FixedWindowCounter,
+			want:    &memoryFixedWindowCounter{},
+		},
+		{
+			name:    "Memory Sliding Window Counter",
+			backend: MemoryBackend,
+			typ:     SlidingWindowCounter,
+			want:    &memorySlidingWindowCounter{},
+		},
+		{
+			name:    "Memory Token Bucket",
+			backend: MemoryBackend,
+			typ:     TokenBucket,
+			want:    &memoryTokenBucket{},
+		},
+		{
+			name:    "Memory GCRA",
+			backend: MemoryBackend,
+			typ:     GCRA,
+			want:    &memoryGCRA{},
+		},
+		{
+			name:    "Memory Default",
+			backend: MemoryBackend,
+			typ:     "Default",
+			want:    &memorySlidingWindowCounter{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := GetWithBackend(tt.backend, tt.typ, nil)
+			assert.IsType(t, tt.want, got)
+
+			if closer, ok := got.(io.Closer); ok {
+				assert.NoError(t, closer.Close())
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	redisClient := newTestRedisClient(t)
+
+	got, err := New(RedisBackend, GCRA, redisClient)
+	require.NoError(t, err)
+	assert.IsType(t, &gcra{}, got)
+
+	got, err = New("", FixedWindowCounter, redisClient)
+	require.NoError(t, err)
+	assert.IsType(t, &fixedWindowCounter{}, got)
+
+	got, err = New(MemoryBackend, TokenBucket, nil)
+	require.NoError(t, err)
+	assert.IsType(t, &memoryTokenBucket{}, got)
+	assert.NoError(t, got.(io.Closer).Close())
+
+	// Unknown types and backends are rejected instead of falling back to the defaults
+	_, err = New(MemoryBackend, "leaky_bucket", nil)
+	assert.ErrorIs(t, err, errs.ErrInvalidArguments)
+	assert.ErrorContains(t, err, `unknown rate limiter type "leaky_bucket"`)
+
+	_, err = New("memroy", SlidingWindowCounter, redisClient)
+	assert.ErrorIs(t, err, errs.ErrInvalidArguments)
+	assert.ErrorContains(t, err, `unknown rate limiter backend "memroy"`)
+
+	_, err = New(RedisBackend, SlidingWindowCounter, nil)
+	assert.ErrorIs(t, err, errs.ErrInvalidArguments)
+}
+
+func TestIsValidType(t *testing.T) {
+	for _, typ := range Types {
+		assert.True(t, IsValidType(typ), typ)
+	}
+	assert.False(t, IsValidType(""))
+	assert.False(t, IsValidType("leaky_bucket"))
+
+	assert.True(t, IsValidBackend(""))
+	assert.True(t, IsValidBackend(RedisBackend))
+	assert.True(t, IsValidBackend(MemoryBackend))
+	assert.False(t, IsValidBackend("postgres"))
+}
+
+func TestGetWithBackend_SubMillisecondWindow(t *testing.T) {
+	redisClient := newTestRedisClient(t)
+
+	for _, backend := range []string{RedisBackend, MemoryBackend} {
+		for _, typ := range Types {
+			t.Run(backend+"/"+typ, func(t *testing.T) {
+				ctx := context.Background()
+				limiter := GetWithBackend(backend, typ, redisClient)
+				if closer, ok := limiter.(io.Closer); ok {
+					t.Cleanup(func() {
+						assert.NoError(t, closer.Close())
+					})
+				}
+
+				// A window shorter than a millisecond never lets a request through, instead of never limiting it
+				key := testKey()
+				for i := 0; i < 3; i++ {
+					status, err := limiter.CheckLimit(ctx, key, 5, 500*time.Microsecond)
+					require.NoError(t, err)
+					assert.Equal(t, models.Denied, status.State)
+				}
+
+				// Peeking and reserving agree with the checks
+				status, err := limiter.Status(ctx, key, 5, 500*time.Microsecond)
+				require.NoError(t, err)
+				assert.Equal(t, models.Denied, status.State)
+
+				reservation, err := limiter.Reserve(ctx, key, 5, 500*time.Microsecond)
+				require.NoError(t, err)
+				assert.False(t, reservation.OK)
+				assert.Equal(t, models.Denied, reservation.Status.State)
+
+				statuses, err := limiter.CheckLimitsN(ctx, []Window{
+					{Key: key + ":0", Limit: 5, Size: 500 * time.Microsecond},
+					{Key: key + ":60000", Limit: 5, Size: time.Minute},
+				}, 1)
+				require.NoError(t, err)
+				assert.False(t, Allowed(statuses))
+				assert.Equal(t, models.Denied, statuses[0].State)
+				assert.Equal(t, models.Allowed, statuses[1].State)
+			})
+		}
+	}
+}
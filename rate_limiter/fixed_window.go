@@ -2,105 +2,486 @@ package rate_limiter
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/godoylucase/rate-limit/errs"
 	"github.com/godoylucase/rate-limit/models"
 
 	"github.com/go-redis/redis/v8"
 )
 
-const (
-	keyThatDoesNotExist = -2
-	keyWithoutExpire    = -1
-)
+// fixedWindowScript counts the n units of the current request in the window stored at KEYS[1].
+// The window is kept as a hash holding its start timestamp and the count of units, and it starts with
+// the first request. Requests that do not fit within the current window may be reserved in the following ones
+// when the maximum delay allows it, in which case the count carries them over once the current window ends.
+// A request is never split across windows, so the units left in the windows it skips are given up
+// until the request is refunded.
+//
+// ARGV[1] is the limit, ARGV[2] the window in milliseconds, ARGV[3] the current timestamp in milliseconds,
+// ARGV[4] the maximum delay in milliseconds, -1 meaning unbounded, and ARGV[5] the cost n of the request.
+// It returns whether the request was counted, the count of units within the window of the request,
+// the timestamp when that window expires, the milliseconds until it starts and the number of units given up.
+var fixedWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local maxDelay = tonumber(ARGV[4])
+local n = tonumber(ARGV[5])
+
+local start = tonumber(redis.call('HGET', KEYS[1], 'start'))
+local count = tonumber(redis.call('HGET', KEYS[1], 'count'))
+if start == nil or count == nil then
+	start = now
+	count = 0
+end
+
+-- A request never fits within a window shorter than a millisecond
+if limit < 1 or window < 1 then
+	return {0, 0, now + window, 0, 0}
+end
+
+-- Move to the current window, carrying over the requests reserved for it
+if now >= start + window then
+	local passed = math.floor((now - start) / window)
+	count = math.max(0, count - passed * limit)
+	if count == 0 then
+		start = now
+	else
+		start = start + passed * window
+	end
+end
+
+if n > limit then
+	return {0, math.min(count, limit), start + window, 0, 0}
+end
+
+local slot = math.floor((count + n - 1) / limit)
+local delay = math.max(0, start + slot * window - now)
+if maxDelay >= 0 and delay > maxDelay then
+	return {0, math.min(count, limit), start + window, delay, 0}
+end
+
+local skipped = math.max(0, slot * limit - count)
+count = count + skipped + n
+redis.call('HSET', KEYS[1], 'start', start, 'count', count)
+redis.call('PEXPIRE', KEYS[1], start + (slot + 1) * window - now)
+
+return {1, count - slot * limit, start + (slot + 1) * window, delay, skipped}
+`)
+
+// fixedWindowRefundScript gives back n units to the window stored at KEYS[1], when the request they were charged to
+// was counted in its current window or in one of the following ones. A refund that comes after the window of the request
+// ended does nothing, since the units of the windows that followed were never charged to it.
+//
+// ARGV[1] is the limit, ARGV[2] the window in milliseconds, ARGV[3] the current timestamp in milliseconds,
+// ARGV[4] the start of the window the request was counted in and ARGV[5] the number of units to give back.
+var fixedWindowRefundScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local requestStart = tonumber(ARGV[4])
+local n = tonumber(ARGV[5])
+
+local start = tonumber(redis.call('HGET', KEYS[1], 'start'))
+local count = tonumber(redis.call('HGET', KEYS[1], 'count'))
+if start == nil or count == nil then
+	return 0
+end
+
+-- A window no request fits within holds nothing to give back
+if limit < 1 or window < 1 then
+	return 0
+end
+
+-- Move to the current window, carrying over the requests reserved for it
+if now >= start + window then
+	local passed = math.floor((now - start) / window)
+	count = math.max(0, count - passed * limit)
+	start = start + passed * window
+end
+
+if count == 0 or requestStart < start then
+	return 0
+end
+
+redis.call('HSET', KEYS[1], 'start', start, 'count', math.max(0, count - n))
+return 1
+`)
+
+// fixedWindowsScript counts the n units of the current request in every window stored at KEYS,
+// only when they fit within the current window of all of them, so either every window counts the request or none does.
+// Each window is kept the same way fixedWindowScript does, and requests are never reserved in the following windows.
+//
+// ARGV[1] is the current timestamp in milliseconds and ARGV[2] the cost n of the request, followed by the limit
+// and the window in milliseconds of each key.
+// It returns whether the request was counted, followed by whether it fits, the start and the count of units
+// of each window, which include the request only when it was counted.
+var fixedWindowsScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+
+local windows = {}
+local allowed = 1
+for i, key in ipairs(KEYS) do
+	local limit = tonumber(ARGV[2 * i + 1])
+	local window = tonumber(ARGV[2 * i + 2])
+
+	local start = tonumber(redis.call('HGET', key, 'start'))
+	local count = tonumber(redis.call('HGET', key, 'count'))
+	if start == nil or count == nil then
+		start = now
+		count = 0
+	end
+
+	local valid = limit >= 1 and window >= 1
+	if valid and now >= start + window then
+		local passed = math.floor((now - start) / window)
+		count = math.max(0, count - passed * limit)
+		if count == 0 then
+			start = now
+		else
+			start = start + passed * window
+		end
+	end
+
+	local fits = 0
+	if valid and count + n <= limit then
+		fits = 1
+	else
+		allowed = 0
+	end
+	windows[i] = {fits, start, count, window}
+end
+
+local result = {allowed}
+for i, key in ipairs(KEYS) do
+	local w = windows[i]
+	if allowed == 1 then
+		w[3] = w[3] + n
+		redis.call('HSET', key, 'start', w[2], 'count', w[3])
+		redis.call('PEXPIRE', key, w[2] + w[4] - now)
+	end
+	table.insert(result, w[1])
+	table.insert(result, w[2])
+	table.insert(result, w[3])
+end
+
+return result
+`)
 
 type fixedWindowCounter struct {
-	redis *redis.Client
+	redis redis.UniversalClient
 }
 
-func newFixedWindowCounter(redis *redis.Client) *fixedWindowCounter {
+func newFixedWindowCounter(redis redis.UniversalClient) *fixedWindowCounter {
 	return &fixedWindowCounter{
 		redis: redis,
 	}
 }
 
 // CheckLimit checks the rate limit for a given key within a fixed window.
-// It increments the counter for the current window, retrieves the current counter value,
-// sets the expiration for the window key, and checks against the limit.
-// If the total count is less than or equal to the limit, it returns a RateLimitStatus with State Allowed.
-// If the total count exceeds the limit, it returns a RateLimitStatus with State Denied.
+// It increments the counter for the current window unless it already reached the limit,
+// starting a new window, with its own expiration, on the first request after the previous one expired.
+// If the request fits within the limit, it returns a RateLimitStatus with State Allowed.
+// If the limit was already reached, it returns a RateLimitStatus with State Denied.
 // The RateLimitStatus also includes the count, which is the current counter value,
 // and the expiresAtMs, which is the timestamp when the window expires in milliseconds.
+// The RetryAfter of a Denied request tells how long it has to wait for the window it fits within to start,
+// and the RequestID of an Allowed one tells the window it was counted in, so it can be refunded.
+// The check and the increment are performed atomically by a server side script.
 // It returns the RateLimitStatus and any error encountered during the process.
 func (fwc *fixedWindowCounter) CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
-	pipe := fwc.redis.TxPipeline()
+	return fwc.CheckLimitN(ctx, key, limit, tWindow, 1)
+}
 
-	// Get the current timestamp
-	now := time.Now()
-	expiresAt := now.Add(tWindow).UnixMilli()
+// CheckLimitN works like CheckLimit for a request that costs n units, which are counted all at once
+// only when all of them fit within the current window.
+func (fwc *fixedWindowCounter) CheckLimitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	reservation, err := fwc.reserve(ctx, key, limit, tWindow, n, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation.Status, nil
+}
 
-	// Check the current counter value
-	pipe.Get(ctx, key)
+// Reserve reserves a request within the first window that has room for it, as long as that window
+// starts before the context deadline. The returned reservation tells how long to wait for that window to start.
+func (fwc *fixedWindowCounter) Reserve(ctx context.Context, key string, limit int64, tWindow time.Duration) (*Reservation, error) {
+	return fwc.ReserveN(ctx, key, limit, tWindow, 1)
+}
+
+// ReserveN works like Reserve for a request that costs n units, all of them within the same window.
+func (fwc *fixedWindowCounter) ReserveN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*Reservation, error) {
+	return fwc.reserve(ctx, key, limit, tWindow, n, maxDelay(ctx))
+}
 
-	// Execute the pipeline
-	results, err := pipe.Exec(ctx)
+// Wait blocks until the request fits within a window, or returns a Denied status right away
+// when that does not happen before the context deadline.
+func (fwc *fixedWindowCounter) Wait(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return fwc.WaitN(ctx, key, limit, tWindow, 1)
+}
+
+// WaitN works like Wait for a request that costs n units.
+func (fwc *fixedWindowCounter) WaitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	reservation, err := fwc.ReserveN(ctx, key, limit, tWindow, n)
 	if err != nil {
-		if !errors.Is(err, redis.Nil) {
-			return nil, fmt.Errorf("failed to execute pipeline: %v", err)
-		}
+		return nil, err
+	}
 
-		// If the key does not exist, we create it and set the expiration
-		pipe.Set(ctx, key, 1, tWindow)
-		_, err := pipe.Exec(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("failed to execute pipeline: %v", err)
-		}
+	return wait(ctx, reservation)
+}
 
-		if limit >= 1 {
-			return &models.RateLimitStatus{
-				State:       models.Allowed,
-				Count:       1,
-				ExpiresAtMs: expiresAt,
-			}, nil
+// Status returns the current status of the window for a given key without counting a request.
+// The State tells whether a request would be allowed right now, and the count, the remaining units,
+// the expiresAtMs and the RetryAfter have the same meaning as in CheckLimit.
+func (fwc *fixedWindowCounter) Status(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	now := time.Now()
+	key = hashTagged(key)
+
+	values, err := fwc.redis.HMGet(ctx, key, "start", "count").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fixed window status for key: %v with error: %w", key, err)
+	}
+
+	start, count := now.UnixMilli(), int64(0)
+	if values[0] != nil && values[1] != nil {
+		if start, err = parseInt(values[0]); err != nil {
+			return nil, fmt.Errorf("failed to parse fixed window start for key: %v with error: %w", key, err)
+		}
+		if count, err = parseInt(values[1]); err != nil {
+			return nil, fmt.Errorf("failed to parse fixed window count for key: %v with error: %w", key, err)
 		}
 	}
 
-	// Increment the counter for the current window
-	pipe.Incr(ctx, key)
+	return fixedWindowStatus(start, count, now.UnixMilli(), limit, tWindow), nil
+}
+
+// Keys returns the keys starting with prefix, such as the hash tag of a user, sorted.
+// The keys are returned as they are stored, so they can be given back to Reset.
+func (fwc *fixedWindowCounter) Keys(ctx context.Context, prefix string) ([]string, error) {
+	return scanKeys(ctx, fwc.redis, prefix)
+}
+
+// Reset clears the window of a given key, so the next request starts a new one.
+func (fwc *fixedWindowCounter) Reset(ctx context.Context, key string) error {
+	key = hashTagged(key)
+
+	if err := fwc.redis.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to reset fixed window for key: %v with error: %w", key, err)
+	}
 
-	// Get the current counter value
-	pipe.Get(ctx, key)
+	return nil
+}
 
-	// Set expiration for the window key
-	//pipe.Expire(ctx, key, tWindow)
+// Refund gives back n units of the request identified by requestID to the window of a given key, which is
+// the RequestID of its status, telling the window the request was counted in. A refund that comes after that window
+// ended does nothing, since the units of the current window were never charged to the request.
+func (fwc *fixedWindowCounter) Refund(ctx context.Context, key string, limit int64, tWindow time.Duration, requestID string, n int64) error {
+	if err := checkCost(n); err != nil {
+		return err
+	}
 
-	// Execute the pipeline
-	results, err = pipe.Exec(ctx)
+	requestStart, err := parseFixedWindowRequestID(requestID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute pipeline: %v", err)
+		return err
 	}
 
-	// Retrieve the count from the result
-	countResult := results[1].(*redis.StringCmd)
-	total, err := countResult.Int64()
+	key = hashTagged(key)
+
+	err = fixedWindowRefundScript.Run(ctx, fwc.redis, []string{key}, limit, tWindow.Milliseconds(), time.Now().UnixMilli(), requestStart, n).Err()
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve counter value: %v", err)
+		return fmt.Errorf("failed to refund fixed window for key: %v with error: %w", key, err)
 	}
 
-	// Check against the limit
-	if total <= limit {
-		return &models.RateLimitStatus{
+	return nil
+}
+
+// CheckLimitsN checks a request that costs n units against the current window of every given window at once,
+// counting it in all of them only when it fits within each one. It returns the status of every window,
+// in the same order, whose State tells whether the request fits within it, and the count includes the request
+// only when every window allowed it, in which case the RequestID of every status tells the window the request
+// was counted in. The whole check is performed atomically by a server side script.
+func (fwc *fixedWindowCounter) CheckLimitsN(ctx context.Context, windows []Window, n int64) ([]*models.RateLimitStatus, error) {
+	if err := checkWindows(windows, n); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	keys, args := windowsArgs(windows, now.UnixMilli(), n)
+
+	result, err := fixedWindowsScript.Run(ctx, fwc.redis, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run fixed windows script for keys: %v with error: %w", keys, err)
+	}
+
+	if len(result) != 1+3*len(windows) {
+		return nil, fmt.Errorf("unexpected fixed windows result for keys: %v with length: %v", keys, len(result))
+	}
+
+	allowed := result[0] == 1
+	statuses := make([]*models.RateLimitStatus, 0, len(windows))
+	for i, window := range windows {
+		fits, start, count := result[1+3*i] == 1, result[2+3*i], result[3+3*i]
+
+		status := fixedWindowStatus(start, count, now.UnixMilli(), window.Limit, window.Size)
+		checkedWindow(status, fits, fixedWindowRetry(start, count, now.UnixMilli(), window.Limit, window.Size, n))
+		if allowed {
+			status.RequestID = fixedWindowRequestID(start)
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func (fwc *fixedWindowCounter) reserve(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64, maxDelay time.Duration) (*Reservation, error) {
+	if err := checkCost(n); err != nil {
+		return nil, err
+	}
+
+	// Get the current timestamp
+	now := time.Now()
+	key = hashTagged(key)
+
+	result, err := fixedWindowScript.Run(ctx, fwc.redis, []string{key}, limit, tWindow.Milliseconds(), now.UnixMilli(), delayMs(maxDelay), n).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run fixed window script for key: %v with error: %w", key, err)
+	}
+
+	if len(result) != 5 {
+		return nil, fmt.Errorf("unexpected fixed window result for key: %v with length: %v", key, len(result))
+	}
+
+	allowed, total, expiresAt, delay, skipped := result[0] == 1, result[1], result[2], time.Duration(result[3])*time.Millisecond, result[4]
+
+	if !allowed {
+		return &Reservation{
+			OK:    false,
+			Delay: delay,
+			Status: &models.RateLimitStatus{
+				State:       models.Denied,
+				Count:       int(total),
+				Limit:       limit,
+				Remaining:   remaining(limit, int(total)),
+				RetryAfter:  delay,
+				ExpiresAtMs: expiresAt,
+			},
+		}, nil
+	}
+
+	// The request was counted in the window that expires at expiresAt
+	requestID := fixedWindowRequestID(expiresAt - tWindow.Milliseconds())
+
+	return &Reservation{
+		OK:    true,
+		Delay: delay,
+		Status: &models.RateLimitStatus{
 			State:       models.Allowed,
 			Count:       int(total),
+			Limit:       limit,
+			Remaining:   remaining(limit, int(total)),
 			ExpiresAtMs: expiresAt,
-		}, nil
+			RequestID:   requestID,
+		},
+		cancel: func(ctx context.Context) error {
+			return fwc.Refund(ctx, key, limit, tWindow, requestID, n+skipped)
+		},
+	}, nil
+}
+
+// fixedWindowStatus returns the status as of nowMs of a window that started at start with count units,
+// carrying over the units reserved for the current window the same way fixedWindowScript does.
+// A request never fits within a window shorter than a millisecond, which is always Denied.
+func fixedWindowStatus(start, count, nowMs, limit int64, tWindow time.Duration) *models.RateLimitStatus {
+	window := tWindow.Milliseconds()
+	if neverFits(limit, tWindow) {
+		return &models.RateLimitStatus{
+			State:       models.Denied,
+			Limit:       limit,
+			Remaining:   remaining(limit, 0),
+			ExpiresAtMs: nowMs + window,
+		}
 	}
 
-	return &models.RateLimitStatus{
-		State:       models.Denied,
-		Count:       int(total),
-		ExpiresAtMs: expiresAt,
-	}, nil
+	start, count = carryOver(start, count, nowMs, limit, tWindow)
+
+	status := &models.RateLimitStatus{
+		State:       models.Allowed,
+		Count:       int(min(count, max(limit, 0))),
+		Limit:       limit,
+		ExpiresAtMs: start + window,
+	}
+	status.Remaining = remaining(limit, status.Count)
+	if count >= limit {
+		status.State = models.Denied
+		status.RetryAfter = fixedWindowRetry(start, count, nowMs, limit, tWindow, 1)
+	}
+
+	return status
+}
+
+// fixedWindowRetry returns how long a request that costs n units has to wait, as of nowMs, for the window
+// it fits within to start, in a window that started at start with count units. The units carried over
+// to the following windows are taken into account, and it is zero when the request never fits.
+func fixedWindowRetry(start, count, nowMs, limit int64, tWindow time.Duration, n int64) time.Duration {
+	if neverFits(limit, tWindow) || n > limit {
+		return 0
+	}
+
+	start, count = carryOver(start, count, nowMs, limit, tWindow)
+	slot := (count + n - 1) / limit
+	return retryAfter(start+slot*tWindow.Milliseconds(), nowMs)
+}
+
+// carryOver moves a window that started at start with count units to the one current as of nowMs,
+// carrying over the units reserved for it, and returns its start and count. A window no request fits within
+// is left as it is.
+func carryOver(start, count, nowMs, limit int64, tWindow time.Duration) (int64, int64) {
+	window := tWindow.Milliseconds()
+	if neverFits(limit, tWindow) || nowMs < start+window {
+		return start, count
+	}
+
+	passed := (nowMs - start) / window
+	count = max(0, count-passed*limit)
+	if count == 0 {
+		return nowMs, count
+	}
+
+	return start + passed*window, count
+}
+
+// fixedWindowRequestID returns the request ID of the requests counted in the window that started at start,
+// which is what tells their refunds whether that window is still current.
+func fixedWindowRequestID(start int64) string {
+	return strconv.FormatInt(start, 10)
+}
+
+// parseFixedWindowRequestID returns the start of the window a request was counted in from its request ID.
+func parseFixedWindowRequestID(requestID string) (int64, error) {
+	start, err := strconv.ParseInt(requestID, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid request id %v: %w", requestID, errs.ErrInvalidArguments)
+	}
+
+	return start, nil
+}
+
+// parseInt converts a number stored in redis by a script, which is read back as a string
+// that might use the floating point notation of the scripts numbers.
+func parseInt(value interface{}) (int64, error) {
+	str, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected value %v", value)
+	}
+
+	number, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(number), nil
 }
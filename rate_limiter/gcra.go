@@ -0,0 +1,430 @@
+package rate_limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/godoylucase/rate-limit/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// gcraScript applies the generic cell rate algorithm to the theoretical arrival time (TAT) stored at KEYS[1].
+// Requests are spaced by an emission interval of window/limit, and up to limit requests are tolerated at once.
+// The TAT is the only value kept per key, and it expires as soon as it is in the past. It is written with 17
+// significant digits, as every value the scripts return, since tostring would round away the fractional emission
+// intervals of the limits that do not divide their window.
+// A request that costs n units takes n emission intervals at once.
+// When the maximum delay allows it, a request that arrives too early is accepted anyway,
+// and it has to wait until it fits within the tolerance.
+//
+// ARGV[1] is the limit, ARGV[2] the window in milliseconds, ARGV[3] the current timestamp in milliseconds,
+// ARGV[4] the maximum delay in milliseconds, -1 meaning unbounded, and ARGV[5] the cost n of the request.
+// It returns whether the request was allowed, the TAT after the request, the timestamp
+// when the next request will be allowed and the milliseconds until the request fits.
+var gcraScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local maxDelay = tonumber(ARGV[4])
+local n = tonumber(ARGV[5])
+local interval = window / limit
+
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil or tat < now then
+	tat = now
+end
+
+local newTat = tat + n * interval
+local allowAt = newTat - window
+local delay = math.max(0, allowAt - now)
+if maxDelay >= 0 and delay > maxDelay then
+	return {0, string.format('%.17g', tat), string.format('%.17g', allowAt), string.format('%.17g', delay)}
+end
+
+redis.call('SET', KEYS[1], string.format('%.17g', newTat), 'PX', math.max(1, math.ceil(newTat - now)))
+
+return {1, string.format('%.17g', newTat), string.format('%.17g', math.max(now, newTat + interval - window)), string.format('%.17g', delay)}
+`)
+
+// gcraRefundScript moves the theoretical arrival time stored at KEYS[1] back by n emission intervals,
+// when it still exists, removing it when it ends up in the past.
+//
+// ARGV[1] is the emission interval in milliseconds, ARGV[2] the current timestamp in milliseconds
+// and ARGV[3] the number of requests to give back.
+var gcraRefundScript = redis.NewScript(`
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if tat == nil then
+	return 0
+end
+
+local now = tonumber(ARGV[2])
+tat = tat - tonumber(ARGV[1]) * tonumber(ARGV[3])
+if tat <= now then
+	redis.call('DEL', KEYS[1])
+	return 1
+end
+
+redis.call('SET', KEYS[1], string.format('%.17g', tat), 'PX', math.max(1, math.ceil(tat - now)))
+return 1
+`)
+
+// gcrasScript applies the generic cell rate algorithm to every theoretical arrival time stored at KEYS,
+// advancing them only when the current request fits within the tolerance of all of them, so either every key
+// accounts for the request or none does. Each key is kept the same way gcraScript does, and requests that arrive
+// too early are never accepted in advance.
+//
+// ARGV[1] is the current timestamp in milliseconds and ARGV[2] the cost n of the request, followed by the limit
+// and the window in milliseconds of each key.
+// It returns whether the request was allowed, followed by whether it fits and the TAT of each key,
+// which includes the request only when it was allowed.
+var gcrasScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+
+local tats = {}
+local allowed = 1
+for i, key in ipairs(KEYS) do
+	local limit = tonumber(ARGV[2 * i + 1])
+	local window = tonumber(ARGV[2 * i + 2])
+
+	local fits = 0
+	local tat = now
+	local newTat = now
+	if limit >= 1 and window >= 1 then
+		tat = tonumber(redis.call('GET', key))
+		if tat == nil or tat < now then
+			tat = now
+		end
+
+		newTat = tat + n * window / limit
+		if newTat - window <= now then
+			fits = 1
+		end
+	end
+
+	if fits == 0 then
+		allowed = 0
+	end
+	tats[i] = {fits, tat, newTat}
+end
+
+local result = {allowed}
+for i, key in ipairs(KEYS) do
+	local t = tats[i]
+	if allowed == 1 then
+		t[2] = t[3]
+		redis.call('SET', key, string.format('%.17g', t[2]), 'PX', math.max(1, math.ceil(t[2] - now)))
+	end
+	table.insert(result, t[1])
+	table.insert(result, string.format('%.17g', t[2]))
+end
+
+return result
+`)
+
+type gcra struct {
+	redis redis.UniversalClient
+}
+
+func newGCRA(redis redis.UniversalClient) *gcra {
+	return &gcra{
+		redis: redis,
+	}
+}
+
+// CheckLimit checks the rate limit for a given key using the generic cell rate algorithm.
+// Requests are expected to arrive evenly spaced by tWindow/limit, and up to limit requests
+// are tolerated at once, which makes it behave like a sliding window of size tWindow.
+// Unlike the sliding window, only the theoretical arrival time of the next request is stored per key.
+// If the request arrives early enough to fit within the tolerance, it returns a RateLimitStatus with State Allowed,
+// otherwise it returns a RateLimitStatus with State Denied.
+// The RateLimitStatus also includes the count, which is the number of requests currently accounted for,
+// and the expiresAtMs, which is the timestamp in milliseconds when the next request will be allowed.
+// The RetryAfter of a Denied request tells how long it has to wait until it fits within the tolerance.
+// It returns the RateLimitStatus and any error encountered during the process.
+func (g *gcra) CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return g.CheckLimitN(ctx, key, limit, tWindow, 1)
+}
+
+// CheckLimitN works like CheckLimit for a request that costs n units, which takes n emission intervals at once
+// only when all of them fit within the tolerance.
+func (g *gcra) CheckLimitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	reservation, err := g.reserve(ctx, key, limit, tWindow, n, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation.Status, nil
+}
+
+// Reserve accepts a request that arrives too early, as long as it fits within the tolerance before
+// the context deadline. The returned reservation tells how long to wait for it to fit.
+func (g *gcra) Reserve(ctx context.Context, key string, limit int64, tWindow time.Duration) (*Reservation, error) {
+	return g.ReserveN(ctx, key, limit, tWindow, 1)
+}
+
+// ReserveN works like Reserve for a request that costs n units.
+func (g *gcra) ReserveN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*Reservation, error) {
+	return g.reserve(ctx, key, limit, tWindow, n, maxDelay(ctx))
+}
+
+// Wait blocks until the request fits within the tolerance, or returns a Denied status right away
+// when that does not happen before the context deadline.
+func (g *gcra) Wait(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return g.WaitN(ctx, key, limit, tWindow, 1)
+}
+
+// WaitN works like Wait for a request that costs n units.
+func (g *gcra) WaitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	reservation, err := g.ReserveN(ctx, key, limit, tWindow, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return wait(ctx, reservation)
+}
+
+// Status returns the current status of the rate limit for a given key without accounting for a request.
+// The State tells whether a request would be allowed right now, and the count, the remaining requests,
+// the expiresAtMs and the RetryAfter have the same meaning as in CheckLimit.
+func (g *gcra) Status(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	now := time.Now()
+	key = hashTagged(key)
+
+	tat := float64(now.UnixMilli())
+	value, err := g.redis.Get(ctx, key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to get gcra status for key: %v with error: %w", key, err)
+	} else if err == nil {
+		if tat, err = parseFloat(value); err != nil {
+			return nil, fmt.Errorf("failed to parse gcra tat for key: %v with error: %w", key, err)
+		}
+	}
+
+	return gcraStatus(tat, float64(now.UnixMilli()), limit, tWindow), nil
+}
+
+// Keys returns the keys starting with prefix, such as the hash tag of a user, sorted.
+// The keys are returned as they are stored, so they can be given back to Reset.
+func (g *gcra) Keys(ctx context.Context, prefix string) ([]string, error) {
+	return scanKeys(ctx, g.redis, prefix)
+}
+
+// Reset clears the theoretical arrival time of a given key, so the whole tolerance is available again.
+func (g *gcra) Reset(ctx context.Context, key string) error {
+	key = hashTagged(key)
+
+	if err := g.redis.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to reset gcra for key: %v with error: %w", key, err)
+	}
+
+	return nil
+}
+
+// Refund moves the theoretical arrival time of a given key back by n emission intervals, when it still exists.
+// The request ID is not used, since only the theoretical arrival time is kept per key.
+func (g *gcra) Refund(ctx context.Context, key string, limit int64, tWindow time.Duration, _ string, n int64) error {
+	if err := checkCost(n); err != nil {
+		return err
+	}
+
+	if neverFits(limit, tWindow) {
+		return nil
+	}
+
+	key = hashTagged(key)
+	interval := float64(tWindow.Milliseconds()) / float64(limit)
+
+	if err := gcraRefundScript.Run(ctx, g.redis, []string{key}, interval, time.Now().UnixMilli(), n).Err(); err != nil {
+		return fmt.Errorf("failed to refund gcra for key: %v with error: %w", key, err)
+	}
+
+	return nil
+}
+
+// CheckLimitsN checks a request that costs n units against every given window at once, advancing
+// the theoretical arrival time of all of them only when the request fits within the tolerance of each one.
+// It returns the status of every window, in the same order, whose State tells whether the request fits within it,
+// and the count includes the request only when every window allowed it.
+// The whole check is performed atomically by a server side script.
+func (g *gcra) CheckLimitsN(ctx context.Context, windows []Window, n int64) ([]*models.RateLimitStatus, error) {
+	if err := checkWindows(windows, n); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	keys, args := windowsArgs(windows, now.UnixMilli(), n)
+
+	result, err := gcrasScript.Run(ctx, g.redis, keys, args...).Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run gcras script for keys: %v with error: %w", keys, err)
+	}
+
+	if len(result) != 1+2*len(windows) {
+		return nil, fmt.Errorf("unexpected gcras result for keys: %v with length: %v", keys, len(result))
+	}
+
+	statuses := make([]*models.RateLimitStatus, 0, len(windows))
+	for i, window := range windows {
+		fits, ok := result[1+2*i].(int64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected gcras fits value for key: %v: %v", keys[i], result[1+2*i])
+		}
+
+		tat, err := parseFloat(result[2+2*i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse gcras tat for key: %v with error: %w", keys[i], err)
+		}
+
+		statuses = append(statuses, gcraWindowStatus(tat, float64(now.UnixMilli()), window, n, fits == 1))
+	}
+
+	return statuses, nil
+}
+
+func (g *gcra) reserve(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64, maxDelay time.Duration) (*Reservation, error) {
+	if err := checkCost(n); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	// A request that costs more than the tolerance never fits
+	if neverFits(limit, tWindow) || n > limit {
+		return &Reservation{
+			OK: false,
+			Status: &models.RateLimitStatus{
+				State:       models.Denied,
+				Count:       0,
+				Limit:       limit,
+				Remaining:   remaining(limit, 0),
+				ExpiresAtMs: now.Add(tWindow).UnixMilli(),
+			},
+		}, nil
+	}
+
+	key = hashTagged(key)
+
+	result, err := gcraScript.Run(ctx, g.redis, []string{key}, limit, tWindow.Milliseconds(), now.UnixMilli(), delayMs(maxDelay), n).Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run gcra script for key: %v with error: %w", key, err)
+	}
+
+	allowed, tat, allowAt, delay, err := parseGCRAResult(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse gcra result for key: %v with error: %w", key, err)
+	}
+
+	// Every pending emission interval until the TAT accounts for a request within the window
+	interval := float64(tWindow.Milliseconds()) / float64(limit)
+	count := int(ceil((tat - float64(now.UnixMilli())) / interval))
+
+	reservation := &Reservation{
+		OK:    allowed,
+		Delay: time.Duration(ceil(delay)) * time.Millisecond,
+		Status: &models.RateLimitStatus{
+			State:       models.Denied,
+			Count:       count,
+			Limit:       limit,
+			Remaining:   remaining(limit, count),
+			RetryAfter:  time.Duration(ceil(delay)) * time.Millisecond,
+			ExpiresAtMs: int64(ceil(allowAt)),
+		},
+	}
+
+	if allowed {
+		reservation.Status.State = models.Allowed
+		reservation.Status.RetryAfter = 0
+		reservation.cancel = func(ctx context.Context) error {
+			return g.Refund(ctx, key, limit, tWindow, "", n)
+		}
+	}
+
+	return reservation, nil
+}
+
+// gcraStatus returns the status as of nowMs of a key with the given theoretical arrival time.
+func gcraStatus(tat, nowMs float64, limit int64, tWindow time.Duration) *models.RateLimitStatus {
+	if neverFits(limit, tWindow) {
+		return &models.RateLimitStatus{
+			State:       models.Denied,
+			Limit:       limit,
+			ExpiresAtMs: int64(nowMs) + tWindow.Milliseconds(),
+		}
+	}
+
+	window := float64(tWindow.Milliseconds())
+	interval := window / float64(limit)
+	tat = math.Max(nowMs, tat)
+	allowAt := tat + interval - window
+
+	status := &models.RateLimitStatus{
+		State:       models.Allowed,
+		Count:       int(ceil((tat - nowMs) / interval)),
+		Limit:       limit,
+		ExpiresAtMs: int64(ceil(math.Max(nowMs, allowAt))),
+	}
+	status.Remaining = remaining(limit, status.Count)
+	if ceil(allowAt) > nowMs {
+		status.State = models.Denied
+		status.RetryAfter = retryAfter(status.ExpiresAtMs, int64(nowMs))
+	}
+
+	return status
+}
+
+// gcraWindowStatus returns the status as of nowMs of a window checked by CheckLimitsN with the given
+// theoretical arrival time. The expiresAtMs of a window the request does not fit within is when it will,
+// which its RetryAfter tells as well unless the request costs more than the limit.
+func gcraWindowStatus(tat, nowMs float64, window Window, n int64, fits bool) *models.RateLimitStatus {
+	status := gcraStatus(tat, nowMs, window.Limit, window.Size)
+
+	var retry time.Duration
+	if !fits && !neverFits(window.Limit, window.Size) {
+		size := float64(window.Size.Milliseconds())
+		status.ExpiresAtMs = int64(ceil(math.Max(nowMs, tat) + float64(n)*size/float64(window.Limit) - size))
+		if n <= window.Limit {
+			retry = retryAfter(status.ExpiresAtMs, int64(nowMs))
+		}
+	}
+
+	return checkedWindow(status, fits, retry)
+}
+
+// parseGCRAResult converts the reply of the gcra script into its typed values.
+func parseGCRAResult(result []interface{}) (bool, float64, float64, float64, error) {
+	if len(result) != 4 {
+		return false, 0, 0, 0, fmt.Errorf("unexpected result length %v", len(result))
+	}
+
+	allowed, ok := result[0].(int64)
+	if !ok {
+		return false, 0, 0, 0, fmt.Errorf("unexpected allowed value %v", result[0])
+	}
+
+	values := make([]float64, 0, 3)
+	for _, raw := range result[1:] {
+		str, ok := raw.(string)
+		if !ok {
+			return false, 0, 0, 0, fmt.Errorf("unexpected value %v", raw)
+		}
+
+		value, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return false, 0, 0, 0, err
+		}
+		values = append(values, value)
+	}
+
+	return allowed == 1, values[0], values[1], values[2], nil
+}
+
+// ceil rounds up a number of milliseconds, ignoring the floating point noise of the scripts arithmetic.
+func ceil(ms float64) float64 {
+	return math.Ceil(ms - 1e-9)
+}
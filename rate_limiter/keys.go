@@ -0,0 +1,63 @@
+package rate_limiter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// scanCount is how many keys a SCAN call is hinted to look at, per iteration.
+const scanCount = 100
+
+// globEscaper escapes the characters of a key that have a special meaning in the SCAN MATCH patterns.
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// hashTagged returns the key wrapped in a redis cluster hash tag, so every key derived from it lands on the same slot.
+// Keys that already include a hash tag, such as "{user}-type", are returned as they are, which lets callers
+// group several keys on one slot by sharing the tagged part.
+func hashTagged(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key
+		}
+	}
+
+	return "{" + key + "}"
+}
+
+// scanKeys returns the keys starting with prefix, sorted, walking the keyspace with SCAN so redis is not blocked.
+// The keys of a cluster are spread across its masters, so every one of them is scanned.
+func scanKeys(ctx context.Context, cli redis.UniversalClient, prefix string) ([]string, error) {
+	match := globEscaper.Replace(prefix) + "*"
+
+	var mu sync.Mutex
+	var keys []string
+	scan := func(ctx context.Context, node redis.Cmdable) error {
+		iter := node.Scan(ctx, 0, match, scanCount).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+
+	var err error
+	if cluster, ok := cli.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			return scan(ctx, master)
+		})
+	} else {
+		err = scan(ctx, cli)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan keys with prefix: %v with error: %w", prefix, err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
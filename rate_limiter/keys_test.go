@@ -0,0 +1,42 @@
+package rate_limiter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashTagged(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{
+			name: "plain key",
+			key:  "user-type",
+			want: "{user-type}",
+		},
+		{
+			name: "tagged key",
+			key:  "{user}-type",
+			want: "{user}-type",
+		},
+		{
+			name: "empty tag",
+			key:  "{}user-type",
+			want: "{{}user-type}",
+		},
+		{
+			name: "unclosed tag",
+			key:  "{user-type",
+			want: "{{user-type}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, hashTagged(tt.key))
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package rate_limiter
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// memoryShards is the number of independently locked maps the memory store spreads its keys across.
+	memoryShards = 64
+	// memoryCleanupInterval is how often the memory store evicts the expired keys.
+	memoryCleanupInterval = 10 * time.Second
+)
+
+// memoryEntry is the state of a key within the memory store, along with its expiration.
+type memoryEntry struct {
+	state     interface{}
+	expiresAt time.Time
+}
+
+// memoryShard is a portion of the memory store keys protected by its own lock.
+type memoryShard struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+// memoryStore is an in-process key value store with expiration, used as the memory backend of the rate limiters.
+// Keys are spread across sharded maps to reduce lock contention, and the expired ones are evicted in the background
+// until the store is closed.
+type memoryStore struct {
+	shards [memoryShards]*memoryShard
+	now    func() time.Time
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newMemoryStore(cleanupInterval time.Duration) *memoryStore {
+	ms := &memoryStore{
+		now:  time.Now,
+		done: make(chan struct{}),
+	}
+	for i := range ms.shards {
+		ms.shards[i] = &memoryShard{entries: make(map[string]*memoryEntry)}
+	}
+
+	go ms.janitor(cleanupInterval)
+
+	return ms
+}
+
+// Close stops the background eviction of expired keys.
+func (ms *memoryStore) Close() error {
+	ms.once.Do(func() {
+		close(ms.done)
+	})
+
+	return nil
+}
+
+// Reset removes the state of a key, which clears its rate limit whatever the algorithm using the store.
+func (ms *memoryStore) Reset(_ context.Context, key string) error {
+	ms.update(key, func(*memoryEntry, time.Time) *memoryEntry {
+		return nil
+	})
+
+	return nil
+}
+
+// Keys returns the keys of the store starting with prefix that did not expire, sorted, whatever the algorithm using it.
+func (ms *memoryStore) Keys(_ context.Context, prefix string) ([]string, error) {
+	now := ms.now()
+
+	var keys []string
+	for _, shard := range ms.shards {
+		shard.mu.Lock()
+		for key, entry := range shard.entries {
+			if strings.HasPrefix(key, prefix) && now.Before(entry.expiresAt) {
+				keys = append(keys, key)
+			}
+		}
+		shard.mu.Unlock()
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// update runs fn with the current entry of the key while holding its shard lock.
+// The entry is nil when the key does not exist or it already expired.
+// The entry returned by fn replaces the current one, and a nil or expired entry removes the key.
+func (ms *memoryStore) update(key string, fn func(entry *memoryEntry, now time.Time) *memoryEntry) {
+	shard := ms.shard(key)
+	now := ms.now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, ok := shard.entries[key]
+	if !ok || !now.Before(entry.expiresAt) {
+		entry = nil
+	}
+
+	entry = fn(entry, now)
+	if entry == nil || !now.Before(entry.expiresAt) {
+		delete(shard.entries, key)
+		return
+	}
+
+	shard.entries[key] = entry
+}
+
+// updateAll runs fn with the current entries of the keys while holding all their shard locks, so the keys
+// are updated at once. The entries are nil for the keys that do not exist or already expired, and the entries
+// returned by fn replace the current ones in the same order, the same way update does. The keys must be distinct.
+func (ms *memoryStore) updateAll(keys []string, fn func(entries []*memoryEntry, now time.Time) []*memoryEntry) {
+	// Shards are locked in ascending order, so concurrent updates of overlapping keys do not deadlock
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, ms.shardIndex(key))
+	}
+
+	locked := append([]int(nil), indexes...)
+	sort.Ints(locked)
+	for i, idx := range locked {
+		if i == 0 || idx != locked[i-1] {
+			ms.shards[idx].mu.Lock()
+			defer ms.shards[idx].mu.Unlock()
+		}
+	}
+
+	now := ms.now()
+	entries := make([]*memoryEntry, len(keys))
+	for i, key := range keys {
+		entry, ok := ms.shards[indexes[i]].entries[key]
+		if ok && now.Before(entry.expiresAt) {
+			entries[i] = entry
+		}
+	}
+
+	for i, entry := range fn(entries, now) {
+		shard := ms.shards[indexes[i]]
+		if entry == nil || !now.Before(entry.expiresAt) {
+			delete(shard.entries, keys[i])
+			continue
+		}
+
+		shard.entries[keys[i]] = entry
+	}
+}
+
+// shard returns the shard the key belongs to.
+func (ms *memoryStore) shard(key string) *memoryShard {
+	return ms.shards[ms.shardIndex(key)]
+}
+
+// shardIndex returns the index of the shard the key belongs to.
+func (ms *memoryStore) shardIndex(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return int(h.Sum32() % memoryShards)
+}
+
+// janitor evicts the expired keys every interval until the store is closed.
+func (ms *memoryStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ms.evictExpired()
+		case <-ms.done:
+			return
+		}
+	}
+}
+
+// evictExpired removes the expired keys from every shard.
+func (ms *memoryStore) evictExpired() {
+	now := ms.now()
+
+	for _, shard := range ms.shards {
+		shard.mu.Lock()
+		for key, entry := range shard.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(shard.entries, key)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
@@ -0,0 +1,1004 @@
+package rate_limiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/godoylucase/rate-limit/errs"
+	"github.com/godoylucase/rate-limit/models"
+)
+
+// memoryFixedWindowState is the state of a fixed window within the memory store.
+type memoryFixedWindowState struct {
+	start int64
+	count int64
+}
+
+// memoryFixedWindowCounter is the in-process version of the fixed window counter.
+type memoryFixedWindowCounter struct {
+	*memoryStore
+}
+
+func newMemoryFixedWindowCounter(store *memoryStore) *memoryFixedWindowCounter {
+	return &memoryFixedWindowCounter{
+		memoryStore: store,
+	}
+}
+
+// CheckLimit checks the rate limit for a given key within a fixed window, the same way the redis counterpart does.
+func (fwc *memoryFixedWindowCounter) CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return fwc.CheckLimitN(ctx, key, limit, tWindow, 1)
+}
+
+// CheckLimitN works like CheckLimit for a request that costs n units, the same way the redis counterpart does.
+func (fwc *memoryFixedWindowCounter) CheckLimitN(_ context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	if err := checkCost(n); err != nil {
+		return nil, err
+	}
+
+	return fwc.reserve(key, limit, tWindow, n, 0).Status, nil
+}
+
+// Reserve reserves a request within the first window that has room for it, the same way the redis counterpart does.
+func (fwc *memoryFixedWindowCounter) Reserve(ctx context.Context, key string, limit int64, tWindow time.Duration) (*Reservation, error) {
+	return fwc.ReserveN(ctx, key, limit, tWindow, 1)
+}
+
+// ReserveN works like Reserve for a request that costs n units, the same way the redis counterpart does.
+func (fwc *memoryFixedWindowCounter) ReserveN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*Reservation, error) {
+	if err := checkCost(n); err != nil {
+		return nil, err
+	}
+
+	return fwc.reserve(key, limit, tWindow, n, maxDelay(ctx)), nil
+}
+
+// Wait blocks until the request fits within a window, the same way the redis counterpart does.
+func (fwc *memoryFixedWindowCounter) Wait(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return fwc.WaitN(ctx, key, limit, tWindow, 1)
+}
+
+// WaitN works like Wait for a request that costs n units, the same way the redis counterpart does.
+func (fwc *memoryFixedWindowCounter) WaitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	reservation, err := fwc.ReserveN(ctx, key, limit, tWindow, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return wait(ctx, reservation)
+}
+
+// Status returns the current status of the window for a given key without counting a request,
+// the same way the redis counterpart does.
+func (fwc *memoryFixedWindowCounter) Status(_ context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	var status *models.RateLimitStatus
+
+	fwc.update(key, func(entry *memoryEntry, now time.Time) *memoryEntry {
+		start, count := now.UnixMilli(), int64(0)
+		if entry != nil {
+			state := entry.state.(*memoryFixedWindowState)
+			start, count = state.start, state.count
+		}
+
+		status = fixedWindowStatus(start, count, now.UnixMilli(), limit, tWindow)
+		return entry
+	})
+
+	return status, nil
+}
+
+// CheckLimitsN checks a request that costs n units against the current window of every given window at once,
+// the same way the redis counterpart does.
+func (fwc *memoryFixedWindowCounter) CheckLimitsN(_ context.Context, windows []Window, n int64) ([]*models.RateLimitStatus, error) {
+	if err := checkWindows(windows, n); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*models.RateLimitStatus, len(windows))
+
+	fwc.updateAll(windowKeys(windows), func(entries []*memoryEntry, now time.Time) []*memoryEntry {
+		nowMs := now.UnixMilli()
+		states := make([]*memoryFixedWindowState, len(windows))
+		fits := make([]bool, len(windows))
+		allowed := true
+
+		for i, window := range windows {
+			state := &memoryFixedWindowState{start: nowMs}
+			if entries[i] != nil {
+				current := *entries[i].state.(*memoryFixedWindowState)
+				state = &current
+			}
+
+			state.start, state.count = carryOver(state.start, state.count, nowMs, window.Limit, window.Size)
+			states[i] = state
+			fits[i] = !neverFits(window.Limit, window.Size) && state.count+n <= window.Limit
+			allowed = allowed && fits[i]
+		}
+
+		updated := entries
+		if allowed {
+			updated = make([]*memoryEntry, len(windows))
+		}
+
+		for i, window := range windows {
+			if allowed {
+				states[i].count += n
+				updated[i] = &memoryEntry{state: states[i], expiresAt: time.UnixMilli(states[i].start + window.Size.Milliseconds())}
+			}
+
+			statuses[i] = fixedWindowStatus(states[i].start, states[i].count, nowMs, window.Limit, window.Size)
+			checkedWindow(statuses[i], fits[i], fixedWindowRetry(states[i].start, states[i].count, nowMs, window.Limit, window.Size, n))
+			if allowed {
+				statuses[i].RequestID = fixedWindowRequestID(states[i].start)
+			}
+		}
+
+		return updated
+	})
+
+	return statuses, nil
+}
+
+func (fwc *memoryFixedWindowCounter) reserve(key string, limit int64, tWindow time.Duration, n int64, maxDelay time.Duration) *Reservation {
+	var reservation *Reservation
+	window := tWindow.Milliseconds()
+
+	fwc.update(key, func(entry *memoryEntry, now time.Time) *memoryEntry {
+		nowMs := now.UnixMilli()
+
+		// The first request of a window starts it
+		state := &memoryFixedWindowState{start: nowMs}
+		if entry != nil {
+			state = entry.state.(*memoryFixedWindowState)
+		}
+
+		// A request never fits within a window shorter than a millisecond
+		if neverFits(limit, tWindow) {
+			reservation = &Reservation{Status: fixedWindowStatus(nowMs, 0, nowMs, limit, tWindow)}
+			return entry
+		}
+
+		// Move to the current window, carrying over the requests reserved for it
+		state.start, state.count = carryOver(state.start, state.count, nowMs, limit, tWindow)
+
+		// A request that costs more than the limit never fits
+		if n > limit {
+			count := int(min(state.count, limit))
+			reservation = &Reservation{
+				Status: &models.RateLimitStatus{
+					State:       models.Denied,
+					Count:       count,
+					Limit:       limit,
+					Remaining:   remaining(limit, count),
+					ExpiresAtMs: state.start + window,
+				},
+			}
+			return entry
+		}
+
+		// The request is not split across windows, so the units left in the windows it skips are given up
+		// until the request is cancelled
+		slot := (state.count + n - 1) / limit
+		delay := time.Duration(max(0, state.start+slot*window-nowMs)) * time.Millisecond
+		if !allows(delay, maxDelay) {
+			reservation = &Reservation{
+				Delay: delay,
+				Status: &models.RateLimitStatus{
+					State:       models.Denied,
+					Count:       int(min(state.count, limit)),
+					Limit:       limit,
+					Remaining:   remaining(limit, int(min(state.count, limit))),
+					RetryAfter:  delay,
+					ExpiresAtMs: state.start + window,
+				},
+			}
+			return entry
+		}
+
+		skipped := max(0, slot*limit-state.count)
+		state.count += skipped + n
+		requestStart := state.start + slot*window
+		expiresAt := requestStart + window
+		reservation = &Reservation{
+			OK:    true,
+			Delay: delay,
+			Status: &models.RateLimitStatus{
+				State:       models.Allowed,
+				Count:       int(state.count - slot*limit),
+				Limit:       limit,
+				Remaining:   remaining(limit, int(state.count-slot*limit)),
+				ExpiresAtMs: expiresAt,
+				RequestID:   fixedWindowRequestID(requestStart),
+			},
+			cancel: func(context.Context) error {
+				fwc.release(key, limit, tWindow, requestStart, n+skipped)
+				return nil
+			},
+		}
+		return &memoryEntry{state: state, expiresAt: time.UnixMilli(expiresAt)}
+	})
+
+	return reservation
+}
+
+// Refund gives back n units of the request identified by requestID to the window of a given key,
+// the same way the redis counterpart does.
+func (fwc *memoryFixedWindowCounter) Refund(_ context.Context, key string, limit int64, tWindow time.Duration, requestID string, n int64) error {
+	if err := checkCost(n); err != nil {
+		return err
+	}
+
+	requestStart, err := parseFixedWindowRequestID(requestID)
+	if err != nil {
+		return err
+	}
+
+	fwc.release(key, limit, tWindow, requestStart, n)
+	return nil
+}
+
+// release gives back n units to the window of the key, when the request counted in the window that started
+// at requestStart is still within the current window or the following ones.
+func (fwc *memoryFixedWindowCounter) release(key string, limit int64, tWindow time.Duration, requestStart, n int64) {
+	fwc.update(key, func(entry *memoryEntry, now time.Time) *memoryEntry {
+		if entry == nil || neverFits(limit, tWindow) {
+			return entry
+		}
+
+		state := entry.state.(*memoryFixedWindowState)
+		start, count := carryOver(state.start, state.count, now.UnixMilli(), limit, tWindow)
+		if count > 0 && requestStart >= start {
+			state.start, state.count = start, max(0, count-n)
+		}
+		return entry
+	})
+}
+
+// memorySlidingWindowRequest is a request within a sliding window of the memory store.
+type memorySlidingWindowRequest struct {
+	at int64
+	id uint64
+}
+
+// memorySlidingWindowState is the state of a sliding window within the memory store,
+// with its requests sorted by timestamp.
+type memorySlidingWindowState struct {
+	requests []memorySlidingWindowRequest
+	nextID   uint64
+}
+
+// memorySlidingWindowCounter is the in-process version of the sliding window counter.
+type memorySlidingWindowCounter struct {
+	*memoryStore
+}
+
+func newMemorySlidingWindowCounter(store *memoryStore) *memorySlidingWindowCounter {
+	return &memorySlidingWindowCounter{
+		memoryStore: store,
+	}
+}
+
+// CheckLimit checks the rate limit for a given key within a sliding window, the same way the redis counterpart does.
+func (swc *memorySlidingWindowCounter) CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return swc.CheckLimitN(ctx, key, limit, tWindow, 1)
+}
+
+// CheckLimitN works like CheckLimit for a request that costs n units, the same way the redis counterpart does.
+func (swc *memorySlidingWindowCounter) CheckLimitN(_ context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	if err := checkCost(n); err != nil {
+		return nil, err
+	}
+
+	return swc.reserve(key, limit, tWindow, n, 0).Status, nil
+}
+
+// Reserve reserves a request at the time a slot frees up within the sliding window,
+// the same way the redis counterpart does.
+func (swc *memorySlidingWindowCounter) Reserve(ctx context.Context, key string, limit int64, tWindow time.Duration) (*Reservation, error) {
+	return swc.ReserveN(ctx, key, limit, tWindow, 1)
+}
+
+// ReserveN works like Reserve for a request that costs n units, the same way the redis counterpart does.
+func (swc *memorySlidingWindowCounter) ReserveN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*Reservation, error) {
+	if err := checkCost(n); err != nil {
+		return nil, err
+	}
+
+	return swc.reserve(key, limit, tWindow, n, maxDelay(ctx)), nil
+}
+
+// Wait blocks until the request fits within the sliding window, the same way the redis counterpart does.
+func (swc *memorySlidingWindowCounter) Wait(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return swc.WaitN(ctx, key, limit, tWindow, 1)
+}
+
+// WaitN works like Wait for a request that costs n units, the same way the redis counterpart does.
+func (swc *memorySlidingWindowCounter) WaitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	reservation, err := swc.ReserveN(ctx, key, limit, tWindow, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return wait(ctx, reservation)
+}
+
+// Status returns the current status of the sliding window for a given key without adding a request,
+// the same way the redis counterpart does.
+func (swc *memorySlidingWindowCounter) Status(_ context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	var status *models.RateLimitStatus
+
+	swc.update(key, func(entry *memoryEntry, now time.Time) *memoryEntry {
+		nowMs := now.UnixMilli()
+		minimum := nowMs - tWindow.Milliseconds()
+
+		count, lastAt, freeAt := int64(0), minimum, nowMs
+		if entry != nil {
+			state := entry.state.(*memorySlidingWindowState)
+			oldest := sort.Search(len(state.requests), func(i int) bool { return state.requests[i].at > minimum })
+			count = int64(len(state.requests) - oldest)
+			if len(state.requests) > 0 {
+				lastAt = state.requests[len(state.requests)-1].at
+			}
+
+			// The request that has to leave the window is the oldest one beyond the limit
+			if !neverFits(limit, tWindow) && count >= limit {
+				freeAt = state.requests[int64(oldest)+count-limit].at + tWindow.Milliseconds()
+			}
+		}
+
+		status = slidingWindowStatus(count, lastAt, freeAt, nowMs, limit, tWindow)
+		return entry
+	})
+
+	return status, nil
+}
+
+// CheckLimitsN checks a request that costs n units against every given sliding window at once,
+// the same way the redis counterpart does. The units get their own request ID within every window.
+func (swc *memorySlidingWindowCounter) CheckLimitsN(_ context.Context, windows []Window, n int64) ([]*models.RateLimitStatus, error) {
+	if err := checkWindows(windows, n); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*models.RateLimitStatus, len(windows))
+
+	swc.updateAll(windowKeys(windows), func(entries []*memoryEntry, now time.Time) []*memoryEntry {
+		nowMs := now.UnixMilli()
+		states := make([]*memorySlidingWindowState, len(windows))
+		fits := make([]bool, len(windows))
+		retries := make([]time.Duration, len(windows))
+		allowed := true
+
+		for i, window := range windows {
+			state := &memorySlidingWindowState{}
+			if entries[i] != nil {
+				current := *entries[i].state.(*memorySlidingWindowState)
+				state = &current
+			}
+
+			// Remove all requests that have already expired within the sliding window
+			minimum := nowMs - window.Size.Milliseconds()
+			for len(state.requests) > 0 && state.requests[0].at <= minimum {
+				state.requests = state.requests[1:]
+			}
+
+			states[i] = state
+			count := int64(len(state.requests))
+			fits[i] = !neverFits(window.Limit, window.Size) && count+n <= window.Limit
+			allowed = allowed && fits[i]
+
+			// The request fits once the oldest units beyond the limit leave the window
+			if !fits[i] && !neverFits(window.Limit, window.Size) && n <= window.Limit {
+				retries[i] = retryAfter(state.requests[count+n-window.Limit-1].at+window.Size.Milliseconds(), nowMs)
+			}
+		}
+
+		updated := entries
+		if allowed {
+			updated = make([]*memoryEntry, len(windows))
+		}
+
+		for i, window := range windows {
+			state := states[i]
+
+			var requestID string
+			if allowed {
+				// The units go before the requests reserved in the future, within a new slice
+				// so the current state is left untouched
+				state.nextID++
+				idx := sort.Search(len(state.requests), func(j int) bool { return state.requests[j].at > nowMs })
+				requests := make([]memorySlidingWindowRequest, 0, int64(len(state.requests))+n)
+				requests = append(requests, state.requests[:idx]...)
+				for j := int64(0); j < n; j++ {
+					requests = append(requests, memorySlidingWindowRequest{at: nowMs, id: state.nextID})
+				}
+				state.requests = append(requests, state.requests[idx:]...)
+				requestID = strconv.FormatUint(state.nextID, 10)
+
+				expiresAt := now.Add(window.Size)
+				if entries[i] != nil && entries[i].expiresAt.After(expiresAt) {
+					expiresAt = entries[i].expiresAt
+				}
+				updated[i] = &memoryEntry{state: state, expiresAt: expiresAt}
+			}
+
+			statuses[i] = slidingWindowStatus(int64(len(state.requests)), nowMs, nowMs, nowMs, window.Limit, window.Size)
+			checkedWindow(statuses[i], fits[i], retries[i])
+			statuses[i].RequestID = requestID
+		}
+
+		return updated
+	})
+
+	return statuses, nil
+}
+
+func (swc *memorySlidingWindowCounter) reserve(key string, limit int64, tWindow time.Duration, n int64, maxDelay time.Duration) *Reservation {
+	var reservation *Reservation
+
+	swc.update(key, func(entry *memoryEntry, now time.Time) *memoryEntry {
+		nowMs := now.UnixMilli()
+		state := &memorySlidingWindowState{}
+		if entry != nil {
+			state = entry.state.(*memorySlidingWindowState)
+		}
+
+		// Remove all requests that have already expired within the sliding window
+		minimum := nowMs - tWindow.Milliseconds()
+		for len(state.requests) > 0 && state.requests[0].at <= minimum {
+			state.requests = state.requests[1:]
+		}
+
+		count := int64(len(state.requests))
+
+		// A request that costs more than the limit, or within a window shorter than a millisecond, never fits
+		if neverFits(limit, tWindow) || n > limit {
+			reservation = &Reservation{
+				Status: slidingWindowDenied(count, now, 0, limit, tWindow, n),
+			}
+			return entry
+		}
+
+		at := nowMs
+		if count+n > limit {
+			at = max(nowMs, state.requests[count+n-limit-1].at+tWindow.Milliseconds())
+		}
+
+		delay := time.Duration(at-nowMs) * time.Millisecond
+		if !allows(delay, maxDelay) {
+			reservation = &Reservation{
+				Delay:  delay,
+				Status: slidingWindowDenied(count, now, delay, limit, tWindow, n),
+			}
+			return entry
+		}
+
+		// The n units of the request share its id
+		state.nextID++
+		id := state.nextID
+		units := make([]memorySlidingWindowRequest, n)
+		for i := range units {
+			units[i] = memorySlidingWindowRequest{at: at, id: id}
+		}
+		idx := sort.Search(len(state.requests), func(i int) bool { return state.requests[i].at > at })
+		state.requests = append(state.requests[:idx], append(units, state.requests[idx:]...)...)
+
+		expiresAt := now.Add(delay + tWindow)
+		reservation = &Reservation{
+			OK:    true,
+			Delay: delay,
+			Status: &models.RateLimitStatus{
+				State:       models.Allowed,
+				Count:       int(count + n),
+				Limit:       limit,
+				Remaining:   remaining(limit, int(count+n)),
+				ExpiresAtMs: expiresAt.UnixMilli(),
+				RequestID:   strconv.FormatUint(id, 10),
+			},
+			cancel: func(context.Context) error {
+				swc.remove(key, id, n)
+				return nil
+			},
+		}
+
+		if entry != nil && entry.expiresAt.After(expiresAt) {
+			expiresAt = entry.expiresAt
+		}
+		return &memoryEntry{state: state, expiresAt: expiresAt}
+	})
+
+	return reservation
+}
+
+// Refund removes n units of the request identified by requestID from the sliding window of a given key,
+// the same way the redis counterpart does.
+func (swc *memorySlidingWindowCounter) Refund(_ context.Context, key string, _ int64, _ time.Duration, requestID string, n int64) error {
+	if err := checkCost(n); err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseUint(requestID, 10, 64)
+	if err != nil || id == 0 {
+		return fmt.Errorf("invalid request id %v: %w", requestID, errs.ErrInvalidArguments)
+	}
+
+	swc.remove(key, id, n)
+	return nil
+}
+
+// remove removes up to n units of the request with the given id from the sliding window of the key,
+// when it still exists.
+func (swc *memorySlidingWindowCounter) remove(key string, id uint64, n int64) {
+	swc.update(key, func(entry *memoryEntry, _ time.Time) *memoryEntry {
+		if entry == nil {
+			return nil
+		}
+
+		state := entry.state.(*memorySlidingWindowState)
+		for i := len(state.requests) - 1; i >= 0 && n > 0; i-- {
+			if state.requests[i].id == id {
+				state.requests = append(state.requests[:i], state.requests[i+1:]...)
+				n--
+			}
+		}
+		return entry
+	})
+}
+
+// memoryTokenBucketState is the state of a token bucket within the memory store.
+type memoryTokenBucketState struct {
+	tokens float64
+	ts     int64
+}
+
+// memoryTokenBucket is the in-process version of the token bucket.
+type memoryTokenBucket struct {
+	*memoryStore
+}
+
+func newMemoryTokenBucket(store *memoryStore) *memoryTokenBucket {
+	return &memoryTokenBucket{
+		memoryStore: store,
+	}
+}
+
+// CheckLimit checks the rate limit for a given key using a token bucket, the same way the redis counterpart does.
+func (tb *memoryTokenBucket) CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return tb.CheckLimitN(ctx, key, limit, tWindow, 1)
+}
+
+// CheckLimitN works like CheckLimit for a request that costs n tokens, the same way the redis counterpart does.
+func (tb *memoryTokenBucket) CheckLimitN(_ context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	if err := checkCost(n); err != nil {
+		return nil, err
+	}
+
+	return tb.reserve(key, limit, tWindow, n, 0).Status, nil
+}
+
+// Reserve takes a token in advance, the same way the redis counterpart does.
+func (tb *memoryTokenBucket) Reserve(ctx context.Context, key string, limit int64, tWindow time.Duration) (*Reservation, error) {
+	return tb.ReserveN(ctx, key, limit, tWindow, 1)
+}
+
+// ReserveN works like Reserve for a request that costs n tokens, the same way the redis counterpart does.
+func (tb *memoryTokenBucket) ReserveN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*Reservation, error) {
+	if err := checkCost(n); err != nil {
+		return nil, err
+	}
+
+	return tb.reserve(key, limit, tWindow, n, maxDelay(ctx)), nil
+}
+
+// Wait blocks until a token is available, the same way the redis counterpart does.
+func (tb *memoryTokenBucket) Wait(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return tb.WaitN(ctx, key, limit, tWindow, 1)
+}
+
+// WaitN works like Wait for a request that costs n tokens, the same way the redis counterpart does.
+func (tb *memoryTokenBucket) WaitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	reservation, err := tb.ReserveN(ctx, key, limit, tWindow, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return wait(ctx, reservation)
+}
+
+// Status returns the current status of the bucket for a given key without taking a token,
+// the same way the redis counterpart does.
+func (tb *memoryTokenBucket) Status(_ context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	var status *models.RateLimitStatus
+
+	tb.update(key, func(entry *memoryEntry, now time.Time) *memoryEntry {
+		tokens, ts := float64(limit), now.UnixMilli()
+		if entry != nil {
+			bucket := entry.state.(*memoryTokenBucketState)
+			tokens, ts = bucket.tokens, bucket.ts
+		}
+
+		status = tokenBucketStatus(tokens, ts, now.UnixMilli(), limit, tWindow)
+		return entry
+	})
+
+	return status, nil
+}
+
+// CheckLimitsN checks a request that costs n tokens against every given bucket at once,
+// the same way the redis counterpart does.
+func (tb *memoryTokenBucket) CheckLimitsN(_ context.Context, windows []Window, n int64) ([]*models.RateLimitStatus, error) {
+	if err := checkWindows(windows, n); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*models.RateLimitStatus, len(windows))
+
+	tb.updateAll(windowKeys(windows), func(entries []*memoryEntry, now time.Time) []*memoryEntry {
+		nowMs := now.UnixMilli()
+		buckets := make([]*memoryTokenBucketState, len(windows))
+		fits := make([]bool, len(windows))
+		allowed := true
+
+		for i, window := range windows {
+			bucket := &memoryTokenBucketState{ts: nowMs}
+			if !neverFits(window.Limit, window.Size) {
+				capacity := float64(window.Limit)
+				bucket.tokens = capacity
+				if entries[i] != nil {
+					current := *entries[i].state.(*memoryTokenBucketState)
+					bucket = &current
+				}
+
+				if nowMs > bucket.ts {
+					rate := capacity / float64(window.Size.Milliseconds())
+					bucket.tokens = math.Min(capacity, bucket.tokens+float64(nowMs-bucket.ts)*rate)
+					bucket.ts = nowMs
+				}
+
+				fits[i] = bucket.tokens >= float64(n)
+			}
+
+			buckets[i] = bucket
+			allowed = allowed && fits[i]
+		}
+
+		updated := entries
+		if allowed {
+			updated = make([]*memoryEntry, len(windows))
+		}
+
+		for i, window := range windows {
+			bucket := buckets[i]
+			if allowed {
+				bucket.tokens -= float64(n)
+
+				// The bucket expires once it would be full again
+				rate := float64(window.Limit) / float64(window.Size.Milliseconds())
+				ttl := math.Max(1, math.Ceil((float64(window.Limit)-bucket.tokens)/rate))
+				updated[i] = &memoryEntry{state: bucket, expiresAt: now.Add(time.Duration(ttl) * time.Millisecond)}
+			}
+
+			statuses[i] = tokenBucketStatus(bucket.tokens, nowMs, nowMs, window.Limit, window.Size)
+			checkedWindow(statuses[i], fits[i], tokensIn(bucket.tokens, n, window.Limit, window.Size))
+		}
+
+		return updated
+	})
+
+	return statuses, nil
+}
+
+func (tb *memoryTokenBucket) reserve(key string, limit int64, tWindow time.Duration, n int64, maxDelay time.Duration) *Reservation {
+	// A request that costs more than the bucket capacity never fits
+	if neverFits(limit, tWindow) || n > limit {
+		return &Reservation{
+			Status: &models.RateLimitStatus{
+				State:       models.Denied,
+				Count:       0,
+				Limit:       limit,
+				Remaining:   remaining(limit, 0),
+				ExpiresAtMs: tb.now().Add(tWindow).UnixMilli(),
+			},
+		}
+	}
+
+	var reservation *Reservation
+	capacity := float64(limit)
+	rate := capacity / float64(tWindow.Milliseconds())
+
+	tb.update(key, func(entry *memoryEntry, now time.Time) *memoryEntry {
+		nowMs := now.UnixMilli()
+
+		bucket := &memoryTokenBucketState{tokens: capacity, ts: nowMs}
+		if entry != nil {
+			bucket = entry.state.(*memoryTokenBucketState)
+		}
+
+		if nowMs > bucket.ts {
+			bucket.tokens = math.Min(capacity, bucket.tokens+float64(nowMs-bucket.ts)*rate)
+			bucket.ts = nowMs
+		}
+
+		var delay time.Duration
+		if bucket.tokens < float64(n) {
+			delay = time.Duration(math.Ceil((float64(n)-bucket.tokens)/rate)) * time.Millisecond
+		}
+
+		reservation = &Reservation{
+			Delay: delay,
+			Status: &models.RateLimitStatus{
+				State:      models.Denied,
+				Limit:      limit,
+				RetryAfter: delay,
+			},
+		}
+		if allows(delay, maxDelay) {
+			bucket.tokens -= float64(n)
+			reservation.OK = true
+			reservation.Status.State = models.Allowed
+			reservation.Status.RetryAfter = 0
+			reservation.cancel = func(context.Context) error {
+				tb.release(key, limit, n)
+				return nil
+			}
+		}
+
+		reservation.Status.Count = int(limit - int64(math.Floor(bucket.tokens)))
+		reservation.Status.Remaining = remaining(limit, reservation.Status.Count)
+		reservation.Status.ExpiresAtMs = now.Add(tokensIn(bucket.tokens, 1, limit, tWindow)).UnixMilli()
+
+		// The bucket expires once it would be full again
+		ttl := math.Max(1, math.Ceil((capacity-bucket.tokens)/rate))
+		return &memoryEntry{state: bucket, expiresAt: now.Add(time.Duration(ttl) * time.Millisecond)}
+	})
+
+	return reservation
+}
+
+// Refund puts n tokens back into the bucket of a given key, the same way the redis counterpart does.
+func (tb *memoryTokenBucket) Refund(_ context.Context, key string, limit int64, _ time.Duration, _ string, n int64) error {
+	if err := checkCost(n); err != nil {
+		return err
+	}
+
+	tb.release(key, limit, n)
+	return nil
+}
+
+// release puts n tokens back into the bucket of the key, when it still exists.
+func (tb *memoryTokenBucket) release(key string, limit int64, n int64) {
+	tb.update(key, func(entry *memoryEntry, _ time.Time) *memoryEntry {
+		if entry != nil {
+			bucket := entry.state.(*memoryTokenBucketState)
+			bucket.tokens = math.Min(float64(limit), bucket.tokens+float64(n))
+		}
+		return entry
+	})
+}
+
+// memoryGCRA is the in-process version of the generic cell rate algorithm.
+type memoryGCRA struct {
+	*memoryStore
+}
+
+func newMemoryGCRA(store *memoryStore) *memoryGCRA {
+	return &memoryGCRA{
+		memoryStore: store,
+	}
+}
+
+// CheckLimit checks the rate limit for a given key using the generic cell rate algorithm,
+// the same way the redis counterpart does.
+func (g *memoryGCRA) CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return g.CheckLimitN(ctx, key, limit, tWindow, 1)
+}
+
+// CheckLimitN works like CheckLimit for a request that costs n units, the same way the redis counterpart does.
+func (g *memoryGCRA) CheckLimitN(_ context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	if err := checkCost(n); err != nil {
+		return nil, err
+	}
+
+	return g.reserve(key, limit, tWindow, n, 0).Status, nil
+}
+
+// Reserve accepts a request that arrives too early, the same way the redis counterpart does.
+func (g *memoryGCRA) Reserve(ctx context.Context, key string, limit int64, tWindow time.Duration) (*Reservation, error) {
+	return g.ReserveN(ctx, key, limit, tWindow, 1)
+}
+
+// ReserveN works like Reserve for a request that costs n units, the same way the redis counterpart does.
+func (g *memoryGCRA) ReserveN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*Reservation, error) {
+	if err := checkCost(n); err != nil {
+		return nil, err
+	}
+
+	return g.reserve(key, limit, tWindow, n, maxDelay(ctx)), nil
+}
+
+// Wait blocks until the request fits within the tolerance, the same way the redis counterpart does.
+func (g *memoryGCRA) Wait(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return g.WaitN(ctx, key, limit, tWindow, 1)
+}
+
+// WaitN works like Wait for a request that costs n units, the same way the redis counterpart does.
+func (g *memoryGCRA) WaitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	reservation, err := g.ReserveN(ctx, key, limit, tWindow, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return wait(ctx, reservation)
+}
+
+// Status returns the current status of the rate limit for a given key without accounting for a request,
+// the same way the redis counterpart does.
+func (g *memoryGCRA) Status(_ context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	var status *models.RateLimitStatus
+
+	g.update(key, func(entry *memoryEntry, now time.Time) *memoryEntry {
+		nowMs := float64(now.UnixMilli())
+
+		tat := nowMs
+		if entry != nil {
+			tat = entry.state.(float64)
+		}
+
+		status = gcraStatus(tat, nowMs, limit, tWindow)
+		return entry
+	})
+
+	return status, nil
+}
+
+// CheckLimitsN checks a request that costs n units against every given window at once,
+// the same way the redis counterpart does.
+func (g *memoryGCRA) CheckLimitsN(_ context.Context, windows []Window, n int64) ([]*models.RateLimitStatus, error) {
+	if err := checkWindows(windows, n); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*models.RateLimitStatus, len(windows))
+
+	g.updateAll(windowKeys(windows), func(entries []*memoryEntry, now time.Time) []*memoryEntry {
+		nowMs := float64(now.UnixMilli())
+		tats := make([]float64, len(windows))
+		newTats := make([]float64, len(windows))
+		fits := make([]bool, len(windows))
+		allowed := true
+
+		for i, window := range windows {
+			tats[i], newTats[i] = nowMs, nowMs
+			if !neverFits(window.Limit, window.Size) {
+				if entries[i] != nil {
+					tats[i] = math.Max(nowMs, entries[i].state.(float64))
+				}
+
+				size := float64(window.Size.Milliseconds())
+				newTats[i] = tats[i] + float64(n)*size/float64(window.Limit)
+				fits[i] = newTats[i]-size <= nowMs
+			}
+
+			allowed = allowed && fits[i]
+		}
+
+		updated := entries
+		if allowed {
+			updated = make([]*memoryEntry, len(windows))
+		}
+
+		for i, window := range windows {
+			if allowed {
+				tats[i] = newTats[i]
+				updated[i] = g.entry(tats[i], now)
+			}
+
+			statuses[i] = gcraWindowStatus(tats[i], nowMs, window, n, fits[i])
+		}
+
+		return updated
+	})
+
+	return statuses, nil
+}
+
+func (g *memoryGCRA) reserve(key string, limit int64, tWindow time.Duration, n int64, maxDelay time.Duration) *Reservation {
+	// A request that costs more than the tolerance never fits
+	if neverFits(limit, tWindow) || n > limit {
+		return &Reservation{
+			Status: &models.RateLimitStatus{
+				State:       models.Denied,
+				Count:       0,
+				Limit:       limit,
+				Remaining:   remaining(limit, 0),
+				ExpiresAtMs: g.now().Add(tWindow).UnixMilli(),
+			},
+		}
+	}
+
+	var reservation *Reservation
+	window := float64(tWindow.Milliseconds())
+	interval := window / float64(limit)
+
+	g.update(key, func(entry *memoryEntry, now time.Time) *memoryEntry {
+		nowMs := float64(now.UnixMilli())
+
+		tat := nowMs
+		if entry != nil {
+			tat = math.Max(nowMs, entry.state.(float64))
+		}
+
+		newTat := tat + float64(n)*interval
+		allowAt := newTat - window
+		delay := time.Duration(ceil(math.Max(0, allowAt-nowMs))) * time.Millisecond
+		if !allows(delay, maxDelay) {
+			reservation = &Reservation{
+				Delay: delay,
+				Status: &models.RateLimitStatus{
+					State:       models.Denied,
+					Count:       int(ceil((tat - nowMs) / interval)),
+					Limit:       limit,
+					Remaining:   remaining(limit, int(ceil((tat-nowMs)/interval))),
+					RetryAfter:  delay,
+					ExpiresAtMs: int64(ceil(allowAt)),
+				},
+			}
+			return entry
+		}
+
+		reservation = &Reservation{
+			OK:    true,
+			Delay: delay,
+			Status: &models.RateLimitStatus{
+				State:       models.Allowed,
+				Count:       int(ceil((newTat - nowMs) / interval)),
+				Limit:       limit,
+				Remaining:   remaining(limit, int(ceil((newTat-nowMs)/interval))),
+				ExpiresAtMs: int64(ceil(math.Max(nowMs, newTat+interval-window))),
+			},
+			cancel: func(context.Context) error {
+				g.release(key, interval, n)
+				return nil
+			},
+		}
+
+		return g.entry(newTat, now)
+	})
+
+	return reservation
+}
+
+// Refund moves the theoretical arrival time of a given key back by n emission intervals,
+// the same way the redis counterpart does.
+func (g *memoryGCRA) Refund(_ context.Context, key string, limit int64, tWindow time.Duration, _ string, n int64) error {
+	if err := checkCost(n); err != nil {
+		return err
+	}
+
+	if neverFits(limit, tWindow) {
+		return nil
+	}
+
+	g.release(key, float64(tWindow.Milliseconds())/float64(limit), n)
+	return nil
+}
+
+// release moves the theoretical arrival time of the key back by n emission intervals, when it still exists.
+func (g *memoryGCRA) release(key string, interval float64, n int64) {
+	g.update(key, func(entry *memoryEntry, now time.Time) *memoryEntry {
+		if entry == nil {
+			return nil
+		}
+		return g.entry(entry.state.(float64)-interval*float64(n), now)
+	})
+}
+
+// entry returns the memory entry of a theoretical arrival time, which expires as soon as it is in the past.
+func (g *memoryGCRA) entry(tat float64, now time.Time) *memoryEntry {
+	ttl := math.Ceil(tat - float64(now.UnixMilli()))
+	return &memoryEntry{state: tat, expiresAt: now.Add(time.Duration(ttl) * time.Millisecond)}
+}
@@ -0,0 +1,638 @@
+package rate_limiter
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/godoylucase/rate-limit/errs"
+	"github.com/godoylucase/rate-limit/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a manually advanced clock for the memory store.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestMemoryStore(t *testing.T) (*memoryStore, *fakeClock) {
+	clock := &fakeClock{now: time.UnixMilli(1_700_000_000_000)}
+
+	store := newMemoryStore(time.Hour)
+	store.now = clock.Now
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	return store, clock
+}
+
+// memoryLimiters builds every memory rate limiter by type.
+var memoryLimiters = map[string]func(store *memoryStore) RateLimiter{
+	FixedWindowCounter:   func(store *memoryStore) RateLimiter { return newMemoryFixedWindowCounter(store) },
+	SlidingWindowCounter: func(store *memoryStore) RateLimiter { return newMemorySlidingWindowCounter(store) },
+	TokenBucket:          func(store *memoryStore) RateLimiter { return newMemoryTokenBucket(store) },
+	GCRA:                 func(store *memoryStore) RateLimiter { return newMemoryGCRA(store) },
+}
+
+type memoryStep struct {
+	advance   time.Duration
+	wantState models.State
+	wantCount int
+}
+
+func TestMemoryRateLimiters_CheckLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		limiter func(store *memoryStore) RateLimiter
+		limit   int64
+		tWindow time.Duration
+		steps   []memoryStep
+	}{
+		{
+			name:    "fixed window resets at the end of the window",
+			limiter: func(store *memoryStore) RateLimiter { return newMemoryFixedWindowCounter(store) },
+			limit:   2,
+			tWindow: time.Second,
+			steps: []memoryStep{
+				{wantState: models.Allowed, wantCount: 1},
+				{advance: 500 * time.Millisecond, wantState: models.Allowed, wantCount: 2},
+				{advance: 400 * time.Millisecond, wantState: models.Denied, wantCount: 2},
+				{advance: 100 * time.Millisecond, wantState: models.Allowed, wantCount: 1},
+			},
+		},
+		{
+			name:    "sliding window lets requests out of the window",
+			limiter: func(store *memoryStore) RateLimiter { return newMemorySlidingWindowCounter(store) },
+			limit:   2,
+			tWindow: time.Second,
+			steps: []memoryStep{
+				{wantState: models.Allowed, wantCount: 1},
+				{advance: 500 * time.Millisecond, wantState: models.Allowed, wantCount: 2},
+				{advance: 400 * time.Millisecond, wantState: models.Denied, wantCount: 2},
+				{advance: 100 * time.Millisecond, wantState: models.Allowed, wantCount: 2},
+				{advance: 100 * time.Millisecond, wantState: models.Denied, wantCount: 2},
+			},
+		},
+		{
+			name:    "token bucket refills at a steady rate",
+			limiter: func(store *memoryStore) RateLimiter { return newMemoryTokenBucket(store) },
+			limit:   2,
+			tWindow: time.Second,
+			steps: []memoryStep{
+				{wantState: models.Allowed, wantCount: 1},
+				{wantState: models.Allowed, wantCount: 2},
+				{wantState: models.Denied, wantCount: 2},
+				{advance: 500 * time.Millisecond, wantState: models.Allowed, wantCount: 2},
+				{advance: time.Second, wantState: models.Allowed, wantCount: 1},
+			},
+		},
+		{
+			name:    "gcra spaces requests by the emission interval",
+			limiter: func(store *memoryStore) RateLimiter { return newMemoryGCRA(store) },
+			limit:   2,
+			tWindow: time.Second,
+			steps: []memoryStep{
+				{wantState: models.Allowed, wantCount: 1},
+				{wantState: models.Allowed, wantCount: 2},
+				{wantState: models.Denied, wantCount: 2},
+				{advance: 500 * time.Millisecond, wantState: models.Allowed, wantCount: 2},
+				{advance: time.Second, wantState: models.Allowed, wantCount: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, clock := newTestMemoryStore(t)
+			limiter := tt.limiter(store)
+
+			for i, step := range tt.steps {
+				clock.Advance(step.advance)
+
+				status, err := limiter.CheckLimit(context.Background(), "key", tt.limit, tt.tWindow)
+				require.NoError(t, err)
+				assert.Equal(t, step.wantState, status.State, "unexpected state at step %v", i)
+				assert.Equal(t, step.wantCount, status.Count, "unexpected count at step %v", i)
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimiters_CheckLimitN(t *testing.T) {
+	for typ, newLimiter := range memoryLimiters {
+		t.Run(typ, func(t *testing.T) {
+			ctx := context.Background()
+			store, clock := newTestMemoryStore(t)
+			limiter := newLimiter(store)
+
+			status, err := limiter.CheckLimitN(ctx, "key", 5, time.Second, 3)
+			require.NoError(t, err)
+			assert.Equal(t, models.Allowed, status.State)
+			assert.Equal(t, 3, status.Count)
+
+			// The remaining 2 units are not enough for a request of 3, which is not charged at all
+			status, err = limiter.CheckLimitN(ctx, "key", 5, time.Second, 3)
+			require.NoError(t, err)
+			assert.Equal(t, models.Denied, status.State)
+
+			status, err = limiter.CheckLimitN(ctx, "key", 5, time.Second, 2)
+			require.NoError(t, err)
+			assert.Equal(t, models.Allowed, status.State)
+			assert.Equal(t, 5, status.Count)
+
+			// A request that costs more than the limit never fits
+			clock.Advance(time.Hour)
+			status, err = limiter.CheckLimitN(ctx, "key", 5, time.Second, 6)
+			require.NoError(t, err)
+			assert.Equal(t, models.Denied, status.State)
+
+			reservation, err := limiter.ReserveN(ctx, "key", 5, time.Second, 6)
+			require.NoError(t, err)
+			assert.False(t, reservation.OK)
+
+			_, err = limiter.CheckLimitN(ctx, "key", 5, time.Second, 0)
+			require.ErrorIs(t, err, errs.ErrInvalidArguments)
+		})
+	}
+}
+
+func TestMemoryRateLimiters_CheckLimitsN(t *testing.T) {
+	for typ, newLimiter := range memoryLimiters {
+		t.Run(typ, func(t *testing.T) {
+			ctx := context.Background()
+			store, clock := newTestMemoryStore(t)
+			limiter := newLimiter(store)
+
+			windows := []Window{
+				{Key: "key:second", Limit: 2, Size: time.Second},
+				{Key: "key:minute", Limit: 3, Size: time.Minute},
+			}
+
+			for i := 0; i < 2; i++ {
+				statuses, err := limiter.CheckLimitsN(ctx, windows, 1)
+				require.NoError(t, err)
+				require.Len(t, statuses, 2)
+				assert.True(t, Allowed(statuses))
+				assert.Equal(t, i+1, statuses[1].Count)
+			}
+
+			// The per second window is exceeded, so the request is not charged to the per minute one either
+			statuses, err := limiter.CheckLimitsN(ctx, windows, 1)
+			require.NoError(t, err)
+			assert.False(t, Allowed(statuses))
+			assert.Equal(t, models.Denied, statuses[0].State)
+			assert.Equal(t, models.Allowed, statuses[1].State)
+
+			status, err := limiter.Status(ctx, "key:minute", 3, time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, 1, status.Remaining)
+
+			// Once the per second window frees up, the per minute one is the one exceeded by a request of 2 units
+			clock.Advance(time.Second)
+			statuses, err = limiter.CheckLimitsN(ctx, windows, 2)
+			require.NoError(t, err)
+			assert.False(t, Allowed(statuses))
+			assert.Equal(t, models.Allowed, statuses[0].State)
+			assert.Equal(t, models.Denied, statuses[1].State)
+
+			statuses, err = limiter.CheckLimitsN(ctx, windows, 1)
+			require.NoError(t, err)
+			assert.True(t, Allowed(statuses))
+
+			// Refunding every window with its request ID gives the request back
+			for i, window := range windows {
+				require.NoError(t, limiter.Refund(ctx, window.Key, window.Limit, window.Size, statuses[i].RequestID, 1))
+			}
+
+			status, err = limiter.Status(ctx, "key:minute", 3, time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, 1, status.Remaining)
+
+			_, err = limiter.CheckLimitsN(ctx, nil, 1)
+			require.ErrorIs(t, err, errs.ErrInvalidArguments)
+
+			_, err = limiter.CheckLimitsN(ctx, windows, 0)
+			require.ErrorIs(t, err, errs.ErrInvalidArguments)
+		})
+	}
+}
+
+func TestMemoryRateLimiters_RetryAfter(t *testing.T) {
+	// Two requests at once use the whole limit, and the third one is checked 100ms later
+	tests := map[string]time.Duration{
+		FixedWindowCounter:   900 * time.Millisecond, // The window started with the first request
+		SlidingWindowCounter: 900 * time.Millisecond, // The oldest request leaves the window
+		TokenBucket:          400 * time.Millisecond, // A token is refilled every 500ms
+		GCRA:                 400 * time.Millisecond, // Requests are spaced by 500ms
+	}
+
+	for typ, retryAfter := range tests {
+		t.Run(typ, func(t *testing.T) {
+			ctx := context.Background()
+			store, clock := newTestMemoryStore(t)
+			limiter := memoryLimiters[typ](store)
+
+			windows := []Window{
+				{Key: "key:second", Limit: 2, Size: time.Second},
+				{Key: "key:minute", Limit: 10, Size: time.Minute},
+			}
+
+			for i := 0; i < 2; i++ {
+				status, err := limiter.CheckLimit(ctx, "key", 2, time.Second)
+				require.NoError(t, err)
+				assert.Equal(t, models.Allowed, status.State)
+				assert.Equal(t, int64(2), status.Limit)
+				assert.Zero(t, status.RetryAfter)
+
+				_, err = limiter.CheckLimitsN(ctx, windows, 1)
+				require.NoError(t, err)
+			}
+			clock.Advance(100 * time.Millisecond)
+
+			status, err := limiter.CheckLimit(ctx, "key", 2, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, models.Denied, status.State)
+			assert.Equal(t, int64(2), status.Limit)
+			assert.Equal(t, retryAfter, status.RetryAfter)
+
+			peeked, err := limiter.Status(ctx, "key", 2, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, retryAfter, peeked.RetryAfter)
+
+			statuses, err := limiter.CheckLimitsN(ctx, windows, 1)
+			require.NoError(t, err)
+			assert.Equal(t, retryAfter, statuses[0].RetryAfter)
+			assert.Zero(t, statuses[1].RetryAfter)
+			assert.Equal(t, int64(10), statuses[1].Limit)
+
+			// A request that costs more than the limit never fits
+			status, err = limiter.CheckLimitN(ctx, "key", 2, time.Second, 3)
+			require.NoError(t, err)
+			assert.Equal(t, models.Denied, status.State)
+			assert.Zero(t, status.RetryAfter)
+
+			clock.Advance(retryAfter)
+			status, err = limiter.CheckLimit(ctx, "key", 2, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, models.Allowed, status.State)
+		})
+	}
+}
+
+func TestMemoryRateLimiters_ReserveNCancel(t *testing.T) {
+	for typ, newLimiter := range memoryLimiters {
+		t.Run(typ, func(t *testing.T) {
+			ctx := context.Background()
+			store, _ := newTestMemoryStore(t)
+			limiter := newLimiter(store)
+
+			_, err := limiter.CheckLimitN(ctx, "key", 5, time.Second, 3)
+			require.NoError(t, err)
+
+			reservation, err := limiter.ReserveN(ctx, "key", 5, time.Second, 3)
+			require.NoError(t, err)
+			require.True(t, reservation.OK)
+			assert.Positive(t, reservation.Delay)
+
+			// Cancelling gives back all the units of the request
+			require.NoError(t, reservation.Cancel(ctx))
+
+			status, err := limiter.CheckLimitN(ctx, "key", 5, time.Second, 2)
+			require.NoError(t, err)
+			assert.Equal(t, models.Allowed, status.State)
+		})
+	}
+}
+
+func TestMemoryRateLimiters_Status(t *testing.T) {
+	for typ, newLimiter := range memoryLimiters {
+		t.Run(typ, func(t *testing.T) {
+			ctx := context.Background()
+			store, clock := newTestMemoryStore(t)
+			limiter := newLimiter(store)
+
+			status, err := limiter.Status(ctx, "key", 3, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, models.Allowed, status.State)
+			assert.Equal(t, 0, status.Count)
+			assert.Equal(t, 3, status.Remaining)
+
+			for i := 0; i < 2; i++ {
+				_, err := limiter.CheckLimit(ctx, "key", 3, time.Second)
+				require.NoError(t, err)
+			}
+
+			// Peeking does not use any of the limit
+			for i := 0; i < 5; i++ {
+				status, err = limiter.Status(ctx, "key", 3, time.Second)
+				require.NoError(t, err)
+				assert.Equal(t, models.Allowed, status.State)
+				assert.Equal(t, 2, status.Count)
+				assert.Equal(t, 1, status.Remaining)
+			}
+
+			checked, err := limiter.CheckLimit(ctx, "key", 3, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, models.Allowed, checked.State)
+			assert.Equal(t, 0, checked.Remaining)
+
+			status, err = limiter.Status(ctx, "key", 3, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, models.Denied, status.State)
+			assert.Equal(t, 0, status.Remaining)
+			assert.Equal(t, checked.ExpiresAtMs, status.ExpiresAtMs)
+
+			clock.Advance(time.Second)
+			status, err = limiter.Status(ctx, "key", 3, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, models.Allowed, status.State)
+			assert.Equal(t, 3, status.Remaining)
+		})
+	}
+}
+
+func TestMemoryRateLimiters_ResetAndRefund(t *testing.T) {
+	for typ, newLimiter := range memoryLimiters {
+		t.Run(typ, func(t *testing.T) {
+			ctx := context.Background()
+			store, _ := newTestMemoryStore(t)
+			limiter := newLimiter(store)
+
+			var statuses []*models.RateLimitStatus
+			for i := 0; i < 3; i++ {
+				status, err := limiter.CheckLimit(ctx, "key", 3, time.Second)
+				require.NoError(t, err)
+				require.Equal(t, models.Allowed, status.State)
+				statuses = append(statuses, status)
+			}
+
+			require.NoError(t, limiter.Refund(ctx, "key", 3, time.Second, statuses[0].RequestID, 1))
+
+			status, err := limiter.Status(ctx, "key", 3, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, 1, status.Remaining)
+
+			require.ErrorIs(t, limiter.Refund(ctx, "key", 3, time.Second, "", 0), errs.ErrInvalidArguments)
+
+			require.NoError(t, limiter.Reset(ctx, "key"))
+
+			status, err = limiter.Status(ctx, "key", 3, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, 3, status.Remaining)
+		})
+	}
+}
+
+func TestMemoryRateLimiters_Keys(t *testing.T) {
+	for typ, newLimiter := range memoryLimiters {
+		t.Run(typ, func(t *testing.T) {
+			ctx := context.Background()
+			store, clock := newTestMemoryStore(t)
+			limiter := newLimiter(store)
+
+			for _, key := range []string{"{user}-news", "{user}-status:60000", "{other}-news"} {
+				_, err := limiter.CheckLimit(ctx, key, 3, time.Second)
+				require.NoError(t, err)
+			}
+			_, err := limiter.CheckLimit(ctx, "{user}-status:3600000", 3, time.Hour)
+			require.NoError(t, err)
+
+			keys, err := limiter.Keys(ctx, "{user}")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"{user}-news", "{user}-status:3600000", "{user}-status:60000"}, keys)
+
+			// The keys whose window expired are left out
+			clock.Advance(time.Second)
+			keys, err = limiter.Keys(ctx, "{user}")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"{user}-status:3600000"}, keys)
+		})
+	}
+}
+
+func TestMemorySlidingWindowCounter_RefundExactRequest(t *testing.T) {
+	ctx := context.Background()
+	store, clock := newTestMemoryStore(t)
+	limiter := newMemorySlidingWindowCounter(store)
+
+	first, err := limiter.CheckLimit(ctx, "key", 2, time.Second)
+	require.NoError(t, err)
+
+	clock.Advance(500 * time.Millisecond)
+	_, err = limiter.CheckLimit(ctx, "key", 2, time.Second)
+	require.NoError(t, err)
+
+	// Refunding the first request keeps the second one, which is still within the window after the first one left
+	require.NoError(t, limiter.Refund(ctx, "key", 2, time.Second, first.RequestID, 1))
+
+	clock.Advance(600 * time.Millisecond)
+	status, err := limiter.Status(ctx, "key", 2, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 1, status.Count)
+
+	require.ErrorIs(t, limiter.Refund(ctx, "key", 2, time.Second, "unknown", 1), errs.ErrInvalidArguments)
+	require.ErrorIs(t, limiter.Refund(ctx, "key", 2, time.Second, "", 1), errs.ErrInvalidArguments)
+}
+
+func TestMemoryFixedWindowCounter_RefundAfterRollover(t *testing.T) {
+	ctx := context.Background()
+	store, clock := newTestMemoryStore(t)
+	limiter := newMemoryFixedWindowCounter(store)
+
+	first, err := limiter.CheckLimit(ctx, "key", 2, time.Second)
+	require.NoError(t, err)
+
+	clock.Advance(time.Second)
+	second, err := limiter.CheckLimit(ctx, "key", 2, time.Second)
+	require.NoError(t, err)
+	require.NotEqual(t, first.RequestID, second.RequestID)
+
+	// The window of the first request ended, so its refund does not give units of the new window back
+	require.NoError(t, limiter.Refund(ctx, "key", 2, time.Second, first.RequestID, 1))
+
+	status, err := limiter.Status(ctx, "key", 2, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 1, status.Count)
+
+	require.NoError(t, limiter.Refund(ctx, "key", 2, time.Second, second.RequestID, 1))
+
+	status, err = limiter.Status(ctx, "key", 2, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Count)
+
+	require.ErrorIs(t, limiter.Refund(ctx, "key", 2, time.Second, "", 1), errs.ErrInvalidArguments)
+}
+
+func TestMemoryStore_EvictExpired(t *testing.T) {
+	store, clock := newTestMemoryStore(t)
+	limiter := newMemoryFixedWindowCounter(store)
+
+	_, err := limiter.CheckLimit(context.Background(), "key", 1, time.Second)
+	require.NoError(t, err)
+	assert.Len(t, store.shard("key").entries, 1)
+
+	clock.Advance(time.Second)
+	store.evictExpired()
+	assert.Empty(t, store.shard("key").entries)
+}
+
+func TestMemoryRateLimiters_CheckLimitConcurrently(t *testing.T) {
+	for _, typ := range []string{FixedWindowCounter, SlidingWindowCounter, TokenBucket, GCRA} {
+		t.Run(typ, func(t *testing.T) {
+			limiter := GetWithBackend(MemoryBackend, typ, nil)
+			defer limiter.(io.Closer).Close()
+
+			var allowed atomic.Int64
+			var wg sync.WaitGroup
+			for i := 0; i < 400; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					status, err := limiter.CheckLimit(context.Background(), "key", 100, time.Minute)
+					if assert.NoError(t, err) && status.State == models.Allowed {
+						allowed.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, int64(100), allowed.Load())
+		})
+	}
+}
+
+func TestMemoryRateLimiters_Reserve(t *testing.T) {
+	tests := []struct {
+		name      string
+		limiter   func(store *memoryStore) RateLimiter
+		wantDelay time.Duration
+	}{
+		{
+			name:      "fixed window reserves in the next window",
+			limiter:   func(store *memoryStore) RateLimiter { return newMemoryFixedWindowCounter(store) },
+			wantDelay: time.Second,
+		},
+		{
+			name:      "sliding window reserves when the oldest request leaves the window",
+			limiter:   func(store *memoryStore) RateLimiter { return newMemorySlidingWindowCounter(store) },
+			wantDelay: time.Second,
+		},
+		{
+			name:      "token bucket reserves the next refilled token",
+			limiter:   func(store *memoryStore) RateLimiter { return newMemoryTokenBucket(store) },
+			wantDelay: 500 * time.Millisecond,
+		},
+		{
+			name:      "gcra reserves the next emission interval",
+			limiter:   func(store *memoryStore) RateLimiter { return newMemoryGCRA(store) },
+			wantDelay: 500 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store, _ := newTestMemoryStore(t)
+			limiter := tt.limiter(store)
+
+			for i := 0; i < 2; i++ {
+				reservation, err := limiter.Reserve(ctx, "key", 2, time.Second)
+				require.NoError(t, err)
+				assert.True(t, reservation.OK)
+				assert.Zero(t, reservation.Delay)
+			}
+
+			reservation, err := limiter.Reserve(ctx, "key", 2, time.Second)
+			require.NoError(t, err)
+			assert.True(t, reservation.OK)
+			assert.Equal(t, tt.wantDelay, reservation.Delay)
+			assert.Equal(t, models.Allowed, reservation.Status.State)
+
+			// The reserved slot is taken, so the limit is reached right now
+			status, err := limiter.CheckLimit(ctx, "key", 2, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, models.Denied, status.State)
+
+			// A deadline before the slot is available does not reserve it
+			deadlineCtx, cancel := context.WithTimeout(ctx, tt.wantDelay/2)
+			defer cancel()
+
+			late, err := limiter.Reserve(deadlineCtx, "key", 2, time.Second)
+			require.NoError(t, err)
+			assert.False(t, late.OK)
+			assert.Equal(t, models.Denied, late.Status.State)
+			assert.NoError(t, late.Cancel(ctx))
+
+			// Cancelling gives the reserved slot back, only once
+			require.NoError(t, reservation.Cancel(ctx))
+			require.NoError(t, reservation.Cancel(ctx))
+
+			again, err := limiter.Reserve(ctx, "key", 2, time.Second)
+			require.NoError(t, err)
+			assert.True(t, again.OK)
+			assert.Equal(t, tt.wantDelay, again.Delay)
+		})
+	}
+}
+
+func TestMemoryRateLimiters_Wait(t *testing.T) {
+	for _, typ := range []string{FixedWindowCounter, SlidingWindowCounter, TokenBucket, GCRA} {
+		t.Run(typ, func(t *testing.T) {
+			limiter := GetWithBackend(MemoryBackend, typ, nil)
+			defer limiter.(io.Closer).Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			status, err := limiter.Wait(ctx, "key", 1, 100*time.Millisecond)
+			require.NoError(t, err)
+			assert.Equal(t, models.Allowed, status.State)
+
+			start := time.Now()
+			status, err = limiter.Wait(ctx, "key", 1, 100*time.Millisecond)
+			require.NoError(t, err)
+			assert.Equal(t, models.Allowed, status.State)
+			assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+			// The next slot is not available before the deadline
+			shortCtx, shortCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer shortCancel()
+
+			status, err = limiter.Wait(shortCtx, "key", 1, 100*time.Millisecond)
+			require.NoError(t, err)
+			assert.Equal(t, models.Denied, status.State)
+		})
+	}
+}
+
+func TestMemoryRateLimiters_WaitCancelled(t *testing.T) {
+	store, _ := newTestMemoryStore(t)
+	limiter := newMemorySlidingWindowCounter(store)
+
+	_, err := limiter.CheckLimit(context.Background(), "key", 1, time.Minute)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	_, err = limiter.Wait(ctx, "key", 1, time.Minute)
+	require.ErrorIs(t, err, context.Canceled)
+
+	// The cancelled wait gave its reserved slot back
+	assert.Len(t, store.shard("key").entries["key"].state.(*memorySlidingWindowState).requests, 1)
+}
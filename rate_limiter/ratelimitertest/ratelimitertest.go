@@ -0,0 +1,25 @@
+// Package ratelimitertest provides utilities for testing code that uses the rate limiters of the rate_limiter package.
+package ratelimitertest
+
+import (
+	"io"
+	"testing"
+
+	"github.com/godoylucase/rate-limit/rate_limiter"
+)
+
+// NewMemory returns a rate limiter of the type on the memory backend, which is closed when the test ends,
+// so every test gets rate limits of its own.
+func NewMemory(t testing.TB, typ string) rate_limiter.RateLimiter {
+	t.Helper()
+
+	rlimiter, err := rate_limiter.New(rate_limiter.MemoryBackend, typ, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = rlimiter.(io.Closer).Close()
+	})
+
+	return rlimiter
+}
@@ -0,0 +1,97 @@
+package rate_limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/godoylucase/rate-limit/errs"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisClient(t *testing.T) *redis.Client {
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	t.Cleanup(func() {
+		_ = redisClient.Close()
+	})
+
+	return redisClient
+}
+
+// testKey returns a key of its own for every test run, so runs against the same redis do not share windows.
+func testKey() string {
+	return "{" + ksuid.New().String() + "}-news"
+}
+
+func TestFixedWindowCounter_RefundAfterRollover(t *testing.T) {
+	ctx := context.Background()
+	limiter := newFixedWindowCounter(newTestRedisClient(t))
+	key := testKey()
+	window := 200 * time.Millisecond
+
+	first, err := limiter.CheckLimit(ctx, key, 2, window)
+	require.NoError(t, err)
+
+	time.Sleep(window + 50*time.Millisecond)
+	second, err := limiter.CheckLimit(ctx, key, 2, window)
+	require.NoError(t, err)
+	require.NotEqual(t, first.RequestID, second.RequestID)
+
+	// The window of the first request ended, so its refund does not give units of the new window back
+	require.NoError(t, limiter.Refund(ctx, key, 2, window, first.RequestID, 1))
+
+	status, err := limiter.Status(ctx, key, 2, window)
+	require.NoError(t, err)
+	assert.Equal(t, 1, status.Count)
+
+	require.NoError(t, limiter.Refund(ctx, key, 2, window, second.RequestID, 1))
+
+	status, err = limiter.Status(ctx, key, 2, window)
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Count)
+
+	require.ErrorIs(t, limiter.Refund(ctx, key, 2, window, "", 1), errs.ErrInvalidArguments)
+}
+
+func TestSlidingWindowCounter_RefundExactRequest(t *testing.T) {
+	ctx := context.Background()
+	redisClient := newTestRedisClient(t)
+	limiter := newSlidingWindowCounter(redisClient)
+	key := testKey()
+
+	first, err := limiter.CheckLimit(ctx, key, 2, time.Minute)
+	require.NoError(t, err)
+	second, err := limiter.CheckLimit(ctx, key, 2, time.Minute)
+	require.NoError(t, err)
+
+	// Only the members of the refunded request are removed, even though the other one was added later
+	require.NoError(t, limiter.Refund(ctx, key, 2, time.Minute, first.RequestID, 1))
+
+	members, err := redisClient.ZRange(ctx, key, 0, -1).Result()
+	require.NoError(t, err)
+	assert.Equal(t, []string{second.RequestID + ":1"}, members)
+
+	require.ErrorIs(t, limiter.Refund(ctx, key, 2, time.Minute, "", 1), errs.ErrInvalidArguments)
+}
+
+func TestGCRA_StoresExactTAT(t *testing.T) {
+	ctx := context.Background()
+	redisClient := newTestRedisClient(t)
+	key := testKey()
+	now := time.Now().UnixMilli()
+
+	// An emission interval of a third of the window has more digits than tostring keeps
+	res, err := gcraScript.Run(ctx, redisClient, []string{key}, 3, 1000000, now, -1, 1).Slice()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), res[0])
+
+	tat, err := redisClient.Get(ctx, key).Float64()
+	require.NoError(t, err)
+	assert.Equal(t, float64(now)+1000000.0/3, tat)
+}
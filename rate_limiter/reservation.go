@@ -0,0 +1,115 @@
+package rate_limiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godoylucase/rate-limit/errs"
+	"github.com/godoylucase/rate-limit/models"
+)
+
+// unboundedDelay is the maximum delay of reservations made with a context without deadline.
+const unboundedDelay = time.Duration(-1)
+
+// Reservation is a slot reserved by Reserve, which the caller is allowed to use once Delay has elapsed.
+// A reservation that is not OK could not be made within the context deadline, so it holds no slot,
+// and its Delay is how long it would have needed to wait.
+type Reservation struct {
+	OK     bool
+	Delay  time.Duration
+	Status *models.RateLimitStatus
+
+	cancel     func(ctx context.Context) error
+	cancelOnce sync.Once
+}
+
+// Cancel gives the reserved slot back, so it can be used by other requests.
+// It does nothing when the reservation is not OK, and only the first call has any effect.
+func (r *Reservation) Cancel(ctx context.Context) error {
+	if !r.OK || r.cancel == nil {
+		return nil
+	}
+
+	var err error
+	r.cancelOnce.Do(func() {
+		err = r.cancel(ctx)
+	})
+
+	return err
+}
+
+// allows tells whether a reservation delayed by delay can be made within maxDelay.
+func allows(delay, maxDelay time.Duration) bool {
+	return maxDelay == unboundedDelay || delay <= maxDelay
+}
+
+// delayMs returns the maximum delay in milliseconds for the server side scripts, where -1 means unbounded.
+func delayMs(maxDelay time.Duration) int64 {
+	if maxDelay == unboundedDelay {
+		return -1
+	}
+
+	return maxDelay.Milliseconds()
+}
+
+// maxDelay returns how long a reservation can be delayed to be used before the context deadline.
+func maxDelay(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return unboundedDelay
+	}
+
+	if untilDeadline := time.Until(deadline); untilDeadline > 0 {
+		return untilDeadline
+	}
+
+	return 0
+}
+
+// neverFits tells whether no request ever fits within a window of tWindow holding limit units, which is the case
+// of a limit under one unit and of a window shorter than a millisecond, the resolution of the timestamps.
+func neverFits(limit int64, tWindow time.Duration) bool {
+	return limit < 1 || tWindow.Milliseconds() < 1
+}
+
+// wait blocks until the reservation can be used, and returns its status.
+// It returns the Denied status right away when the reservation could not be made within the context deadline,
+// and it cancels the reservation when the context is done before the delay elapses.
+func wait(ctx context.Context, r *Reservation) (*models.RateLimitStatus, error) {
+	if !r.OK || r.Delay <= 0 {
+		return r.Status, nil
+	}
+
+	timer := time.NewTimer(r.Delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return r.Status, nil
+	case <-ctx.Done():
+		// The caller is no longer interested, so the slot is given back with a context of its own
+		_ = r.Cancel(context.WithoutCancel(ctx))
+		return nil, ctx.Err()
+	}
+}
+
+// checkCost returns an error when the cost of a request is not a positive number of units.
+func checkCost(n int64) error {
+	if n < 1 {
+		return fmt.Errorf("invalid cost %v, it must be at least 1: %w", n, errs.ErrInvalidArguments)
+	}
+
+	return nil
+}
+
+// remaining returns how many units of the limit are left when count of them are in use.
+func remaining(limit int64, count int) int {
+	return int(max(0, limit-int64(count)))
+}
+
+// retryAfter returns how long it takes, as of nowMs, to get to the timestamp atMs, which is zero once it passed.
+func retryAfter(atMs, nowMs int64) time.Duration {
+	return time.Duration(max(0, atMs-nowMs)) * time.Millisecond
+}
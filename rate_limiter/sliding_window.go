@@ -3,96 +3,390 @@ package rate_limiter
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"time"
 
+	"github.com/godoylucase/rate-limit/errs"
 	"github.com/godoylucase/rate-limit/models"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/segmentio/ksuid"
 )
 
+// slidingWindowScript removes the requests that fell out of the window from the sorted set stored at KEYS[1],
+// and adds the n units of the current request to it unless the remaining ones leave no room for them.
+// Units are scored by their timestamp, and the whole set expires a window after the last one.
+// When the maximum delay allows it, a request that does not fit is reserved at the time enough units free up,
+// that is a window after the last unit it replaces, so reserved units are scored in the future.
+//
+// ARGV[1] is the limit, ARGV[2] the window in milliseconds, ARGV[3] the current timestamp in milliseconds,
+// ARGV[4] the unique member identifying the current request, ARGV[5] the maximum delay in milliseconds,
+// -1 meaning unbounded, and ARGV[6] the cost n of the request. Each unit is added as the member followed by
+// a colon and its index, from 1 to n.
+// It returns whether the request was added, the number of units within the window
+// and the milliseconds until the request fits.
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local maxDelay = tonumber(ARGV[5])
+local n = tonumber(ARGV[6])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+
+local count = redis.call('ZCARD', KEYS[1])
+if limit < 1 or window < 1 or n > limit then
+	return {0, count, 0}
+end
+
+local at = now
+if count + n > limit then
+	local idx = count + n - limit - 1
+	local replaced = redis.call('ZRANGE', KEYS[1], idx, idx, 'WITHSCORES')
+	at = math.max(now, tonumber(replaced[2]) + window)
+end
+
+local delay = at - now
+if maxDelay >= 0 and delay > maxDelay then
+	return {0, count, delay}
+end
+
+for i = 1, n do
+	redis.call('ZADD', KEYS[1], at, ARGV[4] .. ':' .. i)
+end
+redis.call('PEXPIRE', KEYS[1], delay + window)
+
+return {1, count + n, delay}
+`)
+
+// slidingWindowsScript adds the n units of the current request to every sliding window stored at KEYS,
+// only when they fit within all of them, so either every window holds the request or none does.
+// Each window is kept the same way slidingWindowScript does, and requests are never reserved in the future.
+//
+// ARGV[1] is the current timestamp in milliseconds, ARGV[2] the cost n of the request and ARGV[3] the unique member
+// identifying it, followed by the limit and the window in milliseconds of each key.
+// It returns whether the request was added, followed by whether it fits, the number of units of each window,
+// which include the request only when it was added, and the milliseconds until the request fits within it,
+// that is a window after the oldest unit that has to leave it.
+var slidingWindowsScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+
+local windows = {}
+local allowed = 1
+for i, key in ipairs(KEYS) do
+	local limit = tonumber(ARGV[2 * i + 2])
+	local window = tonumber(ARGV[2 * i + 3])
+
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+
+	local count = redis.call('ZCARD', key)
+	local fits = 0
+	local retry = 0
+	if limit >= 1 and window >= 1 and count + n <= limit then
+		fits = 1
+	else
+		allowed = 0
+		if limit >= 1 and window >= 1 and n <= limit then
+			local idx = count + n - limit - 1
+			local oldest = redis.call('ZRANGE', key, idx, idx, 'WITHSCORES')
+			retry = math.max(0, tonumber(oldest[2]) + window - now)
+		end
+	end
+	windows[i] = {fits, count, window, retry}
+end
+
+local result = {allowed}
+for i, key in ipairs(KEYS) do
+	local w = windows[i]
+	if allowed == 1 then
+		for j = 1, n do
+			redis.call('ZADD', key, now, ARGV[3] .. ':' .. j)
+		end
+		redis.call('PEXPIRE', key, w[3])
+		w[2] = w[2] + n
+	end
+	table.insert(result, w[1])
+	table.insert(result, w[2])
+	table.insert(result, w[4])
+end
+
+return result
+`)
+
 type slidingWindowCounter struct {
-	redis *redis.Client
+	redis redis.UniversalClient
 }
 
-func newSlidingWindowCounter(redis *redis.Client) *slidingWindowCounter {
+func newSlidingWindowCounter(redis redis.UniversalClient) *slidingWindowCounter {
 	return &slidingWindowCounter{
 		redis: redis,
 	}
 }
 
 // CheckLimit checks the rate limit for a given key within a sliding window.
-// It counts the number of non-expired requests in the sorted set and compares it to the specified limit.
-// If the number of requests exceeds the limit, it returns a RateLimitStatus with the state set to Denied.
-// Otherwise, it returns a RateLimitStatus with the state set to Allowed.
-// The RateLimitStatus also includes the count of requests and the expiration timestamp in milliseconds.
+// It removes the expired requests from the sorted set and counts the remaining ones.
+// If the number of requests already reached the limit, it returns a RateLimitStatus with the state set to Denied.
+// Otherwise, it adds the current request and returns a RateLimitStatus with the state set to Allowed.
+// The RateLimitStatus also includes the count of requests and the expiration timestamp in milliseconds,
+// which for a Denied request is when it fits, a window after the oldest request that has to leave the window,
+// as also told by its RetryAfter.
 // The sliding window duration is specified by tWindow.
 // The key is used to identify the rate limit in the sorted set.
-// The function uses a Redis pipeline to efficiently execute multiple Redis commands in a single round trip.
+// The whole check is performed atomically by a server side script in a single round trip.
 // If any error occurs during the execution, it returns an error.
 func (swc *slidingWindowCounter) CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
-	now := time.Now()
-	expiresAtMs := now.Add(tWindow)
-	// Calculate the minimum timestamp allowed within the sliding window
-	minimum := now.Add(-tWindow)
-
-	// Count how many non-expired requests we have in the sorted set before adding the current request
-	result, err := swc.redis.ZCount(ctx, key, strconv.FormatInt(minimum.UnixMilli(), 10), "+inf").Uint64()
-	if err == nil && int64(result) >= limit {
-		return &models.RateLimitStatus{
-			State:       models.Denied,
-			Count:       int(result),
-			ExpiresAtMs: expiresAtMs.UnixMilli(),
-		}, nil
+	return swc.CheckLimitN(ctx, key, limit, tWindow, 1)
+}
+
+// CheckLimitN works like CheckLimit for a request that costs n units, which are added to the sorted set
+// all at once only when all of them fit within the sliding window.
+func (swc *slidingWindowCounter) CheckLimitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	reservation, err := swc.reserve(ctx, key, limit, tWindow, n, 0)
+	if err != nil {
+		return nil, err
 	}
 
-	pipe := swc.redis.TxPipeline()
-	// Remove all requests that have already expired within the sliding window
-	removeByScore := pipe.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(minimum.UnixMilli(), 10))
+	return reservation.Status, nil
+}
 
-	// Add the current request to the sorted set
-	add := pipe.ZAdd(ctx, key, &redis.Z{
-		Score:  float64(now.UnixMilli()),
-		Member: ksuid.New(),
+// Reserve reserves a request at the time a slot frees up within the sliding window, as long as that happens
+// before the context deadline. The returned reservation tells how long to wait for that time.
+func (swc *slidingWindowCounter) Reserve(ctx context.Context, key string, limit int64, tWindow time.Duration) (*Reservation, error) {
+	return swc.ReserveN(ctx, key, limit, tWindow, 1)
+}
+
+// ReserveN works like Reserve for a request that costs n units, reserved at the time n slots are free.
+func (swc *slidingWindowCounter) ReserveN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*Reservation, error) {
+	return swc.reserve(ctx, key, limit, tWindow, n, maxDelay(ctx))
+}
+
+// Wait blocks until the request fits within the sliding window, or returns a Denied status right away
+// when that does not happen before the context deadline.
+func (swc *slidingWindowCounter) Wait(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return swc.WaitN(ctx, key, limit, tWindow, 1)
+}
+
+// WaitN works like Wait for a request that costs n units.
+func (swc *slidingWindowCounter) WaitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	reservation, err := swc.ReserveN(ctx, key, limit, tWindow, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return wait(ctx, reservation)
+}
+
+// Status returns the current status of the sliding window for a given key without adding a request.
+// The State tells whether a request would be allowed right now, the count and the remaining units include
+// the requests reserved in the future, and the expiresAtMs is the timestamp when the last request
+// leaves the window, or the current timestamp when the window is empty.
+// When the window is full, RetryAfter tells when the oldest request that has to leave it for another one to fit does.
+func (swc *slidingWindowCounter) Status(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	now := time.Now()
+	key = hashTagged(key)
+	minimum := fmt.Sprintf("(%v", now.Add(-tWindow).UnixMilli())
+
+	var count *redis.IntCmd
+	var last *redis.ZSliceCmd
+	_, err := swc.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.ZCount(ctx, key, minimum, "+inf")
+		last = pipe.ZRangeWithScores(ctx, key, -1, -1)
+		return nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sliding window status for key: %v with error: %w", key, err)
+	}
 
-	// Count how many non-expired requests we have in the sorted set
-	count := pipe.ZCount(ctx, key, "-inf", "+inf")
+	lastAt := now.UnixMilli() - tWindow.Milliseconds()
+	if members := last.Val(); len(members) > 0 {
+		lastAt = int64(members[0].Score)
+	}
 
-	if _, err := pipe.Exec(ctx); err != nil {
-		return nil, fmt.Errorf("failed to execute sorted set pipeline for key: %v with error: %w", key, err)
+	// The request that has to leave the window is the oldest one beyond the limit
+	freeAt := now.UnixMilli()
+	if !neverFits(limit, tWindow) && count.Val() >= limit {
+		oldest, err := swc.redis.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+			Min:    minimum,
+			Max:    "+inf",
+			Offset: count.Val() - limit,
+			Count:  1,
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get sliding window oldest request for key: %v with error: %w", key, err)
+		}
+		if len(oldest) > 0 {
+			freeAt = int64(oldest[0].Score) + tWindow.Milliseconds()
+		}
 	}
 
-	// Check for errors in removing expired items
-	if err := removeByScore.Err(); err != nil {
-		return nil, fmt.Errorf("failed to remove items from key: %v with error: %w", key, err)
+	return slidingWindowStatus(count.Val(), lastAt, freeAt, now.UnixMilli(), limit, tWindow), nil
+}
+
+// Keys returns the keys starting with prefix, such as the hash tag of a user, sorted.
+// The keys are returned as they are stored, so they can be given back to Reset.
+func (swc *slidingWindowCounter) Keys(ctx context.Context, prefix string) ([]string, error) {
+	return scanKeys(ctx, swc.redis, prefix)
+}
+
+// Reset clears the sliding window of a given key, removing all its requests.
+func (swc *slidingWindowCounter) Reset(ctx context.Context, key string) error {
+	key = hashTagged(key)
+
+	if err := swc.redis.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to reset sliding window for key: %v with error: %w", key, err)
+	}
+
+	return nil
+}
+
+// Refund removes n units of the request identified by requestID from the sliding window of a given key,
+// which are the exact members added for it, so the request ID is required.
+func (swc *slidingWindowCounter) Refund(ctx context.Context, key string, _ int64, _ time.Duration, requestID string, n int64) error {
+	if err := checkCost(n); err != nil {
+		return err
 	}
 
-	// Check for errors in adding the current item
-	if err := add.Err(); err != nil {
-		return nil, fmt.Errorf("failed to add item to key: %v with error: %w", key, err)
+	if requestID == "" {
+		return fmt.Errorf("invalid request id %v: %w", requestID, errs.ErrInvalidArguments)
 	}
 
-	// Retrieve the total number of non-expired requests
-	total, err := count.Result()
+	key = hashTagged(key)
+
+	members := make([]interface{}, 0, n)
+	for i := int64(1); i <= n; i++ {
+		members = append(members, fmt.Sprintf("%v:%v", requestID, i))
+	}
+
+	if err := swc.redis.ZRem(ctx, key, members...).Err(); err != nil {
+		return fmt.Errorf("failed to refund sliding window for key: %v with error: %w", key, err)
+	}
+
+	return nil
+}
+
+// CheckLimitsN checks a request that costs n units against every given sliding window at once,
+// adding it to all of them only when it fits within each one. It returns the status of every window,
+// in the same order, whose State tells whether the request fits within it, and the count includes the request
+// only when every window allowed it. The units share the same member in every window, which is the RequestID
+// of the statuses. The RetryAfter of a window the request does not fit within is based on its oldest units.
+// The whole check is performed atomically by a server side script.
+func (swc *slidingWindowCounter) CheckLimitsN(ctx context.Context, windows []Window, n int64) ([]*models.RateLimitStatus, error) {
+	if err := checkWindows(windows, n); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	member := ksuid.New().String()
+	keys, args := windowsArgs(windows, now.UnixMilli(), n, member)
+
+	result, err := slidingWindowsScript.Run(ctx, swc.redis, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run sliding windows script for keys: %v with error: %w", keys, err)
+	}
+
+	if len(result) != 1+3*len(windows) {
+		return nil, fmt.Errorf("unexpected sliding windows result for keys: %v with length: %v", keys, len(result))
+	}
+
+	allowed := result[0] == 1
+	statuses := make([]*models.RateLimitStatus, 0, len(windows))
+	for i, window := range windows {
+		fits, count, retry := result[1+3*i] == 1, result[2+3*i], time.Duration(result[3+3*i])*time.Millisecond
+
+		status := slidingWindowStatus(count, now.UnixMilli(), now.UnixMilli(), now.UnixMilli(), window.Limit, window.Size)
+		checkedWindow(status, fits, retry)
+		if allowed {
+			status.RequestID = member
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func (swc *slidingWindowCounter) reserve(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64, maxDelay time.Duration) (*Reservation, error) {
+	if err := checkCost(n); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	key = hashTagged(key)
+	member := ksuid.New().String()
+
+	result, err := slidingWindowScript.Run(ctx, swc.redis, []string{key}, limit, tWindow.Milliseconds(), now.UnixMilli(), member, delayMs(maxDelay), n).Int64Slice()
 	if err != nil {
-		return nil, fmt.Errorf("failed to count items for key: %v with error: %w", key, err)
+		return nil, fmt.Errorf("failed to run sliding window script for key: %v with error: %w", key, err)
 	}
 
+	if len(result) != 3 {
+		return nil, fmt.Errorf("unexpected sliding window result for key: %v with length: %v", key, len(result))
+	}
+
+	allowed, total, delay := result[0] == 1, result[1], time.Duration(result[2])*time.Millisecond
+	expiresAtMs := now.Add(delay + tWindow)
+
 	// Check if the total requests exceed the specified limit
-	if total > limit {
-		return &models.RateLimitStatus{
-			State:       models.Denied,
-			Count:       int(total),
-			ExpiresAtMs: expiresAtMs.UnixMilli(),
+	if !allowed {
+		return &Reservation{
+			OK:     false,
+			Delay:  delay,
+			Status: slidingWindowDenied(total, now, delay, limit, tWindow, n),
 		}, nil
 	}
 
 	// No rate limit exceeded
-	return &models.RateLimitStatus{
-		State:       models.Allowed,
-		Count:       int(total),
-		ExpiresAtMs: expiresAtMs.UnixMilli(),
+	return &Reservation{
+		OK:    true,
+		Delay: delay,
+		Status: &models.RateLimitStatus{
+			State:       models.Allowed,
+			Count:       int(total),
+			Limit:       limit,
+			Remaining:   remaining(limit, int(total)),
+			ExpiresAtMs: expiresAtMs.UnixMilli(),
+			RequestID:   member,
+		},
+		cancel: func(ctx context.Context) error {
+			return swc.Refund(ctx, key, limit, tWindow, member, n)
+		},
 	}, nil
 }
+
+// slidingWindowDenied returns the status of a request that costs n units and was denied by a sliding window
+// holding count units, which expires when the request fits, that is after delay, unless it never does.
+func slidingWindowDenied(count int64, now time.Time, delay time.Duration, limit int64, tWindow time.Duration, n int64) *models.RateLimitStatus {
+	status := &models.RateLimitStatus{
+		State:       models.Denied,
+		Count:       int(count),
+		Limit:       limit,
+		Remaining:   remaining(limit, int(count)),
+		RetryAfter:  delay,
+		ExpiresAtMs: now.Add(delay).UnixMilli(),
+	}
+	if neverFits(limit, tWindow) || n > limit {
+		status.RetryAfter = 0
+		status.ExpiresAtMs = now.Add(tWindow).UnixMilli()
+	}
+
+	return status
+}
+
+// slidingWindowStatus returns the status as of nowMs of a sliding window holding count units,
+// the last of them at lastAt. When the window is full, a request fits again at freeAt.
+func slidingWindowStatus(count, lastAt, freeAt, nowMs, limit int64, tWindow time.Duration) *models.RateLimitStatus {
+	status := &models.RateLimitStatus{
+		State:       models.Allowed,
+		Count:       int(count),
+		Limit:       limit,
+		Remaining:   remaining(limit, int(count)),
+		ExpiresAtMs: max(nowMs, lastAt+tWindow.Milliseconds()),
+	}
+	if neverFits(limit, tWindow) {
+		status.State = models.Denied
+	} else if count >= limit {
+		status.State = models.Denied
+		status.RetryAfter = retryAfter(freeAt, nowMs)
+	}
+
+	return status
+}
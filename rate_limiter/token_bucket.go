@@ -0,0 +1,436 @@
+package rate_limiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/godoylucase/rate-limit/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucketScript refills the bucket stored at KEYS[1] for the time elapsed since the last request
+// and takes the n tokens of the request from it when available. The bucket is kept as a hash holding the current
+// amount of tokens and the timestamp of the last refill, and it expires once it would be full again.
+// When the maximum delay allows it, the tokens are taken in advance, leaving the bucket with a negative amount
+// of tokens, and the request has to wait until those tokens are refilled.
+//
+// ARGV[1] is the bucket capacity, ARGV[2] the time in milliseconds it takes to refill an empty bucket,
+// ARGV[3] the current timestamp in milliseconds, ARGV[4] the maximum delay in milliseconds, -1 meaning unbounded,
+// and ARGV[5] the cost n of the request.
+// It returns whether the tokens were taken, the remaining tokens and the milliseconds until the tokens are available.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local maxDelay = tonumber(ARGV[4])
+local n = tonumber(ARGV[5])
+local rate = capacity / interval
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) * rate)
+	ts = now
+end
+
+local delay = 0
+if tokens < n then
+	delay = math.ceil((n - tokens) / rate)
+end
+
+local allowed = 0
+if maxDelay < 0 or delay <= maxDelay then
+	tokens = tokens - n
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+redis.call('PEXPIRE', KEYS[1], math.max(1, math.ceil((capacity - tokens) / rate)))
+
+return {allowed, tostring(tokens), delay}
+`)
+
+// tokenBucketRefundScript puts n tokens back into the bucket stored at KEYS[1], when it still exists.
+//
+// ARGV[1] is the bucket capacity and ARGV[2] the number of tokens to put back.
+var tokenBucketRefundScript = redis.NewScript(`
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens'))
+if tokens == nil then
+	return 0
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(math.min(tonumber(ARGV[1]), tokens + tonumber(ARGV[2]))))
+return 1
+`)
+
+// tokenBucketsScript takes the n tokens of the current request from every bucket stored at KEYS,
+// only when all of them have the tokens available, so either every bucket gives its tokens or none does.
+// Each bucket is kept the same way tokenBucketScript does, and tokens are never taken in advance.
+//
+// ARGV[1] is the current timestamp in milliseconds and ARGV[2] the cost n of the request, followed by the capacity
+// and the time in milliseconds it takes to refill each bucket.
+// It returns whether the tokens were taken, followed by whether they fit and the tokens of each bucket,
+// without the ones of the request unless they were taken.
+var tokenBucketsScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local n = tonumber(ARGV[2])
+
+local buckets = {}
+local allowed = 1
+for i, key in ipairs(KEYS) do
+	local capacity = tonumber(ARGV[2 * i + 1])
+	local interval = tonumber(ARGV[2 * i + 2])
+
+	local fits = 0
+	local tokens = 0
+	local rate = 0
+	if capacity >= 1 and interval >= 1 then
+		rate = capacity / interval
+
+		local bucket = redis.call('HMGET', key, 'tokens', 'ts')
+		tokens = tonumber(bucket[1])
+		local ts = tonumber(bucket[2])
+		if tokens == nil or ts == nil then
+			tokens = capacity
+			ts = now
+		end
+
+		if now > ts then
+			tokens = math.min(capacity, tokens + (now - ts) * rate)
+		end
+
+		if tokens >= n then
+			fits = 1
+		end
+	end
+
+	if fits == 0 then
+		allowed = 0
+	end
+	buckets[i] = {fits, tokens, capacity, rate}
+end
+
+local result = {allowed}
+for i, key in ipairs(KEYS) do
+	local b = buckets[i]
+	if allowed == 1 then
+		b[2] = b[2] - n
+		redis.call('HSET', key, 'tokens', tostring(b[2]), 'ts', tostring(now))
+		redis.call('PEXPIRE', key, math.max(1, math.ceil((b[3] - b[2]) / b[4])))
+	end
+	table.insert(result, b[1])
+	table.insert(result, tostring(b[2]))
+end
+
+return result
+`)
+
+type tokenBucket struct {
+	redis redis.UniversalClient
+}
+
+func newTokenBucket(redis redis.UniversalClient) *tokenBucket {
+	return &tokenBucket{
+		redis: redis,
+	}
+}
+
+// CheckLimit checks the rate limit for a given key using a token bucket.
+// The limit is the capacity of the bucket, that is the maximum burst of requests allowed at once,
+// and tWindow is the time it takes to refill an empty bucket, so tokens are added back at a steady
+// rate of limit/tWindow.
+// Each request takes a token from the bucket. If there is a token available it returns a RateLimitStatus
+// with State Allowed, otherwise it returns a RateLimitStatus with State Denied.
+// The RateLimitStatus also includes the count, which is the number of tokens in use,
+// and the expiresAtMs, which is the timestamp in milliseconds when the next token is available.
+// The RetryAfter of a Denied request tells how long it has to wait for all of its tokens to be refilled.
+// The refill and the take are performed atomically by a server side script.
+// It returns the RateLimitStatus and any error encountered during the process.
+func (tb *tokenBucket) CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return tb.CheckLimitN(ctx, key, limit, tWindow, 1)
+}
+
+// CheckLimitN works like CheckLimit for a request that costs n tokens, which are taken all at once
+// only when all of them are available.
+func (tb *tokenBucket) CheckLimitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	reservation, err := tb.reserve(ctx, key, limit, tWindow, n, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation.Status, nil
+}
+
+// Reserve takes a token in advance, as long as it is refilled before the context deadline.
+// The returned reservation tells how long to wait for the token to be refilled.
+func (tb *tokenBucket) Reserve(ctx context.Context, key string, limit int64, tWindow time.Duration) (*Reservation, error) {
+	return tb.ReserveN(ctx, key, limit, tWindow, 1)
+}
+
+// ReserveN works like Reserve for a request that costs n tokens.
+func (tb *tokenBucket) ReserveN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*Reservation, error) {
+	return tb.reserve(ctx, key, limit, tWindow, n, maxDelay(ctx))
+}
+
+// Wait blocks until a token is available, or returns a Denied status right away
+// when that does not happen before the context deadline.
+func (tb *tokenBucket) Wait(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	return tb.WaitN(ctx, key, limit, tWindow, 1)
+}
+
+// WaitN works like Wait for a request that costs n tokens.
+func (tb *tokenBucket) WaitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	reservation, err := tb.ReserveN(ctx, key, limit, tWindow, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return wait(ctx, reservation)
+}
+
+// Status returns the current status of the bucket for a given key without taking a token.
+// The State tells whether a token is available right now, and the count, the remaining tokens,
+// the expiresAtMs and the RetryAfter have the same meaning as in CheckLimit.
+func (tb *tokenBucket) Status(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	now := time.Now()
+	key = hashTagged(key)
+
+	values, err := tb.redis.HMGet(ctx, key, "tokens", "ts").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token bucket status for key: %v with error: %w", key, err)
+	}
+
+	tokens, ts := float64(limit), now.UnixMilli()
+	if values[0] != nil && values[1] != nil {
+		if tokens, err = parseFloat(values[0]); err != nil {
+			return nil, fmt.Errorf("failed to parse token bucket tokens for key: %v with error: %w", key, err)
+		}
+		if ts, err = parseInt(values[1]); err != nil {
+			return nil, fmt.Errorf("failed to parse token bucket timestamp for key: %v with error: %w", key, err)
+		}
+	}
+
+	return tokenBucketStatus(tokens, ts, now.UnixMilli(), limit, tWindow), nil
+}
+
+// Keys returns the keys starting with prefix, such as the hash tag of a user, sorted.
+// The keys are returned as they are stored, so they can be given back to Reset.
+func (tb *tokenBucket) Keys(ctx context.Context, prefix string) ([]string, error) {
+	return scanKeys(ctx, tb.redis, prefix)
+}
+
+// Reset clears the bucket of a given key, so it is full again.
+func (tb *tokenBucket) Reset(ctx context.Context, key string) error {
+	key = hashTagged(key)
+
+	if err := tb.redis.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to reset token bucket for key: %v with error: %w", key, err)
+	}
+
+	return nil
+}
+
+// Refund puts n tokens back into the bucket of a given key, when it still exists, up to its capacity.
+// The request ID is not used, since the bucket only keeps the amount of tokens.
+func (tb *tokenBucket) Refund(ctx context.Context, key string, limit int64, _ time.Duration, _ string, n int64) error {
+	if err := checkCost(n); err != nil {
+		return err
+	}
+
+	key = hashTagged(key)
+
+	if err := tokenBucketRefundScript.Run(ctx, tb.redis, []string{key}, limit, n).Err(); err != nil {
+		return fmt.Errorf("failed to refund token bucket for key: %v with error: %w", key, err)
+	}
+
+	return nil
+}
+
+// CheckLimitsN checks a request that costs n tokens against every given bucket at once, taking the tokens
+// from all of them only when each one has them available. It returns the status of every bucket,
+// in the same order, whose State tells whether the tokens are available in it, and the count includes the request
+// only when every bucket allowed it. The whole check is performed atomically by a server side script.
+func (tb *tokenBucket) CheckLimitsN(ctx context.Context, windows []Window, n int64) ([]*models.RateLimitStatus, error) {
+	if err := checkWindows(windows, n); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	keys, args := windowsArgs(windows, now.UnixMilli(), n)
+
+	result, err := tokenBucketsScript.Run(ctx, tb.redis, keys, args...).Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run token buckets script for keys: %v with error: %w", keys, err)
+	}
+
+	if len(result) != 1+2*len(windows) {
+		return nil, fmt.Errorf("unexpected token buckets result for keys: %v with length: %v", keys, len(result))
+	}
+
+	statuses := make([]*models.RateLimitStatus, 0, len(windows))
+	for i, window := range windows {
+		fits, ok := result[1+2*i].(int64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected token buckets fits value for key: %v: %v", keys[i], result[1+2*i])
+		}
+
+		tokens, err := parseFloat(result[2+2*i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse token buckets tokens for key: %v with error: %w", keys[i], err)
+		}
+
+		status := tokenBucketStatus(tokens, now.UnixMilli(), now.UnixMilli(), window.Limit, window.Size)
+		checkedWindow(status, fits == 1, tokensIn(tokens, n, window.Limit, window.Size))
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func (tb *tokenBucket) reserve(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64, maxDelay time.Duration) (*Reservation, error) {
+	if err := checkCost(n); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	// A request that costs more than the bucket capacity never fits
+	if neverFits(limit, tWindow) || n > limit {
+		return &Reservation{
+			OK: false,
+			Status: &models.RateLimitStatus{
+				State:       models.Denied,
+				Count:       0,
+				Limit:       limit,
+				Remaining:   remaining(limit, 0),
+				ExpiresAtMs: now.Add(tWindow).UnixMilli(),
+			},
+		}, nil
+	}
+
+	key = hashTagged(key)
+
+	result, err := tokenBucketScript.Run(ctx, tb.redis, []string{key}, limit, tWindow.Milliseconds(), now.UnixMilli(), delayMs(maxDelay), n).Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run token bucket script for key: %v with error: %w", key, err)
+	}
+
+	allowed, tokens, delay, err := parseTokenBucketResult(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token bucket result for key: %v with error: %w", key, err)
+	}
+
+	reservation := &Reservation{
+		OK:    allowed,
+		Delay: delay,
+		Status: &models.RateLimitStatus{
+			State:       models.Denied,
+			Count:       int(limit - int64(math.Floor(tokens))),
+			Limit:       limit,
+			Remaining:   remaining(limit, int(limit-int64(math.Floor(tokens)))),
+			RetryAfter:  delay,
+			ExpiresAtMs: now.Add(tokensIn(tokens, 1, limit, tWindow)).UnixMilli(),
+		},
+	}
+
+	if allowed {
+		reservation.Status.RetryAfter = 0
+		reservation.Status.State = models.Allowed
+		reservation.cancel = func(ctx context.Context) error {
+			return tb.Refund(ctx, key, limit, tWindow, "", n)
+		}
+	}
+
+	return reservation, nil
+}
+
+// tokenBucketStatus returns the status as of nowMs of a bucket that held the given amount of tokens at ts,
+// refilling it the same way tokenBucketScript does.
+func tokenBucketStatus(tokens float64, ts, nowMs, limit int64, tWindow time.Duration) *models.RateLimitStatus {
+	if neverFits(limit, tWindow) {
+		return &models.RateLimitStatus{
+			State:       models.Denied,
+			Limit:       limit,
+			ExpiresAtMs: nowMs + tWindow.Milliseconds(),
+		}
+	}
+
+	if nowMs > ts {
+		rate := float64(limit) / float64(tWindow.Milliseconds())
+		tokens = math.Min(float64(limit), tokens+float64(nowMs-ts)*rate)
+	}
+
+	status := &models.RateLimitStatus{
+		State:       models.Allowed,
+		Count:       int(limit - int64(math.Floor(tokens))),
+		Limit:       limit,
+		ExpiresAtMs: nowMs + tokensIn(tokens, 1, limit, tWindow).Milliseconds(),
+	}
+	status.Remaining = remaining(limit, status.Count)
+	if tokens < 1 {
+		status.State = models.Denied
+		status.RetryAfter = tokensIn(tokens, 1, limit, tWindow)
+	}
+
+	return status
+}
+
+// tokensIn returns how long it takes for a bucket with the given amount of tokens to have n whole tokens available,
+// which is zero when they never are, since n is more than the bucket capacity.
+func tokensIn(tokens float64, n, limit int64, tWindow time.Duration) time.Duration {
+	if tokens >= float64(n) || n > limit || neverFits(limit, tWindow) {
+		return 0
+	}
+
+	rate := float64(limit) / float64(tWindow.Milliseconds())
+	return time.Duration(math.Ceil((float64(n)-tokens)/rate)) * time.Millisecond
+}
+
+// parseFloat converts a number stored in redis by a script, which is read back as a string.
+func parseFloat(value interface{}) (float64, error) {
+	str, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected value %v", value)
+	}
+
+	return strconv.ParseFloat(str, 64)
+}
+
+// parseTokenBucketResult converts the reply of the token bucket script into its typed values.
+func parseTokenBucketResult(result []interface{}) (bool, float64, time.Duration, error) {
+	if len(result) != 3 {
+		return false, 0, 0, fmt.Errorf("unexpected result length %v", len(result))
+	}
+
+	allowed, ok := result[0].(int64)
+	if !ok {
+		return false, 0, 0, fmt.Errorf("unexpected allowed value %v", result[0])
+	}
+
+	rawTokens, ok := result[1].(string)
+	if !ok {
+		return false, 0, 0, fmt.Errorf("unexpected tokens value %v", result[1])
+	}
+
+	tokens, err := strconv.ParseFloat(rawTokens, 64)
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	wait, ok := result[2].(int64)
+	if !ok {
+		return false, 0, 0, fmt.Errorf("unexpected wait value %v", result[2])
+	}
+
+	return allowed == 1, tokens, time.Duration(wait) * time.Millisecond, nil
+}
@@ -0,0 +1,110 @@
+package rate_limiter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/godoylucase/rate-limit/errs"
+	"github.com/godoylucase/rate-limit/models"
+)
+
+// Window is one of the rate limits a request is checked against at once by CheckLimitsN.
+// Each window is kept under its own key, and on the redis backend the keys of the windows checked together
+// must share a cluster hash tag, such as "{user}-type:60000" and "{user}-type:86400000".
+type Window struct {
+	Key   string
+	Limit int64
+	Size  time.Duration
+}
+
+// Allowed tells whether the statuses returned by CheckLimitsN allowed the request, which happens
+// only when every window allowed it.
+func Allowed(statuses []*models.RateLimitStatus) bool {
+	for _, status := range statuses {
+		if status.State != models.Allowed {
+			return false
+		}
+	}
+
+	return len(statuses) > 0
+}
+
+// Decisive returns the index of the status that decides the outcome of a check against several windows,
+// which is the Denied one the request has to wait the longest for, or the one with the fewest remaining units
+// when all of them are Allowed.
+func Decisive(statuses []*models.RateLimitStatus) int {
+	idx := 0
+	for i, status := range statuses {
+		current := statuses[idx]
+		switch {
+		case status.State == models.Denied && current.State != models.Denied,
+			status.State == models.Denied && waitsLonger(status, current),
+			status.State == models.Allowed && current.State == models.Allowed && status.Remaining < current.Remaining:
+			idx = i
+		}
+	}
+
+	return idx
+}
+
+// waitsLonger tells whether a request has to wait longer for the Denied status than for the other one.
+// A Denied status without RetryAfter is the one of a window the request never fits within, so it comes first.
+func waitsLonger(status, other *models.RateLimitStatus) bool {
+	if other.RetryAfter == 0 {
+		return false
+	}
+
+	return status.RetryAfter == 0 || status.RetryAfter > other.RetryAfter
+}
+
+// checkWindows returns an error when there are no windows to check or the cost of the request is not valid.
+func checkWindows(windows []Window, n int64) error {
+	if len(windows) == 0 {
+		return fmt.Errorf("no windows to check: %w", errs.ErrInvalidArguments)
+	}
+
+	return checkCost(n)
+}
+
+// windowKeys returns the keys of the windows.
+func windowKeys(windows []Window) []string {
+	keys := make([]string, 0, len(windows))
+	for _, window := range windows {
+		keys = append(keys, window.Key)
+	}
+
+	return keys
+}
+
+// windowsArgs returns the script arguments for the windows, which are the given leading arguments
+// followed by the limit and the size in milliseconds of every window, along with their hash tagged keys.
+func windowsArgs(windows []Window, args ...interface{}) ([]string, []interface{}) {
+	keys := make([]string, 0, len(windows))
+	for _, window := range windows {
+		keys = append(keys, hashTagged(window.Key))
+		args = append(args, window.Limit, window.Size.Milliseconds())
+	}
+
+	return keys, args
+}
+
+// checkedWindow sets the state of the status of a window checked by CheckLimitsN, which is Allowed when the request
+// fits within the window, and otherwise how long the request has to wait until it does.
+func checkedWindow(status *models.RateLimitStatus, fits bool, retryAfter time.Duration) *models.RateLimitStatus {
+	status.State = windowState(fits)
+	status.RetryAfter = 0
+	if !fits {
+		status.RetryAfter = retryAfter
+	}
+
+	return status
+}
+
+// windowState returns the state a window status should have, which is Allowed when the request fits within it.
+func windowState(fits bool) models.State {
+	if fits {
+		return models.Allowed
+	}
+
+	return models.Denied
+}
@@ -0,0 +1,293 @@
+// Package server exposes the notification service over HTTP, so it can be shared by services written in any language.
+// Notifications are sent with POST /v1/notifications, either one at a time or in batches, and the server answers
+// GET /healthz while it is running and GET /readyz while its dependencies, such as Redis, are reachable.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/godoylucase/rate-limit/errs"
+	"github.com/godoylucase/rate-limit/http_limiter"
+	"github.com/godoylucase/rate-limit/models"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// MaxBatchSize is the most notifications a batch can have.
+	MaxBatchSize = 100
+	// maxBodyBytes is the largest request body accepted.
+	maxBodyBytes = 1 << 20
+	// readinessTimeout is how long the readiness check waits for the dependencies.
+	readinessTimeout = 2 * time.Second
+)
+
+// Error codes of the responses, telling why a notification was not sent.
+const (
+	CodeInvalidArguments = "invalid_arguments"
+	CodeRateLimited      = "rate_limited"
+	CodeGatewayError     = "gateway_error"
+	CodeInternalError    = "internal_error"
+	CodeNotReady         = "not_ready"
+)
+
+// Messages of the errors whose details are only logged, since they may reveal the internals of the server.
+var errorMessages = map[string]string{
+	CodeRateLimited:   "rate limit exceeded",
+	CodeGatewayError:  "the gateway failed to send the notification",
+	CodeInternalError: "internal error",
+	CodeNotReady:      "the dependencies of the server are not reachable",
+}
+
+// Sender sends notifications, such as notification.Service does.
+type Sender interface {
+	SendWithStatus(ctx context.Context, notif *models.Notification) (*models.RateLimitStatus, error)
+}
+
+// Server is the http.Handler of the notification API.
+type Server struct {
+	sender Sender
+	ready  func(ctx context.Context) error
+	logf   func(format string, args ...interface{})
+	mux    *http.ServeMux
+}
+
+// Option configures optional behavior of the Server.
+type Option func(*Server)
+
+// WithReadinessCheck sets the function that tells whether the dependencies of the server are reachable,
+// such as pinging Redis. The server is always ready without it.
+func WithReadinessCheck(fn func(ctx context.Context) error) Option {
+	return func(s *Server) {
+		s.ready = fn
+	}
+}
+
+// WithLogger sets the logger the details of the gateway, internal and readiness errors are written to, which
+// the responses leave out. It is the standard logger by default.
+func WithLogger(logger *log.Logger) Option {
+	return func(s *Server) {
+		s.logf = logger.Printf
+	}
+}
+
+// New creates a Server that sends the notifications with the sender.
+func New(sender Sender, opts ...Option) *Server {
+	s := &Server{
+		sender: sender,
+		logf:   log.Printf,
+		mux:    http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.mux.HandleFunc("POST /v1/notifications", s.send)
+	s.mux.HandleFunc("GET /healthz", s.health)
+	s.mux.HandleFunc("GET /readyz", s.readiness)
+
+	return s
+}
+
+// ServeHTTP serves the requests of the notification API.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// NotificationRequest is a notification to send. TenantID is optional.
+type NotificationRequest struct {
+	Type     string `json:"type"`
+	UserID   string `json:"user_id"`
+	TenantID string `json:"tenant_id,omitempty"`
+	Message  string `json:"message"`
+}
+
+// SendRequest is the body of POST /v1/notifications, which is either a single notification,
+// or a batch of them listed in Notifications.
+type SendRequest struct {
+	NotificationRequest
+	Notifications []NotificationRequest `json:"notifications,omitempty"`
+}
+
+// StatusResponse is the rate limit status a notification was sent, or rejected, with.
+type StatusResponse struct {
+	State        models.State `json:"state"`
+	Count        int          `json:"count"`
+	Limit        int64        `json:"limit"`
+	Remaining    int          `json:"remaining"`
+	RetryAfterMs int64        `json:"retry_after_ms,omitempty"`
+	Window       string       `json:"window,omitempty"`
+	Fallback     string       `json:"fallback,omitempty"`
+	Exempt       bool         `json:"exempt,omitempty"`
+}
+
+// ErrorResponse tells why a request failed, with one of the error codes.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// SendResponse is the outcome of sending a notification. StatusCode is the HTTP status code the notification
+// would have been answered with on its own, which is only set for the notifications of a batch.
+type SendResponse struct {
+	StatusCode int             `json:"status_code,omitempty"`
+	Status     *StatusResponse `json:"status,omitempty"`
+	Error      *ErrorResponse  `json:"error,omitempty"`
+}
+
+// BatchResponse is the outcome of sending a batch, with the result of every notification in the same order.
+type BatchResponse struct {
+	Results []*SendResponse `json:"results"`
+}
+
+// send sends a single notification, answering with its own status code and RateLimit headers,
+// or a batch of notifications one after the other, answering 200 OK with the result of each one.
+func (s *Server) send(w http.ResponseWriter, r *http.Request) {
+	var req SendRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidArguments, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	if req.Notifications == nil {
+		code, resp, status := s.sendOne(r.Context(), &req.NotificationRequest)
+		if status != nil && !status.Exempt {
+			http_limiter.SetHeaders(w.Header(), status, time.Now())
+		}
+		writeJSON(w, code, resp)
+		return
+	}
+
+	switch {
+	case req.NotificationRequest != NotificationRequest{}:
+		writeError(w, http.StatusBadRequest, CodeInvalidArguments, "a batch must not be sent along with a single notification")
+		return
+	case len(req.Notifications) == 0:
+		writeError(w, http.StatusBadRequest, CodeInvalidArguments, "a batch must have at least one notification")
+		return
+	case len(req.Notifications) > MaxBatchSize:
+		writeError(w, http.StatusBadRequest, CodeInvalidArguments, fmt.Sprintf("a batch must have at most %v notifications, got %v", MaxBatchSize, len(req.Notifications)))
+		return
+	}
+
+	batch := &BatchResponse{Results: make([]*SendResponse, 0, len(req.Notifications))}
+	for i := range req.Notifications {
+		code, resp, _ := s.sendOne(r.Context(), &req.Notifications[i])
+		resp.StatusCode = code
+		batch.Results = append(batch.Results, resp)
+	}
+	writeJSON(w, http.StatusOK, batch)
+}
+
+// sendOne sends a notification, returning the status code and the response it is answered with,
+// along with the rate limit status it was sent or rejected with, if any.
+func (s *Server) sendOne(ctx context.Context, req *NotificationRequest) (int, *SendResponse, *models.RateLimitStatus) {
+	userID, err := ksuid.Parse(req.UserID)
+	if err != nil {
+		err = fmt.Errorf("invalid user_id %q: %w", req.UserID, errs.ErrInvalidArguments)
+		return http.StatusBadRequest, &SendResponse{Error: &ErrorResponse{Code: CodeInvalidArguments, Message: err.Error()}}, nil
+	}
+
+	status, err := s.sender.SendWithStatus(ctx, &models.Notification{
+		Type:     req.Type,
+		UserID:   userID,
+		TenantID: req.TenantID,
+		Message:  req.Message,
+	})
+
+	resp := &SendResponse{Status: statusResponse(status)}
+	if err == nil {
+		return http.StatusOK, resp, status
+	}
+
+	code, errCode := errorCode(err)
+	resp.Error = &ErrorResponse{Code: errCode, Message: s.errorMessage(errCode, err)}
+
+	return code, resp, status
+}
+
+// errorMessage returns the message of the error response, which is the error itself for invalid arguments only.
+// The details of the gateway and internal errors are logged instead.
+func (s *Server) errorMessage(errCode string, err error) string {
+	switch errCode {
+	case CodeInvalidArguments:
+		return err.Error()
+	case CodeGatewayError, CodeInternalError:
+		s.logf("error sending notification: %v", err)
+	}
+
+	return errorMessages[errCode]
+}
+
+// errorCode returns the HTTP status code and the error code of an error returned by the sender.
+func errorCode(err error) (int, string) {
+	var limitErr *errs.ErrExceededRateLimit
+	switch {
+	case errors.Is(err, errs.ErrInvalidArguments):
+		return http.StatusBadRequest, CodeInvalidArguments
+	case errors.As(err, &limitErr):
+		return http.StatusTooManyRequests, CodeRateLimited
+	case errors.Is(err, errs.ErrGateway):
+		return http.StatusBadGateway, CodeGatewayError
+	default:
+		return http.StatusInternalServerError, CodeInternalError
+	}
+}
+
+// statusResponse returns the response of a rate limit status, which may be nil.
+func statusResponse(status *models.RateLimitStatus) *StatusResponse {
+	if status == nil {
+		return nil
+	}
+
+	return &StatusResponse{
+		State:        status.State,
+		Count:        status.Count,
+		Limit:        status.Limit,
+		Remaining:    status.Remaining,
+		RetryAfterMs: status.RetryAfter.Milliseconds(),
+		Window:       status.Window,
+		Fallback:     string(status.Fallback),
+		Exempt:       status.Exempt,
+	}
+}
+
+// health answers 200 OK as long as the server is running.
+func (s *Server) health(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readiness answers 200 OK when the dependencies of the server are reachable, and 503 Service Unavailable otherwise.
+func (s *Server) readiness(w http.ResponseWriter, r *http.Request) {
+	if s.ready != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		if err := s.ready(ctx); err != nil {
+			s.logf("server not ready: %v", err)
+			writeError(w, http.StatusServiceUnavailable, CodeNotReady, errorMessages[CodeNotReady])
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// writeError writes an error response with the status code.
+func writeError(w http.ResponseWriter, code int, errCode, message string) {
+	writeJSON(w, code, &SendResponse{Error: &ErrorResponse{Code: errCode, Message: message}})
+}
+
+// writeJSON writes the value as the JSON body of the response with the status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godoylucase/rate-limit/configs"
+	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/notification"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatewayFunc is a notification.Gateway that sends the notifications with a function.
+type gatewayFunc func(ctx context.Context, userID string, message string) error
+
+func (fn gatewayFunc) Send(ctx context.Context, userID string, message string) error {
+	return fn(ctx, userID, message)
+}
+
+func newServer(t *testing.T, gateway gatewayFunc, opts ...Option) *Server {
+	rlimiter := rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, rate_limiter.SlidingWindowCounter, nil)
+	t.Cleanup(func() {
+		_ = rlimiter.(interface{ Close() error }).Close()
+	})
+
+	limits := configs.LimitConfigMap{
+		"status": {Type: "status", Limit: 2, WSize: configs.Duration(time.Minute)},
+	}
+
+	return New(notification.NewService(rlimiter, gateway, limits), opts...)
+}
+
+func serve(s *Server, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+
+	return rec
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) *T {
+	var v T
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&v))
+
+	return &v
+}
+
+func notificationBody(userID ksuid.KSUID, typ, message string) string {
+	body, _ := json.Marshal(NotificationRequest{Type: typ, UserID: userID.String(), Message: message})
+	return string(body)
+}
+
+func TestServer_Send(t *testing.T) {
+	var sent []string
+	s := newServer(t, func(ctx context.Context, userID string, message string) error {
+		sent = append(sent, message)
+		return nil
+	})
+	userID := ksuid.New()
+
+	for i := 0; i < 2; i++ {
+		rec := serve(s, http.MethodPost, "/v1/notifications", notificationBody(userID, "status", "hello"))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, []string{"1", "0"}[i], rec.Header().Get("RateLimit-Remaining"))
+
+		resp := decode[SendResponse](t, rec)
+		require.NotNil(t, resp.Status)
+		assert.Equal(t, models.Allowed, resp.Status.State)
+		assert.Equal(t, "status/1m0s", resp.Status.Window)
+		assert.Nil(t, resp.Error)
+	}
+
+	rec := serve(s, http.MethodPost, "/v1/notifications", notificationBody(userID, "status", "hello"))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+
+	resp := decode[SendResponse](t, rec)
+	assert.Equal(t, models.Denied, resp.Status.State)
+	assert.InDelta(t, time.Minute.Milliseconds(), resp.Status.RetryAfterMs, 1000)
+	assert.Equal(t, CodeRateLimited, resp.Error.Code)
+
+	assert.Equal(t, []string{"hello", "hello"}, sent)
+}
+
+func TestServer_SendErrors(t *testing.T) {
+	var logs bytes.Buffer
+	s := newServer(t, func(ctx context.Context, userID string, message string) error {
+		return errors.New("provider unavailable")
+	}, WithLogger(log.New(&logs, "", 0)))
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantErr  string
+		wantMsg  string
+	}{
+		{name: "malformed body", body: `{"type":`, wantCode: http.StatusBadRequest, wantErr: CodeInvalidArguments},
+		{name: "invalid user ID", body: `{"type": "status", "user_id": "nope", "message": "hello"}`, wantCode: http.StatusBadRequest, wantErr: CodeInvalidArguments, wantMsg: `invalid user_id "nope": invalid arguments`},
+		{name: "missing message", body: notificationBody(ksuid.New(), "status", ""), wantCode: http.StatusBadRequest, wantErr: CodeInvalidArguments},
+		{name: "unknown type", body: notificationBody(ksuid.New(), "news", "hello"), wantCode: http.StatusBadRequest, wantErr: CodeInvalidArguments},
+		{name: "gateway error", body: notificationBody(ksuid.New(), "status", "hello"), wantCode: http.StatusBadGateway, wantErr: CodeGatewayError, wantMsg: "the gateway failed to send the notification"},
+		{name: "empty batch", body: `{"notifications": []}`, wantCode: http.StatusBadRequest, wantErr: CodeInvalidArguments},
+		{name: "batch along with a notification", body: `{"type": "status", "notifications": [{}]}`, wantCode: http.StatusBadRequest, wantErr: CodeInvalidArguments},
+		{name: "batch too large", body: `{"notifications": [` + strings.Repeat(`{},`, MaxBatchSize) + `{}]}`, wantCode: http.StatusBadRequest, wantErr: CodeInvalidArguments},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(s, http.MethodPost, "/v1/notifications", tt.body)
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			resp := decode[SendResponse](t, rec)
+			require.NotNil(t, resp.Error)
+			assert.Equal(t, tt.wantErr, resp.Error.Code)
+			if tt.wantMsg != "" {
+				assert.Equal(t, tt.wantMsg, resp.Error.Message)
+			}
+		})
+	}
+
+	// The details of the gateway error are only logged
+	assert.Contains(t, logs.String(), "provider unavailable")
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(s, http.MethodGet, "/v1/notifications", "").Code)
+}
+
+func TestServer_SendBatch(t *testing.T) {
+	s := newServer(t, func(ctx context.Context, userID string, message string) error {
+		return nil
+	})
+	userID := ksuid.New()
+
+	body, err := json.Marshal(SendRequest{Notifications: []NotificationRequest{
+		{Type: "status", UserID: userID.String(), Message: "first"},
+		{Type: "status", UserID: userID.String(), Message: "second"},
+		{Type: "status", UserID: userID.String(), Message: "third"},
+		{Type: "status", UserID: "nope", Message: "fourth"},
+	}})
+	require.NoError(t, err)
+
+	rec := serve(s, http.MethodPost, "/v1/notifications", string(body))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("RateLimit-Limit"))
+
+	resp := decode[BatchResponse](t, rec)
+	require.Len(t, resp.Results, 4)
+	assert.Equal(t, http.StatusOK, resp.Results[0].StatusCode)
+	assert.Equal(t, http.StatusOK, resp.Results[1].StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, resp.Results[2].StatusCode)
+	assert.Equal(t, CodeRateLimited, resp.Results[2].Error.Code)
+	assert.Equal(t, 0, resp.Results[2].Status.Remaining)
+	assert.Equal(t, http.StatusBadRequest, resp.Results[3].StatusCode)
+	assert.Nil(t, resp.Results[3].Status)
+}
+
+func TestServer_HealthAndReadiness(t *testing.T) {
+	s := newServer(t, nil)
+	assert.Equal(t, http.StatusOK, serve(s, http.MethodGet, "/healthz", "").Code)
+	assert.Equal(t, http.StatusOK, serve(s, http.MethodGet, "/readyz", "").Code)
+
+	var redisErr error
+	s = newServer(t, nil, WithReadinessCheck(func(ctx context.Context) error {
+		_, ok := ctx.Deadline()
+		assert.True(t, ok)
+		return redisErr
+	}))
+	assert.Equal(t, http.StatusOK, serve(s, http.MethodGet, "/readyz", "").Code)
+
+	redisErr = errors.New("dial tcp 127.0.0.1:6379: connect: connection refused")
+	rec := serve(s, http.MethodGet, "/readyz", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	resp := decode[SendResponse](t, rec)
+	assert.Equal(t, CodeNotReady, resp.Error.Code)
+	assert.NotContains(t, resp.Error.Message, "6379")
+
+	// The server keeps running while it is not ready
+	assert.Equal(t, http.StatusOK, serve(s, http.MethodGet, "/healthz", "").Code)
+}
@@ -0,0 +1,151 @@
+// Package tracing provides the OpenTelemetry spans of the rate limits, which tell where the time of a slow notification
+// goes: the notification service opens a span for every notification it sends, with a child span for its gateway call,
+// and the rate limiter returned by RateLimiter opens one for every check, such as the redis round trip of CheckLimit.
+// The spans are children of the span of the context they are given, so the trace context propagates through the
+// context.Context of every call.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName is the name of the tracers of the rate limits.
+const InstrumentationName = "github.com/godoylucase/rate-limit"
+
+// The attributes of the spans.
+const (
+	TypeKey      = attribute.Key("notification.type")
+	AlgorithmKey = attribute.Key("ratelimit.algorithm")
+	DecisionKey  = attribute.Key("ratelimit.decision")
+	CountKey     = attribute.Key("ratelimit.count")
+	LimitKey     = attribute.Key("ratelimit.limit")
+	CostKey      = attribute.Key("ratelimit.cost")
+	WindowKey    = attribute.Key("ratelimit.window")
+	FallbackKey  = attribute.Key("ratelimit.fallback")
+	ExemptKey    = attribute.Key("ratelimit.exempt")
+)
+
+// Tracer returns the tracer of the rate limits from the provider, or from the global one when it is nil.
+func Tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+
+	return tp.Tracer(InstrumentationName)
+}
+
+// StatusAttributes returns the attributes of a rate limit status: its decision, count and limit, along with
+// its window and fallback when they are known.
+func StatusAttributes(status *models.RateLimitStatus) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		DecisionKey.String(string(status.State)),
+		CountKey.Int(status.Count),
+		LimitKey.Int64(status.Limit),
+	}
+	if status.Window != "" {
+		attrs = append(attrs, WindowKey.String(status.Window))
+	}
+	if status.Fallback != "" {
+		attrs = append(attrs, FallbackKey.String(string(status.Fallback)))
+	}
+
+	return attrs
+}
+
+// Fail records the error on the span and marks it as failed, unless the error is nil.
+func Fail(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// RateLimiter returns the rate limiter that opens a span for every check of the wrapped one, named after the method,
+// such as "RateLimiter.CheckLimit", with its algorithm, decision, count and limit. The checks of several windows
+// carry the status of the window that decides them. The spans come from the provider, or from the global one
+// when it is nil.
+func RateLimiter(rlimiter rate_limiter.RateLimiter, algorithm string, tp trace.TracerProvider) rate_limiter.RateLimiter {
+	return &traced{RateLimiter: rlimiter, algorithm: algorithm, tracer: Tracer(tp)}
+}
+
+// traced is the rate limiter returned by RateLimiter.
+type traced struct {
+	rate_limiter.RateLimiter
+	algorithm string
+	tracer    trace.Tracer
+}
+
+func (tl *traced) CheckLimit(ctx context.Context, key string, limit int64, tWindow time.Duration) (*models.RateLimitStatus, error) {
+	ctx, span := tl.start(ctx, "RateLimiter.CheckLimit", 1)
+	defer span.End()
+
+	status, err := tl.RateLimiter.CheckLimit(ctx, key, limit, tWindow)
+	return status, tl.end(span, status, err)
+}
+
+func (tl *traced) CheckLimitN(ctx context.Context, key string, limit int64, tWindow time.Duration, n int64) (*models.RateLimitStatus, error) {
+	ctx, span := tl.start(ctx, "RateLimiter.CheckLimitN", n)
+	defer span.End()
+
+	status, err := tl.RateLimiter.CheckLimitN(ctx, key, limit, tWindow, n)
+	return status, tl.end(span, status, err)
+}
+
+func (tl *traced) CheckLimitsN(ctx context.Context, windows []rate_limiter.Window, n int64) ([]*models.RateLimitStatus, error) {
+	ctx, span := tl.start(ctx, "RateLimiter.CheckLimitsN", n)
+	defer span.End()
+
+	statuses, err := tl.RateLimiter.CheckLimitsN(ctx, windows, n)
+	if err != nil || len(statuses) == 0 {
+		return statuses, tl.end(span, nil, err)
+	}
+
+	return statuses, tl.end(span, statuses[rate_limiter.Decisive(statuses)], nil)
+}
+
+// Close closes the wrapped rate limiter when it is an io.Closer, such as the ones of the memory backend.
+func (tl *traced) Close() error {
+	if closer, ok := tl.RateLimiter.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// start opens the span of a check that costs n units.
+func (tl *traced) start(ctx context.Context, name string, n int64) (context.Context, trace.Span) {
+	return tl.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(AlgorithmKey.String(tl.algorithm), CostKey.Int64(n)),
+	)
+}
+
+// end sets the attributes of the status of the check on its span, or records its error, which it returns.
+// A check stopped because its context was canceled is not marked as failed.
+func (tl *traced) end(span trace.Span, status *models.RateLimitStatus, err error) error {
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			Fail(span, err)
+		}
+		return err
+	}
+
+	if status != nil {
+		span.SetAttributes(StatusAttributes(status)...)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/godoylucase/rate-limit/models"
+	"github.com/godoylucase/rate-limit/rate_limiter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// failingLimiter is a rate limiter whose checks fail with an error.
+type failingLimiter struct {
+	rate_limiter.RateLimiter
+	err error
+}
+
+func (fl *failingLimiter) CheckLimit(context.Context, string, int64, time.Duration) (*models.RateLimitStatus, error) {
+	return nil, fl.err
+}
+
+func newProvider(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+	})
+
+	return tp, exporter
+}
+
+func attributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value, len(span.Attributes))
+	for _, kv := range span.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+
+	return attrs
+}
+
+func TestRateLimiter(t *testing.T) {
+	tp, exporter := newProvider(t)
+	memory := rate_limiter.GetWithBackend(rate_limiter.MemoryBackend, rate_limiter.TokenBucket, nil)
+	rlimiter := RateLimiter(memory, rate_limiter.TokenBucket, tp)
+	t.Cleanup(func() {
+		require.NoError(t, rlimiter.(io.Closer).Close())
+	})
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	_, err := rlimiter.CheckLimit(ctx, "{user}-news", 2, time.Minute)
+	require.NoError(t, err)
+	_, err = rlimiter.CheckLimitN(ctx, "{user}-news", 2, time.Minute, 2)
+	require.NoError(t, err)
+	_, err = rlimiter.CheckLimitsN(ctx, []rate_limiter.Window{
+		{Key: "{user}-alert:60000", Limit: 3, Size: time.Minute},
+		{Key: "{user}-alert:3600000", Limit: 1, Size: time.Hour},
+	}, 1)
+	require.NoError(t, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 4)
+
+	for _, span := range spans[:3] {
+		assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext.TraceID())
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+		assert.Equal(t, "token_bucket", attributes(span)[AlgorithmKey].AsString())
+	}
+
+	assert.Equal(t, "RateLimiter.CheckLimit", spans[0].Name)
+	attrs := attributes(spans[0])
+	assert.Equal(t, "allowed", attrs[DecisionKey].AsString())
+	assert.EqualValues(t, 1, attrs[CountKey].AsInt64())
+	assert.EqualValues(t, 2, attrs[LimitKey].AsInt64())
+	assert.EqualValues(t, 1, attrs[CostKey].AsInt64())
+
+	assert.Equal(t, "RateLimiter.CheckLimitN", spans[1].Name)
+	attrs = attributes(spans[1])
+	assert.Equal(t, "denied", attrs[DecisionKey].AsString())
+	assert.EqualValues(t, 2, attrs[CostKey].AsInt64())
+
+	// the window that decides the check is the one with the fewest remaining units
+	assert.Equal(t, "RateLimiter.CheckLimitsN", spans[2].Name)
+	attrs = attributes(spans[2])
+	assert.Equal(t, "allowed", attrs[DecisionKey].AsString())
+	assert.EqualValues(t, 1, attrs[LimitKey].AsInt64())
+}
+
+func TestRateLimiter_Errors(t *testing.T) {
+	tp, exporter := newProvider(t)
+	failing := &failingLimiter{err: errors.New("connection refused")}
+	rlimiter := RateLimiter(failing, rate_limiter.GCRA, tp)
+
+	_, err := rlimiter.CheckLimit(context.Background(), "{user}-news", 1, time.Minute)
+	require.ErrorIs(t, err, failing.err)
+
+	failing.err = context.Canceled
+	_, err = rlimiter.CheckLimit(context.Background(), "{user}-news", 1, time.Minute)
+	require.ErrorIs(t, err, context.Canceled)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, "connection refused", spans[0].Status.Description)
+	require.Len(t, spans[0].Events, 1)
+	assert.Equal(t, "exception", spans[0].Events[0].Name)
+	assert.NotContains(t, attributes(spans[0]), DecisionKey)
+
+	// a canceled check is not a failure
+	assert.Equal(t, codes.Unset, spans[1].Status.Code)
+}
@@ -0,0 +1,39 @@
+// Package transport provides what the HTTP middleware and the gRPC interceptors share, which are the RateLimit headers
+// they send along with the requests they limit and the combinators of their key extractors.
+package transport
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/godoylucase/rate-limit/models"
+)
+
+// The names of the RateLimit headers of the IETF draft, which the HTTP middleware and the gRPC interceptors send
+// along with the requests they limit, the latter in lower case as gRPC metadata expects.
+const (
+	LimitHeader     = "RateLimit-Limit"
+	RemainingHeader = "RateLimit-Remaining"
+	ResetHeader     = "RateLimit-Reset"
+)
+
+// Headers returns the RateLimit headers of the status as of now, as pairs of names and values. The reset is how many
+// seconds the request has to wait to be allowed when it is denied, or until the window expires when it is allowed.
+func Headers(status *models.RateLimitStatus, now time.Time) []string {
+	reset := status.RetryAfter
+	if status.State == models.Allowed {
+		reset = max(0, time.UnixMilli(status.ExpiresAtMs).Sub(now))
+	}
+
+	return []string{
+		LimitHeader, strconv.FormatInt(status.Limit, 10),
+		RemainingHeader, strconv.Itoa(status.Remaining),
+		ResetHeader, strconv.FormatInt(Seconds(reset), 10),
+	}
+}
+
+// Seconds rounds up a duration to whole seconds, as the headers expect.
+func Seconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
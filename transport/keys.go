@@ -0,0 +1,31 @@
+package transport
+
+import "strings"
+
+// FirstKey returns the first non empty key that key extracts from the given sources, which are only looked at
+// until one is found, or an empty key when all of them are empty. It is meant for combining the key extractors
+// of the HTTP middleware and the gRPC interceptors, such as an API key or a client IP for anonymous requests.
+func FirstKey[S any](sources []S, key func(S) string) string {
+	for _, source := range sources {
+		if k := key(source); k != "" {
+			return k
+		}
+	}
+
+	return ""
+}
+
+// JoinKeys returns the keys that key extracts from all the given sources joined by colons, such as a client IP
+// per route, or an empty key when any of them is empty.
+func JoinKeys[S any](sources []S, key func(S) string) string {
+	parts := make([]string, 0, len(sources))
+	for _, source := range sources {
+		k := key(source)
+		if k == "" {
+			return ""
+		}
+		parts = append(parts, k)
+	}
+
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,17 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirstKeyAndJoinKeys(t *testing.T) {
+	key := func(k string) string { return k }
+
+	assert.Equal(t, "acme", FirstKey([]string{"", "acme", "other"}, key))
+	assert.Empty(t, FirstKey([]string{"", ""}, key))
+
+	assert.Equal(t, "acme:GET /v1/status", JoinKeys([]string{"acme", "GET /v1/status"}, key))
+	assert.Empty(t, JoinKeys([]string{"acme", ""}, key))
+}